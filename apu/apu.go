@@ -0,0 +1,428 @@
+// Package apu emulates the 2A03's audio processing unit: two pulse channels,
+// a triangle, noise and the delta modulation channel, mixed down to 16 bit
+// samples.
+//
+// The APU runs lazily. It catches up to the CPU's cycle count whenever a
+// register is accessed and when the frontend calls Run, usually at the end
+// of each frame, so it costs nothing between accesses.
+package apu
+
+import (
+	"math"
+
+	"switchtrue.com/hankee/cpu"
+)
+
+const DEFAULT_SAMPLE_RATE = 44100
+
+// Samples nobody collects are dropped after this many so a machine without
+// audio output doesn't grow forever.
+const MAX_BUFFERED_SAMPLES = 2 * DEFAULT_SAMPLE_RATE
+
+// The console filters its output, the 90Hz high pass is the one that matters
+// for taking out the DC offset of the mixer.
+const HIGH_PASS_HZ = 90
+
+// The channels, in the order ChannelSamples returns them.
+const CHANNELS = 5
+
+var CHANNEL_NAMES = [CHANNELS]string{"pulse1", "pulse2", "triangle", "noise", "dmc"}
+
+// Timing is the region specific part of the APU, with the frame counter steps
+// and periods in CPU cycles.
+type Timing struct {
+	CPUClock          float64
+	FrameCounterSteps [5]int
+	NoisePeriods      [16]uint16
+	DMCRates          [16]uint16
+}
+
+// Mixer lookup tables for the non-linear DAC, from the nesdev wiki.
+var pulseTable, tndTable = func() (pulse [31]float64, tnd [203]float64) {
+	for n := 1; n < len(pulse); n++ {
+		pulse[n] = 95.52 / (8128.0/float64(n) + 100)
+	}
+	for n := 1; n < len(tnd); n++ {
+		tnd[n] = 163.67 / (24329.0/float64(n) + 100)
+	}
+	return pulse, tnd
+}()
+
+type APU struct {
+	cpu    *cpu.CPU
+	timing Timing
+	cycle  uint64
+
+	pulse1   pulse
+	pulse2   pulse
+	triangle triangle
+	noise    noise
+	dmc      dmc
+
+	fiveStep   bool
+	irqInhibit bool
+	frameIRQ   bool
+	frameCycle int
+	frameStep  int
+
+	sampleRate  int
+	sampleClock float64
+	sum         float64
+	count       int
+	filterDecay float64
+	filter      highPass
+	samples     []int16
+	// Samples made since the APU was created, so Restore can tell which in
+	// the buffer came after a state.
+	made uint64
+
+	// Each channel on its own, only kept when asked for since it slows the
+	// APU down.
+	captureChannels bool
+	channelSums     [CHANNELS]float64
+	channelFilters  [CHANNELS]highPass
+	channelSamples  [CHANNELS][]int16
+}
+
+// highPass is a first order high pass filter, which takes out the DC offset.
+type highPass struct {
+	in  float64
+	out float64
+}
+
+func (f *highPass) filter(in float64, decay float64) float64 {
+	f.out = in - f.in + decay*f.out
+	f.in = in
+	return f.out
+}
+
+// Creates an APU clocked by the CPU, which it also reads DMC samples through.
+func New(timing Timing, c *cpu.CPU) *APU {
+	a := &APU{cpu: c, cycle: c.Cycles()}
+	a.pulse1.onesComplement = true
+	a.noise.periods = &a.timing.NoisePeriods
+	a.dmc.rates = &a.timing.DMCRates
+	a.dmc.read = c.MemRead
+	a.SetTiming(timing)
+	a.SetSampleRate(DEFAULT_SAMPLE_RATE)
+	a.Reset()
+	return a
+}
+
+// Switches region. The tables apply from the next register write.
+func (a *APU) SetTiming(timing Timing) {
+	a.Run()
+	a.timing = timing
+}
+
+func (a *APU) SetSampleRate(rate int) {
+	a.sampleRate = rate
+	a.filterDecay = math.Exp(-2 * math.Pi * HIGH_PASS_HZ / float64(rate))
+}
+
+func (a *APU) SampleRate() int {
+	return a.sampleRate
+}
+
+// Silences every channel and restarts the frame counter, as the console's
+// reset does.
+func (a *APU) Reset() {
+	a.cycle = a.cpu.Cycles()
+	a.Write(0x4015, 0)
+	a.Write(0x4017, 0)
+	a.noise.shift = 1
+	a.noise.period = a.timing.NoisePeriods[0]
+	a.dmc.rate = a.timing.DMCRates[0]
+	a.dmc.bufferEmpty = true
+	a.dmc.silence = true
+	a.dmc.bits = 8
+}
+
+// Catches up to the CPU's current cycle.
+func (a *APU) Run() {
+	now := a.cpu.Cycles()
+	if now < a.cycle {
+		// The CPU went back in time with a state load, start again from
+		// there.
+		a.cycle = now
+	}
+	for ; a.cycle < now; a.cycle++ {
+		a.clock()
+	}
+}
+
+func (a *APU) clock() {
+	a.triangle.clockTimer()
+	a.noise.clockTimer()
+	a.dmc.clockTimer()
+	if a.cycle&1 == 0 {
+		a.pulse1.clockTimer()
+		a.pulse2.clockTimer()
+	}
+	a.clockFrameCounter()
+
+	a.sum += a.mix()
+	if a.captureChannels {
+		a.channelSums[0] += pulseTable[a.pulse1.output()]
+		a.channelSums[1] += pulseTable[a.pulse2.output()]
+		a.channelSums[2] += tndTable[3*int(a.triangle.output())]
+		a.channelSums[3] += tndTable[2*int(a.noise.output())]
+		a.channelSums[4] += tndTable[a.dmc.output()]
+	}
+	a.count++
+	a.sampleClock += float64(a.sampleRate)
+	if a.sampleClock >= a.timing.CPUClock {
+		a.sampleClock -= a.timing.CPUClock
+		a.samples = a.emit(a.samples, &a.filter, a.sum/float64(a.count))
+		a.made++
+		if a.captureChannels {
+			for i, sum := range a.channelSums {
+				a.channelSamples[i] = a.emit(a.channelSamples[i], &a.channelFilters[i], sum/float64(a.count))
+			}
+			a.channelSums = [CHANNELS]float64{}
+		}
+		a.sum, a.count = 0, 0
+	}
+}
+
+func (a *APU) clockFrameCounter() {
+	a.frameCycle++
+	if a.frameCycle < a.timing.FrameCounterSteps[a.frameStep] {
+		return
+	}
+	switch a.frameStep {
+	case 0, 2:
+		a.quarterFrame()
+	case 1:
+		a.quarterFrame()
+		a.halfFrame()
+	case 3:
+		if !a.fiveStep {
+			a.quarterFrame()
+			a.halfFrame()
+			if !a.irqInhibit {
+				a.frameIRQ = true
+			}
+			a.frameCycle, a.frameStep = 0, 0
+			return
+		}
+	case 4:
+		a.quarterFrame()
+		a.halfFrame()
+		a.frameCycle, a.frameStep = 0, 0
+		return
+	}
+	a.frameStep++
+}
+
+func (a *APU) quarterFrame() {
+	a.pulse1.envelope.clock()
+	a.pulse2.envelope.clock()
+	a.noise.envelope.clock()
+	a.triangle.clockLinear()
+}
+
+func (a *APU) halfFrame() {
+	a.pulse1.length.clock()
+	a.pulse2.length.clock()
+	a.triangle.length.clock()
+	a.noise.length.clock()
+	a.pulse1.clockSweep()
+	a.pulse2.clockSweep()
+}
+
+// Returns the DAC output from 0 to about 1.
+func (a *APU) mix() float64 {
+	pulse := pulseTable[a.pulse1.output()+a.pulse2.output()]
+	tnd := tndTable[3*int(a.triangle.output())+2*int(a.noise.output())+int(a.dmc.output())]
+	return pulse + tnd
+}
+
+// Filters a sample and adds it to a buffer.
+func (a *APU) emit(samples []int16, f *highPass, in float64) []int16 {
+	sample := math.Round(f.filter(in, a.filterDecay) * math.MaxInt16)
+	sample = max(min(sample, math.MaxInt16), math.MinInt16)
+	if len(samples) >= MAX_BUFFERED_SAMPLES {
+		samples = append(samples[:0], samples[len(samples)/2:]...)
+	}
+	return append(samples, int16(sample))
+}
+
+// Catches up and returns the mixed samples made since the last call.
+func (a *APU) Samples() []int16 {
+	a.Run()
+	samples := a.samples
+	a.samples = nil
+	return samples
+}
+
+// Starts or stops keeping samples of each channel on its own. They're made
+// in step with the mixed samples.
+func (a *APU) SetChannelCapture(capture bool) {
+	a.Run()
+	a.captureChannels = capture
+	a.channelSamples = [CHANNELS][]int16{}
+	a.channelSums = [CHANNELS]float64{}
+}
+
+// Catches up and returns the samples of each channel made since the last
+// call, in the order of CHANNEL_NAMES. Call Samples first to keep the two in
+// step.
+func (a *APU) ChannelSamples() [CHANNELS][]int16 {
+	a.Run()
+	samples := a.channelSamples
+	a.channelSamples = [CHANNELS][]int16{}
+	return samples
+}
+
+// Reads the status register at $4015. Every other register is write only.
+func (a *APU) Read(addr uint16) uint8 {
+	if addr != 0x4015 {
+		return 0
+	}
+	a.Run()
+	var status uint8
+	for i, length := range []uint8{a.pulse1.length.value, a.pulse2.length.value, a.triangle.length.value, a.noise.length.value} {
+		if length > 0 {
+			status |= 1 << i
+		}
+	}
+	if a.dmc.remaining > 0 {
+		status |= 0x10
+	}
+	if a.frameIRQ {
+		status |= 0x40
+	}
+	if a.dmc.irq {
+		status |= 0x80
+	}
+	// Reading acknowledges the frame interrupt.
+	a.frameIRQ = false
+	return status
+}
+
+func (a *APU) Write(addr uint16, data uint8) {
+	a.Run()
+	switch {
+	case addr >= 0x4000 && addr <= 0x4003:
+		a.pulse1.write(addr-0x4000, data)
+	case addr >= 0x4004 && addr <= 0x4007:
+		a.pulse2.write(addr-0x4004, data)
+	case addr >= 0x4008 && addr <= 0x400B:
+		a.triangle.write(addr-0x4008, data)
+	case addr >= 0x400C && addr <= 0x400F:
+		a.noise.write(addr-0x400C, data)
+	case addr >= 0x4010 && addr <= 0x4013:
+		a.dmc.write(addr-0x4010, data)
+	case addr == 0x4015:
+		a.pulse1.length.setEnabled(data&0x01 != 0)
+		a.pulse2.length.setEnabled(data&0x02 != 0)
+		a.triangle.length.setEnabled(data&0x04 != 0)
+		a.noise.length.setEnabled(data&0x08 != 0)
+		a.dmc.setEnabled(data&0x10 != 0)
+	case addr == 0x4017:
+		a.fiveStep = data&0x80 != 0
+		a.irqInhibit = data&0x40 != 0
+		if a.irqInhibit {
+			a.frameIRQ = false
+		}
+		a.frameCycle, a.frameStep = 0, 0
+		// The 5 step sequence clocks everything straight away.
+		if a.fiveStep {
+			a.quarterFrame()
+			a.halfFrame()
+		}
+	}
+}
+
+// State is a copy of the APU's registers, channels and mixer, for going back
+// to an earlier point as netplay does or saving to a file with MarshalBinary.
+// It doesn't include the region, sample rate or samples waiting to be
+// collected.
+type State struct {
+	cycle    uint64
+	pulse1   pulse
+	pulse2   pulse
+	triangle triangle
+	noise    noise
+	dmc      dmc
+
+	fiveStep   bool
+	irqInhibit bool
+	frameIRQ   bool
+	frameCycle int
+	frameStep  int
+
+	sampleClock    float64
+	sum            float64
+	count          int
+	filter         highPass
+	channelSums    [CHANNELS]float64
+	channelFilters [CHANNELS]highPass
+	made           uint64
+}
+
+// Copies the APU into state, catching up to the CPU first.
+func (a *APU) Save(state *State) {
+	a.Run()
+	*state = State{
+		cycle:          a.cycle,
+		pulse1:         a.pulse1,
+		pulse2:         a.pulse2,
+		triangle:       a.triangle,
+		noise:          a.noise,
+		dmc:            a.dmc,
+		fiveStep:       a.fiveStep,
+		irqInhibit:     a.irqInhibit,
+		frameIRQ:       a.frameIRQ,
+		frameCycle:     a.frameCycle,
+		frameStep:      a.frameStep,
+		sampleClock:    a.sampleClock,
+		sum:            a.sum,
+		count:          a.count,
+		filter:         a.filter,
+		channelSums:    a.channelSums,
+		channelFilters: a.channelFilters,
+		made:           a.made,
+	}
+	// These are how the APU is wired up rather than what it's doing, and it
+	// puts them back itself on Restore.
+	state.pulse1.onesComplement = false
+	state.noise.periods = nil
+	state.dmc.rates = nil
+	state.dmc.read = nil
+}
+
+// Puts back a state from Save. Samples made since then that haven't been
+// collected are thrown away, so frames run and then undone stay silent.
+func (a *APU) Restore(state *State) {
+	a.cycle = state.cycle
+	a.pulse1 = state.pulse1
+	a.pulse1.onesComplement = true
+	a.pulse2 = state.pulse2
+	a.triangle = state.triangle
+	a.noise = state.noise
+	a.noise.periods = &a.timing.NoisePeriods
+	a.dmc = state.dmc
+	a.dmc.rates = &a.timing.DMCRates
+	a.dmc.read = a.cpu.MemRead
+	a.fiveStep = state.fiveStep
+	a.irqInhibit = state.irqInhibit
+	a.frameIRQ = state.frameIRQ
+	a.frameCycle = state.frameCycle
+	a.frameStep = state.frameStep
+	a.sampleClock = state.sampleClock
+	a.sum = state.sum
+	a.count = state.count
+	a.filter = state.filter
+	a.channelSums = state.channelSums
+	a.channelFilters = state.channelFilters
+	drop := func(samples []int16) []int16 {
+		return samples[:len(samples)-int(min(a.made-state.made, uint64(len(samples))))]
+	}
+	a.samples = drop(a.samples)
+	for i := range a.channelSamples {
+		a.channelSamples[i] = drop(a.channelSamples[i])
+	}
+	a.made = state.made
+}
@@ -0,0 +1,88 @@
+package apu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"switchtrue.com/hankee/cpu"
+)
+
+var ntsc = Timing{
+	CPUClock:          1789773,
+	FrameCounterSteps: [5]int{7457, 14913, 22371, 29829, 37281},
+	NoisePeriods:      [16]uint16{4, 8, 16, 32, 64, 96, 128, 160, 202, 254, 380, 508, 762, 1016, 2034, 4068},
+	DMCRates:          [16]uint16{428, 380, 340, 320, 286, 254, 226, 214, 190, 160, 142, 128, 106, 84, 72, 54},
+}
+
+func newAPU() (*APU, *cpu.CPU) {
+	c := cpu.NewCPU()
+	return New(ntsc, c), c
+}
+
+// Advances the CPU's clock without running anything.
+func wait(c *cpu.CPU, cycles uint64) {
+	c.SetCycles(c.Cycles() + cycles)
+}
+
+// Test that a pulse plays at the frequency its period gives
+func Test_APU_Pulse(t *testing.T) {
+	a, c := newAPU()
+	a.Write(0x4015, 0x01)
+	a.Write(0x4000, 0xBF) // 50% duty, halted, constant volume 15
+	// 1789773 / (16 * 440) - 1
+	a.Write(0x4002, 253)
+	a.Write(0x4003, 0)
+	wait(c, uint64(ntsc.CPUClock))
+
+	samples := a.Samples()
+	assert.InDelta(t, DEFAULT_SAMPLE_RATE, len(samples), 1)
+	crossings := 0
+	for i := 1; i < len(samples); i++ {
+		if samples[i-1] < 0 && samples[i] >= 0 {
+			crossings++
+		}
+	}
+	assert.InDelta(t, 440, crossings, 2)
+	assert.Empty(t, a.Samples(), "samples are only returned once")
+}
+
+// Test that length counters run out and the frame interrupt flag is raised
+func Test_APU_Status(t *testing.T) {
+	a, c := newAPU()
+	a.Write(0x4015, 0x0F)
+	a.Write(0x4000, 0x10)
+	a.Write(0x4003, 3<<3) // a length of 2 half frames
+	a.Write(0x400F, 0)    // a length of 10
+	assert.Equal(t, uint8(0x09), a.Read(0x4015))
+
+	wait(c, 29830)
+	assert.Equal(t, uint8(0x48), a.Read(0x4015), "pulse 1 ran out and the frame interrupt fired")
+	assert.Equal(t, uint8(0x08), a.Read(0x4015), "reading acknowledges the interrupt")
+
+	a.Write(0x4017, 0x40)
+	wait(c, 29830)
+	assert.Equal(t, uint8(0x08), a.Read(0x4015), "the interrupt is inhibited")
+	a.Write(0x4015, 0)
+	assert.Equal(t, uint8(0), a.Read(0x4015))
+}
+
+// Test that the DMC plays samples fetched from memory
+func Test_APU_DMC(t *testing.T) {
+	a, c := newAPU()
+	for addr := 0xC000; addr < 0xC011; addr++ {
+		c.MemWrite(uint16(addr), 0xFF)
+	}
+	a.Write(0x4010, 0x8F) // IRQ at the end, fastest rate
+	a.Write(0x4011, 0)
+	a.Write(0x4012, 0)
+	a.Write(0x4013, 1) // 17 bytes
+	a.Write(0x4015, 0x10)
+	require.Equal(t, uint8(0x10), a.Read(0x4015))
+
+	wait(c, 17*8*54+100)
+	a.Run()
+	assert.Equal(t, uint8(0x80), a.Read(0x4015), "finished with an interrupt")
+	// Every bit is a 1, so the level climbs by 2 each bit until it tops out.
+	assert.Equal(t, uint8(126), a.dmc.level)
+}
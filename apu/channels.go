@@ -0,0 +1,397 @@
+package apu
+
+// Length counter loads, indexed by the top five bits written to a channel's
+// fourth register.
+var lengthTable = [32]uint8{
+	10, 254, 20, 2, 40, 4, 80, 6, 160, 8, 60, 10, 14, 12, 26, 14,
+	12, 16, 24, 18, 48, 20, 96, 22, 192, 24, 72, 26, 16, 28, 32, 30,
+}
+
+// Pulse waveforms for each duty setting, 12.5%, 25%, 50% and 75% inverted.
+var dutyTable = [4][8]uint8{
+	{0, 1, 0, 0, 0, 0, 0, 0},
+	{0, 1, 1, 0, 0, 0, 0, 0},
+	{0, 1, 1, 1, 1, 0, 0, 0},
+	{1, 0, 0, 1, 1, 1, 1, 1},
+}
+
+var triangleTable = [32]uint8{
+	15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1, 0,
+	0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+}
+
+// envelope is the volume unit shared by the pulse and noise channels. It
+// either gives a constant volume or decays from 15 to 0, optionally looping.
+type envelope struct {
+	start    bool
+	loop     bool
+	constant bool
+	volume   uint8
+	divider  uint8
+	decay    uint8
+}
+
+func (e *envelope) write(data uint8) {
+	e.loop = data&0x20 != 0
+	e.constant = data&0x10 != 0
+	e.volume = data & 0x0F
+}
+
+// Clocked every quarter frame.
+func (e *envelope) clock() {
+	switch {
+	case e.start:
+		e.start = false
+		e.decay = 15
+		e.divider = e.volume
+	case e.divider > 0:
+		e.divider--
+	default:
+		e.divider = e.volume
+		if e.decay > 0 {
+			e.decay--
+		} else if e.loop {
+			e.decay = 15
+		}
+	}
+}
+
+func (e *envelope) output() uint8 {
+	if e.constant {
+		return e.volume
+	}
+	return e.decay
+}
+
+// lengthCounter silences a channel after a set number of half frames unless
+// halted.
+type lengthCounter struct {
+	enabled bool
+	halt    bool
+	value   uint8
+}
+
+func (l *lengthCounter) load(data uint8) {
+	if l.enabled {
+		l.value = lengthTable[data>>3]
+	}
+}
+
+func (l *lengthCounter) setEnabled(enabled bool) {
+	l.enabled = enabled
+	if !enabled {
+		l.value = 0
+	}
+}
+
+// Clocked every half frame.
+func (l *lengthCounter) clock() {
+	if !l.halt && l.value > 0 {
+		l.value--
+	}
+}
+
+type pulse struct {
+	// Pulse 1's sweep negates with one's complement, pulse 2's with two's.
+	onesComplement bool
+
+	length   lengthCounter
+	envelope envelope
+	duty     uint8
+	step     uint8
+	timer    uint16
+	period   uint16
+
+	sweepEnabled bool
+	sweepNegate  bool
+	sweepReload  bool
+	sweepPeriod  uint8
+	sweepShift   uint8
+	sweepDivider uint8
+}
+
+func (p *pulse) write(reg uint16, data uint8) {
+	switch reg {
+	case 0:
+		p.duty = data >> 6
+		p.length.halt = data&0x20 != 0
+		p.envelope.write(data)
+	case 1:
+		p.sweepEnabled = data&0x80 != 0
+		p.sweepPeriod = (data >> 4) & 7
+		p.sweepNegate = data&0x08 != 0
+		p.sweepShift = data & 7
+		p.sweepReload = true
+	case 2:
+		p.period = p.period&0x700 | uint16(data)
+	case 3:
+		p.period = p.period&0x0FF | uint16(data&7)<<8
+		p.length.load(data)
+		p.step = 0
+		p.envelope.start = true
+	}
+}
+
+// Clocked every APU cycle, every other CPU cycle.
+func (p *pulse) clockTimer() {
+	if p.timer == 0 {
+		p.timer = p.period
+		p.step = (p.step + 1) & 7
+	} else {
+		p.timer--
+	}
+}
+
+func (p *pulse) sweepTarget() int {
+	change := int(p.period >> p.sweepShift)
+	if p.sweepNegate {
+		change = -change
+		if p.onesComplement {
+			change--
+		}
+	}
+	return int(p.period) + change
+}
+
+// The sweep unit mutes the channel when the period is too short to hear or
+// would overflow, even if the sweep is disabled.
+func (p *pulse) muted() bool {
+	return p.period < 8 || p.sweepTarget() > 0x7FF
+}
+
+// Clocked every half frame.
+func (p *pulse) clockSweep() {
+	if p.sweepDivider == 0 && p.sweepEnabled && p.sweepShift > 0 && !p.muted() {
+		p.period = uint16(max(p.sweepTarget(), 0))
+	}
+	if p.sweepDivider == 0 || p.sweepReload {
+		p.sweepDivider = p.sweepPeriod
+		p.sweepReload = false
+	} else {
+		p.sweepDivider--
+	}
+}
+
+func (p *pulse) output() uint8 {
+	if p.length.value == 0 || p.muted() || dutyTable[p.duty][p.step] == 0 {
+		return 0
+	}
+	return p.envelope.output()
+}
+
+type triangle struct {
+	length       lengthCounter
+	timer        uint16
+	period       uint16
+	step         uint8
+	linear       uint8
+	linearReload uint8
+	reloadLinear bool
+	control      bool
+}
+
+func (t *triangle) write(reg uint16, data uint8) {
+	switch reg {
+	case 0:
+		t.control = data&0x80 != 0
+		t.length.halt = t.control
+		t.linearReload = data & 0x7F
+	case 2:
+		t.period = t.period&0x700 | uint16(data)
+	case 3:
+		t.period = t.period&0x0FF | uint16(data&7)<<8
+		t.length.load(data)
+		t.reloadLinear = true
+	}
+}
+
+// Clocked every CPU cycle.
+func (t *triangle) clockTimer() {
+	if t.timer == 0 {
+		t.timer = t.period
+		if t.length.value > 0 && t.linear > 0 {
+			t.step = (t.step + 1) & 31
+		}
+	} else {
+		t.timer--
+	}
+}
+
+// Clocked every quarter frame.
+func (t *triangle) clockLinear() {
+	if t.reloadLinear {
+		t.linear = t.linearReload
+	} else if t.linear > 0 {
+		t.linear--
+	}
+	if !t.control {
+		t.reloadLinear = false
+	}
+}
+
+func (t *triangle) output() uint8 {
+	// Games silence the triangle with ultrasonic periods, which real
+	// hardware filters out, so play the middle of the wave instead.
+	if t.period < 2 {
+		return 7
+	}
+	return triangleTable[t.step]
+}
+
+type noise struct {
+	periods  *[16]uint16
+	length   lengthCounter
+	envelope envelope
+	mode     bool
+	shift    uint16
+	timer    uint16
+	period   uint16
+}
+
+func (n *noise) write(reg uint16, data uint8) {
+	switch reg {
+	case 0:
+		n.length.halt = data&0x20 != 0
+		n.envelope.write(data)
+	case 2:
+		n.mode = data&0x80 != 0
+		n.period = n.periods[data&0x0F]
+	case 3:
+		n.length.load(data)
+		n.envelope.start = true
+	}
+}
+
+// Clocked every CPU cycle, the period table is in CPU cycles.
+func (n *noise) clockTimer() {
+	if n.timer > 0 {
+		n.timer--
+		return
+	}
+	n.timer = n.period - 1
+	tap := uint16(1)
+	if n.mode {
+		tap = 6
+	}
+	feedback := (n.shift ^ n.shift>>tap) & 1
+	n.shift = n.shift>>1 | feedback<<14
+}
+
+func (n *noise) output() uint8 {
+	if n.length.value == 0 || n.shift&1 != 0 {
+		return 0
+	}
+	return n.envelope.output()
+}
+
+// dmc plays 1 bit delta encoded samples fetched from CPU memory.
+type dmc struct {
+	rates *[16]uint16
+	read  func(addr uint16) uint8
+
+	irqEnabled bool
+	irq        bool
+	loop       bool
+	rate       uint16
+	timer      uint16
+	level      uint8
+
+	sampleAddress uint16
+	sampleLength  uint16
+	address       uint16
+	remaining     uint16
+
+	buffer      uint8
+	bufferEmpty bool
+	shift       uint8
+	bits        uint8
+	silence     bool
+}
+
+func (d *dmc) write(reg uint16, data uint8) {
+	switch reg {
+	case 0:
+		d.irqEnabled = data&0x80 != 0
+		if !d.irqEnabled {
+			d.irq = false
+		}
+		d.loop = data&0x40 != 0
+		d.rate = d.rates[data&0x0F]
+	case 1:
+		d.level = data & 0x7F
+	case 2:
+		d.sampleAddress = 0xC000 | uint16(data)<<6
+	case 3:
+		d.sampleLength = uint16(data)<<4 | 1
+	}
+}
+
+func (d *dmc) setEnabled(enabled bool) {
+	d.irq = false
+	if !enabled {
+		d.remaining = 0
+	} else if d.remaining == 0 {
+		d.restart()
+	}
+}
+
+func (d *dmc) restart() {
+	d.address = d.sampleAddress
+	d.remaining = d.sampleLength
+}
+
+// Clocked every CPU cycle, the rate table is in CPU cycles.
+func (d *dmc) clockTimer() {
+	if d.bufferEmpty && d.remaining > 0 {
+		d.fetch()
+	}
+	if d.timer > 0 {
+		d.timer--
+		return
+	}
+	d.timer = d.rate - 1
+
+	if !d.silence {
+		if d.shift&1 != 0 {
+			if d.level <= 125 {
+				d.level += 2
+			}
+		} else if d.level >= 2 {
+			d.level -= 2
+		}
+	}
+	d.shift >>= 1
+	if d.bits > 0 {
+		d.bits--
+	}
+	if d.bits == 0 {
+		d.bits = 8
+		d.silence = d.bufferEmpty
+		if !d.bufferEmpty {
+			d.shift = d.buffer
+			d.bufferEmpty = true
+		}
+	}
+}
+
+func (d *dmc) fetch() {
+	d.buffer = d.read(d.address)
+	d.bufferEmpty = false
+	// The address wraps around to $8000 rather than $0000.
+	d.address++
+	if d.address == 0 {
+		d.address = 0x8000
+	}
+	d.remaining--
+	if d.remaining == 0 {
+		if d.loop {
+			d.restart()
+		} else if d.irqEnabled {
+			d.irq = true
+		}
+	}
+}
+
+func (d *dmc) output() uint8 {
+	return d.level
+}
@@ -0,0 +1,97 @@
+package apu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Returns pointers to every field of the state that's saved, in the order
+// they're saved. The tables and memory the channels read from belong to the
+// APU rather than the state, so they're left out.
+func (s *State) fields() []any {
+	fields := []any{&s.cycle}
+	fields = append(fields, s.pulse1.fields()...)
+	fields = append(fields, s.pulse2.fields()...)
+	fields = append(fields, s.triangle.fields()...)
+	fields = append(fields, s.noise.fields()...)
+	fields = append(fields, s.dmc.fields()...)
+	fields = append(fields,
+		&s.fiveStep, &s.irqInhibit, &s.frameIRQ, &s.frameCycle, &s.frameStep,
+		&s.sampleClock, &s.sum, &s.count, &s.filter.in, &s.filter.out, &s.made)
+	for i := range s.channelSums {
+		fields = append(fields, &s.channelSums[i], &s.channelFilters[i].in, &s.channelFilters[i].out)
+	}
+	return fields
+}
+
+func (e *envelope) fields() []any {
+	return []any{&e.start, &e.loop, &e.constant, &e.volume, &e.divider, &e.decay}
+}
+
+func (l *lengthCounter) fields() []any {
+	return []any{&l.enabled, &l.halt, &l.value}
+}
+
+func (p *pulse) fields() []any {
+	fields := append(p.length.fields(), p.envelope.fields()...)
+	return append(fields, &p.duty, &p.step, &p.timer, &p.period,
+		&p.sweepEnabled, &p.sweepNegate, &p.sweepReload, &p.sweepPeriod, &p.sweepShift, &p.sweepDivider)
+}
+
+func (t *triangle) fields() []any {
+	return append(t.length.fields(), &t.timer, &t.period, &t.step, &t.linear, &t.linearReload, &t.reloadLinear, &t.control)
+}
+
+func (n *noise) fields() []any {
+	fields := append(n.length.fields(), n.envelope.fields()...)
+	return append(fields, &n.mode, &n.shift, &n.timer, &n.period)
+}
+
+func (d *dmc) fields() []any {
+	return []any{&d.irqEnabled, &d.irq, &d.loop, &d.rate, &d.timer, &d.level,
+		&d.sampleAddress, &d.sampleLength, &d.address, &d.remaining,
+		&d.buffer, &d.bufferEmpty, &d.shift, &d.bits, &d.silence}
+}
+
+// Encodes the state for a save state file.
+func (s *State) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	for _, field := range s.fields() {
+		// Counters that are ints in memory are saved as 64 bits.
+		if n, ok := field.(*int); ok {
+			field = int64(*n)
+		}
+		if err := binary.Write(&buf, binary.LittleEndian, field); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Decodes a state written by MarshalBinary.
+func (s *State) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	var decoded State
+	for _, field := range decoded.fields() {
+		var err error
+		if n, ok := field.(*int); ok {
+			var wide int64
+			err = binary.Read(r, binary.LittleEndian, &wide)
+			*n = int(wide)
+		} else {
+			err = binary.Read(r, binary.LittleEndian, field)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return errors.New("APU state is truncated")
+		} else if err != nil {
+			return err
+		}
+	}
+	if r.Len() != 0 {
+		return errors.New("APU state has trailing data")
+	}
+	*s = decoded
+	return nil
+}
@@ -0,0 +1,145 @@
+// Package audio records what the APU plays to WAV files or a raw PCM stream.
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"switchtrue.com/hankee/apu"
+	"switchtrue.com/hankee/wav"
+)
+
+// Options say where a recording goes.
+type Options struct {
+	// WAV file for the mixed output, or "-" for raw signed 16 bit little
+	// endian mono PCM on Stdout.
+	Path   string
+	Stdout io.Writer
+	// Also write each channel to its own WAV file, see ChannelPath.
+	Channels bool
+}
+
+// sink is somewhere samples go.
+type sink interface {
+	Write(samples []int16) error
+	Close() error
+}
+
+// Recorder collects samples from an APU and writes them out. Frontends call
+// Collect every frame or so and Close at the end.
+type Recorder struct {
+	apu      *apu.APU
+	mixed    sink
+	channels []sink
+}
+
+// Starts recording an APU.
+func Record(a *apu.APU, options Options) (*Recorder, error) {
+	r := &Recorder{apu: a}
+	if options.Path == "-" {
+		if options.Channels {
+			return nil, errors.New("recording each channel needs a WAV file, not stdout")
+		}
+		r.mixed = rawPCM{options.Stdout}
+	} else {
+		mixed, err := createWAV(options.Path, a.SampleRate())
+		if err != nil {
+			return nil, err
+		}
+		r.mixed = mixed
+	}
+
+	if options.Channels {
+		for _, name := range apu.CHANNEL_NAMES {
+			channel, err := createWAV(ChannelPath(options.Path, name), a.SampleRate())
+			if err != nil {
+				r.closeSinks()
+				return nil, err
+			}
+			r.channels = append(r.channels, channel)
+		}
+		a.SetChannelCapture(true)
+	}
+	// Start from now rather than with whatever was buffered.
+	a.Samples()
+	a.ChannelSamples()
+	return r, nil
+}
+
+// Returns where a channel is recorded, the mixed output's path with the
+// channel's name added, so track.wav has track-pulse1.wav alongside it.
+func ChannelPath(path string, channel string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + channel + ext
+}
+
+// Writes everything the APU has made since the last call.
+func (r *Recorder) Collect() error {
+	if err := r.mixed.Write(r.apu.Samples()); err != nil {
+		return err
+	}
+	if len(r.channels) == 0 {
+		return nil
+	}
+	for i, samples := range r.apu.ChannelSamples() {
+		if err := r.channels[i].Write(samples); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Collects the last samples and finishes the files.
+func (r *Recorder) Close() error {
+	err := r.Collect()
+	if len(r.channels) > 0 {
+		r.apu.SetChannelCapture(false)
+	}
+	return errors.Join(err, r.closeSinks())
+}
+
+func (r *Recorder) closeSinks() error {
+	errs := []error{r.mixed.Close()}
+	for _, channel := range r.channels {
+		errs = append(errs, channel.Close())
+	}
+	return errors.Join(errs...)
+}
+
+type wavFile struct {
+	*wav.Writer
+	file *os.File
+}
+
+func createWAV(path string, rate int) (*wavFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := wav.NewWriter(f, rate, 1)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &wavFile{Writer: w, file: f}, nil
+}
+
+func (f *wavFile) Close() error {
+	return errors.Join(f.Writer.Close(), f.file.Close())
+}
+
+type rawPCM struct {
+	w io.Writer
+}
+
+func (p rawPCM) Write(samples []int16) error {
+	return binary.Write(p.w, binary.LittleEndian, samples)
+}
+
+func (p rawPCM) Close() error {
+	return nil
+}
@@ -0,0 +1,83 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"switchtrue.com/hankee/apu"
+	"switchtrue.com/hankee/nes"
+	"switchtrue.com/hankee/wav"
+)
+
+// Plays a square wave on pulse 1 for a second.
+func play(m *nes.Machine, r *Recorder) error {
+	a := m.APU()
+	a.Write(0x4015, 0x01)
+	a.Write(0x4000, 0xBF)
+	a.Write(0x4002, 253)
+	a.Write(0x4003, 0)
+	c := m.CPU()
+	for i := 0; i < 60; i++ {
+		c.SetCycles(c.Cycles() + 29830)
+		if err := r.Collect(); err != nil {
+			return err
+		}
+	}
+	return r.Close()
+}
+
+// Test recording the mix and each channel to WAV files
+func Test_Record_WAV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "take.wav")
+	m := nes.New()
+	r, err := Record(m.APU(), Options{Path: path, Channels: true})
+	require.NoError(t, err)
+	require.NoError(t, play(m, r))
+
+	mixed, err := os.ReadFile(path)
+	require.NoError(t, err)
+	samples := (len(mixed) - wav.HEADER_SIZE) / 2
+	assert.InDelta(t, 44100, samples, 50)
+	for _, name := range apu.CHANNEL_NAMES {
+		channel, err := os.ReadFile(ChannelPath(path, name))
+		require.NoError(t, err, name)
+		assert.Equal(t, len(mixed), len(channel), "%s is in step with the mix", name)
+		// The idle triangle's DC level takes a moment to settle out of the
+		// high pass, so only the second half is checked.
+		loudest := 0
+		for i := wav.HEADER_SIZE + samples/2*2; i+1 < len(channel); i += 2 {
+			loudest = max(loudest, abs(int(int16(binary.LittleEndian.Uint16(channel[i:])))))
+		}
+		if name == "pulse1" {
+			assert.Greater(t, loudest, 1000, "pulse 1 is playing")
+		} else {
+			assert.Zero(t, loudest, "%s is silent", name)
+		}
+	}
+	assert.Equal(t, "/tmp/take-dmc.wav", ChannelPath("/tmp/take.wav", "dmc"))
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Test streaming raw PCM
+func Test_Record_Raw(t *testing.T) {
+	var out bytes.Buffer
+	m := nes.New()
+	r, err := Record(m.APU(), Options{Path: "-", Stdout: &out})
+	require.NoError(t, err)
+	require.NoError(t, play(m, r))
+	assert.InDelta(t, 2*44100, out.Len(), 100)
+
+	_, err = Record(m.APU(), Options{Path: "-", Stdout: &out, Channels: true})
+	assert.Error(t, err)
+}
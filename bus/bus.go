@@ -0,0 +1,132 @@
+package bus
+
+import "switchtrue.com/hankee/cartridge"
+
+// CPU memory map, the left side shows the detailed layout and the right side
+// the broad regions.
+//
+//	 _______________ $10000  _______________
+//	| PRG-ROM       |       |               |
+//	| Upper Bank    |       |               |
+//	|_ _ _ _ _ _ _ _| $C000 | PRG-ROM       |
+//	| PRG-ROM       |       |               |
+//	| Lower Bank    |       |               |
+//	|_______________| $8000 |_______________|
+//	| SRAM          |       | SRAM          |
+//	|_______________| $6000 |_______________|
+//	| Expansion ROM |       | Expansion ROM |
+//	|_______________| $4020 |_______________|
+//	| I/O Registers |       |               |
+//	|_ _ _ _ _ _ _ _| $4000 |               |
+//	| Mirrors       |       | I/O Registers |
+//	| $2000-$2007   |       |               |
+//	|_ _ _ _ _ _ _ _| $2008 |               |
+//	| I/O Registers |       |               |
+//	|_______________| $2000 |_______________|
+//	| Mirrors       |       |               |
+//	| $0000-$07FF   |       |               |
+//	|_ _ _ _ _ _ _ _| $0800 |               |
+//	| RAM           |       | RAM           |
+//	|_ _ _ _ _ _ _ _| $0200 |               |
+//	| Stack         |       |               |
+//	|_ _ _ _ _ _ _ _| $0100 |               |
+//	| Zero Page     |       |               |
+//	|_______________| $0000 |_______________|
+const (
+	RAM                 uint16 = 0x0000
+	RAM_MIRRORS_END     uint16 = 0x1FFF
+	RAM_SIZE                   = 0x0800
+	CARTRIDGE_SPACE     uint16 = 0x4020
+	CARTRIDGE_SPACE_END uint16 = 0xFFFF
+)
+
+// Device is anything that can be attached to a range of the CPU address space,
+// such as PPU or APU registers or a controller port.
+type Device interface {
+	Read(addr uint16) uint8
+	Write(addr uint16, data uint8)
+}
+
+type mapping struct {
+	start  uint16
+	end    uint16
+	device Device
+}
+
+// Bus routes CPU reads and writes to the 2KB of work RAM, attached devices and
+// the cartridge.
+type Bus struct {
+	ram       [RAM_SIZE]uint8
+	devices   []mapping
+	cartridge *cartridge.Cartridge
+}
+
+func New() *Bus {
+	return &Bus{}
+}
+
+// Attaches a device to the inclusive address range start-end. Devices attached
+// later take priority over earlier ones where ranges overlap, and all devices
+// take priority over the cartridge.
+func (bus *Bus) Attach(start uint16, end uint16, device Device) {
+	bus.devices = append(bus.devices, mapping{start, end, device})
+}
+
+// Plugs a cartridge into the bus, replacing any cartridge already inserted.
+func (bus *Bus) InsertCartridge(cart *cartridge.Cartridge) {
+	bus.cartridge = cart
+}
+
+// Returns the inserted cartridge, or nil if there isn't one.
+func (bus *Bus) Cartridge() *cartridge.Cartridge {
+	return bus.cartridge
+}
+
+// Returns the 2KB of work RAM. The slice aliases the bus memory.
+func (bus *Bus) RAM() []uint8 {
+	return bus.ram[:]
+}
+
+func (bus *Bus) Read(addr uint16) uint8 {
+	if addr <= RAM_MIRRORS_END {
+		// RAM is 2KB but addressed with 13 bits, so the top two bits are
+		// dropped to mirror it through $0000-$1FFF.
+		return bus.ram[addr&0b0000_0111_1111_1111]
+	}
+
+	if device := bus.deviceFor(addr); device != nil {
+		return device.Read(addr)
+	}
+
+	if addr >= CARTRIDGE_SPACE && bus.cartridge != nil {
+		return bus.cartridge.Read(addr)
+	}
+
+	return 0
+}
+
+func (bus *Bus) Write(addr uint16, data uint8) {
+	if addr <= RAM_MIRRORS_END {
+		bus.ram[addr&0b0000_0111_1111_1111] = data
+		return
+	}
+
+	if device := bus.deviceFor(addr); device != nil {
+		device.Write(addr, data)
+		return
+	}
+
+	if addr >= CARTRIDGE_SPACE && bus.cartridge != nil {
+		bus.cartridge.Write(addr, data)
+	}
+}
+
+func (bus *Bus) deviceFor(addr uint16) Device {
+	for i := len(bus.devices) - 1; i >= 0; i-- {
+		m := bus.devices[i]
+		if addr >= m.start && addr <= m.end {
+			return m.device
+		}
+	}
+	return nil
+}
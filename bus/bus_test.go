@@ -0,0 +1,49 @@
+package bus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type register struct {
+	value uint8
+}
+
+func (r *register) Read(addr uint16) uint8 {
+	return r.value
+}
+
+func (r *register) Write(addr uint16, data uint8) {
+	r.value = data
+}
+
+// Test that work RAM is mirrored every 2KB up to $1FFF
+func Test_Bus_RAMMirroring(t *testing.T) {
+	bus := New()
+	bus.Write(0x0001, 0x42)
+	assert.Equal(t, uint8(0x42), bus.Read(0x0801))
+	assert.Equal(t, uint8(0x42), bus.Read(0x1801))
+}
+
+// Test that reads and writes in an attached range go to the device
+func Test_Bus_AttachDevice(t *testing.T) {
+	bus := New()
+	device := &register{}
+	bus.Attach(0x4016, 0x4016, device)
+	bus.Write(0x4016, 0x01)
+	assert.Equal(t, uint8(0x01), device.value)
+	assert.Equal(t, uint8(0x01), bus.Read(0x4016))
+	assert.Equal(t, uint8(0x00), bus.Read(0x4017))
+}
+
+// Test that a later device shadows an earlier one in overlapping ranges
+func Test_Bus_AttachDevice_Overlap(t *testing.T) {
+	bus := New()
+	first := &register{value: 1}
+	second := &register{value: 2}
+	bus.Attach(0x4000, 0x401F, first)
+	bus.Attach(0x4016, 0x4017, second)
+	assert.Equal(t, uint8(2), bus.Read(0x4016))
+	assert.Equal(t, uint8(1), bus.Read(0x4015))
+}
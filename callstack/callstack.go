@@ -0,0 +1,76 @@
+// Package callstack rebuilds the chain of subroutine calls from JSR and RTS,
+// since the 6502 stack only holds bare return addresses.
+package callstack
+
+import "switchtrue.com/hankee/cpu"
+
+const (
+	OPCODE_JSR = 0x20
+	OPCODE_RTS = 0x60
+)
+
+// Frame is a subroutine call that hasn't returned yet.
+type Frame struct {
+	// Address of the JSR instruction.
+	Caller uint16
+	// Address of the subroutine that was called.
+	Target uint16
+	// Stack pointer before the JSR pushed the return address.
+	SP uint8
+}
+
+// Returns the address execution continues from once the call returns.
+func (f Frame) Return() uint16 {
+	return f.Caller + 3
+}
+
+// Tracker follows JSR and RTS to keep a call stack. It has to see every
+// instruction before it executes.
+type Tracker struct {
+	frames []Frame
+}
+
+// Updates the call stack for the instruction the CPU is about to execute.
+//
+// Frames are matched against the stack pointer rather than simply pushed and
+// popped, so code that drops a return address or jumps through an RTS trick
+// leaves the stack in a sensible state once it returns to a shallower depth.
+func (t *Tracker) Before(c *cpu.CPU) {
+	registers := c.Registers()
+	switch c.MemRead(registers.PC) {
+	case OPCODE_JSR:
+		t.unwind(int(registers.SP))
+		t.frames = append(t.frames, Frame{
+			Caller: registers.PC,
+			Target: c.MemReadUInt16(registers.PC + 1),
+			SP:     registers.SP,
+		})
+	case OPCODE_RTS:
+		// The return address sits just above the stack pointer, so the call
+		// being returned from is the one made with the stack two bytes higher.
+		t.unwind(int(registers.SP) + 2)
+	}
+}
+
+// Drops every frame made with the stack pointer at or below sp.
+func (t *Tracker) unwind(sp int) {
+	for len(t.frames) > 0 && int(t.frames[len(t.frames)-1].SP) <= sp {
+		t.frames = t.frames[:len(t.frames)-1]
+	}
+}
+
+// Returns the calls in progress, outermost first. The slice must not be
+// modified.
+func (t *Tracker) Frames() []Frame {
+	return t.frames
+}
+
+// Returns the number of calls in progress.
+func (t *Tracker) Depth() int {
+	return len(t.frames)
+}
+
+// Forgets all calls, for when the CPU is reset.
+func (t *Tracker) Reset() {
+	t.frames = t.frames[:0]
+}
@@ -0,0 +1,57 @@
+package callstack
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"switchtrue.com/hankee/cpu"
+)
+
+func run(program []uint8) []int {
+	c := cpu.NewCPU()
+	c.LoadAt(0x0600, program)
+	c.Reset()
+
+	var tracker Tracker
+	var depths []int
+	c.RunWithCallback(func(c *cpu.CPU) bool {
+		tracker.Before(c)
+		depths = append(depths, tracker.Depth())
+		return true
+	})
+	return depths
+}
+
+// Test that nested calls push frames and returns pop them
+func Test_Tracker_Nested(t *testing.T) {
+	c := cpu.NewCPU()
+	// JSR $0604; BRK; JSR $0608; RTS; NOP; RTS
+	c.LoadAt(0x0600, []uint8{0x20, 0x04, 0x06, 0x00, 0x20, 0x08, 0x06, 0x60, 0xea, 0x60})
+	c.Reset()
+
+	var tracker Tracker
+	var stacks [][]Frame
+	c.RunWithCallback(func(c *cpu.CPU) bool {
+		tracker.Before(c)
+		stacks = append(stacks, append([]Frame(nil), tracker.Frames()...))
+		return true
+	})
+
+	outer := Frame{Caller: 0x0600, Target: 0x0604, SP: 0xFD}
+	inner := Frame{Caller: 0x0604, Target: 0x0608, SP: 0xFB}
+	assert.Len(t, stacks, 6)
+	assert.Equal(t, []Frame{outer}, stacks[0])
+	assert.Equal(t, []Frame{outer, inner}, stacks[1])
+	assert.Equal(t, []Frame{outer, inner}, stacks[2])
+	assert.Equal(t, []Frame{outer}, stacks[3])
+	assert.Empty(t, stacks[4])
+	assert.Empty(t, stacks[5])
+	assert.Equal(t, uint16(0x0603), outer.Return())
+}
+
+// Test that a routine dropping its return address doesn't leave a frame behind
+func Test_Tracker_DroppedReturn(t *testing.T) {
+	// JSR $0604; BRK; PLA; PLA; JSR $060A; BRK; RTS
+	depths := run([]uint8{0x20, 0x04, 0x06, 0x00, 0x68, 0x68, 0x20, 0x0A, 0x06, 0x00, 0x60})
+	assert.Equal(t, []int{1, 1, 1, 1, 0, 0}, depths)
+}
@@ -0,0 +1,179 @@
+// Package capture records what a machine shows to an animated GIF or a
+// YUV4MPEG2 stream, with its sound alongside as WAV, for attaching to bug
+// reports or piping into a video encoder.
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"switchtrue.com/hankee/audio"
+	"switchtrue.com/hankee/nes"
+	"switchtrue.com/hankee/video"
+)
+
+// Format is a kind of video file.
+type Format int
+
+const (
+	// Picks the format from the file's extension.
+	Auto Format = iota
+	GIFFormat
+	Y4MFormat
+)
+
+var formatNames = []string{"auto", "gif", "y4m"}
+
+func (f Format) String() string {
+	if int(f) < len(formatNames) {
+		return formatNames[f]
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// Parses a format name, auto, gif or y4m.
+func ParseFormat(name string) (Format, error) {
+	for i, n := range formatNames {
+		if strings.EqualFold(n, name) {
+			return Format(i), nil
+		}
+	}
+	return Auto, fmt.Errorf("unknown capture format %q, expected one of %s", name, strings.Join(formatNames, ", "))
+}
+
+// Returns the format for a path from its extension, Y4M for stdout.
+func FormatFor(path string) (Format, error) {
+	switch ext := strings.ToLower(filepath.Ext(path)); {
+	case path == "-", ext == ".y4m":
+		return Y4MFormat, nil
+	case ext == ".gif":
+		return GIFFormat, nil
+	default:
+		return Auto, fmt.Errorf("can't tell the capture format of %s, use a .gif or .y4m file", path)
+	}
+}
+
+// Returns the WAV file that goes alongside a capture, game.gif has game.wav.
+func AudioPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".wav"
+}
+
+// Options say where a capture goes.
+type Options struct {
+	// File to write, or "-" for Stdout.
+	Path   string
+	Format Format
+	Stdout io.Writer
+	// WAV file to record the sound to, or "" for no sound.
+	Audio string
+	// Colours for the GIF and Y4M, video.DefaultPalette if nil.
+	Palette *video.Palette
+}
+
+// sink is somewhere frames go.
+type sink interface {
+	WriteFrame(f *video.Frame) error
+	Close() error
+}
+
+// Capture is a recording in progress. Frontends call Frame at the end of each
+// of the machine's frames and Stop when they're done.
+type Capture struct {
+	machine *nes.Machine
+	path    string
+	video   sink
+	file    *os.File
+	audio   *audio.Recorder
+	frames  int
+}
+
+// Starts capturing a machine's frames.
+func Start(m *nes.Machine, options Options) (*Capture, error) {
+	format := options.Format
+	if format == Auto {
+		var err error
+		if format, err = FormatFor(options.Path); err != nil {
+			return nil, err
+		}
+	}
+	if options.Path == "-" && options.Audio == "-" {
+		return nil, errors.New("the picture and sound can't both go to stdout")
+	}
+	palette := options.Palette
+	if palette == nil {
+		palette = &video.DefaultPalette
+	}
+
+	c := &Capture{machine: m, path: options.Path}
+	w := options.Stdout
+	if options.Path != "-" {
+		f, err := os.Create(options.Path)
+		if err != nil {
+			return nil, err
+		}
+		c.file, w = f, f
+	}
+	timing := m.Timing()
+	switch format {
+	case GIFFormat:
+		c.video = NewGIF(w, palette, timing.FrameRate())
+	case Y4MFormat:
+		c.video = NewY4M(w, palette, uint64(timing.MasterClock), timing.FrameClocks())
+	default:
+		c.closeFile()
+		return nil, fmt.Errorf("unknown capture format %v", format)
+	}
+
+	if options.Audio != "" {
+		recorder, err := audio.Record(m.APU(), audio.Options{Path: options.Audio, Stdout: options.Stdout})
+		if err != nil {
+			c.closeFile()
+			return nil, err
+		}
+		c.audio = recorder
+	}
+	return c, nil
+}
+
+// Returns where the capture is going.
+func (c *Capture) Path() string {
+	return c.path
+}
+
+// Returns how many frames have been captured.
+func (c *Capture) Frames() int {
+	return c.frames
+}
+
+// Adds the machine's current picture and the sound since the last frame.
+func (c *Capture) Frame() error {
+	if err := c.video.WriteFrame(c.machine.Frame()); err != nil {
+		return err
+	}
+	c.frames++
+	if c.audio != nil {
+		return c.audio.Collect()
+	}
+	return nil
+}
+
+// Finishes the capture and closes its files.
+func (c *Capture) Stop() error {
+	errs := []error{c.video.Close()}
+	if c.audio != nil {
+		errs = append(errs, c.audio.Close())
+	}
+	errs = append(errs, c.closeFile())
+	return errors.Join(errs...)
+}
+
+func (c *Capture) closeFile() error {
+	if c.file == nil {
+		return nil
+	}
+	return c.file.Close()
+}
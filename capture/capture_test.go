@@ -0,0 +1,124 @@
+package capture
+
+import (
+	"bytes"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"switchtrue.com/hankee/nes"
+	"switchtrue.com/hankee/video"
+	"switchtrue.com/hankee/wav"
+)
+
+// Returns frames with a square moving across them.
+func moving(n int) []*video.Frame {
+	var frames []*video.Frame
+	for i := 0; i < n; i++ {
+		f := video.NewFrame(video.WIDTH, video.HEIGHT)
+		f.Fill(0x0F)
+		for y := 100; y < 110; y++ {
+			for x := 10 * i; x < 10*i+10; x++ {
+				f.Set(x, y, 0x16)
+			}
+		}
+		frames = append(frames, f)
+	}
+	return frames
+}
+
+// Test that GIFs decode to the palette's colours at a rate browsers accept
+func Test_GIF(t *testing.T) {
+	var out bytes.Buffer
+	g := NewGIF(&out, &video.DefaultPalette, nes.NTSC.Timing().FrameRate())
+	frames := moving(8)
+	for _, f := range frames {
+		require.NoError(t, g.WriteFrame(f))
+	}
+	require.NoError(t, g.Close())
+
+	decoded, err := gif.DecodeAll(&out)
+	require.NoError(t, err)
+	require.Len(t, decoded.Image, 4, "every other frame at 60fps")
+	assert.Equal(t, []int{3, 4, 3, 3}, decoded.Delay)
+	assert.Equal(t, 256, decoded.Config.Width)
+	// Later frames are only the square's old and new place.
+	assert.Equal(t, 30, decoded.Image[1].Bounds().Dx())
+	assert.Equal(t, 10, decoded.Image[1].Bounds().Dy())
+	assert.Equal(t, video.DefaultPalette.Color(0x16), decoded.Image[3].At(65, 105))
+	assert.Equal(t, video.DefaultPalette.Color(0x0F), decoded.Image[0].At(0, 0))
+}
+
+// Test that emphasised colours get a table of their own
+func Test_GIF_Emphasis(t *testing.T) {
+	var out bytes.Buffer
+	g := NewGIF(&out, &video.DefaultPalette, 50)
+	f := video.NewFrame(4, 1)
+	f.Pix = []uint16{0x30, video.Pixel(0x30, 0x20), video.Pixel(0x16, 0xC0), 0x16}
+	require.NoError(t, g.WriteFrame(f))
+	require.NoError(t, g.Close())
+
+	decoded, err := gif.DecodeAll(&out)
+	require.NoError(t, err)
+	for x, pixel := range f.Pix {
+		assert.Equal(t, video.DefaultPalette.Color(pixel), decoded.Image[0].At(x, 0))
+	}
+}
+
+// Test the YUV4MPEG2 header and frame layout
+func Test_Y4M(t *testing.T) {
+	var out bytes.Buffer
+	timing := nes.NTSC.Timing()
+	y := NewY4M(&out, &video.DefaultPalette, uint64(timing.MasterClock), timing.FrameClocks())
+	for _, f := range moving(2) {
+		require.NoError(t, y.WriteFrame(f))
+	}
+	require.NoError(t, y.Close())
+
+	header, rest, ok := bytes.Cut(out.Bytes(), []byte("\n"))
+	require.True(t, ok)
+	assert.Equal(t, "YUV4MPEG2 W256 H240 F10738636:178683 Ip A1:1 C444", string(header))
+	frameSize := len("FRAME\n") + 3*video.WIDTH*video.HEIGHT
+	require.Len(t, rest, 2*frameSize)
+	assert.Equal(t, "FRAME\n", string(rest[frameSize:frameSize+6]))
+	// Black and white come out at the ends of the limited range.
+	white := video.NewFrame(1, 1)
+	white.Fill(0x30)
+	out.Reset()
+	y = NewY4M(&out, &video.DefaultPalette, 60, 1)
+	require.NoError(t, y.WriteFrame(white))
+	require.NoError(t, y.Close())
+	assert.Equal(t, []byte{235, 128, 128}, out.Bytes()[out.Len()-3:])
+}
+
+// Test capturing a machine with its sound to files
+func Test_Start(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "bug.gif")
+	m := nes.New()
+	_, err := Start(m, Options{Path: filepath.Join(dir, "bug.mp4")})
+	assert.Error(t, err)
+
+	c, err := Start(m, Options{Path: path, Audio: AudioPath(path)})
+	require.NoError(t, err)
+	for i := 0; i < 30; i++ {
+		m.CPU().SetCycles(m.FrameEnd())
+		m.EndFrame()
+		require.NoError(t, c.Frame())
+	}
+	assert.Equal(t, 30, c.Frames())
+	require.NoError(t, c.Stop())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	decoded, err := gif.DecodeAll(f)
+	require.NoError(t, err)
+	assert.Len(t, decoded.Image, 15)
+	sound, err := os.ReadFile(filepath.Join(dir, "bug.wav"))
+	require.NoError(t, err)
+	assert.InDelta(t, 44100/2, (len(sound)-wav.HEADER_SIZE)/2, 800)
+}
@@ -0,0 +1,249 @@
+package capture
+
+import (
+	"bufio"
+	"compress/lzw"
+	"errors"
+	"io"
+	"math"
+
+	"switchtrue.com/hankee/video"
+)
+
+// Shortest delay between GIF frames in hundredths of a second. Browsers slow
+// anything quicker right down, so fast frame rates drop frames instead.
+const MIN_GIF_DELAY = 2
+
+// Bits per pixel in the GIF, enough for the 64 colour palette.
+const GIF_COLOUR_BITS = 6
+
+// GIF streams frames into an animated GIF. Its colour table is the NES
+// palette, so pixels are written as palette indexes without any quantisation,
+// and each frame only holds the rectangle that changed since the last one.
+// Frames using emphasis get a table of their own with room for 192 emphasised
+// colours, more than a frame has in practice. Should one have more, the rest
+// lose their emphasis.
+type GIF struct {
+	w       *bufio.Writer
+	palette *video.Palette
+	// Frames of the source for every frame in the GIF.
+	step      int
+	frameRate float64
+	frames    int
+	written   int
+	previous  []uint16
+	width     int
+	height    int
+	err       error
+}
+
+// Starts an animated GIF of frames arriving frameRate times a second.
+func NewGIF(w io.Writer, palette *video.Palette, frameRate float64) *GIF {
+	return &GIF{
+		w:         bufio.NewWriter(w),
+		palette:   palette,
+		step:      max(1, int(math.Ceil(frameRate*MIN_GIF_DELAY/100-0.01))),
+		frameRate: frameRate,
+	}
+}
+
+// Writes the header the first time there's a frame to size it from.
+func (g *GIF) writeHeader(width int, height int) {
+	g.width, g.height = width, height
+	header := []uint8{
+		'G', 'I', 'F', '8', '9', 'a',
+		uint8(width), uint8(width >> 8), uint8(height), uint8(height >> 8),
+		// A global colour table of 2^6 entries.
+		0x80 | (GIF_COLOUR_BITS-1)<<4 | (GIF_COLOUR_BITS - 1),
+		0, 0,
+	}
+	for _, c := range g.palette.Base() {
+		header = append(header, c.R, c.G, c.B)
+	}
+	// Loop forever.
+	header = append(header, 0x21, 0xFF, 11)
+	header = append(header, "NETSCAPE2.0"...)
+	header = append(header, 3, 1, 0, 0, 0)
+	g.w.Write(header)
+}
+
+// Adds a frame, unless it falls between the GIF's frames.
+func (g *GIF) WriteFrame(f *video.Frame) error {
+	if g.err != nil {
+		return g.err
+	}
+	g.frames++
+	if (g.frames-1)%g.step != 0 {
+		return nil
+	}
+	if g.previous == nil {
+		g.writeHeader(f.Width, f.Height)
+		g.previous = make([]uint16, f.Width*f.Height)
+	} else if f.Width != g.width || f.Height != g.height {
+		g.err = errors.New("frames changed size part way through the GIF")
+		return g.err
+	}
+
+	pix := make([]uint16, len(f.Pix))
+	emphasised := false
+	for i, index := range f.Pix {
+		pix[i] = index & 0x1FF
+		emphasised = emphasised || pix[i] > 0x3F
+	}
+	left, top, right, bottom := 0, 0, f.Width, f.Height
+	if g.written > 0 {
+		left, top, right, bottom = changed(g.previous, pix, f.Width)
+	}
+	copy(g.previous, pix)
+
+	rect := make([]uint16, 0, (right-left)*(bottom-top))
+	for y := top; y < bottom; y++ {
+		rect = append(rect, pix[y*f.Width+left:y*f.Width+right]...)
+	}
+	bits := GIF_COLOUR_BITS
+	var local, indexes []uint8
+	if emphasised {
+		bits = 8
+		local, indexes = g.localTable(rect)
+	} else {
+		indexes = make([]uint8, len(rect))
+		for i, p := range rect {
+			indexes[i] = uint8(p)
+		}
+	}
+
+	// The delay is rounded from where the frame starts and ends so the
+	// rounding doesn't add up.
+	start := math.Round(float64(100*g.written*g.step) / g.frameRate)
+	end := math.Round(float64(100*(g.written+1)*g.step) / g.frameRate)
+	delay := int(end - start)
+	g.written++
+
+	// A graphic control extension leaving the frame in place for the next
+	// one to draw over.
+	g.w.Write([]uint8{0x21, 0xF9, 4, 1 << 2, uint8(delay), uint8(delay >> 8), 0, 0})
+	flags := uint8(0)
+	if local != nil {
+		flags = 0x80 | uint8(bits-1)
+	}
+	g.w.Write([]uint8{
+		0x2C,
+		uint8(left), uint8(left >> 8), uint8(top), uint8(top >> 8),
+		uint8(right - left), uint8((right - left) >> 8), uint8(bottom - top), uint8((bottom - top) >> 8),
+		flags,
+	})
+	g.w.Write(local)
+	g.w.WriteByte(uint8(bits))
+	blocks := &blockWriter{w: g.w}
+	lw := lzw.NewWriter(blocks, lzw.LSB, bits)
+	if _, err := lw.Write(indexes); err != nil {
+		g.err = err
+		return err
+	}
+	if err := lw.Close(); err != nil {
+		g.err = err
+		return err
+	}
+	if err := blocks.close(); err != nil {
+		g.err = err
+	}
+	return g.err
+}
+
+// Returns a local colour table for a frame using emphasis and the changed
+// pixels' indexes into it. The base colours keep their usual places so there's
+// always somewhere to fall back to once the other 192 are used up.
+func (g *GIF) localTable(changed []uint16) ([]uint8, []uint8) {
+	table := make([]uint8, 0, 256*3)
+	for _, c := range g.palette.Base() {
+		table = append(table, c.R, c.G, c.B)
+	}
+	slots := map[uint16]uint8{}
+	indexes := make([]uint8, len(changed))
+	for i, p := range changed {
+		slot, ok := slots[p]
+		switch {
+		case p <= 0x3F:
+			slot = uint8(p)
+		case ok:
+		case len(table) < cap(table):
+			slot = uint8(len(table) / 3)
+			slots[p] = slot
+			c := g.palette.Color(p)
+			table = append(table, c.R, c.G, c.B)
+		default:
+			slot = uint8(p & 0x3F)
+		}
+		indexes[i] = slot
+	}
+	return table[:cap(table)], indexes
+}
+
+// Returns the rectangle of pixels that differ between two frames. Unchanged
+// frames still need a pixel, so get the top left one.
+func changed(previous []uint16, pix []uint16, width int) (left int, top int, right int, bottom int) {
+	height := len(pix) / width
+	left, top, right, bottom = width, height, 0, 0
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if previous[y*width+x] != pix[y*width+x] {
+				left, right = min(left, x), max(right, x+1)
+				top, bottom = min(top, y), max(bottom, y+1)
+			}
+		}
+	}
+	if right == 0 {
+		return 0, 0, 1, 1
+	}
+	return left, top, right, bottom
+}
+
+// Finishes the GIF. The writer underneath is left open.
+func (g *GIF) Close() error {
+	if g.err != nil {
+		return g.err
+	}
+	if g.previous == nil {
+		return errors.New("no frames were captured for the GIF")
+	}
+	g.w.WriteByte(0x3B)
+	return g.w.Flush()
+}
+
+// blockWriter splits image data into the length prefixed blocks of up to 255
+// bytes that GIF wants.
+type blockWriter struct {
+	w     *bufio.Writer
+	block [255]uint8
+	n     int
+}
+
+func (b *blockWriter) Write(data []uint8) (int, error) {
+	for i, c := range data {
+		b.block[b.n] = c
+		if b.n++; b.n == len(b.block) {
+			if err := b.flush(); err != nil {
+				return i, err
+			}
+		}
+	}
+	return len(data), nil
+}
+
+func (b *blockWriter) flush() error {
+	if b.n == 0 {
+		return nil
+	}
+	b.w.WriteByte(uint8(b.n))
+	_, err := b.w.Write(b.block[:b.n])
+	b.n = 0
+	return err
+}
+
+// Writes what's left and the empty block ending the image.
+func (b *blockWriter) close() error {
+	if err := b.flush(); err != nil {
+		return err
+	}
+	return b.w.WriteByte(0)
+}
@@ -0,0 +1,86 @@
+package capture
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+
+	"switchtrue.com/hankee/video"
+)
+
+// Y4M streams frames as YUV4MPEG2, the uncompressed format encoders like
+// ffmpeg read from a pipe. Chroma isn't subsampled, so every pixel keeps its
+// exact palette colour, emphasis included, apart from the rounding into YCbCr.
+type Y4M struct {
+	w      *bufio.Writer
+	yuv    [512][3]uint8
+	num    uint64
+	den    uint64
+	frames int
+	width  int
+	height int
+	plane  []uint8
+	err    error
+}
+
+// Starts a stream at a frame rate of num/den frames a second.
+func NewY4M(w io.Writer, palette *video.Palette, num uint64, den uint64) *Y4M {
+	y := &Y4M{w: bufio.NewWriter(w), num: num, den: den}
+	if d := gcd(num, den); d > 1 {
+		y.num, y.den = num/d, den/d
+	}
+	for i, c := range palette {
+		r, g, b := float64(c.R), float64(c.G), float64(c.B)
+		// BT.601 limited range, what players assume without being told.
+		y.yuv[i] = [3]uint8{
+			uint8(16 + 0.257*r + 0.504*g + 0.098*b + 0.5),
+			uint8(128 - 0.148*r - 0.291*g + 0.439*b + 0.5),
+			uint8(128 + 0.439*r - 0.368*g - 0.071*b + 0.5),
+		}
+	}
+	return y
+}
+
+func gcd(a uint64, b uint64) uint64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// Adds a frame to the stream.
+func (y *Y4M) WriteFrame(f *video.Frame) error {
+	if y.err != nil {
+		return y.err
+	}
+	if y.frames == 0 {
+		y.width, y.height = f.Width, f.Height
+		y.plane = make([]uint8, f.Width*f.Height)
+		fmt.Fprintf(y.w, "YUV4MPEG2 W%d H%d F%d:%d Ip A1:1 C444\n", f.Width, f.Height, y.num, y.den)
+	} else if f.Width != y.width || f.Height != y.height {
+		y.err = errors.New("frames changed size part way through the stream")
+		return y.err
+	}
+	y.frames++
+
+	y.w.WriteString("FRAME\n")
+	for component := 0; component < 3; component++ {
+		for i, index := range f.Pix {
+			y.plane[i] = y.yuv[index&0x1FF][component]
+		}
+		if _, err := y.w.Write(y.plane); err != nil {
+			y.err = err
+			return err
+		}
+	}
+	return nil
+}
+
+// Flushes the stream. The writer underneath is left open.
+func (y *Y4M) Close() error {
+	if y.err != nil {
+		return y.err
+	}
+	return y.w.Flush()
+}
@@ -0,0 +1,100 @@
+package cartridge
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Returns where a ROM's battery save lives, the ROM's file name with a .sav
+// extension in dir, or next to the ROM if dir is empty.
+func SavePath(romPath string, dir string) string {
+	name := strings.TrimSuffix(filepath.Base(romPath), filepath.Ext(romPath)) + ".sav"
+	if dir == "" {
+		dir = filepath.Dir(romPath)
+	}
+	return filepath.Join(dir, name)
+}
+
+// Loads PRG RAM from a save file. A missing file isn't an error, it's a new
+// game.
+func (c *Cartridge) LoadSave(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(data) != len(c.PRGRAM) {
+		return fmt.Errorf("%s: save is %d bytes, the cartridge has %d bytes of RAM", path, len(data), len(c.PRGRAM))
+	}
+	copy(c.PRGRAM, data)
+	return nil
+}
+
+// Writes PRG RAM to a save file. The RAM goes to a temporary file in the same
+// directory that's renamed over the save once complete, so a crash part way
+// through leaves the old save rather than a truncated one.
+func (c *Cartridge) WriteSave(path string) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	// Temporary files are private, saves are as readable as anything else.
+	err = f.Chmod(0o644)
+	if err == nil {
+		_, err = f.Write(c.PRGRAM)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Saver keeps a battery save up to date, only writing when the RAM has changed
+// since it was last loaded or saved.
+type Saver struct {
+	cart  *Cartridge
+	path  string
+	saved []uint8
+}
+
+// Loads the save at path into the cartridge and returns a saver that writes it
+// back there.
+func NewSaver(cart *Cartridge, path string) (*Saver, error) {
+	if err := cart.LoadSave(path); err != nil {
+		return nil, err
+	}
+	return &Saver{cart: cart, path: path, saved: append([]uint8(nil), cart.PRGRAM...)}, nil
+}
+
+func (s *Saver) Path() string {
+	return s.path
+}
+
+// Writes the save if the RAM has changed, returning whether it did.
+func (s *Saver) Save() (bool, error) {
+	if bytes.Equal(s.saved, s.cart.PRGRAM) {
+		return false, nil
+	}
+	if err := s.cart.WriteSave(s.path); err != nil {
+		return false, err
+	}
+	copy(s.saved, s.cart.PRGRAM)
+	return true, nil
+}
@@ -0,0 +1,107 @@
+package cartridge
+
+import (
+	"fmt"
+	"os"
+)
+
+const (
+	PRG_ROM_PAGE_SIZE = 16 * 1024
+	CHR_ROM_PAGE_SIZE = 8 * 1024
+	PRG_RAM_SIZE      = 8 * 1024
+)
+
+// Cartridge holds the contents of an iNES ROM image and maps it into the CPU
+// address space. Only mapper 0 (NROM) is supported for now.
+type Cartridge struct {
+	Header    Header
+	PRG       []uint8
+	CHR       []uint8
+	Trainer   []uint8
+	Mapper    uint16
+	Mirroring Mirroring
+	Battery   bool
+
+	// PRG RAM is mapped at $6000-$7FFF, games with the battery flag set use it
+	// to keep saves.
+	PRGRAM []uint8
+}
+
+// Reads and parses an iNES ROM file.
+func LoadFile(path string) (*Cartridge, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Load(raw)
+}
+
+// Parses an iNES ROM image into a cartridge.
+func Load(raw []uint8) (*Cartridge, error) {
+	header, err := ParseHeader(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if header.Mapper != 0 {
+		return nil, fmt.Errorf("mapper %d is not supported", header.Mapper)
+	}
+
+	if len(raw) < header.ImageSize() {
+		return nil, fmt.Errorf("ROM is truncated: expected %d bytes, got %d", header.ImageSize(), len(raw))
+	}
+
+	trainer, prg, chr := header.Sections(raw)
+	cart := &Cartridge{
+		Header:    header,
+		PRG:       append([]uint8(nil), prg...),
+		CHR:       append([]uint8(nil), chr...),
+		Mapper:    header.Mapper,
+		Mirroring: header.Mirroring,
+		Battery:   header.Battery,
+		PRGRAM:    make([]uint8, PRG_RAM_SIZE),
+	}
+	if header.Trainer {
+		cart.Trainer = append([]uint8(nil), trainer...)
+	}
+
+	return cart, nil
+}
+
+// Reads from the cartridge side of the CPU address space ($4020-$FFFF).
+func (c *Cartridge) Read(addr uint16) uint8 {
+	switch {
+	case addr >= 0x8000:
+		return c.readPRG(addr)
+	case addr >= 0x6000:
+		return c.PRGRAM[addr-0x6000]
+	default:
+		return 0
+	}
+}
+
+// Writes to the cartridge side of the CPU address space. PRG ROM is read only
+// so writes there are ignored.
+func (c *Cartridge) Write(addr uint16, data uint8) {
+	if addr >= 0x6000 && addr < 0x8000 {
+		c.PRGRAM[addr-0x6000] = data
+	}
+}
+
+func (c *Cartridge) readPRG(addr uint16) uint8 {
+	offset, ok := c.PRGOffset(addr)
+	if !ok {
+		return 0
+	}
+	return c.PRG[offset]
+}
+
+// Returns where in PRG ROM a CPU address currently reads from, or false if
+// the address isn't PRG ROM. NROM-128 boards only have 16KB of PRG ROM which
+// is mirrored into both $8000-$BFFF and $C000-$FFFF.
+func (c *Cartridge) PRGOffset(addr uint16) (int, bool) {
+	if addr < 0x8000 || len(c.PRG) == 0 {
+		return 0, false
+	}
+	return int(addr-0x8000) % len(c.PRG), true
+}
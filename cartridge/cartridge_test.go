@@ -0,0 +1,179 @@
+package cartridge
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Builds a raw iNES image with the given header flags and PRG/CHR page counts.
+// PRG bytes are filled with their offset so reads can be checked.
+func buildROM(prgPages, chrPages int, flags6, flags7 uint8) []uint8 {
+	raw := []uint8{'N', 'E', 'S', 0x1A, uint8(prgPages), uint8(chrPages), flags6, flags7, 0, 0, 0, 0, 0, 0, 0, 0}
+	if flags6&0b100 != 0 {
+		raw = append(raw, make([]uint8, TRAINER_SIZE)...)
+	}
+	for i := 0; i < prgPages*PRG_ROM_PAGE_SIZE; i++ {
+		raw = append(raw, uint8(i))
+	}
+	raw = append(raw, make([]uint8, chrPages*CHR_ROM_PAGE_SIZE)...)
+	return raw
+}
+
+// Test that a valid NROM image is parsed into its parts
+func Test_Load_NROM(t *testing.T) {
+	cart, err := Load(buildROM(2, 1, 0b0000_0011, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, 2*PRG_ROM_PAGE_SIZE, len(cart.PRG))
+	assert.Equal(t, CHR_ROM_PAGE_SIZE, len(cart.CHR))
+	assert.Equal(t, Vertical, cart.Mirroring)
+	assert.True(t, cart.Battery)
+	assert.Nil(t, cart.Trainer)
+}
+
+// Test that the trainer is skipped over before PRG ROM
+func Test_Load_Trainer(t *testing.T) {
+	cart, err := Load(buildROM(1, 1, 0b0000_0100, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, TRAINER_SIZE, len(cart.Trainer))
+	assert.Equal(t, uint8(0x01), cart.PRG[1])
+}
+
+// Test that files without the iNES tag are rejected
+func Test_Load_NotINES(t *testing.T) {
+	_, err := Load([]uint8{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15})
+	assert.ErrorIs(t, err, ErrNotINES)
+}
+
+// Test that mappers other than NROM are rejected
+func Test_Load_UnsupportedMapper(t *testing.T) {
+	_, err := Load(buildROM(1, 1, 0b0001_0000, 0))
+	assert.Error(t, err)
+}
+
+// Test that 16KB of PRG ROM is mirrored into the upper half of the address space
+func Test_Read_PRGMirroring(t *testing.T) {
+	cart, _ := Load(buildROM(1, 1, 0, 0))
+	assert.Equal(t, cart.Read(0x8010), cart.Read(0xC010))
+}
+
+// Test that PRG RAM can be written and read back but PRG ROM can't be written
+func Test_Write_PRGRAM(t *testing.T) {
+	cart, _ := Load(buildROM(1, 1, 0, 0))
+	cart.Write(0x6000, 0x42)
+	cart.Write(0x8000, 0x42)
+	assert.Equal(t, uint8(0x42), cart.Read(0x6000))
+	assert.Equal(t, uint8(0x00), cart.Read(0x8000))
+}
+
+// Test that NES 2.0 headers extend the mapper number and read the submapper and
+// region
+func Test_ParseHeader_NES20(t *testing.T) {
+	raw := buildROM(1, 1, 0b0001_0000, 0b0010_1000)
+	raw[8] = 0x31
+	raw[10] = 0x07
+	raw[12] = 0x03
+	header, err := ParseHeader(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, FormatNES20, header.Format)
+	assert.Equal(t, uint16(0x121), header.Mapper)
+	assert.Equal(t, uint8(3), header.Submapper)
+	assert.Equal(t, TimingDendy, header.Timing)
+	assert.Equal(t, 8192, header.PRGRAMSize)
+	assert.Empty(t, header.Warnings)
+}
+
+// Test that NES 2.0 sizes in exponent-multiplier notation are decoded
+func Test_ParseHeader_NES20_ExponentSize(t *testing.T) {
+	raw := buildROM(0, 0, 0, 0b0000_1000)
+	// 2^4 * (1*2+1) = 48 bytes of PRG ROM
+	raw[4] = 0b0001_0001
+	raw[9] = 0x0F
+	raw = append(raw, make([]uint8, 48)...)
+	header, err := ParseHeader(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, 48, header.PRGSize)
+}
+
+// Test that an exponent-multiplier size too large to hold is rejected rather
+// than overflowing into a negative size
+func Test_ParseHeader_NES20_ExponentTooLarge(t *testing.T) {
+	raw := buildROM(0, 0, 0, 0b0000_1000)
+	raw[4] = 0xFC
+	raw[9] = 0x0F
+	raw = append(raw, make([]uint8, 100)...)
+	_, err := ParseHeader(raw)
+	assert.ErrorContains(t, err, "PRG ROM of 2^63 bytes")
+	_, err = Load(raw)
+	assert.Error(t, err)
+
+	_, prg, chr := Header{PRGSize: 1 << 40, CHRSize: -1}.Sections(raw)
+	assert.Len(t, prg, 100)
+	assert.Empty(t, chr)
+}
+
+// Test that a "DiskDude!" header is treated as archaic so the garbage in byte 7
+// doesn't corrupt the mapper number
+func Test_ParseHeader_DiskDude(t *testing.T) {
+	raw := buildROM(1, 1, 0b0001_0000, 0)
+	copy(raw[7:16], "DiskDude!")
+	header, err := ParseHeader(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, FormatArchaicINES, header.Format)
+	assert.Equal(t, uint16(1), header.Mapper)
+	assert.Contains(t, header.Warnings[0], `"DiskDude!"`)
+}
+
+// Test that a file shorter than its header declares is flagged
+func Test_ParseHeader_Truncated(t *testing.T) {
+	raw := buildROM(1, 1, 0, 0)
+	header, err := ParseHeader(raw[:len(raw)-1])
+	assert.NoError(t, err)
+	assert.Contains(t, header.Warnings[0], "truncated")
+}
+
+// Test checksums against known values for "123456789"
+func Test_ChecksumOf(t *testing.T) {
+	sum := ChecksumOf([]uint8("123456789"))
+	assert.Equal(t, "CBF43926", sum.CRC32)
+	assert.Equal(t, "f7c3bc1d808e04732adf679965ccc34ca7ae3441", sum.SHA1)
+}
+
+// Test that saves only get written when RAM changes and load back
+func Test_Saver(t *testing.T) {
+	dir := t.TempDir()
+	path := SavePath("/roms/Zelda (U).nes", dir)
+	assert.Equal(t, filepath.Join(dir, "Zelda (U).sav"), path)
+	assert.Equal(t, "/roms/Zelda (U).sav", SavePath("/roms/Zelda (U).nes", ""))
+
+	cart, err := Load(buildROM(1, 1, 0b0000_0010, 0))
+	require.NoError(t, err)
+	saver, err := NewSaver(cart, path)
+	require.NoError(t, err, "no save yet is fine")
+	saved, err := saver.Save()
+	require.NoError(t, err)
+	assert.False(t, saved)
+	assert.NoFileExists(t, path)
+
+	cart.Write(0x6000, 0x42)
+	saved, err = saver.Save()
+	require.NoError(t, err)
+	assert.True(t, saved)
+	saved, _ = saver.Save()
+	assert.False(t, saved)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "the temporary file is gone")
+
+	again, _ := Load(buildROM(1, 1, 0b0000_0010, 0))
+	_, err = NewSaver(again, path)
+	require.NoError(t, err)
+	assert.Equal(t, uint8(0x42), again.Read(0x6000))
+
+	require.NoError(t, os.WriteFile(path, []uint8{1, 2, 3}, 0o644))
+	assert.Error(t, again.LoadSave(path))
+}
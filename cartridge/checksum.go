@@ -0,0 +1,22 @@
+package cartridge
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+)
+
+// Checksum identifies a chunk of ROM data the way ROM databases do.
+type Checksum struct {
+	CRC32 string `json:"crc32"`
+	SHA1  string `json:"sha1"`
+}
+
+func ChecksumOf(data []uint8) Checksum {
+	sum := sha1.Sum(data)
+	return Checksum{
+		CRC32: fmt.Sprintf("%08X", crc32.ChecksumIEEE(data)),
+		SHA1:  hex.EncodeToString(sum[:]),
+	}
+}
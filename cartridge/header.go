@@ -0,0 +1,351 @@
+package cartridge
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	HEADER_SIZE  = 16
+	TRAINER_SIZE = 512
+	// The largest exponent accepted for an NES 2.0 exponent-multiplier ROM
+	// size, 2^28 * 7 being about as much as fits in a 32 bit int. Real
+	// boards come nowhere near it.
+	MAX_ROM_EXPONENT = 28
+)
+
+var NES_TAG = []uint8{'N', 'E', 'S', 0x1A}
+
+var ErrNotINES = errors.New("file is not in iNES format")
+
+type Mirroring int
+
+const (
+	Horizontal Mirroring = iota
+	Vertical
+	FourScreen
+)
+
+func (m Mirroring) String() string {
+	switch m {
+	case Horizontal:
+		return "horizontal"
+	case Vertical:
+		return "vertical"
+	case FourScreen:
+		return "four-screen"
+	default:
+		return fmt.Sprintf("Mirroring(%d)", int(m))
+	}
+}
+
+func (m Mirroring) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// Format is the revision of the iNES header a ROM image uses.
+type Format int
+
+const (
+	// Archaic iNES headers predate the flags in byte 7 onwards, which often
+	// hold garbage such as a ripper's signature.
+	FormatArchaicINES Format = iota
+	FormatINES
+	FormatNES20
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatArchaicINES:
+		return "archaic iNES"
+	case FormatINES:
+		return "iNES"
+	case FormatNES20:
+		return "NES 2.0"
+	default:
+		return fmt.Sprintf("Format(%d)", int(f))
+	}
+}
+
+func (f Format) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+// Timing is the CPU/PPU timing a ROM was made for, which is how headers
+// describe the region.
+type Timing int
+
+const (
+	TimingNTSC Timing = iota
+	TimingPAL
+	TimingMultiRegion
+	TimingDendy
+)
+
+func (t Timing) String() string {
+	switch t {
+	case TimingNTSC:
+		return "ntsc"
+	case TimingPAL:
+		return "pal"
+	case TimingMultiRegion:
+		return "multi-region"
+	case TimingDendy:
+		return "dendy"
+	default:
+		return fmt.Sprintf("Timing(%d)", int(t))
+	}
+}
+
+func (t Timing) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// Console is the kind of hardware a ROM was made for.
+type Console int
+
+const (
+	ConsoleNES Console = iota
+	ConsoleVsSystem
+	ConsolePlayChoice10
+	ConsoleExtended
+)
+
+func (c Console) String() string {
+	switch c {
+	case ConsoleNES:
+		return "nes"
+	case ConsoleVsSystem:
+		return "vs-system"
+	case ConsolePlayChoice10:
+		return "playchoice-10"
+	case ConsoleExtended:
+		return "extended"
+	default:
+		return fmt.Sprintf("Console(%d)", int(c))
+	}
+}
+
+func (c Console) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// Header describes the layout of an iNES or NES 2.0 ROM image.
+type Header struct {
+	Format    Format    `json:"format"`
+	Mapper    uint16    `json:"mapper"`
+	Submapper uint8     `json:"submapper"`
+	Console   Console   `json:"console"`
+	Timing    Timing    `json:"region"`
+	Mirroring Mirroring `json:"mirroring"`
+	Battery   bool      `json:"battery"`
+	Trainer   bool      `json:"trainer"`
+
+	PRGSize      int `json:"prg_rom_size"`
+	CHRSize      int `json:"chr_rom_size"`
+	PRGRAMSize   int `json:"prg_ram_size"`
+	PRGNVRAMSize int `json:"prg_nvram_size"`
+	CHRRAMSize   int `json:"chr_ram_size"`
+	CHRNVRAMSize int `json:"chr_nvram_size"`
+
+	// Warnings lists anything suspicious about the header, such as garbage in
+	// unused bytes or sizes that don't match the file.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Parses the 16 byte header at the start of a ROM image.
+//
+//	0-3   Constant $4E $45 $53 $1A ("NES" followed by MS-DOS end-of-file)
+//	4     Size of PRG ROM in 16 KB units
+//	5     Size of CHR ROM in 8 KB units (0 means the board uses CHR RAM)
+//	6     Flags 6 - mapper low nybble, mirroring, battery, trainer
+//	7     Flags 7 - mapper high nybble, NES 2.0 identifier, console type
+//	8     NES 2.0 mapper MSB and submapper, iNES PRG RAM size
+//	9     NES 2.0 PRG and CHR ROM size MSBs, iNES TV system
+//	10    NES 2.0 PRG RAM and NVRAM sizes
+//	11    NES 2.0 CHR RAM and NVRAM sizes
+//	12    NES 2.0 CPU/PPU timing
+//	13-15 NES 2.0 Vs. System type, miscellaneous ROMs, default expansion device
+func ParseHeader(raw []uint8) (Header, error) {
+	if len(raw) < HEADER_SIZE || !bytes.Equal(raw[0:4], NES_TAG) {
+		return Header{}, ErrNotINES
+	}
+
+	h := Header{
+		Mapper:  uint16(raw[6] >> 4),
+		Battery: raw[6]&0b10 != 0,
+		Trainer: raw[6]&0b100 != 0,
+	}
+
+	fourScreen := raw[6]&0b1000 != 0
+	verticalMirroring := raw[6]&0b1 != 0
+	switch {
+	case fourScreen:
+		h.Mirroring = FourScreen
+	case verticalMirroring:
+		h.Mirroring = Vertical
+	default:
+		h.Mirroring = Horizontal
+	}
+
+	switch {
+	case raw[7]&0b1100 == 0b1000:
+		h.Format = FormatNES20
+		if err := h.parseNES20(raw); err != nil {
+			return Header{}, err
+		}
+	case raw[7]&0b1100 == 0 && bytes.Equal(raw[12:16], []uint8{0, 0, 0, 0}):
+		h.Format = FormatINES
+		h.parseINES(raw)
+	default:
+		// Bytes 7-15 can't be trusted so only the original fields are used.
+		h.Format = FormatArchaicINES
+		h.PRGSize = int(raw[4]) * PRG_ROM_PAGE_SIZE
+		h.CHRSize = int(raw[5]) * CHR_ROM_PAGE_SIZE
+		h.PRGRAMSize = PRG_RAM_SIZE
+		h.warn("bytes 7-15 contain garbage %s, ignoring them", describeGarbage(raw[7:16]))
+	}
+
+	// Older headers can't describe RAM sizes, so the battery flag decides
+	// whether PRG RAM is kept and boards without CHR ROM get 8KB of CHR RAM.
+	if h.Format != FormatNES20 {
+		if h.Battery {
+			h.PRGNVRAMSize, h.PRGRAMSize = h.PRGRAMSize, 0
+		}
+		if h.CHRSize == 0 {
+			h.CHRRAMSize = CHR_ROM_PAGE_SIZE
+		}
+	}
+	if h.PRGSize == 0 {
+		h.warn("header declares no PRG ROM")
+	}
+	if h.Trainer {
+		h.warn("ROM has a 512 byte trainer")
+	}
+	switch {
+	case len(raw) < h.ImageSize():
+		h.warn("file is truncated, header declares %d bytes but the file has %d", h.ImageSize(), len(raw))
+	case len(raw) > h.ImageSize():
+		h.warn("file has %d bytes of trailing data after the ROM", len(raw)-h.ImageSize())
+	}
+
+	return h, nil
+}
+
+func (h *Header) parseINES(raw []uint8) {
+	h.Mapper |= uint16(raw[7] & 0b1111_0000)
+	h.Console = Console(raw[7] & 0b11)
+	h.PRGSize = int(raw[4]) * PRG_ROM_PAGE_SIZE
+	h.CHRSize = int(raw[5]) * CHR_ROM_PAGE_SIZE
+
+	// A PRG RAM size of 0 means 8KB for compatibility with older images.
+	h.PRGRAMSize = int(raw[8]) * PRG_RAM_SIZE
+	if h.PRGRAMSize == 0 {
+		h.PRGRAMSize = PRG_RAM_SIZE
+	}
+
+	if raw[9]&0b1 != 0 {
+		h.Timing = TimingPAL
+	}
+	if raw[9]&0b1111_1110 != 0 || raw[10] != 0 || raw[11] != 0 {
+		h.warn("reserved bytes 9-11 are not zero")
+	}
+}
+
+func (h *Header) parseNES20(raw []uint8) error {
+	h.Mapper |= uint16(raw[7]&0b1111_0000) | uint16(raw[8]&0b1111)<<8
+	h.Submapper = raw[8] >> 4
+	h.Console = Console(raw[7] & 0b11)
+	h.Timing = Timing(raw[12] & 0b11)
+	var ok bool
+	if h.PRGSize, ok = romSize(raw[4], raw[9]&0b1111, PRG_ROM_PAGE_SIZE); !ok {
+		return fmt.Errorf("header declares a PRG ROM of 2^%d bytes, which is too large", raw[4]>>2)
+	}
+	if h.CHRSize, ok = romSize(raw[5], raw[9]>>4, CHR_ROM_PAGE_SIZE); !ok {
+		return fmt.Errorf("header declares a CHR ROM of 2^%d bytes, which is too large", raw[5]>>2)
+	}
+	h.PRGRAMSize = shiftSize(raw[10] & 0b1111)
+	h.PRGNVRAMSize = shiftSize(raw[10] >> 4)
+	h.CHRRAMSize = shiftSize(raw[11] & 0b1111)
+	h.CHRNVRAMSize = shiftSize(raw[11] >> 4)
+
+	if h.Battery && h.PRGNVRAMSize == 0 && h.CHRNVRAMSize == 0 {
+		h.warn("battery flag is set but no non-volatile RAM is declared")
+	}
+	return nil
+}
+
+// NES 2.0 ROM sizes use the MSB nybble from byte 9 to extend the page count,
+// or when it is $F switch to exponent-multiplier notation: 2^E * (MM*2+1).
+// Reports false for an exponent above MAX_ROM_EXPONENT.
+func romSize(lsb uint8, msb uint8, pageSize int) (int, bool) {
+	if msb == 0xF {
+		exponent := lsb >> 2
+		if exponent > MAX_ROM_EXPONENT {
+			return 0, false
+		}
+		multiplier := int(lsb&0b11)*2 + 1
+		return (1 << exponent) * multiplier, true
+	}
+	return (int(msb)<<8 | int(lsb)) * pageSize, true
+}
+
+// NES 2.0 RAM sizes are a shift count, 64 << n bytes, with 0 meaning none.
+func shiftSize(shift uint8) int {
+	if shift == 0 {
+		return 0
+	}
+	return 64 << shift
+}
+
+func (h *Header) warn(format string, args ...any) {
+	h.Warnings = append(h.Warnings, fmt.Sprintf(format, args...))
+}
+
+// Shows garbage header bytes as text when they are printable, as with the
+// "DiskDude!" tag left by an old ripping tool, and as hex otherwise.
+func describeGarbage(garbage []uint8) string {
+	text := strings.TrimRight(string(garbage), "\x00")
+	printable := text != ""
+	for _, r := range text {
+		if r < 0x20 || r > 0x7E {
+			printable = false
+			break
+		}
+	}
+	if printable {
+		return fmt.Sprintf("%q", text)
+	}
+	return fmt.Sprintf("% X", garbage)
+}
+
+// Returns the number of bytes the header says the image should take up.
+func (h Header) ImageSize() int {
+	size := HEADER_SIZE + h.PRGSize + h.CHRSize
+	if h.Trainer {
+		size += TRAINER_SIZE
+	}
+	return size
+}
+
+// Splits a raw image into its trainer, PRG ROM and CHR ROM. Sections are cut
+// short if the file is truncated.
+func (h Header) Sections(raw []uint8) (trainer []uint8, prg []uint8, chr []uint8) {
+	section := func(start, size int) []uint8 {
+		if start < 0 || size < 0 || start > len(raw) {
+			return nil
+		}
+		return raw[start : start+min(size, len(raw)-start)]
+	}
+
+	prgStart := HEADER_SIZE
+	if h.Trainer {
+		trainer = section(HEADER_SIZE, TRAINER_SIZE)
+		prgStart += TRAINER_SIZE
+	}
+	prg = section(prgStart, h.PRGSize)
+	chr = section(prgStart+h.PRGSize, h.CHRSize)
+	return trainer, prg, chr
+}
@@ -0,0 +1,161 @@
+// Package cdl logs which bytes of a ROM were used as code and which as data,
+// saved in the .cdl format FCEUX uses so the result can be fed to existing
+// disassembly tools.
+//
+// A .cdl file is one byte per PRG ROM byte followed by one byte per CHR ROM
+// byte. PRG bytes are marked:
+//
+//	bit 0     executed as code
+//	bit 1     read as data
+//	bits 2-3  which 8KB of $8000-$FFFF it was mapped into, (addr >> 13) & 3
+//	bit 4     code reached indirectly, through JMP ($xxxx)
+//	bit 5     data read indirectly, through ($nn),Y or ($nn,X)
+//
+// CHR bytes would be marked as the PPU fetches them, but there is no PPU yet,
+// so the CHR part is only ever what was merged in from an existing file and
+// zero, meaning unknown, otherwise.
+package cdl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"switchtrue.com/hankee/cartridge"
+)
+
+const (
+	PRG_CODE          = 0x01
+	PRG_DATA          = 0x02
+	PRG_BANK_MASK     = 0x0C
+	PRG_INDIRECT_CODE = 0x10
+	PRG_INDIRECT_DATA = 0x20
+)
+
+// Log is the code/data log for one cartridge. It implements
+// cpu.CodeDataLogger so it can be plugged straight into the CPU.
+type Log struct {
+	PRG []uint8
+	// Kept so files round trip, nothing marks it without a PPU.
+	CHR  []uint8
+	cart *cartridge.Cartridge
+}
+
+// Creates an empty log sized for the cartridge.
+func New(cart *cartridge.Cartridge) *Log {
+	return &Log{
+		PRG:  make([]uint8, len(cart.PRG)),
+		CHR:  make([]uint8, len(cart.CHR)),
+		cart: cart,
+	}
+}
+
+// Marks an instruction's bytes as code.
+func (l *Log) LogCode(addr uint16, length int, indirect bool) {
+	flags := uint8(PRG_CODE)
+	if indirect {
+		flags |= PRG_INDIRECT_CODE
+	}
+	for i := 0; i < length; i++ {
+		l.mark(addr+uint16(i), flags)
+	}
+}
+
+// Marks a byte as data.
+func (l *Log) LogData(addr uint16, indirect bool) {
+	flags := uint8(PRG_DATA)
+	if indirect {
+		flags |= PRG_INDIRECT_DATA
+	}
+	l.mark(addr, flags)
+}
+
+// Marks the PRG ROM byte behind a CPU address, ignoring anything that isn't
+// PRG ROM.
+func (l *Log) mark(addr uint16, flags uint8) {
+	offset, ok := l.cart.PRGOffset(addr)
+	if !ok {
+		return
+	}
+	l.PRG[offset] |= flags | uint8((addr>>13)&3)<<2
+}
+
+// Stats counts the bytes marked in a log.
+type Stats struct {
+	Code    int
+	Data    int
+	Unknown int
+}
+
+func (l *Log) Stats() Stats {
+	var stats Stats
+	for _, flags := range l.PRG {
+		if flags&PRG_CODE != 0 {
+			stats.Code++
+		}
+		if flags&PRG_DATA != 0 {
+			stats.Data++
+		}
+		if flags&(PRG_CODE|PRG_DATA) == 0 {
+			stats.Unknown++
+		}
+	}
+	return stats
+}
+
+// Writes the log in .cdl format.
+func (l *Log) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(l.PRG)
+	if err != nil {
+		return int64(n), err
+	}
+	m, err := w.Write(l.CHR)
+	return int64(n + m), err
+}
+
+// Reads a .cdl file into the log, merging with anything already marked. The
+// file has to be the right size for the cartridge.
+func (l *Log) ReadFrom(r io.Reader) (int64, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return int64(len(data)), err
+	}
+	if len(data) != len(l.PRG)+len(l.CHR) {
+		return int64(len(data)), fmt.Errorf("code/data log is %d bytes, expected %d for this ROM", len(data), len(l.PRG)+len(l.CHR))
+	}
+	for i, flags := range data[:len(l.PRG)] {
+		l.PRG[i] |= flags
+	}
+	for i, flags := range data[len(l.PRG):] {
+		l.CHR[i] |= flags
+	}
+	return int64(len(data)), nil
+}
+
+// Merges in a .cdl file if there is one, so logging can carry on across runs.
+func (l *Log) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = l.ReadFrom(f)
+	return err
+}
+
+// Saves the log to a .cdl file.
+func (l *Log) SaveFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := l.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
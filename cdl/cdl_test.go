@@ -0,0 +1,97 @@
+package cdl
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"switchtrue.com/hankee/cartridge"
+	"switchtrue.com/hankee/nes"
+)
+
+// Builds an NROM-128 cartridge with the code at $8000 and data patched in at
+// the given offsets.
+func newCartridge(t *testing.T, code []uint8, data map[int][]uint8) *cartridge.Cartridge {
+	raw := []uint8{'N', 'E', 'S', 0x1A, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	prg := make([]uint8, cartridge.PRG_ROM_PAGE_SIZE)
+	copy(prg, code)
+	for offset, bytes := range data {
+		copy(prg[offset:], bytes)
+	}
+	prg[0x3FFC] = 0x00
+	prg[0x3FFD] = 0x80
+	raw = append(raw, prg...)
+	raw = append(raw, make([]uint8, cartridge.CHR_ROM_PAGE_SIZE)...)
+
+	cart, err := cartridge.Load(raw)
+	require.NoError(t, err)
+	return cart
+}
+
+// LDA $8020; LDA #$30; STA $00; LDA #$C0; STA $01; LDY #$00; LDA ($00),Y;
+// JMP ($8022), which lands on STA $8050; BRK at $8040.
+var program = []uint8{
+	0xAD, 0x20, 0x80, 0xA9, 0x30, 0x85, 0x00, 0xA9, 0xC0, 0x85, 0x01,
+	0xA0, 0x00, 0xB1, 0x00, 0x6C, 0x22, 0x80,
+}
+
+func run(t *testing.T) (*Log, *cartridge.Cartridge) {
+	cart := newCartridge(t, program, map[int][]uint8{
+		0x22: {0x40, 0x80},
+		0x40: {0x8D, 0x50, 0x80, 0x00},
+	})
+	m := nes.New()
+	m.InsertCartridge(cart)
+	log := New(cart)
+	m.CPU().SetCodeDataLogger(log)
+	m.Run()
+	return log, cart
+}
+
+// Test that code, data and indirect accesses are told apart
+func Test_Log_Marks(t *testing.T) {
+	log, _ := run(t)
+
+	assert.Equal(t, uint8(PRG_CODE), log.PRG[0x00])
+	assert.Equal(t, uint8(PRG_CODE), log.PRG[0x02], "operand bytes are code too")
+	assert.Equal(t, uint8(PRG_CODE), log.PRG[0x11])
+	assert.Equal(t, uint8(PRG_DATA), log.PRG[0x20])
+	assert.Equal(t, uint8(PRG_DATA), log.PRG[0x22], "the JMP pointer is data")
+	assert.Equal(t, uint8(PRG_DATA), log.PRG[0x23])
+	// Read through $C030, the third 8KB of $8000-$FFFF.
+	assert.Equal(t, uint8(PRG_DATA|PRG_INDIRECT_DATA|2<<2), log.PRG[0x30])
+	assert.Equal(t, uint8(PRG_CODE|PRG_INDIRECT_CODE), log.PRG[0x40])
+	assert.Equal(t, uint8(PRG_CODE), log.PRG[0x43])
+	assert.Equal(t, uint8(0), log.PRG[0x50], "stores aren't data reads")
+	assert.Equal(t, uint8(0), log.PRG[0x12], "never executed")
+
+	stats := log.Stats()
+	assert.Equal(t, 18+4, stats.Code)
+	assert.Equal(t, 4, stats.Data)
+	assert.Equal(t, cartridge.PRG_ROM_PAGE_SIZE-26, stats.Unknown)
+}
+
+// Test saving and merging logs in .cdl format
+func Test_Log_File(t *testing.T) {
+	log, cart := run(t)
+	// As if merged in from a file another emulator wrote.
+	log.CHR[0x10] = 0x01
+
+	var buf bytes.Buffer
+	_, err := log.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, len(cart.PRG)+len(cart.CHR), buf.Len())
+	assert.Equal(t, uint8(0x01), buf.Bytes()[len(cart.PRG)+0x10])
+
+	merged := New(cart)
+	merged.LogData(0x8100, false)
+	_, err = merged.ReadFrom(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, uint8(PRG_DATA), merged.PRG[0x100])
+	assert.Equal(t, log.PRG[0x30], merged.PRG[0x30])
+	assert.Equal(t, uint8(0x01), merged.CHR[0x10])
+
+	_, err = merged.ReadFrom(bytes.NewReader(buf.Bytes()[:100]))
+	assert.Error(t, err)
+}
@@ -0,0 +1,277 @@
+// Package cheat applies cheat codes the way the cheat devices of the day did:
+// Game Genie codes patch what the CPU reads from the cartridge and Pro Action
+// Replay style codes write RAM every frame.
+package cheat
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"switchtrue.com/hankee/cpu"
+)
+
+// The Game Genie writes hex digits with these letters, 0 to F.
+const GAME_GENIE_LETTERS = "APZLGITYEOXUKSVN"
+
+// Kind is how a code changes the game.
+type Kind int
+
+const (
+	// Substitute codes replace the byte the CPU reads at an address, like a
+	// Game Genie sitting between the console and the cartridge.
+	Substitute Kind = iota
+	// RAMWrite codes store a byte at an address every frame, like a Pro Action
+	// Replay holding a RAM value.
+	RAMWrite
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Substitute:
+		return "substitute"
+	case RAMWrite:
+		return "write"
+	default:
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+}
+
+// Code is a single cheat.
+type Code struct {
+	Kind    Kind
+	Address uint16
+	Value   uint8
+	// Only substitute when the cartridge has this value, or only write when
+	// memory has it, so bank switched games are only patched in the right
+	// bank.
+	Compare    uint8
+	HasCompare bool
+	Name       string
+	Enabled    bool
+}
+
+// Returns the code as Parse accepts it, a Game Genie code where there is one.
+func (c Code) String() string {
+	switch {
+	case c.Kind == Substitute && c.Address >= 0x8000:
+		return EncodeGameGenie(c)
+	case c.Kind == Substitute && c.HasCompare:
+		return fmt.Sprintf("SC%04X:%02X:%02X", c.Address, c.Value, c.Compare)
+	case c.Kind == Substitute:
+		return fmt.Sprintf("S%04X:%02X", c.Address, c.Value)
+	case c.HasCompare:
+		return fmt.Sprintf("C%04X:%02X:%02X", c.Address, c.Value, c.Compare)
+	default:
+		return fmt.Sprintf("%04X:%02X", c.Address, c.Value)
+	}
+}
+
+// Parses an enabled code, either a 6 or 8 letter Game Genie code or a RAM
+// write as AAAA:VV or AAAAVV. The FCEUX forms S, SC and C with colons between
+// the address, value and compare are accepted too.
+func Parse(s string) (Code, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if isGameGenie(s) {
+		return DecodeGameGenie(s)
+	}
+
+	code := Code{Kind: RAMWrite, Enabled: true}
+	fields := strings.Split(s, ":")
+	if len(fields) == 1 && len(s) == 6 {
+		fields = []string{s[:4], s[4:]}
+	}
+	if strings.HasPrefix(fields[0], "S") {
+		code.Kind = Substitute
+		fields[0] = fields[0][1:]
+	}
+	// C is also a hex digit, so it only means a compare with three fields.
+	if len(fields) == 3 && strings.HasPrefix(fields[0], "C") {
+		code.HasCompare = true
+		fields[0] = fields[0][1:]
+	}
+	if len(fields) != 2 && !code.HasCompare {
+		return Code{}, fmt.Errorf("invalid cheat code %q, expected a Game Genie code or AAAA:VV", s)
+	}
+
+	address, err := strconv.ParseUint(fields[0], 16, 16)
+	if err != nil || len(fields[0]) > 4 {
+		return Code{}, fmt.Errorf("invalid address in cheat code %q", s)
+	}
+	value, err := strconv.ParseUint(fields[1], 16, 8)
+	if err != nil {
+		return Code{}, fmt.Errorf("invalid value in cheat code %q", s)
+	}
+	code.Address, code.Value = uint16(address), uint8(value)
+	if code.HasCompare {
+		compare, err := strconv.ParseUint(fields[2], 16, 8)
+		if err != nil {
+			return Code{}, fmt.Errorf("invalid compare value in cheat code %q", s)
+		}
+		code.Compare = uint8(compare)
+	}
+	return code, nil
+}
+
+func isGameGenie(s string) bool {
+	if len(s) != 6 && len(s) != 8 {
+		return false
+	}
+	for _, r := range s {
+		if !strings.ContainsRune(GAME_GENIE_LETTERS, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Decodes a 6 letter Game Genie code, which always substitutes, or an 8 letter
+// one, which only substitutes when the cartridge has the compare value.
+func DecodeGameGenie(s string) (Code, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if len(s) != 6 && len(s) != 8 {
+		return Code{}, fmt.Errorf("invalid Game Genie code %q, expected 6 or 8 letters", s)
+	}
+	n := make([]uint16, len(s))
+	for i, r := range s {
+		digit := strings.IndexRune(GAME_GENIE_LETTERS, r)
+		if digit < 0 {
+			return Code{}, fmt.Errorf("invalid Game Genie code %q, %q isn't a Game Genie letter", s, r)
+		}
+		n[i] = uint16(digit)
+	}
+
+	// The bits are shuffled across the letters so that similar codes do
+	// wildly different things.
+	code := Code{Kind: Substitute, Enabled: true}
+	code.Address = 0x8000 | (n[3]&7)<<12 | (n[5]&7)<<8 | (n[4]&8)<<8 | (n[2]&7)<<4 | (n[1]&8)<<4 | (n[4] & 7) | (n[3] & 8)
+	value := (n[1]&7)<<4 | (n[0]&8)<<4 | (n[0] & 7)
+	if len(n) == 6 {
+		code.Value = uint8(value | n[5]&8)
+	} else {
+		code.Value = uint8(value | n[7]&8)
+		code.Compare = uint8((n[7]&7)<<4 | (n[6]&8)<<4 | (n[6] & 7) | (n[5] & 8))
+		code.HasCompare = true
+	}
+	return code, nil
+}
+
+// Encodes a substitution in cartridge space as a Game Genie code, 8 letters
+// if it has a compare value and 6 if not.
+func EncodeGameGenie(c Code) string {
+	a, v, cmp := c.Address, uint16(c.Value), uint16(c.Compare)
+	n := []uint16{
+		(v & 7) | (v>>4)&8,
+		(v>>4)&7 | (a>>4)&8,
+		(a >> 4) & 7,
+		(a>>12)&7 | a&8,
+		a&7 | (a>>8)&8,
+		(a >> 8) & 7,
+	}
+	if c.HasCompare {
+		// The third letter's top bit tells the Game Genie to read 8 letters.
+		n[2] |= 8
+		n[5] |= cmp & 8
+		n = append(n, cmp&7|(cmp>>4)&8, (cmp>>4)&7|v&8)
+	} else {
+		n[5] |= v & 8
+	}
+
+	var sb strings.Builder
+	for _, digit := range n {
+		sb.WriteByte(GAME_GENIE_LETTERS[digit])
+	}
+	return sb.String()
+}
+
+// Engine sits between the CPU and its memory applying codes. Substitutions
+// happen as the CPU reads and writes happen when the frontend calls Frame.
+type Engine struct {
+	memory cpu.Memory
+	codes  []Code
+	// Indexes of the enabled substitutions by address, so reads that aren't
+	// patched stay cheap.
+	substitutions map[uint16][]int
+}
+
+// Creates an engine in front of memory. Plug it into the CPU with SetMemory.
+func New(memory cpu.Memory) *Engine {
+	return &Engine{memory: memory, substitutions: map[uint16][]int{}}
+}
+
+// Returns the memory the engine sits in front of.
+func (e *Engine) Memory() cpu.Memory {
+	return e.memory
+}
+
+// Adds a code, returning its index.
+func (e *Engine) Add(code Code) int {
+	e.codes = append(e.codes, code)
+	e.index()
+	return len(e.codes) - 1
+}
+
+// Returns a copy of the codes in the order they were added.
+func (e *Engine) Codes() []Code {
+	return append([]Code(nil), e.codes...)
+}
+
+// Turns the code at index i on or off.
+func (e *Engine) SetEnabled(i int, enabled bool) error {
+	if i < 0 || i >= len(e.codes) {
+		return fmt.Errorf("no cheat %d, there are %d", i, len(e.codes))
+	}
+	e.codes[i].Enabled = enabled
+	e.index()
+	return nil
+}
+
+// Takes the code at index i out, moving the later codes down.
+func (e *Engine) Remove(i int) error {
+	if i < 0 || i >= len(e.codes) {
+		return fmt.Errorf("no cheat %d, there are %d", i, len(e.codes))
+	}
+	e.codes = append(e.codes[:i], e.codes[i+1:]...)
+	e.index()
+	return nil
+}
+
+func (e *Engine) index() {
+	clear(e.substitutions)
+	for i, code := range e.codes {
+		if code.Enabled && code.Kind == Substitute {
+			e.substitutions[code.Address] = append(e.substitutions[code.Address], i)
+		}
+	}
+}
+
+func (e *Engine) Read(addr uint16) uint8 {
+	value := e.memory.Read(addr)
+	if len(e.substitutions) == 0 {
+		return value
+	}
+	for _, i := range e.substitutions[addr] {
+		code := e.codes[i]
+		if !code.HasCompare || code.Compare == value {
+			return code.Value
+		}
+	}
+	return value
+}
+
+func (e *Engine) Write(addr uint16, data uint8) {
+	e.memory.Write(addr, data)
+}
+
+// Applies the enabled write codes. Frontends call this once a frame.
+func (e *Engine) Frame() {
+	for _, code := range e.codes {
+		if !code.Enabled || code.Kind != RAMWrite {
+			continue
+		}
+		if code.HasCompare && e.memory.Read(code.Address) != code.Compare {
+			continue
+		}
+		e.memory.Write(code.Address, code.Value)
+	}
+}
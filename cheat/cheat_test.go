@@ -0,0 +1,115 @@
+package cheat
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test that Game Genie codes decode and encode back to the same letters
+func Test_GameGenie(t *testing.T) {
+	code, err := Parse("sxiopo")
+	require.NoError(t, err)
+	assert.Equal(t, Code{Kind: Substitute, Address: 0x91D9, Value: 0xAD, Enabled: true}, code)
+	assert.Equal(t, "SXIOPO", code.String())
+
+	eight := Code{Kind: Substitute, Address: 0xD1DD, Value: 0x14, Compare: 0xA9, HasCompare: true}
+	letters := EncodeGameGenie(eight)
+	assert.Len(t, letters, 8)
+	decoded, err := DecodeGameGenie(letters)
+	require.NoError(t, err)
+	eight.Enabled = true
+	assert.Equal(t, eight, decoded)
+
+	_, err = DecodeGameGenie("SXIOPB")
+	assert.Error(t, err)
+}
+
+// Test RAM write codes and the FCEUX forms
+func Test_Parse(t *testing.T) {
+	for text, want := range map[string]Code{
+		"0075:09":     {Kind: RAMWrite, Address: 0x0075, Value: 0x09},
+		"007509":      {Kind: RAMWrite, Address: 0x0075, Value: 0x09},
+		"C000:05":     {Kind: RAMWrite, Address: 0xC000, Value: 0x05},
+		"C0050:05:03": {Kind: RAMWrite, Address: 0x0050, Value: 0x05, Compare: 0x03, HasCompare: true},
+		"S6000:EA":    {Kind: Substitute, Address: 0x6000, Value: 0xEA},
+	} {
+		code, err := Parse(text)
+		require.NoError(t, err, text)
+		want.Enabled = true
+		assert.Equal(t, want, code, text)
+		again, err := Parse(code.String())
+		require.NoError(t, err, text)
+		assert.Equal(t, code, again, text)
+	}
+
+	for _, text := range []string{"", "75:09:01:02", "12345:00", "0075:100"} {
+		_, err := Parse(text)
+		assert.Error(t, err, text)
+	}
+}
+
+type ram [0x10000]uint8
+
+func (r *ram) Read(addr uint16) uint8        { return r[addr] }
+func (r *ram) Write(addr uint16, data uint8) { r[addr] = data }
+
+// Test that substitutions patch reads and write codes hold RAM each frame
+func Test_Engine(t *testing.T) {
+	memory := &ram{}
+	memory[0x8000], memory[0x8001] = 0x01, 0x02
+	e := New(memory)
+	e.Add(Code{Kind: Substitute, Address: 0x8000, Value: 0xEA, Enabled: true})
+	compared := e.Add(Code{Kind: Substitute, Address: 0x8001, Value: 0xEA, Compare: 0x03, HasCompare: true, Enabled: true})
+	lives := e.Add(Code{Kind: RAMWrite, Address: 0x0075, Value: 9, Enabled: true})
+
+	assert.Equal(t, uint8(0xEA), e.Read(0x8000))
+	assert.Equal(t, uint8(0x02), e.Read(0x8001), "compare doesn't match")
+	memory[0x8001] = 0x03
+	assert.Equal(t, uint8(0xEA), e.Read(0x8001))
+
+	e.Frame()
+	assert.Equal(t, uint8(9), memory[0x0075])
+	e.Write(0x0075, 2)
+	assert.Equal(t, uint8(2), e.Read(0x0075))
+	e.Frame()
+	assert.Equal(t, uint8(9), e.Read(0x0075))
+
+	require.NoError(t, e.SetEnabled(lives, false))
+	require.NoError(t, e.Remove(0))
+	assert.Equal(t, uint8(0x01), e.Read(0x8000))
+	memory[0x0075] = 2
+	e.Frame()
+	assert.Equal(t, uint8(2), memory[0x0075])
+	assert.Equal(t, uint8(0xEA), e.Read(0x8001), "indexes move down after a removal")
+	assert.Error(t, e.SetEnabled(compared+5, true))
+}
+
+// Test reading our own cheat files and FCEUX ones
+func Test_Files(t *testing.T) {
+	codes, err := Read(strings.NewReader("# Super Mario Bros.\n\nSXIOPO  Infinite lives\n-0075:09 Start on world 9\n"))
+	require.NoError(t, err)
+	require.Len(t, codes, 2)
+	assert.Equal(t, "Infinite lives", codes[0].Name)
+	assert.True(t, codes[0].Enabled)
+	assert.False(t, codes[1].Enabled)
+
+	var sb strings.Builder
+	require.NoError(t, Write(&sb, codes))
+	again, err := Read(strings.NewReader(sb.String()))
+	require.NoError(t, err)
+	assert.Equal(t, codes, again)
+
+	_, err = Read(strings.NewReader("SXIOPO\nwrong\n"))
+	assert.ErrorContains(t, err, "line 2")
+
+	codes, err = ReadFCEUX(strings.NewReader("S91D9:AD:Lives\n:0075:09:World 9\nSC8123:05:00:Compared: with a colon\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []Code{
+		{Kind: Substitute, Address: 0x91D9, Value: 0xAD, Name: "Lives", Enabled: true},
+		{Kind: RAMWrite, Address: 0x0075, Value: 0x09, Name: "World 9"},
+		{Kind: Substitute, Address: 0x8123, Value: 0x05, Compare: 0x00, HasCompare: true, Name: "Compared: with a colon", Enabled: true},
+	}, codes)
+}
@@ -0,0 +1,129 @@
+package cheat
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Reads a cheat file, one code per line with an optional name after it:
+//
+//	# Super Mario Bros.
+//	SXIOPO      Infinite lives
+//	-0075:09    Start on world 9, off for now
+//
+// A leading - adds the code switched off. Blank lines and lines starting with
+// # are skipped.
+func Read(r io.Reader) ([]Code, error) {
+	var codes []Code
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		enabled := !strings.HasPrefix(line, "-")
+		text, name, _ := strings.Cut(strings.TrimPrefix(line, "-"), " ")
+		code, err := Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		code.Name = strings.TrimSpace(name)
+		code.Enabled = enabled
+		codes = append(codes, code)
+	}
+	return codes, scanner.Err()
+}
+
+// Writes codes in the format Read accepts.
+func Write(w io.Writer, codes []Code) error {
+	for _, code := range codes {
+		prefix := ""
+		if !code.Enabled {
+			prefix = "-"
+		}
+		line := strings.TrimSpace(fmt.Sprintf("%s%-12s %s", prefix, code, code.Name))
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reads an FCEUX .cht file. Each line is
+//
+//	[S][C][:]AAAA:VV[:CC]:Name
+//
+// where S makes the code a substitution rather than a RAM write, C says a
+// compare value follows the value and a colon before the address means the
+// code is switched off.
+func ReadFCEUX(r io.Reader) ([]Code, error) {
+	var codes []Code
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		code := Code{Kind: RAMWrite, Enabled: true}
+		if strings.HasPrefix(line, "S") {
+			code.Kind = Substitute
+			line = line[1:]
+		}
+		if strings.HasPrefix(line, "C") {
+			code.HasCompare = true
+			line = line[1:]
+		}
+		if strings.HasPrefix(line, ":") {
+			code.Enabled = false
+			line = line[1:]
+		}
+
+		fields := 2
+		if code.HasCompare {
+			fields = 3
+		}
+		parts := strings.SplitN(line, ":", fields+1)
+		if len(parts) < fields {
+			return nil, fmt.Errorf("line %d: invalid FCEUX cheat %q", n, scanner.Text())
+		}
+		if len(parts) > fields {
+			code.Name = parts[fields]
+		}
+		// Parse checks the numbers, keeping the kind worked out here.
+		text := strings.Join(parts[:fields], ":")
+		if code.HasCompare {
+			text = "C" + text
+		}
+		parsed, err := Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		code.Address, code.Value, code.Compare = parsed.Address, parsed.Value, parsed.Compare
+		codes = append(codes, code)
+	}
+	return codes, scanner.Err()
+}
+
+// Loads a cheat file, reading it as FCEUX's format if it has a .cht extension.
+func LoadFile(path string) ([]Code, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var codes []Code
+	if strings.EqualFold(filepath.Ext(path), ".cht") {
+		codes, err = ReadFCEUX(f)
+	} else {
+		codes, err = Read(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return codes, nil
+}
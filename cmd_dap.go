@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"switchtrue.com/hankee/cpu"
+	"switchtrue.com/hankee/dap"
+)
+
+func dapCommand(args []string) int {
+	fs := newFlagSet("dap", "[options] [file]")
+	var load loadOptions
+	load.register(fs)
+	addr := fs.String("listen", "", "address to listen on, host:port or unix:/path/to/socket (default stdin and stdout)")
+	debugInfo := fs.String("dbg", "", "ld65 debug info for the file (default the file with a .dbg extension)")
+	path, code, ok := parseOptionalFileArgs(fs, args)
+	if !ok {
+		return code
+	}
+
+	// With a file editors attach to it, otherwise they launch their own.
+	var target *dap.Target
+	if path != "" {
+		s, err := load.open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+			return exitFailure
+		}
+		target, err = dap.NewTarget(s.cpu, *debugInfo, path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+			return exitFailure
+		}
+	}
+	launcher := func(args dap.LaunchArguments) (*cpu.CPU, error) {
+		return launchDAP(load, args)
+	}
+
+	if *addr == "" {
+		session := dap.NewSession(target, launcher)
+		if err := session.Serve(stdio{}); err != nil {
+			fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+			return exitFailure
+		}
+		return exitOK
+	}
+
+	listener, err := listen(*addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+	defer listener.Close()
+
+	fmt.Fprintf(os.Stderr, "waiting for an editor on %s\n", listener.Addr())
+	conn, err := listener.Accept()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+	defer conn.Close()
+	if err := dap.NewSession(target, launcher).Serve(conn); err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// Loads the program from a launch request, with its options taking over from
+// the ones given on the command line.
+func launchDAP(load loadOptions, args dap.LaunchArguments) (*cpu.CPU, error) {
+	load.raw = load.raw || args.Raw
+	if args.Load != "" {
+		if err := load.load.Set(args.Load); err != nil {
+			return nil, err
+		}
+	}
+	if args.Entry != "" {
+		if err := load.entry.Set(args.Entry); err != nil {
+			return nil, err
+		}
+	}
+
+	s, err := load.open(args.Program)
+	if err != nil {
+		return nil, err
+	}
+	return s.cpu, nil
+}
+
+// stdio joins stdin and stdout, which is how editors usually talk to debug
+// adapters they start themselves.
+type stdio struct{}
+
+func (stdio) Read(p []byte) (int, error) {
+	return os.Stdin.Read(p)
+}
+
+func (stdio) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"switchtrue.com/hankee/cheat"
+	"switchtrue.com/hankee/cpu"
+	"switchtrue.com/hankee/disasm"
+	"switchtrue.com/hankee/ramsearch"
+)
+
+// Most search candidates listed at once.
+const SEARCH_LIST_LIMIT = 20
+
+func debugCommand(args []string) int {
+	fs := newFlagSet("debug", "[options] <file>")
+	var load loadOptions
+	var limits limitOptions
+	var cheats cheatOptions
+	load.register(fs)
+	limits.register(fs)
+	cheats.register(fs)
+
+	path, code, ok := parseFileArgs(fs, args)
+	if !ok {
+		return code
+	}
+
+	s, err := load.open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+
+	codes, err := cheats.codes()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+
+	d := newDebugger(s, limits, os.Stdout)
+	for _, code := range codes {
+		d.cheatEngine().Add(code)
+	}
+	return d.repl(os.Stdin)
+}
+
+type debuggerCommand struct {
+	names []string
+	usage string
+	run   func(d *debugger, args []string) error
+}
+
+var debuggerCommands []debuggerCommand
+
+func init() {
+	debuggerCommands = []debuggerCommand{
+		{[]string{"step", "s"}, "step [n]            execute n instructions (default 1)", (*debugger).step},
+		{[]string{"continue", "c"}, "continue            run until a breakpoint or the program halts", (*debugger).cont},
+		{[]string{"break", "b"}, "break [addr]        set a breakpoint, or list them", (*debugger).setBreakpoint},
+		{[]string{"delete", "d"}, "delete addr         remove a breakpoint", (*debugger).deleteBreakpoint},
+		{[]string{"regs", "r"}, "regs                show the registers", (*debugger).regs},
+		{[]string{"mem", "m"}, "mem addr [len]      dump memory", (*debugger).mem},
+		{[]string{"write", "w"}, "write addr value... write bytes to memory", (*debugger).write},
+		{[]string{"dis"}, "dis [addr] [n]      disassemble n instructions (default 10)", (*debugger).dis},
+		{[]string{"search", "sr"}, "search [op] [n]     search RAM: new [8|16] [signed], eq/ne/gt/lt/ge/le [n], by n, list", (*debugger).ramSearch},
+		{[]string{"freeze", "f"}, "freeze [addr value] hold a value in memory, or list held bytes", (*debugger).freeze},
+		{[]string{"unfreeze"}, "unfreeze addr       stop holding a value", (*debugger).unfreeze},
+		{[]string{"cheat"}, "cheat [cmd]         list cheats, or: add code [name], on n, off n, remove n", (*debugger).cheat},
+		{[]string{"reset"}, "reset               reset the CPU", (*debugger).reset},
+		{[]string{"help", "h"}, "help                show this help", (*debugger).help},
+	}
+}
+
+// debugger is a small line based monitor for stepping through a program.
+type debugger struct {
+	session     *session
+	limits      limitOptions
+	out         io.Writer
+	breakpoints map[uint16]bool
+	halted      bool
+	search      *ramsearch.Search
+	freezer     *ramsearch.Freezer
+	cheats      *cheat.Engine
+}
+
+func newDebugger(s *session, limits limitOptions, out io.Writer) *debugger {
+	return &debugger{
+		session:     s,
+		limits:      limits,
+		out:         out,
+		breakpoints: map[uint16]bool{},
+		freezer:     ramsearch.NewFreezer(),
+	}
+}
+
+// Reads commands until quit or end of input. An empty line repeats the last
+// command, which makes stepping quicker.
+func (d *debugger) repl(in io.Reader) int {
+	scanner := bufio.NewScanner(in)
+	last := ""
+	fmt.Fprintln(d.out, disasm.Trace(d.session.cpu))
+	fmt.Fprint(d.out, "> ")
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			line = last
+		}
+		last = line
+
+		fields := strings.Fields(line)
+		if len(fields) > 0 {
+			if fields[0] == "quit" || fields[0] == "q" {
+				return exitOK
+			}
+			if err := d.exec(fields[0], fields[1:]); err != nil {
+				fmt.Fprintf(d.out, "error: %v\n", err)
+			}
+		}
+		fmt.Fprint(d.out, "> ")
+	}
+	fmt.Fprintln(d.out)
+	return exitOK
+}
+
+func (d *debugger) exec(name string, args []string) error {
+	for _, c := range debuggerCommands {
+		for _, n := range c.names {
+			if n == name {
+				return c.run(d, args)
+			}
+		}
+	}
+	return fmt.Errorf("unknown command %q, try help", name)
+}
+
+func (d *debugger) step(args []string) error {
+	n := 1
+	if len(args) > 0 {
+		var err error
+		n, err = strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid count %q", args[0])
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		if d.halted {
+			return fmt.Errorf("program has halted, use reset to start again")
+		}
+		d.applyHeld()
+		running, err := step(d.session.cpu)
+		if err != nil {
+			return err
+		}
+		d.halted = !running
+		fmt.Fprintln(d.out, disasm.Trace(d.session.cpu))
+	}
+	return nil
+}
+
+func (d *debugger) cont(args []string) error {
+	if d.halted {
+		return fmt.Errorf("program has halted, use reset to start again")
+	}
+
+	first := true
+	reason, err := d.limits.execute(d.session.cpu, func() bool {
+		d.applyHeld()
+		// Don't stop on the breakpoint we're already sitting on.
+		if first {
+			first = false
+			return true
+		}
+		return !d.breakpoints[d.session.cpu.Registers().PC]
+	})
+	if err != nil {
+		return err
+	}
+
+	switch reason {
+	case stopHalted:
+		d.halted = true
+		fmt.Fprintln(d.out, "program halted")
+	case stopLimit:
+		fmt.Fprintln(d.out, "limit reached")
+	case stopRequested:
+		fmt.Fprintf(d.out, "breakpoint at $%04X\n", d.session.cpu.Registers().PC)
+	}
+	fmt.Fprintln(d.out, disasm.Trace(d.session.cpu))
+	return nil
+}
+
+func (d *debugger) setBreakpoint(args []string) error {
+	if len(args) == 0 {
+		addrs := make([]int, 0, len(d.breakpoints))
+		for addr := range d.breakpoints {
+			addrs = append(addrs, int(addr))
+		}
+		sort.Ints(addrs)
+		for _, addr := range addrs {
+			fmt.Fprintf(d.out, "$%04X\n", addr)
+		}
+		return nil
+	}
+
+	addr, err := parseAddress(args[0])
+	if err != nil {
+		return err
+	}
+	d.breakpoints[addr] = true
+	return nil
+}
+
+func (d *debugger) deleteBreakpoint(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: delete addr")
+	}
+	addr, err := parseAddress(args[0])
+	if err != nil {
+		return err
+	}
+	delete(d.breakpoints, addr)
+	return nil
+}
+
+func (d *debugger) regs(args []string) error {
+	printRegisters(d.out, d.session.cpu)
+	return nil
+}
+
+func (d *debugger) mem(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: mem addr [len]")
+	}
+	addr, err := parseAddress(args[0])
+	if err != nil {
+		return err
+	}
+	length := 16
+	if len(args) > 1 {
+		length, err = strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid length %q", args[1])
+		}
+	}
+
+	for row := 0; row < length; row += 16 {
+		fmt.Fprintf(d.out, "%04X ", addr+uint16(row))
+		for col := row; col < row+16 && col < length; col++ {
+			fmt.Fprintf(d.out, " %02X", d.session.cpu.MemRead(addr+uint16(col)))
+		}
+		fmt.Fprintln(d.out)
+	}
+	return nil
+}
+
+func (d *debugger) write(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: write addr value...")
+	}
+	addr, err := parseAddress(args[0])
+	if err != nil {
+		return err
+	}
+	for i, arg := range args[1:] {
+		value, err := parseByte(arg)
+		if err != nil {
+			return err
+		}
+		d.session.cpu.MemWrite(addr+uint16(i), value)
+	}
+	return nil
+}
+
+func (d *debugger) dis(args []string) error {
+	addr := d.session.cpu.Registers().PC
+	n := 10
+	var err error
+	if len(args) > 0 {
+		addr, err = parseAddress(args[0])
+		if err != nil {
+			return err
+		}
+	}
+	if len(args) > 1 {
+		n, err = strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid count %q", args[1])
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		instruction := disasm.Disassemble(d.session.cpu.Memory(), addr)
+		marker := " "
+		if d.breakpoints[addr] {
+			marker = "*"
+		}
+		fmt.Fprintf(d.out, "%s %04X  %-8s  %s\n", marker, addr, instruction.Hex(), instruction)
+		addr = instruction.Next()
+	}
+	return nil
+}
+
+// Returns the memory a RAM search looks through. Raw binaries have a flat
+// 64KB RAM, the first 2KB stand in for the work RAM.
+func (d *debugger) searchRegions() []ramsearch.Region {
+	if d.session.machine != nil {
+		return ramsearch.Regions(d.session.machine.Bus())
+	}
+	memory := d.session.cpu.Memory()
+	if d.cheats != nil {
+		memory = d.cheats.Memory()
+	}
+	if ram, ok := memory.(*cpu.RAM); ok {
+		return []ramsearch.Region{{Name: "ram", Base: 0, Data: ram[:0x800]}}
+	}
+	return nil
+}
+
+func (d *debugger) ramSearch(args []string) error {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+	if args[0] == "new" {
+		options := ramsearch.Options{Size: 1}
+		for _, arg := range args[1:] {
+			switch arg {
+			case "8":
+				options.Size = 1
+			case "16":
+				options.Size = 2
+			case "signed":
+				options.Signed = true
+			default:
+				return fmt.Errorf("usage: search new [8|16] [signed]")
+			}
+		}
+		search, err := ramsearch.New(options, d.searchRegions()...)
+		if err != nil {
+			return err
+		}
+		d.search = search
+		fmt.Fprintf(d.out, "candidates: %d\n", search.Count())
+		return nil
+	}
+
+	if d.search == nil {
+		return fmt.Errorf("no search in progress, start one with search new")
+	}
+	switch {
+	case args[0] == "list":
+		limit := SEARCH_LIST_LIMIT
+		if len(args) > 1 {
+			var err error
+			if limit, err = strconv.Atoi(args[1]); err != nil {
+				return fmt.Errorf("invalid count %q", args[1])
+			}
+		}
+		d.listCandidates(limit)
+		return nil
+	case args[0] == "by" && len(args) == 2:
+		n, err := parseValue(args[1])
+		if err != nil {
+			return err
+		}
+		d.search.FilterChangedBy(n)
+	default:
+		op, err := ramsearch.ParseOperator(args[0])
+		if err != nil {
+			return err
+		}
+		switch len(args) {
+		case 1:
+			d.search.FilterPrevious(op)
+		case 2:
+			value, err := parseValue(args[1])
+			if err != nil {
+				return err
+			}
+			d.search.FilterValue(op, value)
+		default:
+			return fmt.Errorf("usage: search %s [value]", op)
+		}
+	}
+	fmt.Fprintf(d.out, "candidates: %d\n", d.search.Count())
+	if d.search.Count() <= SEARCH_LIST_LIMIT/2 {
+		d.listCandidates(SEARCH_LIST_LIMIT)
+	}
+	return nil
+}
+
+func (d *debugger) listCandidates(limit int) {
+	for _, r := range d.search.Results(limit) {
+		fmt.Fprintf(d.out, "$%04X %-4s %d (was %d)\n", r.Address, r.Region, r.Value, r.Previous)
+	}
+	if d.search.Count() > limit {
+		fmt.Fprintf(d.out, "... %d more\n", d.search.Count()-limit)
+	}
+}
+
+// Values are held in the current search's size, so a 16 bit search freezes
+// both bytes.
+func (d *debugger) freeze(args []string) error {
+	if len(args) == 0 {
+		for _, addr := range d.freezer.Addresses() {
+			value, _ := d.freezer.Value(addr)
+			fmt.Fprintf(d.out, "$%04X = $%02X\n", addr, value)
+		}
+		return nil
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("usage: freeze addr value")
+	}
+	addr, err := parseAddress(args[0])
+	if err != nil {
+		return err
+	}
+	value, err := parseValue(args[1])
+	if err != nil {
+		return err
+	}
+	size := 1
+	if d.search != nil {
+		size = d.search.Options().Size
+	}
+	d.freezer.Freeze(addr, value, size)
+	d.freezer.Apply(d.session.cpu.Memory())
+	return nil
+}
+
+func (d *debugger) unfreeze(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: unfreeze addr")
+	}
+	addr, err := parseAddress(args[0])
+	if err != nil {
+		return err
+	}
+	size := 1
+	if d.search != nil {
+		size = d.search.Options().Size
+	}
+	for i := 0; i < size; i++ {
+		d.freezer.Unfreeze(addr + uint16(i))
+	}
+	return nil
+}
+
+// Writes frozen values and RAM cheats. There are no frames while stepping, so
+// they're written before every instruction instead.
+func (d *debugger) applyHeld() {
+	d.freezer.Apply(d.session.cpu.Memory())
+	if d.cheats != nil {
+		d.cheats.Frame()
+	}
+}
+
+// Returns the cheat engine, putting it in front of memory the first time a
+// cheat is added.
+func (d *debugger) cheatEngine() *cheat.Engine {
+	if d.cheats == nil {
+		d.cheats = cheat.New(d.session.cpu.Memory())
+		d.session.cpu.SetMemory(d.cheats)
+	}
+	return d.cheats
+}
+
+func (d *debugger) cheat(args []string) error {
+	if len(args) == 0 {
+		if d.cheats == nil {
+			return nil
+		}
+		for i, code := range d.cheats.Codes() {
+			state := "off"
+			if code.Enabled {
+				state = "on"
+			}
+			line := fmt.Sprintf("%2d %-3s %-12s %s", i, state, code, code.Name)
+			fmt.Fprintln(d.out, strings.TrimRight(line, " "))
+		}
+		return nil
+	}
+
+	cheats := d.cheatEngine()
+	if args[0] == "add" {
+		if len(args) < 2 {
+			return fmt.Errorf("usage: cheat add code [name]")
+		}
+		code, err := cheat.Parse(args[1])
+		if err != nil {
+			return err
+		}
+		code.Name = strings.Join(args[2:], " ")
+		fmt.Fprintf(d.out, "cheat %d: %s\n", cheats.Add(code), code)
+		return nil
+	}
+
+	if len(args) != 2 {
+		return fmt.Errorf("usage: cheat on|off|remove n")
+	}
+	i, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid cheat number %q", args[1])
+	}
+	switch args[0] {
+	case "on":
+		return cheats.SetEnabled(i, true)
+	case "off":
+		return cheats.SetEnabled(i, false)
+	case "remove":
+		return cheats.Remove(i)
+	default:
+		return fmt.Errorf("unknown cheat command %q, expected add, on, off or remove", args[0])
+	}
+}
+
+func (d *debugger) reset(args []string) error {
+	d.session.cpu.Reset()
+	d.halted = false
+	fmt.Fprintln(d.out, disasm.Trace(d.session.cpu))
+	return nil
+}
+
+func (d *debugger) help(args []string) error {
+	for _, c := range debuggerCommands {
+		fmt.Fprintf(d.out, "  %s\n", c.usage)
+	}
+	fmt.Fprintln(d.out, "  quit                leave the debugger")
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"switchtrue.com/hankee/disasm"
+)
+
+func disasmCommand(args []string) int {
+	fs := newFlagSet("disasm", "[options] <file>")
+	var load loadOptions
+	load.register(fs)
+	var start, end address
+	fs.Var(&start, "start", "first address to disassemble (defaults to the start of the program)")
+	fs.Var(&end, "end", "last address to disassemble (defaults to the end of the program)")
+
+	path, code, ok := parseFileArgs(fs, args)
+	if !ok {
+		return code
+	}
+
+	s, err := load.open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+
+	// ROMs cover $8000-$FFFF, raw binaries just the bytes that were loaded.
+	from, to := uint16(0x8000), uint16(0xFFFF)
+	if s.cartridge == nil {
+		from = load.load.value
+		to = from + uint16(len(s.program)) - 1
+	}
+	if start.set {
+		from = start.value
+	}
+	if end.set {
+		to = end.value
+	}
+	if to < from {
+		fmt.Fprintln(os.Stderr, "hankee: end address is before the start address")
+		return exitUsage
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for _, instruction := range disasm.Range(s.cpu.Memory(), from, to) {
+		fmt.Fprintf(w, "%04X  %-8s  %s\n", instruction.Address, instruction.Hex(), instruction)
+	}
+	return exitOK
+}
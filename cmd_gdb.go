@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"switchtrue.com/hankee/gdb"
+)
+
+func gdbCommand(args []string) int {
+	fs := newFlagSet("gdb", "[options] <file>")
+	var load loadOptions
+	load.register(fs)
+	addr := fs.String("listen", "localhost:6502", "address to listen on, host:port or unix:/path/to/socket")
+
+	path, code, ok := parseFileArgs(fs, args)
+	if !ok {
+		return code
+	}
+
+	s, err := load.open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+
+	listener, err := listen(*addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+	defer listener.Close()
+
+	fmt.Fprintf(os.Stderr, "waiting for a debugger on %s\n", listener.Addr())
+	if err := gdb.NewServer(s.cpu).Serve(listener); err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// Listens on a TCP address, or a Unix socket when the address starts with
+// unix:.
+func listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
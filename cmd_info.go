@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"switchtrue.com/hankee/cartridge"
+)
+
+// romInfo is everything the info command reports about a ROM.
+type romInfo struct {
+	File      string                        `json:"file"`
+	Size      int                           `json:"size"`
+	Header    cartridge.Header              `json:"header"`
+	Checksums map[string]cartridge.Checksum `json:"checksums"`
+}
+
+func infoCommand(args []string) int {
+	fs := newFlagSet("info", "[options] <rom>")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	path, code, ok := parseFileArgs(fs, args)
+	if !ok {
+		return code
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+
+	info, err := inspectROM(path, raw)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %s: %v\n", path, err)
+		return exitFailure
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(info); err != nil {
+			fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+			return exitFailure
+		}
+		return exitOK
+	}
+
+	printROMInfo(os.Stdout, info)
+	return exitOK
+}
+
+func inspectROM(path string, raw []uint8) (romInfo, error) {
+	header, err := cartridge.ParseHeader(raw)
+	if err != nil {
+		return romInfo{}, err
+	}
+
+	_, prg, chr := header.Sections(raw)
+	rom := append(append([]uint8(nil), prg...), chr...)
+	return romInfo{
+		File:   path,
+		Size:   len(raw),
+		Header: header,
+		Checksums: map[string]cartridge.Checksum{
+			"prg":  cartridge.ChecksumOf(prg),
+			"chr":  cartridge.ChecksumOf(chr),
+			"rom":  cartridge.ChecksumOf(rom),
+			"file": cartridge.ChecksumOf(raw),
+		},
+	}, nil
+}
+
+func printROMInfo(w io.Writer, info romInfo) {
+	h := info.Header
+	fmt.Fprintf(w, "File:       %s (%d bytes)\n", info.File, info.Size)
+	fmt.Fprintf(w, "Format:     %s\n", h.Format)
+	fmt.Fprintf(w, "Console:    %s\n", h.Console)
+	fmt.Fprintf(w, "Mapper:     %d", h.Mapper)
+	if h.Format == cartridge.FormatNES20 {
+		fmt.Fprintf(w, " (submapper %d)", h.Submapper)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Region:     %s\n", h.Timing)
+	fmt.Fprintf(w, "PRG ROM:    %s\n", formatSize(h.PRGSize))
+	fmt.Fprintf(w, "CHR ROM:    %s\n", formatSize(h.CHRSize))
+	fmt.Fprintf(w, "PRG RAM:    %s (%s battery backed)\n", formatSize(h.PRGRAMSize), formatSize(h.PRGNVRAMSize))
+	fmt.Fprintf(w, "CHR RAM:    %s (%s battery backed)\n", formatSize(h.CHRRAMSize), formatSize(h.CHRNVRAMSize))
+	fmt.Fprintf(w, "Mirroring:  %s\n", h.Mirroring)
+	fmt.Fprintf(w, "Battery:    %t\n", h.Battery)
+	fmt.Fprintf(w, "Trainer:    %t\n", h.Trainer)
+	fmt.Fprintln(w)
+	for _, name := range []string{"prg", "chr", "rom", "file"} {
+		sum := info.Checksums[name]
+		fmt.Fprintf(w, "%-4s  CRC32 %s  SHA-1 %s\n", name, sum.CRC32, sum.SHA1)
+	}
+	if len(h.Warnings) > 0 {
+		fmt.Fprintln(w)
+		for _, warning := range h.Warnings {
+			fmt.Fprintf(w, "warning: %s\n", warning)
+		}
+	}
+}
+
+func formatSize(size int) string {
+	if size >= 1024 && size%1024 == 0 {
+		return fmt.Sprintf("%d KB", size/1024)
+	}
+	return fmt.Sprintf("%d bytes", size)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"switchtrue.com/hankee/cartridge"
+	"switchtrue.com/hankee/nes"
+	"switchtrue.com/hankee/netplay"
+	"switchtrue.com/hankee/terminal"
+	"switchtrue.com/hankee/video"
+)
+
+// Plays a ROM with someone on another machine. The host is player 1 and
+// waits for player 2 to join.
+func netplayCommand(args []string) int {
+	fs := newFlagSet("netplay", "(--host addr | --join addr) [options] <rom>")
+	var load loadOptions
+	var display displayOptions
+	load.register(fs)
+	display.register(fs)
+	// Playing is the point, so the picture is on unless asked otherwise.
+	fs.Lookup("display").DefValue = "term"
+	display.display = "term"
+	host := fs.String("host", "", "address to wait for player 2 on, such as :7777")
+	join := fs.String("join", "", "address of the host to join as player 2, such as example.com:7777")
+	delay := fs.Int("delay", 2, "frames of input delay, which both sides must agree on")
+	frames := fs.Int("frames", 0, "stop after this many frames (0 to play until quitting)")
+	verbose := fs.Bool("v", false, "print the frames, rollbacks and a hash of the final state")
+
+	path, code, ok := parseFileArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if err := display.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitUsage
+	}
+	switch {
+	case (*host == "") == (*join == ""):
+		fmt.Fprintln(os.Stderr, "hankee: netplay needs one of --host or --join")
+		return exitUsage
+	case display.display == "none" && *frames == 0:
+		fmt.Fprintln(os.Stderr, "hankee: netplay with --display none needs --frames")
+		return exitUsage
+	}
+
+	if err := display.loadPalette(); err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+	s, err := load.open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+	if s.machine == nil {
+		fmt.Fprintln(os.Stderr, "hankee: netplay needs a ROM, raw binaries have no controllers")
+		return exitUsage
+	}
+	s.machine.SetPalette(display.colours)
+
+	config := netplay.Config{Player: 1, Delay: *delay, Game: cartridge.ChecksumOf(s.cartridge.PRG).CRC32}
+	var conn net.Conn
+	if *host != "" {
+		conn, err = acceptPlayer(*host)
+	} else {
+		config.Player = 2
+		conn, err = net.Dial("tcp", *join)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+	session, err := netplay.Start(conn, s.machine, config)
+	if err != nil {
+		conn.Close()
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+	defer session.Close()
+
+	console := &netplayConsole{
+		session: session,
+		machine: s.machine,
+		buttons: terminal.NewButtons(terminal.DefaultKeymap),
+		frames:  *frames,
+	}
+	if display.display == "term" {
+		fps := display.fps
+		if fps == 0 {
+			fps = s.machine.Timing().FrameRate()
+		}
+		err = terminal.Run(console, display.terminal(fps))
+		if errors.Is(err, terminal.ErrInterrupted) {
+			err = nil
+		}
+	} else {
+		for console.StepFrame() {
+		}
+	}
+	if err == nil {
+		err = console.err
+	}
+	if err == nil && *frames > 0 {
+		// Both sides stop on the same frame, so settle any predictions for
+		// them to finish in the same state.
+		err = session.Confirm()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+
+	if *verbose {
+		hash, err := netplay.Hash(s.machine.SaveState())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+			return exitFailure
+		}
+		fmt.Fprintf(os.Stderr, "frames %d, rollbacks %d, state %016x\n", session.FrameCount(), session.Rollbacks(), hash)
+	}
+	return exitOK
+}
+
+// Waits for one player to connect.
+func acceptPlayer(addr string) (net.Conn, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+	fmt.Fprintf(os.Stderr, "waiting for player 2 on %s\n", listener.Addr())
+	return listener.Accept()
+}
+
+// netplayConsole plays the local player's keys through a netplay session.
+type netplayConsole struct {
+	session *netplay.Session
+	machine *nes.Machine
+	buttons *terminal.Buttons
+	frames  int
+	err     error
+}
+
+func (c *netplayConsole) Key(key terminal.Key) {
+	c.buttons.Press(key)
+}
+
+func (c *netplayConsole) StepFrame() bool {
+	if c.frames > 0 && c.session.FrameCount() >= c.frames {
+		return false
+	}
+	c.err = c.session.Frame(c.buttons.Frame())
+	return c.err == nil
+}
+
+func (c *netplayConsole) Frame() *video.Frame {
+	return c.machine.Frame()
+}
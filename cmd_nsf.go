@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"switchtrue.com/hankee/nes"
+	"switchtrue.com/hankee/nsf"
+	"switchtrue.com/hankee/wav"
+)
+
+// Track length and fade out for files that don't give them.
+const (
+	DEFAULT_TRACK_SECONDS = 120
+	DEFAULT_FADE_SECONDS  = 5
+)
+
+func nsfCommand(args []string) int {
+	fs := newFlagSet("nsf", "[options] <file>")
+	track := fs.Int("track", 0, "track to play, counting from 1 (default the file's first track)")
+	out := fs.String("out", "", "WAV file to write (default the file's name and the track number)")
+	seconds := fs.Float64("seconds", 0, fmt.Sprintf("seconds to play for (default the file's track length, or %d)", DEFAULT_TRACK_SECONDS))
+	fadeSeconds := fs.Float64("fade", 0, fmt.Sprintf("seconds to fade out over at the end (default the file's fade, or %d)", DEFAULT_FADE_SECONDS))
+	regionName := fs.String("region", "auto", "console region: auto, ntsc, pal or dendy")
+
+	path, code, ok := parseFileArgs(fs, args)
+	if !ok {
+		return code
+	}
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	f, err := nsf.LoadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+	region := nsf.RegionFor(f)
+	if *regionName != "auto" {
+		if region, err = nes.ParseRegion(*regionName); err != nil {
+			fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+			return exitUsage
+		}
+	}
+	if *track == 0 {
+		*track = f.StartSong
+	}
+	if *out == "" {
+		*out = fmt.Sprintf("%s-%d.wav", strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)), *track)
+	}
+	if f.Expansion != 0 {
+		fmt.Fprintf(os.Stderr, "hankee: the tune uses %s audio, which isn't emulated, only the 2A03 channels are rendered\n", f.Expansion)
+	}
+
+	length, fade := f.TrackLength(*track)
+	if !given["seconds"] {
+		*seconds = DEFAULT_TRACK_SECONDS
+		if length >= 0 {
+			*seconds = float64(length) / 1000
+		}
+	}
+	if !given["fade"] {
+		*fadeSeconds = DEFAULT_FADE_SECONDS
+		if fade >= 0 {
+			*fadeSeconds = float64(fade) / 1000
+		}
+	}
+
+	p := nsf.NewPlayer(f, region)
+	if err := p.Init(*track); err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+	rate := p.APU().SampleRate()
+	total := int(*seconds * float64(rate))
+	samples := make([]int16, 0, total)
+	for len(samples) < total {
+		s, err := p.Play()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+			return exitFailure
+		}
+		samples = append(samples, s...)
+	}
+	samples = samples[:total]
+	fadeOut(samples, int(*fadeSeconds*float64(rate)))
+
+	if err := writeWAV(*out, rate, samples); err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+	name := f.TrackName(*track)
+	if name == "" {
+		name = f.Name
+	}
+	fmt.Fprintf(os.Stderr, "rendered track %d of %d (%s), %.1f seconds, to %s\n", *track, f.Songs, name, *seconds, *out)
+	return exitOK
+}
+
+// Ramps the last n samples down to silence.
+func fadeOut(samples []int16, n int) {
+	n = min(n, len(samples))
+	start := len(samples) - n
+	for i := start; i < len(samples); i++ {
+		samples[i] = int16(int(samples[i]) * (len(samples) - i) / n)
+	}
+}
+
+func writeWAV(path string, rate int, samples []int16) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w, err := wav.NewWriter(f, rate, 1)
+	if err == nil {
+		err = w.Write(samples)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
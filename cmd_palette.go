@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"switchtrue.com/hankee/ntsc"
+	"switchtrue.com/hankee/palette"
+)
+
+// Writes a palette out as a 512 colour .pal file, for tuning the composite
+// palette once or converting a 64 colour file for other emulators.
+func paletteCommand(args []string) int {
+	fs := newFlagSet("palette", "[options] <out.pal>")
+	name := fs.String("palette", "composite", "palette to write: "+strings.Join(palette.NAMES, ", ")+" or a .pal file")
+	var options ntsc.Options
+	fs.Float64Var(&options.Hue, "ntsc-hue", 0, "hue of the composite palette, -1 to 1")
+	fs.Float64Var(&options.Saturation, "ntsc-saturation", 0, "saturation of the composite palette, -1 for black and white to 1")
+
+	out, code, ok := parseFileArgs(fs, args)
+	if !ok {
+		return code
+	}
+	colours, err := palette.Load(*name, options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+	writeErr := palette.Write(f, colours)
+	if closeErr := f.Close(); writeErr == nil {
+		writeErr = closeErr
+	}
+	if writeErr != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", writeErr)
+		return exitFailure
+	}
+	return exitOK
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"switchtrue.com/hankee/dbginfo"
+	"switchtrue.com/hankee/profiler"
+)
+
+// How long to profile for when no limit is given, since games never halt.
+const DEFAULT_PROFILE_FRAMES = 600
+
+func profileCommand(args []string) int {
+	fs := newFlagSet("profile", "[options] <file>")
+	var load loadOptions
+	var limits limitOptions
+	load.register(fs)
+	limits.register(fs)
+	out := fs.String("o", "hankee.pprof", "file to write the pprof profile to")
+	debugInfo := fs.String("dbg", "", "ld65 debug info for routine names (default the file with a .dbg extension)")
+	frames := fs.Uint64("frames", DEFAULT_PROFILE_FRAMES, "frames to run for when there's no other limit")
+
+	path, code, ok := parseFileArgs(fs, args)
+	if !ok {
+		return code
+	}
+
+	s, err := load.open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+
+	var symbols *dbginfo.Info
+	if dbgPath := dbginfo.PathFor(*debugInfo, path); dbgPath != "" {
+		if symbols, err = dbginfo.LoadFile(dbgPath); err != nil {
+			fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+			return exitFailure
+		}
+	}
+	if limits.maxCycles == 0 && limits.maxInstructions == 0 {
+		limits.maxCycles = s.cpu.Cycles() + uint64(float64(*frames)*s.timing().CyclesPerFrame())
+	}
+
+	p := profiler.New(symbols)
+	// Running out of time is how a profile normally ends, so a limit isn't
+	// an error here.
+	_, err = limits.execute(s.cpu, func() bool {
+		p.Before(s.cpu)
+		return true
+	})
+	p.Stop(s.cpu)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v, writing the profile so far\n", err)
+	}
+
+	f, createErr := os.Create(*out)
+	if createErr != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", createErr)
+		return exitFailure
+	}
+	writeErr := p.Write(f, path)
+	if closeErr := f.Close(); writeErr == nil {
+		writeErr = closeErr
+	}
+	if writeErr != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", writeErr)
+		return exitFailure
+	}
+	fmt.Fprintf(os.Stderr, "profiled %d cycles, see go tool pprof %s\n", p.Cycles(), *out)
+	if err != nil {
+		return exitFailure
+	}
+	return exitOK
+}
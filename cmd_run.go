@@ -0,0 +1,283 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"switchtrue.com/hankee/audio"
+	"switchtrue.com/hankee/cartridge"
+	"switchtrue.com/hankee/cdl"
+	"switchtrue.com/hankee/joypad"
+	"switchtrue.com/hankee/script"
+)
+
+// Frames between battery saves, about 5 seconds.
+const DEFAULT_SAVE_INTERVAL = 300
+
+func runCommand(args []string) int {
+	fs := newFlagSet("run", "[options] <file>")
+	var load loadOptions
+	var limits limitOptions
+	var display displayOptions
+	load.register(fs)
+	limits.register(fs)
+	display.register(fs)
+	var cheats cheatOptions
+	cheats.register(fs)
+	var capturing captureOptions
+	capturing.register(fs)
+	verbose := fs.Bool("v", false, "print the registers when the program stops")
+	scriptPath := fs.String("script", "", "Lua script to run alongside the program")
+	saveDir := fs.String("save-dir", "", "directory for battery saves (default next to the ROM)")
+	saveEvery := fs.Uint64("save-every", DEFAULT_SAVE_INTERVAL, "frames between battery saves while running (0 to only save on exit)")
+	audioPath := fs.String("audio", "", "WAV file to record the sound to, or - for raw 16 bit mono PCM on stdout")
+	audioChannels := fs.Bool("audio-channels", false, "also record each APU channel to its own WAV file next to --audio")
+	cdlPath := fs.String("cdl", "", "FCEUX style .cdl file to log code and data use to, added to if it exists")
+	tapePath := fs.String("tape", "", "WAV file to play into the Family BASIC data recorder")
+	tapeRecord := fs.String("tape-record", "", "WAV file to record the Family BASIC data recorder to")
+
+	path, code, ok := parseFileArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if err := display.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitUsage
+	}
+	if err := capturing.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitUsage
+	}
+
+	if err := display.loadPalette(); err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+
+	s, err := load.open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+	if s.machine != nil {
+		s.machine.SetPalette(display.colours)
+	}
+
+	var extras hooks
+	if len(cheats.specs) > 0 {
+		engine, err := cheats.install(s.cpu)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+			return exitFailure
+		}
+		extras.frame = append(extras.frame, func() bool {
+			engine.Frame()
+			return true
+		})
+	}
+
+	var saver *cartridge.Saver
+	if s.cartridge != nil && s.cartridge.Battery {
+		if saver, err = cartridge.NewSaver(s.cartridge, cartridge.SavePath(path, *saveDir)); err != nil {
+			fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+			return exitFailure
+		}
+		if *saveEvery > 0 {
+			var frames uint64
+			extras.frame = append(extras.frame, func() bool {
+				if frames++; frames%*saveEvery == 0 {
+					// A failed save is retried next time round rather than
+					// stopping the game.
+					if _, err := saver.Save(); err != nil {
+						fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+					}
+				}
+				return true
+			})
+		}
+	}
+
+	var sc *script.Script
+	if *scriptPath != "" {
+		target := script.Target{CPU: s.cpu}
+		if s.machine != nil {
+			target.Joypads = s.machine.Joypads()
+		}
+		if sc, err = script.Load(*scriptPath, target); err != nil {
+			fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+			return exitFailure
+		}
+		defer sc.Close()
+		extras.frame = append(extras.frame, sc.Frame)
+		extras.before = append(extras.before, func() bool { return sc.Before(s.cpu) })
+		extras.overlay = append(extras.overlay, sc.Overlay)
+	}
+
+	var recorder *audio.Recorder
+	var recordErr error
+	if *audioPath != "" {
+		if s.machine == nil {
+			fmt.Fprintln(os.Stderr, "hankee: --audio needs a ROM, raw binaries have no APU")
+			return exitUsage
+		}
+		if *audioPath == "-" && display.display == "term" {
+			fmt.Fprintln(os.Stderr, "hankee: --audio - can't share stdout with --display term")
+			return exitUsage
+		}
+		recorder, err = audio.Record(s.machine.APU(), audio.Options{Path: *audioPath, Stdout: os.Stdout, Channels: *audioChannels})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+			return exitFailure
+		}
+		extras.frame = append(extras.frame, func() bool {
+			recordErr = recorder.Collect()
+			return recordErr == nil
+		})
+	} else if *audioChannels {
+		fmt.Fprintln(os.Stderr, "hankee: --audio-channels needs --audio")
+		return exitUsage
+	}
+
+	if capturing.path != "" {
+		switch {
+		case s.machine == nil:
+			fmt.Fprintln(os.Stderr, "hankee: --capture needs a ROM, raw binaries have no picture to capture")
+			return exitUsage
+		case capturing.path == "-" && display.display == "term":
+			fmt.Fprintln(os.Stderr, "hankee: --capture - can't share stdout with --display term")
+			return exitUsage
+		case *audioPath != "" && capturing.audioPath() != "":
+			// Both would be taking samples from the same APU.
+			fmt.Fprintln(os.Stderr, "hankee: --audio and the capture's sound can't both be recorded, use --capture-audio none")
+			return exitUsage
+		}
+		extras.frame = append(extras.frame, capturing.hook(s.machine, display.colours))
+	}
+
+	closeTape := func() error { return nil }
+	if *tapePath != "" || *tapeRecord != "" {
+		var keyboard *joypad.Keyboard
+		if s.machine != nil {
+			keyboard, _ = s.machine.Joypads().Expansion().(*joypad.Keyboard)
+		}
+		if keyboard == nil {
+			fmt.Fprintln(os.Stderr, "hankee: --tape and --tape-record need --expansion keyboard")
+			return exitUsage
+		}
+		if closeTape, err = openTape(keyboard.Recorder(), *tapePath, *tapeRecord); err != nil {
+			fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+			return exitFailure
+		}
+	}
+
+	var codeDataLog *cdl.Log
+	if *cdlPath != "" {
+		if s.cartridge == nil {
+			fmt.Fprintln(os.Stderr, "hankee: --cdl needs a ROM, raw binaries have no PRG ROM to log")
+			return exitUsage
+		}
+		codeDataLog = cdl.New(s.cartridge)
+		if err := codeDataLog.LoadFile(*cdlPath); err != nil {
+			fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+			return exitFailure
+		}
+		s.cpu.SetCodeDataLogger(codeDataLog)
+	}
+
+	var reason stopReason
+	switch {
+	case display.display == "term" && s.machine != nil:
+		reason, err = display.runNES(s.machine, limits, &extras)
+	case display.display == "term":
+		reason, err = display.runEasy6502(s.cpu, limits, &extras)
+	default:
+		reason, err = limits.execute(s.cpu, extras.headless(s))
+	}
+	if *verbose {
+		printRegisters(os.Stderr, s.cpu)
+	}
+	if saver != nil {
+		saved, saveErr := saver.Save()
+		if saveErr != nil {
+			fmt.Fprintf(os.Stderr, "hankee: %v\n", saveErr)
+			return exitFailure
+		}
+		if saved && *verbose {
+			fmt.Fprintf(os.Stderr, "saved %s\n", saver.Path())
+		}
+	}
+	if captureErr := capturing.stop(); captureErr != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", captureErr)
+		return exitFailure
+	}
+	if recorder != nil {
+		if closeErr := recorder.Close(); recordErr == nil {
+			recordErr = closeErr
+		}
+		if recordErr != nil {
+			fmt.Fprintf(os.Stderr, "hankee: %v\n", recordErr)
+			return exitFailure
+		}
+	}
+	if tapeErr := closeTape(); tapeErr != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", tapeErr)
+		return exitFailure
+	}
+	if codeDataLog != nil {
+		if saveErr := codeDataLog.SaveFile(*cdlPath); saveErr != nil {
+			fmt.Fprintf(os.Stderr, "hankee: %v\n", saveErr)
+			return exitFailure
+		}
+		if *verbose {
+			stats := codeDataLog.Stats()
+			fmt.Fprintf(os.Stderr, "CDL: %d code, %d data, %d unknown of %d PRG bytes\n", stats.Code, stats.Data, stats.Unknown, len(codeDataLog.PRG))
+		}
+	}
+	if err == nil && sc != nil && !errors.Is(sc.Err(), script.ErrStopped) {
+		err = sc.Err()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+	if reason == stopLimit {
+		fmt.Fprintln(os.Stderr, "hankee: limit reached before the program halted")
+		return exitLimit
+	}
+	return exitOK
+}
+
+// Loads a tape into the data recorder and starts recording, either of which
+// can be skipped with an empty path. Returns a function finishing the
+// recording.
+func openTape(recorder *joypad.DataRecorder, play string, record string) (func() error, error) {
+	if play != "" {
+		f, err := os.Open(play)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if err := recorder.Load(f); err != nil {
+			return nil, fmt.Errorf("%s: %w", play, err)
+		}
+	}
+	if record == "" {
+		return func() error { return nil }, nil
+	}
+	f, err := os.Create(record)
+	if err != nil {
+		return nil, err
+	}
+	if err := recorder.Record(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() error {
+		err := recorder.Close()
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+
+	"switchtrue.com/hankee/httpapi"
+	"switchtrue.com/hankee/ntsc"
+	"switchtrue.com/hankee/palette"
+)
+
+func serveCommand(args []string) int {
+	fs := newFlagSet("serve", "[options] [rom]")
+	var load loadOptions
+	load.register(fs)
+	addr := fs.String("listen", "localhost:8502", "address to listen on, must be localhost or unix:/path/to/socket")
+	paused := fs.Bool("paused", false, "start with the frame loop paused")
+	captureDir := fs.String("capture-dir", "", "directory the API may write captures to (captures are refused without it)")
+	paletteName := fs.String("palette", "2c02", "colours for pictures and captures: "+strings.Join(palette.NAMES, ", ")+" or a .pal file")
+	path, code, ok := parseOptionalFileArgs(fs, args)
+	if !ok {
+		return code
+	}
+	if err := checkLocal(*addr); err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitUsage
+	}
+
+	colours, err := palette.Load(*paletteName, ntsc.Options{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+
+	e := httpapi.NewEmulator(nil)
+	e.SetPaused(*paused)
+	e.SetPalette(colours)
+	if path != "" {
+		s, err := load.open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+			return exitFailure
+		}
+		if s.machine == nil {
+			fmt.Fprintln(os.Stderr, "hankee: the API needs a ROM, not a raw binary")
+			return exitUsage
+		}
+		e.SetMachine(s.machine)
+	}
+
+	listener, err := listen(*addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go e.Run(ctx)
+
+	server := &http.Server{Handler: httpapi.NewHandler(e, httpapi.Options{
+		CaptureDir: *captureDir,
+		AnyHost:    strings.HasPrefix(*addr, "unix:"),
+	})}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	fmt.Fprintf(os.Stderr, "serving the API on %s\n", listener.Addr())
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// The API has no authentication and can read files, so it must only be
+// reachable from this machine. The handler also turns away requests browsers
+// make on behalf of web pages.
+func checkLocal(addr string) error {
+	if strings.HasPrefix(addr, "unix:") {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if httpapi.IsLocalHost(host) {
+		return nil
+	}
+	return fmt.Errorf("%s is not a localhost address, the API only listens on localhost", addr)
+}
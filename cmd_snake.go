@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"switchtrue.com/hankee/cpu"
+	"switchtrue.com/hankee/easy6502"
+)
+
+// Plays the snake game from chapter 3 of the tutorial in the terminal, an end
+// to end demo of the CPU core.
+func snakeCommand(args []string) int {
+	fs := newFlagSet("snake", "[options]")
+	var display displayOptions
+	display.registerEasy6502(fs)
+	if err := fs.Parse(args); err != nil {
+		return parseExit(err)
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	c := cpu.NewCPU()
+	c.LoadAt(easy6502.SNAKE_LOAD_ADDRESS, easy6502.SNAKE)
+	c.Reset()
+
+	_, err := display.runEasy6502(c, limitOptions{}, &hooks{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+	fmt.Printf("Game over, length %d\n", c.MemRead(0x03)/2)
+	return exitOK
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"switchtrue.com/hankee/cpu"
+)
+
+// Test ROMs written by blargg report their progress through PRG RAM:
+//
+//	$6000       status, $80 while running, $81 when the ROM wants a reset,
+//	            anything below $80 is the final result with 0 meaning passed
+//	$6001-$6003 $DE $B0 $61 once the status byte is valid
+//	$6004       zero terminated text output
+const (
+	TEST_STATUS         uint16 = 0x6000
+	TEST_SIGNATURE      uint16 = 0x6001
+	TEST_OUTPUT         uint16 = 0x6004
+	TEST_STATUS_RUNNING uint8  = 0x80
+	TEST_STATUS_RESET   uint8  = 0x81
+
+	// ROMs asking for a reset need it to happen at least 100ms later, which is
+	// roughly this many cycles on an NTSC CPU.
+	TEST_RESET_DELAY_CYCLES = 178_977
+)
+
+var TEST_SIGNATURE_BYTES = []uint8{0xDE, 0xB0, 0x61}
+
+// expectations is a repeatable flag of ADDR=VALUE memory checks made once the
+// program halts.
+type expectations []expectation
+
+type expectation struct {
+	addr  uint16
+	value uint8
+}
+
+func (e *expectations) String() string {
+	parts := make([]string, len(*e))
+	for i, ex := range *e {
+		parts[i] = fmt.Sprintf("$%04X=$%02X", ex.addr, ex.value)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (e *expectations) Set(s string) error {
+	addr, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("expected ADDR=VALUE, got %q", s)
+	}
+	a, err := parseAddress(addr)
+	if err != nil {
+		return err
+	}
+	v, err := parseByte(value)
+	if err != nil {
+		return err
+	}
+	*e = append(*e, expectation{a, v})
+	return nil
+}
+
+func testCommand(args []string) int {
+	fs := newFlagSet("test", "[options] <file>")
+	var load loadOptions
+	var limits limitOptions
+	var expect expectations
+	load.register(fs)
+	limits.register(fs)
+	fs.Var(&expect, "expect", "check memory holds a value once the program halts, as ADDR=VALUE (repeatable)")
+
+	path, code, ok := parseFileArgs(fs, args)
+	if !ok {
+		return code
+	}
+
+	s, err := load.open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+
+	var resetAt uint64
+	reported := false
+	result := uint8(0)
+	reason, err := limits.execute(s.cpu, func() bool {
+		if !hasTestSignature(s.cpu) {
+			return true
+		}
+
+		switch status := s.cpu.MemRead(TEST_STATUS); {
+		case status == TEST_STATUS_RUNNING:
+			return true
+		case status == TEST_STATUS_RESET:
+			if resetAt == 0 {
+				resetAt = s.cpu.Cycles() + TEST_RESET_DELAY_CYCLES
+			} else if s.cpu.Cycles() >= resetAt {
+				resetAt = 0
+				s.cpu.MemWrite(TEST_STATUS, TEST_STATUS_RUNNING)
+				s.cpu.Reset()
+			}
+			return true
+		default:
+			reported = true
+			result = status
+			return false
+		}
+	})
+
+	if output := testOutput(s.cpu); output != "" {
+		fmt.Println(output)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+	if reason == stopLimit {
+		fmt.Fprintln(os.Stderr, "TIMEOUT")
+		return exitLimit
+	}
+	if reported && result != 0 {
+		fmt.Fprintf(os.Stderr, "FAIL: result code %d\n", result)
+		return exitFailure
+	}
+
+	failed := false
+	for _, ex := range expect {
+		if got := s.cpu.MemRead(ex.addr); got != ex.value {
+			fmt.Fprintf(os.Stderr, "FAIL: $%04X is $%02X, expected $%02X\n", ex.addr, got, ex.value)
+			failed = true
+		}
+	}
+	if failed {
+		return exitFailure
+	}
+
+	fmt.Fprintln(os.Stderr, "PASS")
+	return exitOK
+}
+
+func hasTestSignature(c *cpu.CPU) bool {
+	for i, b := range TEST_SIGNATURE_BYTES {
+		if c.MemRead(TEST_SIGNATURE+uint16(i)) != b {
+			return false
+		}
+	}
+	return true
+}
+
+func testOutput(c *cpu.CPU) string {
+	if !hasTestSignature(c) {
+		return ""
+	}
+	var text bytes.Buffer
+	for addr := TEST_OUTPUT; addr < 0x8000; addr++ {
+		b := c.MemRead(addr)
+		if b == 0 {
+			break
+		}
+		text.WriteByte(b)
+	}
+	return strings.TrimSpace(text.String())
+}
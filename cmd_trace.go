@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"switchtrue.com/hankee/disasm"
+)
+
+func traceCommand(args []string) int {
+	fs := newFlagSet("trace", "[options] <file>")
+	var load loadOptions
+	var limits limitOptions
+	load.register(fs)
+	limits.register(fs)
+	out := fs.String("o", "-", "file to write the trace to, - for stdout")
+
+	path, code, ok := parseFileArgs(fs, args)
+	if !ok {
+		return code
+	}
+
+	s, err := load.open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+
+	f := os.Stdout
+	if *out != "-" {
+		f, err = os.Create(*out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+			return exitFailure
+		}
+		defer f.Close()
+	}
+	w := bufio.NewWriter(f)
+	defer w.Flush()
+
+	reason, err := limits.execute(s.cpu, func() bool {
+		fmt.Fprintln(w, disasm.Trace(s.cpu))
+		return true
+	})
+	if err != nil {
+		w.Flush()
+		fmt.Fprintf(os.Stderr, "hankee: %v\n", err)
+		return exitFailure
+	}
+	if reason == stopLimit {
+		return exitLimit
+	}
+	return exitOK
+}
@@ -1,4 +1,4 @@
-package main
+package cpu
 
 import "fmt"
 
@@ -9,10 +9,57 @@ type CPU struct {
 	status         uint8
 	programCounter uint16
 	stackPointer   uint8
-	memory         []uint8
+	memory         Memory
+	variant        Variant
+	cycles         uint64
+
+	logger         CodeDataLogger
+	readsOperand   bool
+	jumpedIndirect bool
+}
+
+// Variant selects which flavour of 6502 is being emulated.
+type Variant int
+
+const (
+	// The Ricoh 2A03 used in the NES, which has the decimal mode circuitry
+	// disconnected so the D flag has no effect on ADC and SBC.
+	Ricoh2A03 Variant = iota
+	// The original MOS 6502 with working decimal mode.
+	MOS6502
+)
+
+func (v Variant) String() string {
+	switch v {
+	case Ricoh2A03:
+		return "2a03"
+	case MOS6502:
+		return "6502"
+	default:
+		return fmt.Sprintf("Variant(%d)", int(v))
+	}
+}
+
+// Registers is a snapshot of the CPU registers, used to inspect or modify the
+// CPU state from outside the package.
+type Registers struct {
+	A      uint8
+	X      uint8
+	Y      uint8
+	Status uint8
+	SP     uint8
+	PC     uint16
 }
 
+// Creates a CPU backed by a flat 64KB RAM with nothing mapped into it. This is
+// handy for running small programs and tests that don't need a full machine.
 func NewCPU() *CPU {
+	return New(NewRAM())
+}
+
+// Creates a CPU that reads and writes through the given memory, usually a bus
+// with RAM, cartridge and devices attached.
+func New(memory Memory) *CPU {
 	return &CPU{
 		registerA:      0,
 		registerX:      0,
@@ -20,7 +67,8 @@ func NewCPU() *CPU {
 		status:         0,
 		programCounter: 0,
 		stackPointer:   STACK_RESET,
-		memory:         make([]uint8, 0xFFFF),
+		memory:         memory,
+		variant:        Ricoh2A03,
 	}
 }
 
@@ -31,6 +79,10 @@ func NewCPU() *CPU {
 func (cpu *CPU) adc(mode AddressingMode) {
 	addr := cpu.getOperandAddress(mode)
 	value := cpu.memRead(addr)
+	if cpu.decimalModeEnabled() {
+		cpu.addToRegisterADecimal(value)
+		return
+	}
 	cpu.addToRegisterA(value)
 }
 
@@ -52,8 +104,19 @@ func (cpu *CPU) and(mode AddressingMode) {
 // complement considerations), setting the carry if the result will not fit in
 // 8 bits.
 func (cpu *CPU) asl(mode AddressingMode) {
-	cpu.setFlagCarry(cpu.registerA>>7 == 1)
-	cpu.registerA = cpu.registerA << 1
+	if mode == Accumulator {
+		cpu.setFlagCarry(cpu.registerA>>7 == 1)
+		cpu.registerA = cpu.registerA << 1
+		cpu.setFlagZeroAndNegativeForResult(cpu.registerA)
+		return
+	}
+
+	addr := cpu.getOperandAddress(mode)
+	value := cpu.memRead(addr)
+	cpu.setFlagCarry(value>>7 == 1)
+	result := value << 1
+	cpu.memWrite(addr, result)
+	cpu.setFlagZeroAndNegativeForResult(result)
 }
 
 // BCC - Branch if Carry Clear
@@ -74,7 +137,7 @@ func (cpu *CPU) bcs() {
 // If the zero flag is set then add the relative displacement to the program
 // counter to cause a branch to a new location.
 func (cpu *CPU) beq() {
-	cpu.branch(!cpu.getFlagZero())
+	cpu.branch(cpu.getFlagZero())
 }
 
 // BIT - Bit Test
@@ -164,11 +227,7 @@ func (cpu *CPU) clv() {
 func (cpu *CPU) cmp(mode AddressingMode) {
 	addr := cpu.getOperandAddress(mode)
 	value := cpu.memRead(addr)
-	delta := cpu.registerA - value
-	if cpu.registerA > value {
-		cpu.status |= uint8(0b0000_0001)
-	}
-	cpu.setFlagZeroAndNegativeForResult(delta)
+	cpu.compare(cpu.registerA, value)
 }
 
 // CPX - Compare X Register
@@ -177,11 +236,7 @@ func (cpu *CPU) cmp(mode AddressingMode) {
 func (cpu *CPU) cpx(mode AddressingMode) {
 	addr := cpu.getOperandAddress(mode)
 	value := cpu.memRead(addr)
-	delta := cpu.registerX - value
-	if cpu.registerX > value {
-		cpu.status |= uint8(0b0000_0001)
-	}
-	cpu.setFlagZeroAndNegativeForResult(delta)
+	cpu.compare(cpu.registerX, value)
 }
 
 // CPY - Compare Y Register
@@ -190,11 +245,7 @@ func (cpu *CPU) cpx(mode AddressingMode) {
 func (cpu *CPU) cpy(mode AddressingMode) {
 	addr := cpu.getOperandAddress(mode)
 	value := cpu.memRead(addr)
-	delta := cpu.registerY - value
-	if cpu.registerY > value {
-		cpu.status |= uint8(0b0000_0001)
-	}
-	cpu.setFlagZeroAndNegativeForResult(delta)
+	cpu.compare(cpu.registerY, value)
 }
 
 // DEC - Decrement Memory
@@ -231,6 +282,7 @@ func (cpu *CPU) eor(mode AddressingMode) {
 	addr := cpu.getOperandAddress(mode)
 	value := cpu.memRead(addr)
 	cpu.registerA ^= value
+	cpu.setFlagZeroAndNegativeForResult(cpu.registerA)
 }
 
 // INC - Increment Memory
@@ -261,9 +313,7 @@ func (cpu *CPU) iny() {
 // JMP - Jump
 // Sets the program counter to the address specified by the operand.
 func (cpu *CPU) jmp(mode AddressingMode) {
-	addr := cpu.getOperandAddress(mode)
-	value := cpu.memReadUInt16(addr)
-	cpu.programCounter = value
+	cpu.programCounter = cpu.getOperandAddress(mode)
 }
 
 // JSR - Jump to Subroutine
@@ -309,6 +359,13 @@ func (cpu *CPU) ldy(mode AddressingMode) {
 // Each of the bits in A or M is shift one place to the right. The bit that was
 // in bit 0 is shifted into the carry flag. Bit 7 is set to zero.
 func (cpu *CPU) lsr(mode AddressingMode) {
+	if mode == Accumulator {
+		cpu.setFlagCarry(cpu.registerA&1 == 1)
+		cpu.registerA = cpu.registerA >> 1
+		cpu.setFlagZeroAndNegativeForResult(cpu.registerA)
+		return
+	}
+
 	addr := cpu.getOperandAddress(mode)
 	value := cpu.memRead(addr)
 	result := value >> 1
@@ -329,6 +386,7 @@ func (cpu *CPU) ora(mode AddressingMode) {
 	addr := cpu.getOperandAddress(mode)
 	value := cpu.memRead(addr)
 	cpu.registerA |= value
+	cpu.setFlagZeroAndNegativeForResult(cpu.registerA)
 }
 
 // PHA - Push Accumulator
@@ -340,7 +398,9 @@ func (cpu *CPU) pha() {
 // PHP - Push Processor Status
 // Pushes a copy of the status flags on to the stack.
 func (cpu *CPU) php() {
-	cpu.stackPush(cpu.status)
+	// The copy pushed to the stack always has the break flag and the unused
+	// bit 5 set.
+	cpu.stackPush(cpu.status | FlagBreakCommand | FlagUnused)
 }
 
 // PLA - Pull Accumulator
@@ -348,7 +408,7 @@ func (cpu *CPU) php() {
 // negative flags are set as appropriate.
 func (cpu *CPU) pla() {
 	cpu.registerA = cpu.stackPop()
-	cpu.setFlagNegativeForResult(cpu.registerA)
+	cpu.setFlagZeroAndNegativeForResult(cpu.registerA)
 }
 
 // PLP - Pull Processor Status
@@ -356,6 +416,8 @@ func (cpu *CPU) pla() {
 // will take on new states as determined by the value pulled.
 func (cpu *CPU) plp() {
 	cpu.status = cpu.stackPop()
+	cpu.setFlagBreakCommand(false)
+	cpu.setFlag(FlagUnused, true)
 }
 
 // ROL - Rotate Left
@@ -383,8 +445,8 @@ func (cpu *CPU) rol(mode AddressingMode) {
 	} else {
 		addr := cpu.getOperandAddress(mode)
 		cpu.memWrite(addr, result)
-		cpu.setFlagNegativeForResult(result)
 	}
+	cpu.setFlagZeroAndNegativeForResult(result)
 }
 
 // ROR - Rotate Right
@@ -412,15 +474,15 @@ func (cpu *CPU) ror(mode AddressingMode) {
 	} else {
 		addr := cpu.getOperandAddress(mode)
 		cpu.memWrite(addr, result)
-		cpu.setFlagNegativeForResult(result)
 	}
+	cpu.setFlagZeroAndNegativeForResult(result)
 }
 
 // RTI - Return from Interrupt
 // The RTI instruction is used at the end of an interrupt processing routine.
 // It pulls the processor flags from the stack followed by the program counter.
 func (cpu *CPU) rti() {
-	cpu.status = cpu.stackPop()
+	cpu.plp()
 	cpu.programCounter = cpu.stackPopUInt16()
 }
 
@@ -438,6 +500,10 @@ func (cpu *CPU) rts() {
 func (cpu *CPU) sbc(mode AddressingMode) {
 	addr := cpu.getOperandAddress(mode)
 	value := cpu.memRead(addr)
+	if cpu.decimalModeEnabled() {
+		cpu.subtractFromRegisterADecimal(value)
+		return
+	}
 	cpu.addToRegisterA(-value - uint8(1))
 }
 
@@ -500,7 +566,7 @@ func (cpu *CPU) tay() {
 // Copies the current contents of the stack register into the X register and
 // sets the zero and negative flags as appropriate.
 func (cpu *CPU) tsx() {
-	cpu.registerX = cpu.stackPop()
+	cpu.registerX = cpu.stackPointer
 	cpu.setFlagZeroAndNegativeForResult(cpu.registerX)
 }
 
@@ -515,7 +581,7 @@ func (cpu *CPU) txa() {
 // TXS - Transfer X to Stack Pointer
 // Copies the current contents of the X register into the stack register.
 func (cpu *CPU) txs() {
-	cpu.stackPush(cpu.registerX)
+	cpu.stackPointer = cpu.registerX
 }
 
 // TYA - Transfer Y to Accumulator
@@ -527,6 +593,14 @@ func (cpu *CPU) tya() {
 }
 
 func (cpu *CPU) getOperandAddress(mode AddressingMode) uint16 {
+	addr := cpu.operandAddress(mode)
+	if cpu.logger != nil {
+		cpu.logOperand(mode, addr)
+	}
+	return addr
+}
+
+func (cpu *CPU) operandAddress(mode AddressingMode) uint16 {
 	switch mode {
 	case Immediate:
 		return cpu.programCounter
@@ -550,6 +624,18 @@ func (cpu *CPU) getOperandAddress(mode AddressingMode) uint16 {
 		base := cpu.memReadUInt16(cpu.programCounter)
 		addr := base + uint16(cpu.registerY)
 		return addr
+	case Indirect:
+		// The 6502 doesn't carry into the high byte when the pointer sits on
+		// a page boundary, so JMP ($10FF) reads its high byte from $1000.
+		ptr := cpu.memReadUInt16(cpu.programCounter)
+		hiPtr := ptr&0xFF00 | uint16(uint8(ptr)+1)
+		if cpu.logger != nil {
+			cpu.logger.LogData(ptr, false)
+			cpu.logger.LogData(hiPtr, false)
+		}
+		lo := uint16(cpu.memRead(ptr))
+		hi := uint16(cpu.memRead(hiPtr))
+		return hi<<8 | lo
 	case IndirectX:
 		base := cpu.memRead(cpu.programCounter)
 		ptr := uint16(base + cpu.registerX)
@@ -571,175 +657,333 @@ func (cpu *CPU) getOperandAddress(mode AddressingMode) uint16 {
 
 func (cpu *CPU) branch(shouldBranch bool) {
 	if shouldBranch {
-		jump := cpu.memRead(cpu.programCounter)
+		// The displacement is signed so branches can go backwards.
+		jump := int8(cpu.memRead(cpu.programCounter))
 		jump_addr := cpu.programCounter + uint16(1) + uint16(jump)
 		cpu.programCounter = jump_addr
 	}
 }
 
+// Compares a register with a value for CMP, CPX and CPY. Carry is set when the
+// register is greater than or equal to the value, Z and N follow the result of
+// the subtraction.
+func (cpu *CPU) compare(register uint8, value uint8) {
+	cpu.setFlagCarry(register >= value)
+	cpu.setFlagZeroAndNegativeForResult(register - value)
+}
+
 func (cpu *CPU) addToRegisterA(value uint8) {
-	sum := cpu.registerA + value
+	sum := uint16(cpu.registerA) + uint16(value)
 	if cpu.getFlagCarry() {
 		sum += 1
 	}
+	result := uint8(sum)
 
-	overflow := (cpu.registerA^value)&0x80 == 0 && (cpu.registerA^sum)&0x80 != 0
+	// Overflow is set when both inputs have the same sign but the result has
+	// a different one.
+	overflow := (cpu.registerA^result)&(value^result)&0x80 != 0
 
-	cpu.registerA += sum
+	cpu.registerA = result
 
 	cpu.setFlagOverflow(overflow)
-	cpu.setFlagCarryForResult(cpu.registerA)
+	cpu.setFlagCarryForResult(sum)
 	cpu.setFlagZeroAndNegativeForResult(cpu.registerA)
 }
 
-func (cpu *CPU) reset() {
+// Decimal mode only exists on the MOS 6502, the 2A03 ignores the D flag.
+func (cpu *CPU) decimalModeEnabled() bool {
+	return cpu.variant == MOS6502 && cpu.getFlag(FlagDecimalMode)
+}
+
+// Adds a value to register A treating both as packed BCD. The Z flag follows
+// the binary sum and N and V the intermediate result, as on the NMOS 6502.
+func (cpu *CPU) addToRegisterADecimal(value uint8) {
+	carry := uint16(0)
+	if cpu.getFlagCarry() {
+		carry = 1
+	}
+	a := uint16(cpu.registerA)
+	m := uint16(value)
+
+	lo := (a & 0x0F) + (m & 0x0F) + carry
+	if lo > 0x09 {
+		lo += 0x06
+	}
+	result := (a & 0xF0) + (m & 0xF0) + lo
+	if lo > 0x0F {
+		result = (a & 0xF0) + (m & 0xF0) + 0x10 + (lo & 0x0F)
+	}
+
+	cpu.setFlagZeroForResult(uint8(a + m + carry))
+	cpu.setFlagNegativeForResult(uint8(result))
+	cpu.setFlagOverflow((a^result)&(m^result)&0x80 != 0)
+
+	if result > 0x9F {
+		result += 0x60
+	}
+	cpu.setFlagCarry(result > 0xFF)
+	cpu.registerA = uint8(result)
+}
+
+// Subtracts a value from register A treating both as packed BCD. The flags
+// follow the binary subtraction, as on the NMOS 6502.
+func (cpu *CPU) subtractFromRegisterADecimal(value uint8) {
+	borrow := 0
+	if !cpu.getFlagCarry() {
+		borrow = 1
+	}
+	a := int(cpu.registerA)
+	m := int(value)
+
+	binary := a - m - borrow
+	overflow := (a^m)&(a^binary)&0x80 != 0
+
+	lo := (a & 0x0F) - (m & 0x0F) - borrow
+	hi := (a >> 4) - (m >> 4)
+	if lo < 0 {
+		lo -= 6
+		hi--
+	}
+	if hi < 0 {
+		hi -= 6
+	}
+
+	cpu.registerA = uint8(hi<<4 | lo&0x0F)
+	cpu.setFlagCarry(binary >= 0)
+	cpu.setFlagOverflow(overflow)
+	cpu.setFlagZeroAndNegativeForResult(uint8(binary))
+}
+
+// Resets the registers and loads the program counter from the reset vector at
+// $FFFC.
+func (cpu *CPU) Reset() {
 	cpu.registerA = 0
 	cpu.registerX = 0
 	cpu.registerY = 0
 	cpu.status = 0
+	cpu.stackPointer = STACK_RESET
 	cpu.programCounter = cpu.memReadUInt16(0xFFFC)
+	// The reset sequence takes 7 cycles before the first instruction runs.
+	cpu.cycles = 7
 }
 
-func (cpu *CPU) loadAndRun(program []uint8) {
-	cpu.load(program)
-	cpu.reset()
-	cpu.run()
+// Loads a program at $8000, resets the CPU and runs until BRK.
+func (cpu *CPU) LoadAndRun(program []uint8) {
+	cpu.Load(program)
+	cpu.Reset()
+	cpu.Run()
 }
 
-func (cpu *CPU) load(program []uint8) {
-	copy(cpu.memory[0x8000:0x8000+len(program)], program[:])
-	cpu.memWriteUInt16(0xFFFC, 0x8000)
+// Copies a program into memory at $8000 and points the reset vector at it.
+func (cpu *CPU) Load(program []uint8) {
+	cpu.LoadAt(0x8000, program)
 }
 
-func (cpu *CPU) run() {
-	for {
-		code := cpu.memRead(uint16(cpu.programCounter))
-		cpu.programCounter++
-		programCounterState := cpu.programCounter
+// Copies a program into memory at the given address and points the reset
+// vector at it.
+func (cpu *CPU) LoadAt(addr uint16, program []uint8) {
+	for i, b := range program {
+		cpu.memWrite(addr+uint16(i), b)
+	}
+	cpu.memWriteUInt16(0xFFFC, addr)
+}
 
-		opcode, ok := CPU_OP_CODE_TABLE[code]
-		if !ok {
-			panic(fmt.Sprintf("Could not locate opcode in opcode table: 0x%x\n", code))
-		}
+// Runs instructions until BRK is reached.
+func (cpu *CPU) Run() {
+	cpu.RunWithCallback(func(*CPU) bool { return true })
+}
 
-		switch opcode.Name {
-		case "ADC":
-			cpu.adc(opcode.AddressingMode)
-		case "AND":
-			cpu.and(opcode.AddressingMode)
-		case "ASL":
-			cpu.asl(opcode.AddressingMode)
-		case "BCC":
-			cpu.bcc()
-		case "BCS":
-			cpu.bcs()
-		case "BEQ":
-			cpu.beq()
-		case "BIT":
-			cpu.bit(opcode.AddressingMode)
-		case "BMI":
-			cpu.bmi()
-		case "BNE":
-			cpu.bne()
-		case "BPL":
-			cpu.bpl()
-		case "BRK":
-			cpu.brk()
-			return
-		case "BVC":
-			cpu.bvc()
-		case "BVS":
-			cpu.bvs()
-		case "CLC":
-			cpu.clc()
-		case "CLD":
-			cpu.cld()
-		case "CLI":
-			cpu.cli()
-		case "CLV":
-			cpu.clv()
-		case "CMP":
-			cpu.cmp(opcode.AddressingMode)
-		case "CPX":
-			cpu.cpx(opcode.AddressingMode)
-		case "CPY":
-			cpu.cpy(opcode.AddressingMode)
-		case "DEC":
-			cpu.dec(opcode.AddressingMode)
-		case "DEX":
-			cpu.dex(opcode.AddressingMode)
-		case "DEY":
-			cpu.dey(opcode.AddressingMode)
-		case "EOR":
-			cpu.eor(opcode.AddressingMode)
-		case "INC":
-			cpu.inc(opcode.AddressingMode)
-		case "INX":
-			cpu.inx()
-		case "INY":
-			cpu.iny()
-		case "JMP":
-			cpu.jmp(opcode.AddressingMode)
-		case "JSR":
-			cpu.jsr()
-		case "LDA":
-			cpu.lda(opcode.AddressingMode)
-		case "LDX":
-			cpu.ldx(opcode.AddressingMode)
-		case "LDY":
-			cpu.ldy(opcode.AddressingMode)
-		case "NOP":
-			cpu.nop()
-		case "ORA":
-			cpu.ora(opcode.AddressingMode)
-		case "PHA":
-			cpu.pha()
-		case "PHP":
-			cpu.php()
-		case "PLA":
-			cpu.pla()
-		case "PLP":
-			cpu.plp()
-		case "ROL":
-			cpu.rol(opcode.AddressingMode)
-		case "ROR":
-			cpu.ror(opcode.AddressingMode)
-		case "RTI":
-			cpu.rti()
-		case "RTS":
-			cpu.rts()
-		case "SBC":
-			cpu.sbc(opcode.AddressingMode)
-		case "SEC":
-			cpu.sec()
-		case "SED":
-			cpu.sed()
-		case "SEI":
-			cpu.sei()
-		case "STA":
-			cpu.sta(opcode.AddressingMode)
-		case "STX":
-			cpu.stx(opcode.AddressingMode)
-		case "STY":
-			cpu.sty(opcode.AddressingMode)
-		case "TAX":
-			cpu.tax()
-		case "TAY":
-			cpu.tay()
-		case "TSX":
-			cpu.tsx()
-		case "TXA":
-			cpu.txa()
-		case "TXS":
-			cpu.txs()
-		case "TYA":
-			cpu.tya()
-		default:
-			panic(fmt.Sprintf("Unsupported opcode: 0x%x\n", opcode))
-		}
+// Runs instructions until BRK is reached, calling callback before each one.
+// The callback can inspect and modify the CPU and memory, which is how hosts
+// feed in input or pick up output, and stops execution by returning false.
+func (cpu *CPU) RunWithCallback(callback func(cpu *CPU) bool) {
+	for callback(cpu) && cpu.Step() {
+	}
+}
 
-		if programCounterState == cpu.programCounter {
-			cpu.programCounter += uint16(opcode.Bytes) - 1
-		}
+// Returns a snapshot of the current register values.
+func (cpu *CPU) Registers() Registers {
+	return Registers{
+		A:      cpu.registerA,
+		X:      cpu.registerX,
+		Y:      cpu.registerY,
+		Status: cpu.status,
+		SP:     cpu.stackPointer,
+		PC:     cpu.programCounter,
 	}
 }
+
+// Returns the number of cycles executed since the last reset.
+func (cpu *CPU) Cycles() uint64 {
+	return cpu.cycles
+}
+
+// Sets the cycle counter, used when restoring a saved state.
+func (cpu *CPU) SetCycles(cycles uint64) {
+	cpu.cycles = cycles
+}
+
+// Returns the 6502 variant being emulated.
+func (cpu *CPU) Variant() Variant {
+	return cpu.variant
+}
+
+// Selects which 6502 variant to emulate. NES software expects Ricoh2A03, which
+// is the default.
+func (cpu *CPU) SetVariant(variant Variant) {
+	cpu.variant = variant
+}
+
+// Overwrites all registers with the given values.
+func (cpu *CPU) SetRegisters(registers Registers) {
+	cpu.registerA = registers.A
+	cpu.registerX = registers.X
+	cpu.registerY = registers.Y
+	cpu.status = registers.Status
+	cpu.stackPointer = registers.SP
+	cpu.programCounter = registers.PC
+}
+
+// Executes a single instruction. Returns false if the instruction was BRK,
+// which for now is treated as the end of the program.
+func (cpu *CPU) Step() bool {
+	code := cpu.memRead(uint16(cpu.programCounter))
+	cpu.programCounter++
+	programCounterState := cpu.programCounter
+
+	opcode, ok := CPU_OP_CODE_TABLE[code]
+	if !ok {
+		panic(fmt.Sprintf("Could not locate opcode in opcode table: 0x%x\n", code))
+	}
+	if cpu.logger != nil {
+		cpu.logger.LogCode(programCounterState-1, opcode.Bytes, cpu.jumpedIndirect)
+		cpu.readsOperand = !operandNotRead[opcode.Name]
+		cpu.jumpedIndirect = opcode.Name == "JMP" && opcode.AddressingMode == Indirect
+	}
+
+	switch opcode.Name {
+	case "ADC":
+		cpu.adc(opcode.AddressingMode)
+	case "AND":
+		cpu.and(opcode.AddressingMode)
+	case "ASL":
+		cpu.asl(opcode.AddressingMode)
+	case "BCC":
+		cpu.bcc()
+	case "BCS":
+		cpu.bcs()
+	case "BEQ":
+		cpu.beq()
+	case "BIT":
+		cpu.bit(opcode.AddressingMode)
+	case "BMI":
+		cpu.bmi()
+	case "BNE":
+		cpu.bne()
+	case "BPL":
+		cpu.bpl()
+	case "BRK":
+		cpu.brk()
+		return false
+	case "BVC":
+		cpu.bvc()
+	case "BVS":
+		cpu.bvs()
+	case "CLC":
+		cpu.clc()
+	case "CLD":
+		cpu.cld()
+	case "CLI":
+		cpu.cli()
+	case "CLV":
+		cpu.clv()
+	case "CMP":
+		cpu.cmp(opcode.AddressingMode)
+	case "CPX":
+		cpu.cpx(opcode.AddressingMode)
+	case "CPY":
+		cpu.cpy(opcode.AddressingMode)
+	case "DEC":
+		cpu.dec(opcode.AddressingMode)
+	case "DEX":
+		cpu.dex(opcode.AddressingMode)
+	case "DEY":
+		cpu.dey(opcode.AddressingMode)
+	case "EOR":
+		cpu.eor(opcode.AddressingMode)
+	case "INC":
+		cpu.inc(opcode.AddressingMode)
+	case "INX":
+		cpu.inx()
+	case "INY":
+		cpu.iny()
+	case "JMP":
+		cpu.jmp(opcode.AddressingMode)
+	case "JSR":
+		cpu.jsr()
+	case "LDA":
+		cpu.lda(opcode.AddressingMode)
+	case "LDX":
+		cpu.ldx(opcode.AddressingMode)
+	case "LDY":
+		cpu.ldy(opcode.AddressingMode)
+	case "LSR":
+		cpu.lsr(opcode.AddressingMode)
+	case "NOP":
+		cpu.nop()
+	case "ORA":
+		cpu.ora(opcode.AddressingMode)
+	case "PHA":
+		cpu.pha()
+	case "PHP":
+		cpu.php()
+	case "PLA":
+		cpu.pla()
+	case "PLP":
+		cpu.plp()
+	case "ROL":
+		cpu.rol(opcode.AddressingMode)
+	case "ROR":
+		cpu.ror(opcode.AddressingMode)
+	case "RTI":
+		cpu.rti()
+	case "RTS":
+		cpu.rts()
+	case "SBC":
+		cpu.sbc(opcode.AddressingMode)
+	case "SEC":
+		cpu.sec()
+	case "SED":
+		cpu.sed()
+	case "SEI":
+		cpu.sei()
+	case "STA":
+		cpu.sta(opcode.AddressingMode)
+	case "STX":
+		cpu.stx(opcode.AddressingMode)
+	case "STY":
+		cpu.sty(opcode.AddressingMode)
+	case "TAX":
+		cpu.tax()
+	case "TAY":
+		cpu.tay()
+	case "TSX":
+		cpu.tsx()
+	case "TXA":
+		cpu.txa()
+	case "TXS":
+		cpu.txs()
+	case "TYA":
+		cpu.tya()
+	default:
+		panic(fmt.Sprintf("Unsupported opcode: 0x%x\n", opcode))
+	}
+
+	cpu.cycles += uint64(opcode.Cycles)
+
+	if programCounterState == cpu.programCounter {
+		cpu.programCounter += uint16(opcode.Bytes) - 1
+	}
+
+	return true
+}
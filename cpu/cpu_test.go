@@ -0,0 +1,254 @@
+package cpu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test the 0xa9 immediate load opcode by loading 0x05 into register a and checking it's there.
+// Also checks that the zero flag and negative flags are both not set
+func Test_0xa9_LDA_Immediate(t *testing.T) {
+	cpu := NewCPU()
+	cpu.LoadAndRun([]uint8{0xa9, 0x05, 0x00})
+	assert.Equal(t, uint8(0x05), cpu.registerA, "")
+	assertZeroFlagNotSet(t, cpu.status)
+	assertNegativeFlagNotSet(t, cpu.status)
+}
+
+// Test the 0xa9 immediate load opcode by loading 0x05 into register a and checking it's there.
+// Checks that the negative flag is set
+func Test_0xa9_LDA_Immediate_NegativeFlag(t *testing.T) {
+	cpu := NewCPU()
+	cpu.LoadAndRun([]uint8{0xa9, 0xff, 0x00})
+	assert.Equal(t, uint8(0xff), cpu.registerA, "")
+	assertZeroFlagNotSet(t, cpu.status)
+	assertNegativeFlagSet(t, cpu.status)
+}
+
+// Test the 0xa9 immediate load opcode by loading 0x05 into register a and checking it's there.
+// Checks that the zero flag is set
+func Test_0xa9_LDA_Immediate_ZeroFlag(t *testing.T) {
+	cpu := NewCPU()
+	cpu.LoadAndRun([]uint8{0xa9, 0x00, 0x00})
+	assert.Equal(t, uint8(0x00), cpu.registerA, "")
+	assertZeroFlagSet(t, cpu.status)
+	assertNegativeFlagNotSet(t, cpu.status)
+}
+
+// Test that we can load immediate into A from memory
+func Test_0xa5_LDA_ZeroPage(t *testing.T) {
+	cpu := NewCPU()
+	cpu.memWrite(0x10, 0x55)
+	cpu.LoadAndRun([]uint8{0xa5, 0x10, 0x00})
+	assert.Equal(t, uint8(0x55), cpu.registerA, "")
+}
+
+// Test that we can successfully copy register A to register X
+func Test_0xaa_TAX_MoveAToX(t *testing.T) {
+	cpu := NewCPU()
+	// LDA 10, TAX, BRK
+	cpu.LoadAndRun([]uint8{0xa9, 0x0a, 0xaa, 0x00})
+	assert.Equal(t, uint8(10), cpu.registerX)
+}
+
+// Test that we can successfully copy register A to register X
+// Checks that the negative flag is set
+func Test_0xaa_TAX_MoveAToX_NegativeFlag(t *testing.T) {
+	cpu := NewCPU()
+	// LDA -1, TAX, BRK
+	cpu.LoadAndRun([]uint8{0xa9, 0xff, 0xaa, 0x00})
+	assert.Equal(t, uint8(0xff), cpu.registerX)
+	assertZeroFlagNotSet(t, cpu.status)
+	assertNegativeFlagSet(t, cpu.status)
+}
+
+// Test that we can successfully copy register A to register X
+// Checks that the zero flag is set
+func Test_0xaa_TAX_MoveAToX_ZeroFlag(t *testing.T) {
+	cpu := NewCPU()
+	// LDA 0, TAX, BRK
+	cpu.LoadAndRun([]uint8{0xa9, 0x00, 0xaa, 0x00})
+	assert.Equal(t, uint8(0), cpu.registerX)
+	assertZeroFlagSet(t, cpu.status)
+	assertNegativeFlagNotSet(t, cpu.status)
+}
+
+// Thet that increment X wikll increment the value of X by 1
+func Test_0xe8_INX_IncrementX(t *testing.T) {
+	cpu := NewCPU()
+	// LDA 0, TAX, BRK
+	cpu.LoadAndRun([]uint8{0xa9, 0x00, 0xe8, 0x00})
+	assert.Equal(t, uint8(1), cpu.registerX, "")
+}
+
+// Thet that increment X will overflow and wrap the x register
+func Test_0xe8_INX_IncrementX_Overflow(t *testing.T) {
+	cpu := NewCPU()
+	// LDA -1, TAX INX, INX, BRK
+	cpu.LoadAndRun([]uint8{0xa9, 0xff, 0xaa, 0xe8, 0xe8, 0x00})
+	assert.Equal(t, uint8(1), cpu.registerX, "")
+}
+
+// Test that INX will correctly set the negative flag based on its result
+func Test_0xe8_INX_IncrementX_NegativeFlag(t *testing.T) {
+	cpu := NewCPU()
+	// LDA -2, TAX, INX, BRK
+	cpu.LoadAndRun([]uint8{0xa9, 0xfe, 0xaa, 0xe8, 0x00})
+	assert.Equal(t, uint8(0xff), cpu.registerX)
+	assertZeroFlagNotSet(t, cpu.status)
+	assertNegativeFlagSet(t, cpu.status)
+}
+
+// Test that INX will correctly set the zero flag based on its result
+func Test_0xe8_INX_IncrementX_ZeroFlag(t *testing.T) {
+	cpu := NewCPU()
+	// LDA -1, TAX, INX, BRK
+	cpu.LoadAndRun([]uint8{0xa9, 0xff, 0xaa, 0xe8, 0x00})
+	assert.Equal(t, uint8(0x00), cpu.registerX)
+	assertZeroFlagSet(t, cpu.status)
+	assertNegativeFlagNotSet(t, cpu.status)
+}
+
+// Test six op codes working together as a mini program
+func Test_SixOpsWorkingTogether(t *testing.T) {
+	cpu := NewCPU()
+	// LDA -64, TAX, NOP, INX, BRK
+	cpu.LoadAndRun([]uint8{0xa9, 0xc0, 0xaa, 0xea, 0xe8, 0x00})
+	assert.Equal(t, uint8(0xc0), cpu.registerA, "")
+	assert.Equal(t, uint8(0xc1), cpu.registerX, "")
+}
+
+// Test that ADC sets the carry flag when the result doesn't fit in 8 bits
+func Test_0x69_ADC_Immediate_Carry(t *testing.T) {
+	cpu := NewCPU()
+	// LDA 0xff, ADC 0x02, BRK
+	cpu.LoadAndRun([]uint8{0xa9, 0xff, 0x69, 0x02, 0x00})
+	assert.Equal(t, uint8(0x01), cpu.registerA, "")
+	assert.True(t, cpu.getFlagCarry())
+	assert.False(t, cpu.getFlagOverflow())
+}
+
+// Test that ADC sets the overflow flag when adding two positives gives a negative
+func Test_0x69_ADC_Immediate_Overflow(t *testing.T) {
+	cpu := NewCPU()
+	// LDA 0x50, ADC 0x50, BRK
+	cpu.LoadAndRun([]uint8{0xa9, 0x50, 0x69, 0x50, 0x00})
+	assert.Equal(t, uint8(0xa0), cpu.registerA, "")
+	assert.False(t, cpu.getFlagCarry())
+	assert.True(t, cpu.getFlagOverflow())
+	assertNegativeFlagSet(t, cpu.status)
+}
+
+// Test that SBC with the carry set subtracts without borrowing
+func Test_0xe9_SBC_Immediate(t *testing.T) {
+	cpu := NewCPU()
+	// SEC, LDA 0x10, SBC 0x01, BRK
+	cpu.LoadAndRun([]uint8{0x38, 0xa9, 0x10, 0xe9, 0x01, 0x00})
+	assert.Equal(t, uint8(0x0f), cpu.registerA, "")
+	assert.True(t, cpu.getFlagCarry())
+}
+
+// Test that the 2A03 ignores decimal mode
+func Test_0x69_ADC_DecimalMode_2A03(t *testing.T) {
+	cpu := NewCPU()
+	// SED, LDA 0x09, ADC 0x01, BRK
+	cpu.LoadAndRun([]uint8{0xf8, 0xa9, 0x09, 0x69, 0x01, 0x00})
+	assert.Equal(t, uint8(0x0a), cpu.registerA, "")
+}
+
+// Test that the MOS 6502 adds in BCD when decimal mode is set
+func Test_0x69_ADC_DecimalMode_6502(t *testing.T) {
+	cpu := NewCPU()
+	cpu.SetVariant(MOS6502)
+	// SED, LDA 0x99, ADC 0x01, BRK
+	cpu.LoadAndRun([]uint8{0xf8, 0xa9, 0x99, 0x69, 0x01, 0x00})
+	assert.Equal(t, uint8(0x00), cpu.registerA, "")
+	assert.True(t, cpu.getFlagCarry())
+}
+
+// Test that the MOS 6502 subtracts in BCD when decimal mode is set
+func Test_0xe9_SBC_DecimalMode_6502(t *testing.T) {
+	cpu := NewCPU()
+	cpu.SetVariant(MOS6502)
+	// SED, SEC, LDA 0x10, SBC 0x01, BRK
+	cpu.LoadAndRun([]uint8{0xf8, 0x38, 0xa9, 0x10, 0xe9, 0x01, 0x00})
+	assert.Equal(t, uint8(0x09), cpu.registerA, "")
+	assert.True(t, cpu.getFlagCarry())
+}
+
+// Test that the cycle counter starts after the reset sequence and counts each
+// instruction
+func Test_Cycles(t *testing.T) {
+	cpu := NewCPU()
+	// LDA 0x01, NOP, BRK
+	cpu.LoadAndRun([]uint8{0xa9, 0x01, 0xea, 0x00})
+	assert.Equal(t, uint64(7+2+2), cpu.Cycles())
+}
+
+// Test that BEQ branches when the zero flag is set
+func Test_0xf0_BEQ_Taken(t *testing.T) {
+	cpu := NewCPU()
+	// LDA 0, BEQ +2, LDX 1, BRK
+	cpu.LoadAndRun([]uint8{0xa9, 0x00, 0xf0, 0x02, 0xa2, 0x01, 0x00})
+	assert.Equal(t, uint8(0x00), cpu.registerX)
+}
+
+// Test that a negative displacement branches backwards
+func Test_0xd0_BNE_Backwards(t *testing.T) {
+	cpu := NewCPU()
+	// LDX 3, loop: DEX, BNE loop, BRK
+	cpu.LoadAndRun([]uint8{0xa2, 0x03, 0xca, 0xd0, 0xfd, 0x00})
+	assert.Equal(t, uint8(0x00), cpu.registerX)
+	assertZeroFlagSet(t, cpu.status)
+}
+
+// Test that CMP sets carry when A is equal and clears it when A is smaller
+func Test_0xc9_CMP_Carry(t *testing.T) {
+	cpu := NewCPU()
+	// LDA 5, CMP 5, BRK
+	cpu.LoadAndRun([]uint8{0xa9, 0x05, 0xc9, 0x05, 0x00})
+	assert.True(t, cpu.getFlagCarry())
+	assertZeroFlagSet(t, cpu.status)
+
+	cpu = NewCPU()
+	// SEC, LDA 4, CMP 5, BRK
+	cpu.LoadAndRun([]uint8{0x38, 0xa9, 0x04, 0xc9, 0x05, 0x00})
+	assert.False(t, cpu.getFlagCarry())
+	assertNegativeFlagSet(t, cpu.status)
+}
+
+// Test that JSR and RTS return to the instruction after the call and leave the
+// stack balanced
+func Test_0x20_JSR_RTS(t *testing.T) {
+	cpu := NewCPU()
+	// JSR $8006, LDY 2, BRK, sub: LDX 1, RTS
+	cpu.LoadAndRun([]uint8{0x20, 0x06, 0x80, 0xa0, 0x02, 0x00, 0xa2, 0x01, 0x60})
+	assert.Equal(t, uint8(0x01), cpu.registerX)
+	assert.Equal(t, uint8(0x02), cpu.registerY)
+	assert.Equal(t, STACK_RESET, cpu.stackPointer)
+}
+
+// Test that TSX and TXS copy the stack pointer rather than using the stack
+func Test_0xba_TSX_0x9a_TXS(t *testing.T) {
+	cpu := NewCPU()
+	// LDX $40, TXS, LDX 0, TSX, BRK
+	cpu.LoadAndRun([]uint8{0xa2, 0x40, 0x9a, 0xa2, 0x00, 0xba, 0x00})
+	assert.Equal(t, uint8(0x40), cpu.registerX)
+	assert.Equal(t, uint8(0x40), cpu.stackPointer)
+}
+
+// Test that the callback runs before every instruction and can stop execution
+func Test_RunWithCallback(t *testing.T) {
+	cpu := NewCPU()
+	// INX, INX, INX, BRK
+	cpu.Load([]uint8{0xe8, 0xe8, 0xe8, 0x00})
+	cpu.Reset()
+	calls := 0
+	cpu.RunWithCallback(func(c *CPU) bool {
+		calls++
+		return calls < 3
+	})
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, uint8(2), cpu.registerX)
+}
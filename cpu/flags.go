@@ -1,4 +1,4 @@
-package main
+package cpu
 
 type Flag = uint8
 
@@ -17,6 +17,7 @@ const (
 	FlagInterruptDiable      = 1 << 2
 	FlagDecimalMode          = 1 << 3
 	FlagBreakCommand         = 1 << 4
+	FlagUnused               = 1 << 5
 	FlagOverflow             = 1 << 6
 	FlagNegative             = 1 << 7
 )
@@ -71,7 +72,7 @@ func (cpu *CPU) getFlagCarry() bool {
 }
 
 // Sets the Carry flag based on the result of an operation
-func (cpu *CPU) setFlagCarryForResult(result uint8) {
+func (cpu *CPU) setFlagCarryForResult(result uint16) {
 	cpu.setFlag(FlagCarry, result > 0xff)
 }
 
@@ -0,0 +1,31 @@
+package cpu
+
+// CodeDataLogger is told how the CPU uses the bytes it reads, so tools like
+// code/data loggers can tell code from data in a ROM.
+type CodeDataLogger interface {
+	// An instruction length bytes long was fetched from addr. indirect is set
+	// when it was reached through JMP ($xxxx).
+	LogCode(addr uint16, length int, indirect bool)
+	// The byte at addr was read as data. indirect is set when the address
+	// came from a pointer, as with ($nn),Y and ($nn,X).
+	LogData(addr uint16, indirect bool)
+}
+
+// Instructions whose operand address isn't read as data. Stores write to it
+// and JMP goes to it.
+var operandNotRead = map[string]bool{"STA": true, "STX": true, "STY": true, "JMP": true}
+
+// Plugs in a logger to be told about every instruction fetch and operand
+// read, or nil to stop logging.
+func (cpu *CPU) SetCodeDataLogger(logger CodeDataLogger) {
+	cpu.logger = logger
+}
+
+// Logs the operand of the instruction being executed once its address is
+// known.
+func (cpu *CPU) logOperand(mode AddressingMode, addr uint16) {
+	if mode == Immediate || !cpu.readsOperand {
+		return
+	}
+	cpu.logger.LogData(addr, mode == IndirectX || mode == IndirectY)
+}
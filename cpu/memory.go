@@ -0,0 +1,72 @@
+package cpu
+
+// Memory is the address space the CPU reads from and writes to. A bare CPU can
+// use RAM, a full machine plugs in a bus that routes addresses to RAM, the
+// cartridge and any attached devices.
+type Memory interface {
+	Read(addr uint16) uint8
+	Write(addr uint16, data uint8)
+}
+
+// RAM is a flat 64KB address space with no mirroring or devices.
+type RAM [0x10000]uint8
+
+func NewRAM() *RAM {
+	return &RAM{}
+}
+
+func (ram *RAM) Read(addr uint16) uint8 {
+	return ram[addr]
+}
+
+func (ram *RAM) Write(addr uint16, data uint8) {
+	ram[addr] = data
+}
+
+func (cpu *CPU) memRead(addr uint16) uint8 {
+	return cpu.memory.Read(addr)
+}
+
+func (cpu *CPU) memWrite(addr uint16, data uint8) {
+	cpu.memory.Write(addr, data)
+}
+
+func (cpu *CPU) memReadUInt16(pos uint16) uint16 {
+	lo := uint16(cpu.memRead(pos))
+	hi := uint16(cpu.memRead(pos + 1))
+	return (hi << 8) | (lo)
+}
+
+func (cpu *CPU) memWriteUInt16(pos uint16, data uint16) {
+	hi := uint8(data >> 8)
+	lo := uint8(data & 0xff)
+	cpu.memWrite(pos, lo)
+	cpu.memWrite(pos+1, hi)
+}
+
+// Reads a byte through the CPU's view of memory.
+func (cpu *CPU) MemRead(addr uint16) uint8 {
+	return cpu.memRead(addr)
+}
+
+// Writes a byte through the CPU's view of memory.
+func (cpu *CPU) MemWrite(addr uint16, data uint8) {
+	cpu.memWrite(addr, data)
+}
+
+// Reads a little endian 16 bit value through the CPU's view of memory.
+func (cpu *CPU) MemReadUInt16(addr uint16) uint16 {
+	return cpu.memReadUInt16(addr)
+}
+
+// Returns the memory the CPU is attached to.
+func (cpu *CPU) Memory() Memory {
+	return cpu.memory
+}
+
+// Swaps the memory the CPU reads and writes through. Tools that need to see
+// every access, such as debugger watchpoints, wrap the current memory and plug
+// the wrapper back in.
+func (cpu *CPU) SetMemory(memory Memory) {
+	cpu.memory = memory
+}
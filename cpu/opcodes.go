@@ -1,4 +1,4 @@
-package main
+package cpu
 
 type AddressingMode int
 
@@ -40,7 +40,7 @@ var CPU_OP_CODE_TABLE = map[uint8]OpCode{
 	0x29: {0x29, "AND", Immediate, 2, 2},
 	0x25: {0x25, "AND", ZeroPage, 2, 3},
 	0x35: {0x35, "AND", ZeroPageX, 2, 4},
-	0x2D: {0x2D, "AND", Absolute, 2, 4},
+	0x2D: {0x2D, "AND", Absolute, 3, 4},
 	0x3D: {0x3D, "AND", AbsoluteX, 3, 4 /* +1 if page crossed */},
 	0x39: {0x39, "AND", AbsoluteY, 3, 4 /* +1 if page crossed */},
 	0x21: {0x21, "AND", IndirectX, 2, 6},
@@ -84,9 +84,9 @@ var CPU_OP_CODE_TABLE = map[uint8]OpCode{
 	0xC9: {0xC9, "CMP", Immediate, 2, 2},
 	0xC5: {0xC5, "CMP", ZeroPage, 2, 3},
 	0xD5: {0xD5, "CMP", ZeroPageX, 2, 4},
-	0xCD: {0xCD, "CMP", ZeroPageX, 3, 4},
-	0xDD: {0xDD, "CMP", Absolute, 3, 4 /* +1 if page crossed */},
-	0xD9: {0xD9, "CMP", AbsoluteX, 3, 4 /* +1 if page crossed */},
+	0xCD: {0xCD, "CMP", Absolute, 3, 4},
+	0xDD: {0xDD, "CMP", AbsoluteX, 3, 4 /* +1 if page crossed */},
+	0xD9: {0xD9, "CMP", AbsoluteY, 3, 4 /* +1 if page crossed */},
 	0xC1: {0xC1, "CMP", IndirectX, 2, 6},
 	0xD1: {0xD1, "CMP", IndirectY, 2, 5 /* +1 if page crossed */},
 	// CPX
@@ -148,14 +148,14 @@ var CPU_OP_CODE_TABLE = map[uint8]OpCode{
 	0xA0: {0xA0, "LDY", Immediate, 2, 2},
 	0xA4: {0xA4, "LDY", ZeroPage, 2, 3},
 	0xB4: {0xB4, "LDY", ZeroPageX, 2, 4},
-	0xAC: {0xAC, "LDY", ZeroPageX, 3, 4},
+	0xAC: {0xAC, "LDY", Absolute, 3, 4},
 	0xBC: {0xBC, "LDY", AbsoluteX, 3, 4 /* +1 if page crossed */},
 	// LSR
-	0x4A: {0x4A, "LDY", Accumulator, 1, 2},
-	0x46: {0x46, "LDY", ZeroPage, 2, 5},
-	0x56: {0x56, "LDY", ZeroPageX, 2, 6},
-	0x4E: {0x4E, "LDY", Absolute, 3, 6},
-	0x5E: {0x5E, "LDY", AbsoluteX, 3, 7},
+	0x4A: {0x4A, "LSR", Accumulator, 1, 2},
+	0x46: {0x46, "LSR", ZeroPage, 2, 5},
+	0x56: {0x56, "LSR", ZeroPageX, 2, 6},
+	0x4E: {0x4E, "LSR", Absolute, 3, 6},
+	0x5E: {0x5E, "LSR", AbsoluteX, 3, 7},
 	// NOP
 	0xEA: {0xEA, "NOP", Implied, 1, 2},
 	// ORA
@@ -196,7 +196,7 @@ var CPU_OP_CODE_TABLE = map[uint8]OpCode{
 	0xE5: {0xE5, "SBC", ZeroPage, 2, 3},
 	0xF5: {0xF5, "SBC", ZeroPageX, 2, 4},
 	0xED: {0xED, "SBC", Absolute, 3, 4},
-	0xFD: {0xFd, "SBC", AbsoluteX, 3, 4 /* +1 if page crossed */},
+	0xFD: {0xFD, "SBC", AbsoluteX, 3, 4 /* +1 if page crossed */},
 	0xF9: {0xF9, "SBC", AbsoluteY, 3, 4 /* +1 if page crossed */},
 	0xE1: {0xE1, "SBC", IndirectX, 2, 6},
 	0xF1: {0xF1, "SBC", IndirectY, 2, 5 /* +1 if page crossed */},
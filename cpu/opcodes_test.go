@@ -1,4 +1,4 @@
-package main
+package cpu
 
 import (
 	"testing"
@@ -28,5 +28,5 @@ func TestAllOpCodes(t *testing.T) {
 	}
 	program = append(program, 0x00)
 
-	cpu.loadAndRun(program)
+	cpu.LoadAndRun(program)
 }
@@ -1,4 +1,4 @@
-package main
+package cpu
 
 const (
 	STACK       uint16 = 0x0100
@@ -15,12 +15,14 @@ func (cpu *CPU) stackPop() uint8 {
 	return cpu.memRead(STACK + uint16(cpu.stackPointer))
 }
 
+// The high byte is pushed first so the value sits little endian in memory.
 func (cpu *CPU) stackPushUInt16(value uint16) {
-	cpu.memWriteUInt16(STACK+uint16(cpu.stackPointer), value)
-	cpu.stackPointer = cpu.stackPointer - 1
+	cpu.stackPush(uint8(value >> 8))
+	cpu.stackPush(uint8(value & 0xff))
 }
 
 func (cpu *CPU) stackPopUInt16() uint16 {
-	cpu.stackPointer += 1
-	return cpu.memReadUInt16(STACK + uint16(cpu.stackPointer))
+	lo := uint16(cpu.stackPop())
+	hi := uint16(cpu.stackPop())
+	return hi<<8 | lo
 }
@@ -0,0 +1,109 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"sync"
+)
+
+// The largest message the adapter accepts. Requests are small JSON objects,
+// this is far more than any editor sends.
+const MAX_MESSAGE = 1 << 20
+
+// request is an incoming message from the editor. Only requests are expected,
+// the adapter never sends reverse requests so no responses come back.
+type request struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+type response struct {
+	Seq        int    `json:"seq"`
+	Type       string `json:"type"`
+	RequestSeq int    `json:"request_seq"`
+	Success    bool   `json:"success"`
+	Command    string `json:"command"`
+	Message    string `json:"message,omitempty"`
+	Body       any    `json:"body,omitempty"`
+}
+
+type event struct {
+	Seq   int    `json:"seq"`
+	Type  string `json:"type"`
+	Event string `json:"event"`
+	Body  any    `json:"body,omitempty"`
+}
+
+// transport frames messages with a Content-Length header like LSP. Sends are
+// safe from several goroutines since events come from the run loop while
+// responses come from the request loop.
+type transport struct {
+	reader *textproto.Reader
+	mu     sync.Mutex
+	w      io.Writer
+	seq    int
+}
+
+func newTransport(rw io.ReadWriter) *transport {
+	return &transport{reader: textproto.NewReader(bufio.NewReader(rw)), w: rw}
+}
+
+func (t *transport) read() (*request, error) {
+	header, err := t.reader.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+	}
+	if length > MAX_MESSAGE {
+		return nil, fmt.Errorf("message of %d bytes is over the limit of %d", length, MAX_MESSAGE)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(t.reader.R, body); err != nil {
+		return nil, err
+	}
+	var r request
+	if err := json.Unmarshal(body, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (t *transport) respond(r *request, body any, err error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.seq++
+	resp := response{Seq: t.seq, Type: "response", RequestSeq: r.Seq, Success: err == nil, Command: r.Command, Body: body}
+	if err != nil {
+		resp.Message = err.Error()
+	}
+	return t.write(resp)
+}
+
+func (t *transport) event(name string, body any) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.seq++
+	return t.write(event{Seq: t.seq, Type: "event", Event: name, Body: body})
+}
+
+func (t *transport) write(message any) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(t.w, "Content-Length: %d\r\n\r\n", len(data)); err != nil {
+		return err
+	}
+	_, err = t.w.Write(data)
+	return err
+}
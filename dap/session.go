@@ -0,0 +1,748 @@
+// Package dap implements the Debug Adapter Protocol, so editors can launch and
+// debug programs running on the 6502 core.
+package dap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"switchtrue.com/hankee/callstack"
+	"switchtrue.com/hankee/cpu"
+	"switchtrue.com/hankee/dbginfo"
+)
+
+const (
+	// The CPU is the only thread.
+	THREAD_ID = 1
+
+	REGISTERS_REFERENCE = 1
+	FLAGS_REFERENCE     = 2
+
+	// How many instructions run between giving other requests a chance to
+	// look at the CPU while it's running.
+	CHUNK_INSTRUCTIONS = 10000
+)
+
+// Target is a program loaded and ready to debug.
+type Target struct {
+	CPU *cpu.CPU
+	// Debug info from ld65 for source breakpoints and symbol names, nil if
+	// there isn't any.
+	Debug *dbginfo.Info
+	// Directory that source file names in the debug info are relative to.
+	SourceRoot string
+}
+
+// LaunchArguments are the arguments of a launch request.
+type LaunchArguments struct {
+	// ROM or raw binary to load.
+	Program string `json:"program"`
+	// Stop before the first instruction instead of running straight away.
+	StopOnEntry bool `json:"stopOnEntry"`
+	// ld65 debug info file, defaults to the program with a .dbg extension.
+	DebugInfo string `json:"debugInfo"`
+	// Load the program as a raw binary at Load even if it has an iNES header.
+	Raw   bool   `json:"raw"`
+	Load  string `json:"load"`
+	Entry string `json:"entry"`
+}
+
+// Creates a target for a loaded program, with debug info from path or from
+// next to the program if path is empty.
+func NewTarget(c *cpu.CPU, path string, program string) (*Target, error) {
+	target := &Target{CPU: c}
+	if err := target.loadDebugInfo(path, program); err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+type attachArguments struct {
+	StopOnEntry bool   `json:"stopOnEntry"`
+	DebugInfo   string `json:"debugInfo"`
+}
+
+// Launcher loads the program named in a launch request.
+type Launcher func(args LaunchArguments) (*cpu.CPU, error)
+
+type stepMode int
+
+const (
+	stepNone stepMode = iota
+	stepIn
+	stepOver
+	stepOut
+)
+
+// Session is a debugging session with one editor.
+type Session struct {
+	launcher Launcher
+	t        *transport
+	wg       sync.WaitGroup
+	pause    atomic.Bool
+
+	// mu guards everything below, the run loop holds it while executing a
+	// chunk of instructions.
+	mu                     sync.Mutex
+	target                 *Target
+	entry                  uint16
+	calls                  callstack.Tracker
+	sourceBreakpoints      map[string][]uint16
+	instructionBreakpoints []uint16
+	breakpoints            map[uint16]string
+	stopOnEntry            bool
+	configured             bool
+	started                bool
+	running                bool
+	halted                 bool
+}
+
+// Creates a session. The target is what attach requests debug and can be nil
+// if only launching is supported, while launcher loads programs for launch
+// requests and can be nil if only attaching is.
+func NewSession(target *Target, launcher Launcher) *Session {
+	s := &Session{
+		launcher:          launcher,
+		target:            target,
+		sourceBreakpoints: map[string][]uint16{},
+		breakpoints:       map[uint16]string{},
+	}
+	if target != nil {
+		s.entry = target.CPU.Registers().PC
+	}
+	return s
+}
+
+var errDisconnect = errors.New("disconnected")
+
+type handler func(s *Session, r *request) error
+
+var handlers map[string]handler
+
+func init() {
+	handlers = map[string]handler{
+		"initialize":                (*Session).initialize,
+		"launch":                    (*Session).launch,
+		"attach":                    (*Session).attach,
+		"setBreakpoints":            (*Session).setBreakpoints,
+		"setInstructionBreakpoints": (*Session).setInstructionBreakpoints,
+		"setExceptionBreakpoints":   (*Session).setExceptionBreakpoints,
+		"configurationDone":         (*Session).configurationDone,
+		"threads":                   (*Session).threads,
+		"stackTrace":                (*Session).stackTrace,
+		"scopes":                    (*Session).scopes,
+		"variables":                 (*Session).variables,
+		"continue":                  (*Session).cont,
+		"next":                      (*Session).next,
+		"stepIn":                    (*Session).stepIn,
+		"stepOut":                   (*Session).stepOut,
+		"pause":                     (*Session).pauseRequest,
+		"readMemory":                (*Session).readMemory,
+		"terminate":                 (*Session).terminate,
+		"disconnect":                (*Session).disconnect,
+	}
+}
+
+// Handles requests until the editor disconnects or the connection closes.
+func (s *Session) Serve(rw io.ReadWriter) error {
+	s.t = newTransport(rw)
+	defer s.stop()
+
+	for {
+		r, err := s.t.read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		h, ok := handlers[r.Command]
+		if !ok {
+			err = s.t.respond(r, nil, fmt.Errorf("%s is not supported", r.Command))
+		} else {
+			err = h(s, r)
+		}
+		if errors.Is(err, errDisconnect) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Stops the run loop and waits for it to finish.
+func (s *Session) stop() {
+	s.pause.Store(true)
+	s.wg.Wait()
+}
+
+func decode(r *request, args any) error {
+	if len(r.Arguments) == 0 {
+		return nil
+	}
+	return json.Unmarshal(r.Arguments, args)
+}
+
+func (s *Session) initialize(r *request) error {
+	return s.t.respond(r, map[string]any{
+		"supportsConfigurationDoneRequest": true,
+		"supportsInstructionBreakpoints":   true,
+		"supportsReadMemoryRequest":        true,
+		"supportsTerminateRequest":         true,
+	}, nil)
+}
+
+func (s *Session) launch(r *request) error {
+	var args LaunchArguments
+	if err := decode(r, &args); err != nil {
+		return s.t.respond(r, nil, err)
+	}
+	if s.launcher == nil {
+		return s.t.respond(r, nil, errors.New("launching isn't supported, use attach"))
+	}
+
+	c, err := s.launcher(args)
+	if err != nil {
+		return s.t.respond(r, nil, err)
+	}
+	target, err := NewTarget(c, args.DebugInfo, args.Program)
+	if err != nil {
+		return s.t.respond(r, nil, err)
+	}
+
+	s.mu.Lock()
+	s.target = target
+	s.entry = c.Registers().PC
+	s.calls.Reset()
+	s.halted = false
+	s.stopOnEntry = args.StopOnEntry
+	s.mu.Unlock()
+	return s.ready(r)
+}
+
+func (s *Session) attach(r *request) error {
+	var args attachArguments
+	if err := decode(r, &args); err != nil {
+		return s.t.respond(r, nil, err)
+	}
+	if s.target == nil {
+		return s.t.respond(r, nil, errors.New("there's no program to attach to, use launch"))
+	}
+	if args.DebugInfo != "" {
+		if err := s.target.loadDebugInfo(args.DebugInfo, ""); err != nil {
+			return s.t.respond(r, nil, err)
+		}
+	}
+
+	s.mu.Lock()
+	s.stopOnEntry = args.StopOnEntry
+	s.mu.Unlock()
+	return s.ready(r)
+}
+
+// Finishes a launch or attach. Breakpoints can only be resolved once there's a
+// program, so the editor is told it can configure them from here.
+func (s *Session) ready(r *request) error {
+	if err := s.t.respond(r, nil, nil); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.started = true
+	s.mu.Unlock()
+	if err := s.t.event("initialized", nil); err != nil {
+		return err
+	}
+	return s.begin()
+}
+
+func (s *Session) configurationDone(r *request) error {
+	if err := s.t.respond(r, nil, nil); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.configured = true
+	s.mu.Unlock()
+	return s.begin()
+}
+
+// Starts running once the program is loaded and breakpoints are configured.
+func (s *Session) begin() error {
+	s.mu.Lock()
+	ready := s.started && s.configured
+	stopOnEntry := s.stopOnEntry
+	s.mu.Unlock()
+	if !ready {
+		return nil
+	}
+
+	if stopOnEntry {
+		return s.t.event("stopped", stoppedBody{Reason: "entry", ThreadID: THREAD_ID, AllThreadsStopped: true})
+	}
+	s.resume(stepNone)
+	return nil
+}
+
+// Loads debug info from path, or from next to the program if path is empty
+// and there's a .dbg file there.
+func (t *Target) loadDebugInfo(path string, program string) error {
+	path = dbginfo.PathFor(path, program)
+	if path == "" {
+		return nil
+	}
+
+	info, err := dbginfo.LoadFile(path)
+	if err != nil {
+		return err
+	}
+	t.Debug = info
+	t.SourceRoot = filepath.Dir(path)
+	return nil
+}
+
+type source struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type breakpoint struct {
+	Verified             bool   `json:"verified"`
+	Line                 int    `json:"line,omitempty"`
+	Message              string `json:"message,omitempty"`
+	InstructionReference string `json:"instructionReference,omitempty"`
+}
+
+func (s *Session) setBreakpoints(r *request) error {
+	var args struct {
+		Source      source `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}
+	if err := decode(r, &args); err != nil {
+		return s.t.respond(r, nil, err)
+	}
+
+	s.mu.Lock()
+	var addrs []uint16
+	result := make([]breakpoint, 0, len(args.Breakpoints))
+	for _, requested := range args.Breakpoints {
+		bp := breakpoint{Line: requested.Line}
+		var lineAddrs []uint16
+		if s.target != nil && s.target.Debug != nil {
+			lineAddrs = s.target.Debug.AddressesFor(args.Source.Path, requested.Line)
+		}
+		switch {
+		case s.target == nil || s.target.Debug == nil:
+			bp.Message = "no debug info loaded, use a .dbg file from ld65"
+		case len(lineAddrs) == 0:
+			bp.Message = "no code at this line"
+		default:
+			bp.Verified = true
+			bp.InstructionReference = reference(lineAddrs[0])
+			addrs = append(addrs, lineAddrs...)
+		}
+		result = append(result, bp)
+	}
+	s.sourceBreakpoints[args.Source.Path] = addrs
+	s.rebuildBreakpoints()
+	s.mu.Unlock()
+
+	return s.t.respond(r, map[string]any{"breakpoints": result}, nil)
+}
+
+func (s *Session) setInstructionBreakpoints(r *request) error {
+	var args struct {
+		Breakpoints []struct {
+			InstructionReference string `json:"instructionReference"`
+			Offset               int    `json:"offset"`
+		} `json:"breakpoints"`
+	}
+	if err := decode(r, &args); err != nil {
+		return s.t.respond(r, nil, err)
+	}
+
+	s.mu.Lock()
+	s.instructionBreakpoints = s.instructionBreakpoints[:0]
+	result := make([]breakpoint, 0, len(args.Breakpoints))
+	for _, requested := range args.Breakpoints {
+		addr, err := parseReference(requested.InstructionReference, requested.Offset)
+		if err != nil {
+			result = append(result, breakpoint{Message: err.Error()})
+			continue
+		}
+		s.instructionBreakpoints = append(s.instructionBreakpoints, addr)
+		result = append(result, breakpoint{Verified: true, InstructionReference: reference(addr)})
+	}
+	s.rebuildBreakpoints()
+	s.mu.Unlock()
+
+	return s.t.respond(r, map[string]any{"breakpoints": result}, nil)
+}
+
+// There are no exceptions to break on, but editors always send this.
+func (s *Session) setExceptionBreakpoints(r *request) error {
+	return s.t.respond(r, nil, nil)
+}
+
+// Merges source and instruction breakpoints into the set checked while
+// running, remembering which kind each one is for stopped events.
+func (s *Session) rebuildBreakpoints() {
+	s.breakpoints = map[uint16]string{}
+	for _, addrs := range s.sourceBreakpoints {
+		for _, addr := range addrs {
+			s.breakpoints[addr] = "breakpoint"
+		}
+	}
+	for _, addr := range s.instructionBreakpoints {
+		s.breakpoints[addr] = "instruction breakpoint"
+	}
+}
+
+func (s *Session) threads(r *request) error {
+	return s.t.respond(r, map[string]any{
+		"threads": []map[string]any{{"id": THREAD_ID, "name": "6502"}},
+	}, nil)
+}
+
+type stackFrame struct {
+	ID                          int     `json:"id"`
+	Name                        string  `json:"name"`
+	Source                      *source `json:"source,omitempty"`
+	Line                        int     `json:"line"`
+	Column                      int     `json:"column"`
+	InstructionPointerReference string  `json:"instructionPointerReference"`
+}
+
+// Reports the calls in progress, innermost first. Each frame is named after
+// the routine it's in, which is whatever the JSR that entered it called.
+func (s *Session) stackTrace(r *request) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.target == nil {
+		return s.t.respond(r, nil, errors.New("no program loaded"))
+	}
+
+	calls := s.calls.Frames()
+	routine := func(depth int) uint16 {
+		if depth == 0 {
+			return s.entry
+		}
+		return calls[depth-1].Target
+	}
+
+	frames := []stackFrame{s.frame(0, s.target.CPU.Registers().PC, routine(len(calls)))}
+	for i := len(calls) - 1; i >= 0; i-- {
+		frames = append(frames, s.frame(len(frames), calls[i].Caller, routine(i)))
+	}
+	return s.t.respond(r, map[string]any{"stackFrames": frames, "totalFrames": len(frames)}, nil)
+}
+
+func (s *Session) frame(id int, pc uint16, routine uint16) stackFrame {
+	frame := stackFrame{ID: id, Name: fmt.Sprintf("$%04X", routine), InstructionPointerReference: reference(pc)}
+	debug := s.target.Debug
+	if debug == nil {
+		return frame
+	}
+
+	if name, ok := debug.SymbolAt(routine); ok {
+		frame.Name = name
+	}
+	if line, ok := debug.LineFor(pc); ok {
+		path := line.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(s.target.SourceRoot, path)
+		}
+		frame.Source = &source{Name: filepath.Base(path), Path: path}
+		frame.Line = line.Line
+		frame.Column = 1
+	}
+	return frame
+}
+
+// Registers and flags are the same whichever frame is selected, the 6502
+// doesn't save them on calls.
+func (s *Session) scopes(r *request) error {
+	return s.t.respond(r, map[string]any{
+		"scopes": []map[string]any{
+			{"name": "Registers", "variablesReference": REGISTERS_REFERENCE, "expensive": false},
+			{"name": "Flags", "variablesReference": FLAGS_REFERENCE, "expensive": false},
+		},
+	}, nil)
+}
+
+type variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	VariablesReference int    `json:"variablesReference"`
+	MemoryReference    string `json:"memoryReference,omitempty"`
+}
+
+// Flags in the order they're drawn in flags.go, most significant first.
+var flags = []struct {
+	name string
+	flag cpu.Flag
+}{
+	{"Negative", cpu.FlagNegative},
+	{"Overflow", cpu.FlagOverflow},
+	{"Break Command", cpu.FlagBreakCommand},
+	{"Decimal Mode", cpu.FlagDecimalMode},
+	{"Interrupt Disable", cpu.FlagInterruptDiable},
+	{"Zero", cpu.FlagZero},
+	{"Carry", cpu.FlagCarry},
+}
+
+func (s *Session) variables(r *request) error {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	if err := decode(r, &args); err != nil {
+		return s.t.respond(r, nil, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.target == nil {
+		return s.t.respond(r, nil, errors.New("no program loaded"))
+	}
+	registers := s.target.CPU.Registers()
+
+	var variables []variable
+	switch args.VariablesReference {
+	case REGISTERS_REFERENCE:
+		variables = []variable{
+			{Name: "A", Value: fmt.Sprintf("$%02X", registers.A)},
+			{Name: "X", Value: fmt.Sprintf("$%02X", registers.X)},
+			{Name: "Y", Value: fmt.Sprintf("$%02X", registers.Y)},
+			{Name: "P", Value: fmt.Sprintf("$%02X", registers.Status)},
+			{Name: "SP", Value: fmt.Sprintf("$%02X", registers.SP), MemoryReference: reference(0x0100 + uint16(registers.SP))},
+			{Name: "PC", Value: fmt.Sprintf("$%04X", registers.PC), MemoryReference: reference(registers.PC)},
+		}
+	case FLAGS_REFERENCE:
+		for _, f := range flags {
+			variables = append(variables, variable{Name: f.name, Value: fmt.Sprint(registers.Status&f.flag != 0)})
+		}
+	default:
+		return s.t.respond(r, nil, fmt.Errorf("unknown variables reference %d", args.VariablesReference))
+	}
+	return s.t.respond(r, map[string]any{"variables": variables}, nil)
+}
+
+func (s *Session) cont(r *request) error {
+	if err := s.t.respond(r, map[string]any{"allThreadsContinued": true}, nil); err != nil {
+		return err
+	}
+	s.resume(stepNone)
+	return nil
+}
+
+func (s *Session) next(r *request) error {
+	if err := s.t.respond(r, nil, nil); err != nil {
+		return err
+	}
+	s.resume(stepOver)
+	return nil
+}
+
+func (s *Session) stepIn(r *request) error {
+	if err := s.t.respond(r, nil, nil); err != nil {
+		return err
+	}
+	s.resume(stepIn)
+	return nil
+}
+
+func (s *Session) stepOut(r *request) error {
+	if err := s.t.respond(r, nil, nil); err != nil {
+		return err
+	}
+	s.resume(stepOut)
+	return nil
+}
+
+func (s *Session) pauseRequest(r *request) error {
+	s.pause.Store(true)
+	return s.t.respond(r, nil, nil)
+}
+
+func (s *Session) readMemory(r *request) error {
+	var args struct {
+		MemoryReference string `json:"memoryReference"`
+		Offset          int    `json:"offset"`
+		Count           int    `json:"count"`
+	}
+	if err := decode(r, &args); err != nil {
+		return s.t.respond(r, nil, err)
+	}
+	addr, err := parseReference(args.MemoryReference, args.Offset)
+	if err != nil {
+		return s.t.respond(r, nil, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.target == nil {
+		return s.t.respond(r, nil, errors.New("no program loaded"))
+	}
+
+	count := max(min(args.Count, 0x10000-int(addr)), 0)
+	data := make([]byte, count)
+	for i := range data {
+		data[i] = s.target.CPU.MemRead(addr + uint16(i))
+	}
+	return s.t.respond(r, map[string]any{
+		"address":         reference(addr),
+		"data":            data,
+		"unreadableBytes": args.Count - count,
+	}, nil)
+}
+
+func (s *Session) terminate(r *request) error {
+	s.stop()
+	if err := s.t.respond(r, nil, nil); err != nil {
+		return err
+	}
+	return s.t.event("terminated", nil)
+}
+
+func (s *Session) disconnect(r *request) error {
+	s.stop()
+	if err := s.t.respond(r, nil, nil); err != nil {
+		return err
+	}
+	return errDisconnect
+}
+
+type stoppedBody struct {
+	Reason            string `json:"reason"`
+	Description       string `json:"description,omitempty"`
+	Text              string `json:"text,omitempty"`
+	ThreadID          int    `json:"threadId"`
+	AllThreadsStopped bool   `json:"allThreadsStopped"`
+}
+
+// Starts the CPU running on its own goroutine until a breakpoint, a pause or
+// the step finishes.
+func (s *Session) resume(mode stepMode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running || s.halted || s.target == nil {
+		return
+	}
+	s.running = true
+	s.pause.Store(false)
+
+	depth := s.calls.Depth()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.run(mode, depth)
+	}()
+}
+
+func (s *Session) run(mode stepMode, depth int) {
+	executed := 0
+	for {
+		s.mu.Lock()
+		reason, text, halted := s.runChunk(mode, depth, &executed)
+		if reason != "" || halted {
+			s.running = false
+			s.halted = halted
+		}
+		s.mu.Unlock()
+
+		switch {
+		case halted:
+			s.t.event("exited", map[string]any{"exitCode": 0})
+			s.t.event("terminated", nil)
+			return
+		case reason != "":
+			s.t.event("stopped", stoppedBody{Reason: reason, Text: text, ThreadID: THREAD_ID, AllThreadsStopped: true})
+			return
+		}
+	}
+}
+
+// Runs up to CHUNK_INSTRUCTIONS instructions. Returns why execution stopped,
+// or an empty reason if the chunk ran out and there's more to do. Reports
+// halted once the program reaches BRK.
+func (s *Session) runChunk(mode stepMode, depth int, executed *int) (reason string, text string, halted bool) {
+	c := s.target.CPU
+	pc := c.Registers().PC
+	defer func() {
+		if r := recover(); r != nil {
+			registers := c.Registers()
+			registers.PC = pc
+			c.SetRegisters(registers)
+			reason, text, halted = "exception", strings.TrimSpace(fmt.Sprint(r)), false
+		}
+	}()
+
+	chunk := 0
+	halted = true
+	c.RunWithCallback(func(c *cpu.CPU) bool {
+		pc = c.Registers().PC
+		if *executed > 0 {
+			reason = s.stopReason(mode, depth, pc)
+		}
+		if reason != "" || chunk == CHUNK_INSTRUCTIONS {
+			halted = false
+			return false
+		}
+		s.calls.Before(c)
+		*executed++
+		chunk++
+		return true
+	})
+	return reason, text, halted
+}
+
+// Decides whether to stop before the instruction at pc.
+func (s *Session) stopReason(mode stepMode, depth int, pc uint16) string {
+	if s.pause.Load() {
+		return "pause"
+	}
+	if reason, ok := s.breakpoints[pc]; ok {
+		return reason
+	}
+
+	switch mode {
+	case stepIn:
+		return "step"
+	case stepOver:
+		// Stepping over a JSR runs until the call has returned.
+		if s.calls.Depth() <= depth {
+			return "step"
+		}
+	case stepOut:
+		if s.calls.Depth() < depth {
+			return "step"
+		}
+	}
+	return ""
+}
+
+// Formats an address as a memory or instruction reference.
+func reference(addr uint16) string {
+	return fmt.Sprintf("0x%04X", addr)
+}
+
+func parseReference(ref string, offset int) (uint16, error) {
+	value, err := strconv.ParseInt(strings.Replace(ref, "$", "0x", 1), 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid reference %q", ref)
+	}
+	addr := int(value) + offset
+	if addr < 0 || addr > 0xFFFF {
+		return 0, fmt.Errorf("address %d is outside the address space", addr)
+	}
+	return uint16(addr), nil
+}
@@ -0,0 +1,345 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"switchtrue.com/hankee/cpu"
+	"switchtrue.com/hankee/dbginfo"
+)
+
+// main:  JSR sub; INX; BRK
+// sub:   LDA #$05; JSR inner; RTS
+// inner: STA $10; RTS
+var program = []uint8{0x20, 0x06, 0x06, 0xe8, 0x00, 0xea, 0xa9, 0x05, 0x20, 0x0c, 0x06, 0x60, 0x85, 0x10, 0x60}
+
+const programDebugInfo = `file	id=0,name="loop.s",size=100,mtime=0x5E1B6BFB,mod=0
+seg	id=0,name="CODE",start=0x000600,size=0x000F,addrsize=absolute,type=rw
+span	id=0,seg=0,start=0,size=3
+span	id=1,seg=0,start=3,size=1
+span	id=2,seg=0,start=4,size=1
+span	id=3,seg=0,start=6,size=2
+span	id=4,seg=0,start=8,size=3
+span	id=5,seg=0,start=11,size=1
+span	id=6,seg=0,start=12,size=2
+span	id=7,seg=0,start=14,size=1
+line	id=0,file=0,line=2,span=0
+line	id=1,file=0,line=3,span=1
+line	id=2,file=0,line=4,span=2
+line	id=3,file=0,line=7,span=3
+line	id=4,file=0,line=8,span=4
+line	id=5,file=0,line=9,span=5
+line	id=6,file=0,line=12,span=6
+line	id=7,file=0,line=13,span=7
+sym	id=0,name="main",addrsize=absolute,scope=0,def=0,val=0x600,seg=0,type=lab
+sym	id=1,name="sub",addrsize=absolute,scope=0,def=3,val=0x606,seg=0,type=lab
+sym	id=2,name="inner",addrsize=absolute,scope=0,def=6,val=0x60C,seg=0,type=lab
+`
+
+type message map[string]any
+
+// client is a scripted editor talking to a session over an in memory pipe.
+type client struct {
+	t        *testing.T
+	conn     net.Conn
+	messages chan message
+	events   []message
+	seq      int
+}
+
+func newTarget(t *testing.T, code []uint8, withDebugInfo bool) *Target {
+	c := cpu.NewCPU()
+	c.LoadAt(0x0600, code)
+	c.Reset()
+	target := &Target{CPU: c, SourceRoot: "/src"}
+	if withDebugInfo {
+		info, err := dbginfo.Parse(strings.NewReader(programDebugInfo))
+		require.NoError(t, err)
+		target.Debug = info
+	}
+	return target
+}
+
+func newClient(t *testing.T, session *Session) *client {
+	server, conn := net.Pipe()
+	cl := &client{t: t, conn: conn, messages: make(chan message, 100)}
+	go session.Serve(server)
+	go func() {
+		reader := textproto.NewReader(bufio.NewReader(conn))
+		for {
+			header, err := reader.ReadMIMEHeader()
+			if err != nil {
+				close(cl.messages)
+				return
+			}
+			length, _ := strconv.Atoi(header.Get("Content-Length"))
+			body := make([]byte, length)
+			if _, err := io.ReadFull(reader.R, body); err != nil {
+				close(cl.messages)
+				return
+			}
+			var m message
+			json.Unmarshal(body, &m)
+			cl.messages <- m
+		}
+	}()
+	t.Cleanup(func() { conn.Close() })
+	return cl
+}
+
+func (cl *client) next() message {
+	select {
+	case m, ok := <-cl.messages:
+		require.True(cl.t, ok, "connection closed")
+		return m
+	case <-time.After(5 * time.Second):
+		require.FailNow(cl.t, "timed out waiting for a message")
+		return nil
+	}
+}
+
+// Sends a request and returns the body of a successful response. Events that
+// arrive in the meantime are kept for event.
+func (cl *client) request(command string, arguments any) map[string]any {
+	response := cl.send(command, arguments)
+	require.True(cl.t, response["success"].(bool), "%s failed: %v", command, response["message"])
+	body, _ := response["body"].(map[string]any)
+	return body
+}
+
+func (cl *client) send(command string, arguments any) message {
+	cl.seq++
+	data, err := json.Marshal(message{"seq": cl.seq, "type": "request", "command": command, "arguments": arguments})
+	require.NoError(cl.t, err)
+	_, err = fmt.Fprintf(cl.conn, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	require.NoError(cl.t, err)
+
+	for {
+		m := cl.next()
+		if m["type"] == "event" {
+			cl.events = append(cl.events, m)
+			continue
+		}
+		require.Equal(cl.t, float64(cl.seq), m["request_seq"])
+		return m
+	}
+}
+
+// Waits for the named event and returns its body.
+func (cl *client) event(name string) map[string]any {
+	for {
+		var m message
+		if len(cl.events) > 0 {
+			m, cl.events = cl.events[0], cl.events[1:]
+		} else {
+			m = cl.next()
+		}
+		if m["type"] == "event" && m["event"] == name {
+			body, _ := m["body"].(map[string]any)
+			return body
+		}
+	}
+}
+
+// Attaches and configures, stopping on entry if asked.
+func (cl *client) start(stopOnEntry bool, configure func()) {
+	cl.request("initialize", message{"adapterID": "hankee"})
+	cl.request("attach", message{"stopOnEntry": stopOnEntry})
+	cl.event("initialized")
+	if configure != nil {
+		configure()
+	}
+	cl.request("configurationDone", nil)
+}
+
+func (cl *client) pc() string {
+	frames := cl.request("stackTrace", message{"threadId": THREAD_ID})["stackFrames"].([]any)
+	return frames[0].(map[string]any)["instructionPointerReference"].(string)
+}
+
+// Test that an instruction breakpoint stops with a stack rebuilt from JSR
+func Test_Session_StackTrace(t *testing.T) {
+	cl := newClient(t, NewSession(newTarget(t, program, true), nil))
+	cl.start(false, func() {
+		body := cl.request("setInstructionBreakpoints", message{"breakpoints": []message{{"instructionReference": "0x060C"}}})
+		assert.Equal(t, true, body["breakpoints"].([]any)[0].(map[string]any)["verified"])
+	})
+	assert.Equal(t, "instruction breakpoint", cl.event("stopped")["reason"])
+
+	frames := cl.request("stackTrace", message{"threadId": THREAD_ID})["stackFrames"].([]any)
+	require.Len(t, frames, 3)
+	want := []struct {
+		name string
+		pc   string
+		line float64
+	}{
+		{"inner", "0x060C", 12},
+		{"sub", "0x0608", 8},
+		{"main", "0x0600", 2},
+	}
+	for i, w := range want {
+		frame := frames[i].(map[string]any)
+		assert.Equal(t, w.name, frame["name"])
+		assert.Equal(t, w.pc, frame["instructionPointerReference"])
+		assert.Equal(t, w.line, frame["line"])
+		assert.Equal(t, "/src/loop.s", frame["source"].(map[string]any)["path"])
+	}
+}
+
+// Test that source breakpoints resolve through the debug info
+func Test_Session_SourceBreakpoints(t *testing.T) {
+	cl := newClient(t, NewSession(newTarget(t, program, true), nil))
+	cl.start(false, func() {
+		body := cl.request("setBreakpoints", message{
+			"source":      message{"path": "/home/me/game/loop.s"},
+			"breakpoints": []message{{"line": 8}, {"line": 5}},
+		})
+		breakpoints := body["breakpoints"].([]any)
+		assert.Equal(t, true, breakpoints[0].(map[string]any)["verified"])
+		assert.Equal(t, false, breakpoints[1].(map[string]any)["verified"])
+	})
+	assert.Equal(t, "breakpoint", cl.event("stopped")["reason"])
+	assert.Equal(t, "0x0608", cl.pc())
+
+	cl.request("continue", message{"threadId": THREAD_ID})
+	assert.Equal(t, float64(0), cl.event("exited")["exitCode"])
+	cl.event("terminated")
+}
+
+// Test stepping into and over subroutines
+func Test_Session_Stepping(t *testing.T) {
+	cl := newClient(t, NewSession(newTarget(t, program, false), nil))
+	cl.start(true, nil)
+	assert.Equal(t, "entry", cl.event("stopped")["reason"])
+	assert.Equal(t, "0x0600", cl.pc())
+
+	steps := []struct {
+		command string
+		pc      string
+	}{
+		{"stepIn", "0x0606"},
+		{"next", "0x0608"},
+		{"next", "0x060B"},
+		{"stepIn", "0x0603"},
+		{"stepIn", "0x0604"},
+	}
+	for _, step := range steps {
+		cl.request(step.command, message{"threadId": THREAD_ID})
+		assert.Equal(t, "step", cl.event("stopped")["reason"])
+		assert.Equal(t, step.pc, cl.pc(), step.command)
+	}
+
+	cl.request("stepIn", message{"threadId": THREAD_ID})
+	cl.event("terminated")
+}
+
+// Test that step out runs until the current routine returns
+func Test_Session_StepOut(t *testing.T) {
+	cl := newClient(t, NewSession(newTarget(t, program, false), nil))
+	cl.start(false, func() {
+		cl.request("setInstructionBreakpoints", message{"breakpoints": []message{{"instructionReference": "0x060C"}}})
+	})
+	cl.event("stopped")
+
+	// Without debug info frames are named after the address called.
+	frames := cl.request("stackTrace", message{"threadId": THREAD_ID})["stackFrames"].([]any)
+	assert.Equal(t, "$060C", frames[0].(map[string]any)["name"])
+	assert.Equal(t, "$0600", frames[2].(map[string]any)["name"])
+
+	cl.request("stepOut", message{"threadId": THREAD_ID})
+	cl.event("stopped")
+	assert.Equal(t, "0x060B", cl.pc())
+	cl.request("stepOut", message{"threadId": THREAD_ID})
+	cl.event("stopped")
+	assert.Equal(t, "0x0603", cl.pc())
+}
+
+// Test the register and flag variables and reading memory
+func Test_Session_Variables(t *testing.T) {
+	cl := newClient(t, NewSession(newTarget(t, program, false), nil))
+	cl.start(true, nil)
+	cl.event("stopped")
+	cl.request("stepIn", message{"threadId": THREAD_ID})
+	cl.event("stopped")
+	cl.request("stepIn", message{"threadId": THREAD_ID})
+	cl.event("stopped")
+
+	scopes := cl.request("scopes", message{"frameId": 0})["scopes"].([]any)
+	assert.Len(t, scopes, 2)
+
+	values := map[string]string{}
+	for _, reference := range []int{REGISTERS_REFERENCE, FLAGS_REFERENCE} {
+		for _, v := range cl.request("variables", message{"variablesReference": reference})["variables"].([]any) {
+			variable := v.(map[string]any)
+			values[variable["name"].(string)] = variable["value"].(string)
+		}
+	}
+	assert.Equal(t, "$05", values["A"])
+	assert.Equal(t, "$FB", values["SP"])
+	assert.Equal(t, "$0608", values["PC"])
+	assert.Equal(t, "false", values["Zero"])
+	assert.Equal(t, "false", values["Negative"])
+
+	body := cl.request("readMemory", message{"memoryReference": "0x0600", "offset": 6, "count": 2})
+	assert.Equal(t, "0x0606", body["address"])
+	assert.Equal(t, "qQU=", body["data"])
+
+	body = cl.request("readMemory", message{"memoryReference": "0xFFFE", "count": 4})
+	assert.Equal(t, float64(2), body["unreadableBytes"])
+}
+
+// Test launching a program and pausing it while it runs
+func Test_Session_LaunchAndPause(t *testing.T) {
+	var launched LaunchArguments
+	session := NewSession(nil, func(args LaunchArguments) (*cpu.CPU, error) {
+		launched = args
+		// JMP $0600
+		return newTarget(t, []uint8{0x4c, 0x00, 0x06}, false).CPU, nil
+	})
+	cl := newClient(t, session)
+
+	cl.request("initialize", message{"adapterID": "hankee"})
+	assert.Equal(t, false, cl.send("attach", nil)["success"])
+	cl.request("launch", message{"program": "/tmp/spin.bin", "raw": true, "load": "$0600"})
+	assert.Equal(t, LaunchArguments{Program: "/tmp/spin.bin", Raw: true, Load: "$0600"}, launched)
+	cl.event("initialized")
+	cl.request("configurationDone", nil)
+
+	time.Sleep(10 * time.Millisecond)
+	cl.request("pause", message{"threadId": THREAD_ID})
+	assert.Equal(t, "pause", cl.event("stopped")["reason"])
+	assert.Equal(t, "0x0600", cl.pc())
+
+	assert.Equal(t, true, cl.send("disconnect", nil)["success"])
+}
+
+// Test that a Content-Length that's negative or over the limit is refused
+// before anything is allocated for it
+func Test_Transport_ContentLength(t *testing.T) {
+	read := func(length int, body string) (*request, error) {
+		in := strings.NewReader(fmt.Sprintf("Content-Length: %d\r\n\r\n%s", length, body))
+		return newTransport(struct {
+			io.Reader
+			io.Writer
+		}{in, io.Discard}).read()
+	}
+	for _, length := range []int{-1, MAX_MESSAGE + 1} {
+		_, err := read(length, "{}")
+		assert.Error(t, err, length)
+	}
+
+	body := `{"seq":1,"command":"threads"}`
+	r, err := read(len(body), body)
+	require.NoError(t, err)
+	assert.Equal(t, "threads", r.Command)
+}
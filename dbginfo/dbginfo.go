@@ -0,0 +1,266 @@
+// Package dbginfo reads the debug info files written by the cc65 linker with
+// ld65 --dbgfile, mapping CPU addresses back to source lines and symbols.
+package dbginfo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Line types from the debug info format. Macro lines point into the macro
+// definition, so plain assembler lines are preferred when both cover an
+// address.
+const (
+	LINE_ASSEMBLER = 0
+	LINE_EXTERNAL  = 1
+	LINE_MACRO     = 2
+)
+
+// Line is a position in a source file.
+type Line struct {
+	File string
+	Line int
+}
+
+// Symbol is a label or equate from the debug info.
+type Symbol struct {
+	Name  string
+	Value uint16
+	Label bool
+}
+
+// Info is the parsed contents of a debug info file.
+type Info struct {
+	Files   []string
+	Symbols []Symbol
+
+	lines     map[uint16]lineEntry
+	addresses map[Line][]uint16
+	labels    []Symbol
+}
+
+type lineEntry struct {
+	line Line
+	kind int
+}
+
+// Reads and parses a debug info file.
+func LoadFile(path string) (*Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Returns the debug info file to use for a program: path if it's given,
+// otherwise the program with a .dbg extension if that exists, or "" if
+// there's none.
+func PathFor(path string, program string) string {
+	if path != "" || program == "" {
+		return path
+	}
+	path = strings.TrimSuffix(program, filepath.Ext(program)) + ".dbg"
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// Parses a debug info file. Each line is a record type followed by a tab and
+// comma separated key=value pairs.
+func Parse(r io.Reader) (*Info, error) {
+	files := map[int]string{}
+	segments := map[int]int{}
+	spans := map[int]int{}
+	spanSizes := map[int]int{}
+	type lineRecord struct {
+		file  int
+		line  int
+		kind  int
+		spans []int
+	}
+	var lineRecords []lineRecord
+	info := &Info{
+		lines:     map[uint16]lineEntry{},
+		addresses: map[Line][]uint16{},
+	}
+
+	var symbolRecords []map[string]string
+	scanner := bufio.NewScanner(r)
+	number := 0
+	for scanner.Scan() {
+		number++
+		kind, rest, found := strings.Cut(strings.TrimSpace(scanner.Text()), "\t")
+		if !found {
+			continue
+		}
+		fields, err := parseFields(rest)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", number, err)
+		}
+		id, _ := parseInt(fields["id"])
+
+		switch kind {
+		case "file":
+			files[id] = fields["name"]
+			info.Files = append(info.Files, fields["name"])
+		case "seg":
+			segments[id], _ = parseInt(fields["start"])
+		case "span":
+			seg, _ := parseInt(fields["seg"])
+			start, _ := parseInt(fields["start"])
+			size, _ := parseInt(fields["size"])
+			spans[id] = seg<<16 | start
+			spanSizes[id] = size
+		case "line":
+			file, _ := parseInt(fields["file"])
+			line, _ := parseInt(fields["line"])
+			lineType, _ := parseInt(fields["type"])
+			record := lineRecord{file: file, line: line, kind: lineType}
+			if fields["span"] != "" {
+				for _, span := range strings.Split(fields["span"], "+") {
+					spanID, err := parseInt(span)
+					if err != nil {
+						return nil, fmt.Errorf("line %d: invalid span %q", number, span)
+					}
+					record.spans = append(record.spans, spanID)
+				}
+			}
+			lineRecords = append(lineRecords, record)
+		case "sym":
+			symbolRecords = append(symbolRecords, fields)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Spans are relative to their segment, so addresses can only be worked
+	// out once everything has been read.
+	for _, record := range lineRecords {
+		line := Line{File: files[record.file], Line: record.line}
+		for _, span := range record.spans {
+			packed, ok := spans[span]
+			if !ok {
+				continue
+			}
+			start := segments[packed>>16] + packed&0xFFFF
+			for i := 0; i < spanSizes[span]; i++ {
+				addr := uint16(start + i)
+				existing, ok := info.lines[addr]
+				if !ok || existing.kind == LINE_MACRO && record.kind != LINE_MACRO {
+					info.lines[addr] = lineEntry{line, record.kind}
+				}
+			}
+			info.addresses[line] = append(info.addresses[line], uint16(start))
+		}
+	}
+
+	for _, fields := range symbolRecords {
+		value, err := parseInt(fields["val"])
+		if err != nil {
+			continue
+		}
+		symbol := Symbol{Name: fields["name"], Value: uint16(value), Label: fields["type"] == "lab"}
+		info.Symbols = append(info.Symbols, symbol)
+		if symbol.Label {
+			info.labels = append(info.labels, symbol)
+		}
+	}
+	sort.SliceStable(info.labels, func(i, j int) bool { return info.labels[i].Value < info.labels[j].Value })
+	for _, addrs := range info.addresses {
+		sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	}
+
+	return info, nil
+}
+
+// Returns the source line that generated the byte at addr.
+func (info *Info) LineFor(addr uint16) (Line, bool) {
+	entry, ok := info.lines[addr]
+	return entry.line, ok
+}
+
+// Returns the addresses of code generated by a source line. The file can be
+// the name from the debug info or any path ending with it, since debug info
+// names are relative to wherever the assembler ran.
+func (info *Info) AddressesFor(file string, line int) []uint16 {
+	name, ok := info.fileName(file)
+	if !ok {
+		return nil
+	}
+	return info.addresses[Line{File: name, Line: line}]
+}
+
+// Returns the file name used by the debug info for path.
+func (info *Info) fileName(path string) (string, bool) {
+	path = filepath.ToSlash(filepath.Clean(path))
+	for _, name := range info.Files {
+		clean := filepath.ToSlash(filepath.Clean(name))
+		if path == clean || strings.HasSuffix(path, "/"+strings.TrimPrefix(clean, "./")) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// Returns the label defined exactly at addr.
+func (info *Info) SymbolAt(addr uint16) (string, bool) {
+	i := sort.Search(len(info.labels), func(i int) bool { return info.labels[i].Value >= addr })
+	if i < len(info.labels) && info.labels[i].Value == addr {
+		return info.labels[i].Name, true
+	}
+	return "", false
+}
+
+// Returns the closest label at or before addr and how far past it addr is.
+func (info *Info) SymbolFor(addr uint16) (string, uint16, bool) {
+	i := sort.Search(len(info.labels), func(i int) bool { return info.labels[i].Value > addr })
+	if i == 0 {
+		return "", 0, false
+	}
+	label := info.labels[i-1]
+	return label.Name, addr - label.Value, true
+}
+
+// Splits key=value pairs separated by commas, where values may be quoted
+// strings containing commas.
+func parseFields(s string) (map[string]string, error) {
+	fields := map[string]string{}
+	for s != "" {
+		key, rest, found := strings.Cut(s, "=")
+		if !found {
+			return nil, fmt.Errorf("expected key=value in %q", s)
+		}
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string in %q", s)
+			}
+			value = rest[1 : end+1]
+			rest = rest[end+2:]
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+			rest = "," + rest
+		}
+		fields[key] = value
+		s = strings.TrimPrefix(rest, ",")
+	}
+	return fields, nil
+}
+
+// Parses a decimal or 0x prefixed hex number.
+func parseInt(s string) (int, error) {
+	value, err := strconv.ParseInt(s, 0, 32)
+	return int(value), err
+}
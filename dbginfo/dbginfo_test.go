@@ -0,0 +1,80 @@
+package dbginfo
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A trimmed down file from ld65 for a program with a reset routine calling a
+// subroutine, one line of which comes from a macro.
+const SAMPLE = `version	major=2,minor=0
+info	csym=0,file=2,lib=0,line=5,mod=1,scope=1,seg=1,span=4,sym=2,type=2
+file	id=0,name="src/main.s",size=120,mtime=0x5E1B6BFB,mod=0
+file	id=1,name="src/macros.inc",size=40,mtime=0x5E1B6BFB,mod=0
+line	id=0,file=0,line=4,span=0
+line	id=1,file=0,line=5,span=1
+line	id=2,file=0,line=9,span=2
+line	id=3,file=1,line=2,type=2,span=3
+line	id=4,file=0,line=10,span=3
+mod	id=0,name="main.o",file=0
+seg	id=0,name="CODE",start=0x008000,size=0x0008,addrsize=absolute,type=ro,oname="game,v2.nes",ooffs=16
+span	id=0,seg=0,start=0,size=2
+span	id=1,seg=0,start=2,size=3
+span	id=2,seg=0,start=5,size=2
+span	id=3,seg=0,start=7,size=1
+scope	id=0,name="",mod=0,size=8,span=0+1+2+3
+sym	id=0,name="reset",addrsize=absolute,scope=0,def=4,ref=7,val=0x8000,seg=0,type=lab
+sym	id=1,name="subroutine",addrsize=absolute,scope=0,def=9,val=0x8005,seg=0,type=lab
+sym	id=2,name="LIVES",addrsize=zeropage,scope=0,def=2,val=0x10,type=equ
+`
+
+func parseSample(t *testing.T) *Info {
+	info, err := Parse(strings.NewReader(SAMPLE))
+	assert.NoError(t, err)
+	return info
+}
+
+// Test that every byte of a span maps back to its line
+func Test_Info_LineFor(t *testing.T) {
+	info := parseSample(t)
+	line, ok := info.LineFor(0x8003)
+	assert.True(t, ok)
+	assert.Equal(t, Line{File: "src/main.s", Line: 5}, line)
+
+	// Assembler lines win over the macro definition.
+	line, _ = info.LineFor(0x8007)
+	assert.Equal(t, Line{File: "src/main.s", Line: 10}, line)
+
+	_, ok = info.LineFor(0x9000)
+	assert.False(t, ok)
+}
+
+// Test that lines map to addresses given any path ending in the file name
+func Test_Info_AddressesFor(t *testing.T) {
+	info := parseSample(t)
+	assert.Equal(t, []uint16{0x8005}, info.AddressesFor("src/main.s", 9))
+	assert.Equal(t, []uint16{0x8002}, info.AddressesFor("/home/me/game/src/main.s", 5))
+	assert.Empty(t, info.AddressesFor("/home/me/game/other/main.s", 5))
+	assert.Empty(t, info.AddressesFor("src/main.s", 6))
+}
+
+// Test looking up labels, equates aren't treated as code locations
+func Test_Info_Symbols(t *testing.T) {
+	info := parseSample(t)
+	assert.Len(t, info.Symbols, 3)
+
+	name, ok := info.SymbolAt(0x8005)
+	assert.True(t, ok)
+	assert.Equal(t, "subroutine", name)
+	_, ok = info.SymbolAt(0x0010)
+	assert.False(t, ok)
+
+	name, offset, ok := info.SymbolFor(0x8003)
+	assert.True(t, ok)
+	assert.Equal(t, "reset", name)
+	assert.Equal(t, uint16(3), offset)
+	_, _, ok = info.SymbolFor(0x7000)
+	assert.False(t, ok)
+}
@@ -0,0 +1,126 @@
+package disasm
+
+import (
+	"fmt"
+	"strings"
+
+	"switchtrue.com/hankee/cpu"
+)
+
+// Instruction is a single decoded instruction.
+type Instruction struct {
+	Address uint16
+	Bytes   []uint8
+	OpCode  cpu.OpCode
+	// Known is false when the byte at Address isn't in the opcode table, in
+	// which case the instruction is rendered as a data byte.
+	Known bool
+}
+
+// Decodes the instruction at addr.
+func Disassemble(mem cpu.Memory, addr uint16) Instruction {
+	code := mem.Read(addr)
+	opcode, ok := cpu.CPU_OP_CODE_TABLE[code]
+	if !ok {
+		return Instruction{Address: addr, Bytes: []uint8{code}}
+	}
+
+	bytes := make([]uint8, opcode.Bytes)
+	for i := range bytes {
+		bytes[i] = mem.Read(addr + uint16(i))
+	}
+	return Instruction{Address: addr, Bytes: bytes, OpCode: opcode, Known: true}
+}
+
+// Decodes every instruction from start up to and including end.
+func Range(mem cpu.Memory, start uint16, end uint16) []Instruction {
+	var instructions []Instruction
+	addr := uint32(start)
+	for addr <= uint32(end) {
+		instruction := Disassemble(mem, uint16(addr))
+		instructions = append(instructions, instruction)
+		addr += uint32(len(instruction.Bytes))
+	}
+	return instructions
+}
+
+// Returns the address of the instruction following this one.
+func (i Instruction) Next() uint16 {
+	return i.Address + uint16(len(i.Bytes))
+}
+
+// Renders the instruction in assembler syntax, e.g. "LDA #$05".
+func (i Instruction) String() string {
+	if !i.Known {
+		return fmt.Sprintf(".db $%02X", i.Bytes[0])
+	}
+
+	operand := i.Operand()
+	if operand == "" {
+		return i.OpCode.Name
+	}
+	return i.OpCode.Name + " " + operand
+}
+
+// Renders just the operand, e.g. "#$05" or "($10),Y".
+func (i Instruction) Operand() string {
+	if !i.Known {
+		return ""
+	}
+
+	var lo, word uint16
+	if len(i.Bytes) > 1 {
+		lo = uint16(i.Bytes[1])
+		word = lo
+	}
+	if len(i.Bytes) > 2 {
+		word = uint16(i.Bytes[2])<<8 | lo
+	}
+
+	switch i.OpCode.AddressingMode {
+	case cpu.Immediate:
+		return fmt.Sprintf("#$%02X", lo)
+	case cpu.ZeroPage:
+		return fmt.Sprintf("$%02X", lo)
+	case cpu.ZeroPageX:
+		return fmt.Sprintf("$%02X,X", lo)
+	case cpu.ZeroPageY:
+		return fmt.Sprintf("$%02X,Y", lo)
+	case cpu.Absolute:
+		return fmt.Sprintf("$%04X", word)
+	case cpu.AbsoluteX:
+		return fmt.Sprintf("$%04X,X", word)
+	case cpu.AbsoluteY:
+		return fmt.Sprintf("$%04X,Y", word)
+	case cpu.Indirect:
+		return fmt.Sprintf("($%04X)", word)
+	case cpu.IndirectX:
+		return fmt.Sprintf("($%02X,X)", lo)
+	case cpu.IndirectY:
+		return fmt.Sprintf("($%02X),Y", lo)
+	case cpu.Accumulator:
+		return "A"
+	case cpu.Relative:
+		return fmt.Sprintf("$%04X", i.BranchTarget())
+	default:
+		return ""
+	}
+}
+
+// Returns the destination of a relative branch. The offset is signed and
+// relative to the instruction following the branch.
+func (i Instruction) BranchTarget() uint16 {
+	if len(i.Bytes) < 2 {
+		return i.Next()
+	}
+	return i.Next() + uint16(int8(i.Bytes[1]))
+}
+
+// Renders the raw bytes as hex, e.g. "A9 05".
+func (i Instruction) Hex() string {
+	parts := make([]string, len(i.Bytes))
+	for n, b := range i.Bytes {
+		parts[n] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, " ")
+}
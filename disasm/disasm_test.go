@@ -0,0 +1,62 @@
+package disasm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"switchtrue.com/hankee/cpu"
+)
+
+func memoryWith(addr uint16, program []uint8) cpu.Memory {
+	ram := cpu.NewRAM()
+	for i, b := range program {
+		ram.Write(addr+uint16(i), b)
+	}
+	return ram
+}
+
+// Test that each addressing mode is rendered in assembler syntax
+func Test_Disassemble_AddressingModes(t *testing.T) {
+	cases := map[string][]uint8{
+		"LDA #$05":    {0xa9, 0x05},
+		"LDA $10":     {0xa5, 0x10},
+		"LDA $10,X":   {0xb5, 0x10},
+		"LDX $10,Y":   {0xb6, 0x10},
+		"LDA $1234":   {0xad, 0x34, 0x12},
+		"LDA $1234,X": {0xbd, 0x34, 0x12},
+		"LDA $1234,Y": {0xb9, 0x34, 0x12},
+		"JMP ($1234)": {0x6c, 0x34, 0x12},
+		"LDA ($10,X)": {0xa1, 0x10},
+		"LDA ($10),Y": {0xb1, 0x10},
+		"ROL A":       {0x2a},
+		"TAX":         {0xaa},
+		".db $FF":     {0xff},
+		"BNE $8004":   {0xd0, 0x02},
+		"BNE $7FFE":   {0xd0, 0xfc},
+	}
+
+	for expected, program := range cases {
+		instruction := Disassemble(memoryWith(0x8000, program), 0x8000)
+		assert.Equal(t, expected, instruction.String())
+	}
+}
+
+// Test that a range is decoded instruction by instruction
+func Test_Range(t *testing.T) {
+	// LDA #$05, STA $0200, BRK
+	mem := memoryWith(0x8000, []uint8{0xa9, 0x05, 0x8d, 0x00, 0x02, 0x00})
+	instructions := Range(mem, 0x8000, 0x8005)
+	assert.Equal(t, 3, len(instructions))
+	assert.Equal(t, uint16(0x8002), instructions[1].Address)
+	assert.Equal(t, "8D 00 02", instructions[1].Hex())
+}
+
+// Test that trace lines follow the nestest.log layout
+func Test_Trace(t *testing.T) {
+	c := cpu.NewCPU()
+	c.Load([]uint8{0x4c, 0xf5, 0xc5})
+	c.Reset()
+	assert.Equal(t,
+		"8000  4C F5 C5  JMP $C5F5                       A:00 X:00 Y:00 P:00 SP:FD CYC:7",
+		Trace(c))
+}
@@ -0,0 +1,28 @@
+package disasm
+
+import (
+	"fmt"
+
+	"switchtrue.com/hankee/cpu"
+)
+
+// Formats the CPU state and the instruction about to execute in the same
+// layout as the nestest.log reference trace, so traces can be diffed against
+// it or other emulators:
+//
+//	C000  4C F5 C5  JMP $C5F5                       A:00 X:00 Y:00 P:24 SP:FD CYC:7
+func Trace(c *cpu.CPU) string {
+	registers := c.Registers()
+	instruction := Disassemble(c.Memory(), registers.PC)
+	return fmt.Sprintf("%04X  %-8s  %-30s  A:%02X X:%02X Y:%02X P:%02X SP:%02X CYC:%d",
+		registers.PC,
+		instruction.Hex(),
+		instruction.String(),
+		registers.A,
+		registers.X,
+		registers.Y,
+		registers.Status,
+		registers.SP,
+		c.Cycles(),
+	)
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"switchtrue.com/hankee/cpu"
+	"switchtrue.com/hankee/easy6502"
+	"switchtrue.com/hankee/joypad"
+	"switchtrue.com/hankee/nes"
+	"switchtrue.com/hankee/ntsc"
+	"switchtrue.com/hankee/palette"
+	"switchtrue.com/hankee/terminal"
+	"switchtrue.com/hankee/video"
+)
+
+// displayOptions choose where a running program is shown.
+type displayOptions struct {
+	display string
+	fps     float64
+	columns int
+	speed   int
+	pad     int
+	filter  string
+	ntsc    ntsc.Options
+	palette string
+	colours *video.Palette
+}
+
+func (o *displayOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.display, "display", "none", "where to show the picture: none or term")
+	fs.Float64Var(&o.fps, "fps", 0, "frames per second to throttle the display to (0 for the console's frame rate, 60 for raw programs)")
+	fs.IntVar(&o.columns, "columns", 0, "terminal columns to draw in (0 to fit the terminal)")
+	fs.IntVar(&o.speed, "speed", 150, "instructions per frame for raw Easy 6502 style programs")
+	fs.IntVar(&o.pad, "pad", 1, "pad the keyboard plays as, 1 to 4")
+	fs.StringVar(&o.palette, "palette", "2c02", "colours to show: "+strings.Join(palette.NAMES, ", ")+" or a .pal file of 64 or 512 colours")
+	fs.StringVar(&o.filter, "filter", "none", "picture filter: none or ntsc for composite video artifacts")
+	fs.Float64Var(&o.ntsc.Hue, "ntsc-hue", 0, "NTSC filter hue, -1 to 1")
+	fs.Float64Var(&o.ntsc.Saturation, "ntsc-saturation", 0, "NTSC filter saturation, -1 for black and white to 1")
+	fs.Float64Var(&o.ntsc.Sharpness, "ntsc-sharpness", 0, "NTSC filter sharpness, -1 to 1")
+}
+
+// Registers only the options that matter for raw Easy 6502 programs, for
+// commands that never run anything else.
+func (o *displayOptions) registerEasy6502(fs *flag.FlagSet) {
+	fs.Float64Var(&o.fps, "fps", 0, "frames per second to throttle the display to (0 for 60)")
+	fs.IntVar(&o.columns, "columns", 0, "terminal columns to draw in (0 to fit the terminal)")
+	fs.IntVar(&o.speed, "speed", 150, "instructions per frame")
+}
+
+func (o *displayOptions) validate() error {
+	switch o.display {
+	case "none", "term":
+	default:
+		return fmt.Errorf("unknown display %q, expected none or term", o.display)
+	}
+	if o.pad < 1 || o.pad > 4 {
+		return fmt.Errorf("there's no pad %d, expected 1 to 4", o.pad)
+	}
+	switch o.filter {
+	case "none", "ntsc":
+		return nil
+	default:
+		return fmt.Errorf("unknown filter %q, expected none or ntsc", o.filter)
+	}
+}
+
+// Loads the palette, the composite one tuned by the NTSC filter's controls.
+func (o *displayOptions) loadPalette() error {
+	colours, err := palette.Load(o.palette, o.ntsc)
+	if err != nil {
+		return err
+	}
+	o.colours = colours
+	return nil
+}
+
+// Returns the terminal options for drawing at the given rate.
+func (o *displayOptions) terminal(fps float64) terminal.Options {
+	opts := terminal.Options{FPS: fps, Palette: o.colours, Columns: o.columns}
+	if o.filter == "ntsc" {
+		opts.Filter = ntsc.New(o.ntsc).Apply
+	}
+	return opts
+}
+
+// Runs a machine in the terminal until it halts, hits a limit or the user
+// quits, which counts as a requested stop.
+func (o *displayOptions) runNES(machine *nes.Machine, limits limitOptions, extras *hooks) (stopReason, error) {
+	console := newNESConsole(machine, machine.Joypads().Pad(o.pad), limits, extras)
+	fps := o.fps
+	if fps == 0 {
+		fps = machine.Timing().FrameRate()
+	}
+	var run terminal.Console = console
+	if mouse := mouseDevice(machine); mouse != nil {
+		run = &mouseConsole{nesConsole: console, mouse: mouse}
+	}
+	err := terminal.Run(run, o.terminal(fps))
+	switch {
+	case errors.Is(err, terminal.ErrInterrupted):
+		return stopRequested, nil
+	case err != nil:
+		return stopHalted, err
+	default:
+		return console.reason, console.err
+	}
+}
+
+// nesConsole drives a machine from the terminal, turning key presses into
+// one pad's buttons, or into typing when the Family BASIC keyboard is plugged
+// in.
+type nesConsole struct {
+	machine  *nes.Machine
+	pad      *joypad.Joypad
+	keyboard *joypad.Keyboard
+	limits   limitOptions
+	buttons  *terminal.Buttons
+	typing   *terminal.Typing
+	extras   *hooks
+	reason   stopReason
+	err      error
+}
+
+func newNESConsole(machine *nes.Machine, pad *joypad.Joypad, limits limitOptions, extras *hooks) *nesConsole {
+	keyboard, _ := machine.Joypads().Expansion().(*joypad.Keyboard)
+	return &nesConsole{
+		machine:  machine,
+		pad:      pad,
+		keyboard: keyboard,
+		limits:   limits,
+		buttons:  terminal.NewButtons(terminal.DefaultKeymap),
+		typing:   terminal.NewTyping(),
+		extras:   extras,
+	}
+}
+
+func (c *nesConsole) Key(key terminal.Key) {
+	if c.keyboard != nil {
+		c.typing.Press(key)
+		return
+	}
+	c.buttons.Press(key)
+}
+
+func (c *nesConsole) StepFrame() bool {
+	c.pad.SetButtons(c.buttons.Frame())
+	if c.keyboard != nil {
+		c.typing.Frame(c.keyboard)
+	}
+	if !c.extras.startFrame() {
+		c.reason = stopRequested
+		return false
+	}
+
+	// Run until the end of the frame, or earlier if a limit is reached.
+	cpu := c.machine.CPU()
+	frameEnd := c.machine.FrameEnd()
+	stopped := false
+	c.reason, c.err = c.limits.execute(cpu, func() bool {
+		if !c.extras.beforeInstruction() {
+			stopped = true
+			return false
+		}
+		return cpu.Cycles() < frameEnd
+	})
+	if cpu.Cycles() >= frameEnd {
+		c.machine.EndFrame()
+	}
+	return c.err == nil && c.reason == stopRequested && !stopped
+}
+
+func (c *nesConsole) Frame() *video.Frame {
+	return c.extras.draw(c.machine.Frame())
+}
+
+// Returns how the mouse drives the first device plugged in that wants it, or
+// nil if none does. The Zapper is aimed with the mouse and fired with the left
+// button, the Vaus' knob follows the mouse across the picture and the left
+// button fires.
+func mouseDevice(machine *nes.Machine) func(x int, y int, event terminal.Mouse) {
+	devices := []any{machine.Joypads().Device(1), machine.Joypads().Device(2), machine.Joypads().Expansion()}
+	for _, device := range devices {
+		var vaus *joypad.Arkanoid
+		switch device := device.(type) {
+		case *joypad.Zapper:
+			return func(x int, y int, event terminal.Mouse) {
+				device.Aim(x, y)
+				if event.Button == 0 {
+					device.SetTrigger(event.Pressed)
+				}
+			}
+		case *joypad.Arkanoid:
+			vaus = device
+		case *joypad.FamicomArkanoid:
+			vaus = device.Arkanoid
+		default:
+			continue
+		}
+		return func(x int, y int, event terminal.Mouse) {
+			if x >= 0 {
+				vaus.Turn(float64(x) / float64(machine.Frame().Width-1))
+			}
+			if event.Button == 0 {
+				vaus.SetFire(event.Pressed)
+			}
+		}
+	}
+	return nil
+}
+
+// mouseConsole is a nesConsole that passes the mouse on to a device.
+type mouseConsole struct {
+	*nesConsole
+	mouse func(x int, y int, event terminal.Mouse)
+}
+
+func (c *mouseConsole) Mouse(x int, y int, event terminal.Mouse) {
+	c.mouse(x, y, event)
+}
+
+// Runs a raw Easy 6502 style program in the terminal, as in chapter 3 of the
+// tutorial. The host is driven from the CPU's run callback, drawing the screen
+// memory and picking up keys every speed instructions, which is also what
+// counts as a frame for the hooks.
+func (o *displayOptions) runEasy6502(c *cpu.CPU, limits limitOptions, extras *hooks) (stopReason, error) {
+	screen, err := terminal.Open(o.terminal(o.fps))
+	if err != nil {
+		return stopHalted, err
+	}
+	defer screen.Close()
+
+	host := easy6502.NewHost(c, time.Now().UnixNano())
+	instructions := 0
+	var screenErr error
+	reason, err := limits.execute(c, func() bool {
+		if !extras.beforeInstruction() {
+			return false
+		}
+		host.Tick()
+		instructions++
+		if instructions%max(o.speed, 1) != 0 {
+			return true
+		}
+
+		keys, err := screen.Keys()
+		if err != nil {
+			screenErr = err
+			return false
+		}
+		for _, key := range keys {
+			if ascii, ok := easy6502Key(key); ok {
+				host.Key(ascii)
+			}
+		}
+		if !extras.startFrame() {
+			return false
+		}
+		if host.UpdateFrame() || len(extras.overlay) > 0 {
+			if err := screen.Draw(extras.draw(host.Frame())); err != nil {
+				screenErr = err
+				return false
+			}
+		}
+		screen.Wait()
+		return true
+	})
+
+	switch {
+	case errors.Is(screenErr, terminal.ErrInterrupted):
+		return stopRequested, nil
+	case screenErr != nil:
+		return stopHalted, screenErr
+	default:
+		return reason, err
+	}
+}
+
+// Easy 6502 programs want ASCII, arrow keys stand in for WASD.
+func easy6502Key(key terminal.Key) (uint8, bool) {
+	switch key {
+	case terminal.KeyUp:
+		return 'w', true
+	case terminal.KeyDown:
+		return 's', true
+	case terminal.KeyLeft:
+		return 'a', true
+	case terminal.KeyRight:
+		return 'd', true
+	}
+	if key < 0x80 {
+		return uint8(key), true
+	}
+	return 0, false
+}
@@ -0,0 +1,93 @@
+package easy6502
+
+import (
+	"math/rand"
+
+	"switchtrue.com/hankee/cpu"
+	"switchtrue.com/hankee/video"
+)
+
+// Programs written for Easy 6502 talk to the outside world through a few fixed
+// addresses:
+//
+//	$0200-$05FF  32x32 screen, one byte per pixel, left to right, top to bottom
+//	$FE          a new random number every time it is read
+//	$FF          ASCII code of the last key pressed
+const (
+	SCREEN        uint16 = 0x0200
+	SCREEN_END    uint16 = 0x05FF
+	SCREEN_WIDTH         = 32
+	SCREEN_HEIGHT        = 32
+	RANDOM        uint16 = 0x00FE
+	LAST_KEY      uint16 = 0x00FF
+)
+
+// Host plays the part of the Easy 6502 simulator around a CPU, feeding it
+// random numbers and key presses and turning the screen memory into a frame.
+// Call Tick from the CPU's run callback so it happens before every
+// instruction.
+type Host struct {
+	cpu   *cpu.CPU
+	rand  *rand.Rand
+	frame *video.Frame
+}
+
+func NewHost(c *cpu.CPU, seed int64) *Host {
+	return &Host{
+		cpu:   c,
+		rand:  rand.New(rand.NewSource(seed)),
+		frame: video.NewFrame(SCREEN_WIDTH, SCREEN_HEIGHT),
+	}
+}
+
+// Refreshes the random number at $FE. Easy 6502 generates one per read, doing
+// it before every instruction is close enough for the programs it runs.
+func (h *Host) Tick() {
+	h.cpu.MemWrite(RANDOM, uint8(h.rand.Intn(15)+1))
+}
+
+// Reports a key press to the program by writing its ASCII code to $FF.
+func (h *Host) Key(ascii uint8) {
+	h.cpu.MemWrite(LAST_KEY, ascii)
+}
+
+// Copies the screen memory into the frame, returning true if anything
+// changed since the last update so callers can skip redrawing.
+func (h *Host) UpdateFrame() bool {
+	changed := false
+	for i := range h.frame.Pix {
+		index := SCREEN_COLOURS[h.cpu.MemRead(SCREEN+uint16(i))&0x0F]
+		if h.frame.Pix[i] != index {
+			h.frame.Pix[i] = index
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Returns the screen as of the last UpdateFrame.
+func (h *Host) Frame() *video.Frame {
+	return h.frame
+}
+
+// Easy 6502's 16 colours, as the closest NES palette entries. The tutorial
+// folds 8-15 back onto the first set of colours and shows anything else as
+// cyan.
+var SCREEN_COLOURS = [16]uint16{
+	0x0F, // black
+	0x30, // white
+	0x00, // grey
+	0x16, // red
+	0x2A, // green
+	0x12, // blue
+	0x24, // magenta
+	0x28, // yellow
+	0x2C, // cyan
+	0x00, // grey
+	0x16, // red
+	0x2A, // green
+	0x12, // blue
+	0x24, // magenta
+	0x28, // yellow
+	0x2C, // cyan
+}
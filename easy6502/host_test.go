@@ -0,0 +1,56 @@
+package easy6502
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"switchtrue.com/hankee/cpu"
+)
+
+// Test that the random number at $FE is refreshed and never zero
+func Test_Host_Tick(t *testing.T) {
+	c := cpu.NewCPU()
+	host := NewHost(c, 1)
+	for i := 0; i < 100; i++ {
+		host.Tick()
+		value := c.MemRead(RANDOM)
+		assert.True(t, value >= 1 && value <= 15)
+	}
+}
+
+// Test that screen memory is mapped to frame pixels and changes are reported
+func Test_Host_UpdateFrame(t *testing.T) {
+	c := cpu.NewCPU()
+	host := NewHost(c, 1)
+	assert.True(t, host.UpdateFrame())
+	assert.False(t, host.UpdateFrame())
+
+	c.MemWrite(SCREEN+33, 1)
+	assert.True(t, host.UpdateFrame())
+	assert.Equal(t, uint16(0x30), host.Frame().At(1, 1))
+}
+
+// Test that the snake game draws the snake and moves it when steered. The
+// snake starts heading right so pressing S turns it down.
+func Test_Snake(t *testing.T) {
+	c := cpu.NewCPU()
+	c.LoadAt(SNAKE_LOAD_ADDRESS, SNAKE)
+	c.Reset()
+	host := NewHost(c, 1)
+
+	instructions := 0
+	c.RunWithCallback(func(c *cpu.CPU) bool {
+		host.Tick()
+		instructions++
+		if instructions == 5_000 {
+			host.Key('s')
+		}
+		return instructions < 20_000
+	})
+
+	// The head starts at $0411 and has moved down at least one row
+	head := uint16(c.MemRead(0x11))<<8 | uint16(c.MemRead(0x10))
+	assert.Greater(t, head, uint16(0x0431))
+	assert.Equal(t, uint8(0x01), c.MemRead(head))
+	assert.Equal(t, uint8(4), c.MemRead(0x02), "direction should be down")
+}
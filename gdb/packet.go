@@ -0,0 +1,121 @@
+package gdb
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Bytes with a special meaning in the remote serial protocol. Packets are sent
+// as $data#checksum and acknowledged with + or -, while a lone 0x03 asks a
+// running target to stop.
+const (
+	PACKET_START     = '$'
+	PACKET_END       = '#'
+	PACKET_ESCAPE    = '}'
+	PACKET_RUNLENGTH = '*'
+	ACK              = '+'
+	NAK              = '-'
+	INTERRUPT        = 0x03
+)
+
+// event is something received from the debugger, either a packet, a request to
+// resend the last packet or a packet that failed its checksum.
+type event struct {
+	packet []byte
+	nak    bool
+	bad    bool
+}
+
+// Reads events from the debugger until the connection fails. Interrupts are
+// reported straight away through interrupt rather than queued, since they need
+// to reach a target that is busy running.
+func readEvents(r io.Reader, events chan<- event, interrupt func()) error {
+	reader := bufio.NewReader(r)
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		switch b {
+		case INTERRUPT:
+			interrupt()
+		case NAK:
+			events <- event{nak: true}
+		case PACKET_START:
+			packet, ok, err := readPacket(reader)
+			if err != nil {
+				return err
+			}
+			events <- event{packet: packet, bad: !ok}
+		}
+	}
+}
+
+// Reads the body of a packet after the leading $, returning the unescaped data
+// and whether the checksum matched.
+func readPacket(reader *bufio.Reader) ([]byte, bool, error) {
+	raw, err := reader.ReadBytes(PACKET_END)
+	if err != nil {
+		return nil, false, err
+	}
+	raw = raw[:len(raw)-1]
+
+	var checksum [2]byte
+	if _, err := io.ReadFull(reader, checksum[:]); err != nil {
+		return nil, false, err
+	}
+
+	var want uint8
+	if _, err := fmt.Sscanf(string(checksum[:]), "%02x", &want); err != nil {
+		return nil, false, nil
+	}
+	return unescape(raw), sum(raw) == want, nil
+}
+
+// Frames data as a packet, escaping any bytes that would confuse the framing.
+func encodePacket(data []byte) []byte {
+	escaped := escape(data)
+	var buf bytes.Buffer
+	buf.WriteByte(PACKET_START)
+	buf.Write(escaped)
+	buf.WriteByte(PACKET_END)
+	fmt.Fprintf(&buf, "%02x", sum(escaped))
+	return buf.Bytes()
+}
+
+func sum(data []byte) uint8 {
+	var total uint8
+	for _, b := range data {
+		total += b
+	}
+	return total
+}
+
+func escape(data []byte) []byte {
+	escaped := make([]byte, 0, len(data))
+	for _, b := range data {
+		switch b {
+		case PACKET_START, PACKET_END, PACKET_ESCAPE, PACKET_RUNLENGTH:
+			escaped = append(escaped, PACKET_ESCAPE, b^0x20)
+		default:
+			escaped = append(escaped, b)
+		}
+	}
+	return escaped
+}
+
+func unescape(data []byte) []byte {
+	unescaped := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		if data[i] == PACKET_ESCAPE && i+1 < len(data) {
+			i++
+			unescaped = append(unescaped, data[i]^0x20)
+			continue
+		}
+		unescaped = append(unescaped, data[i])
+	}
+	return unescaped
+}
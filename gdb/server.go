@@ -0,0 +1,586 @@
+// Package gdb implements a GDB remote serial protocol stub for the 6502 core,
+// so GDB and other debuggers that speak the protocol can attach to a running
+// CPU over TCP or a Unix socket.
+package gdb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"switchtrue.com/hankee/cpu"
+)
+
+// Registers in the order they appear in g and G packets and the target
+// description. PC is two bytes little endian, everything else is a byte.
+const (
+	REGISTER_A = iota
+	REGISTER_X
+	REGISTER_Y
+	REGISTER_STATUS
+	REGISTER_SP
+	REGISTER_PC
+	REGISTER_COUNT
+)
+
+// Largest packet we accept, advertised to the debugger in qSupported.
+const PACKET_SIZE = 0x1000
+
+// Signals reported in stop replies.
+const (
+	SIGINT  = 2
+	SIGILL  = 4
+	SIGTRAP = 5
+)
+
+// BreakpointKind tells software and hardware breakpoints apart. Both are
+// checked against the PC before each instruction rather than patched into
+// memory, since most code runs from ROM, but GDB wants to know which one hit.
+type BreakpointKind int
+
+const (
+	SoftwareBreakpoint BreakpointKind = iota
+	HardwareBreakpoint
+)
+
+var errKilled = errors.New("killed by debugger")
+
+// Server exposes a CPU to a remote debugger. Only one debugger can be attached
+// at a time.
+type Server struct {
+	cpu         *cpu.CPU
+	memory      *watcher
+	breakpoints map[uint16]BreakpointKind
+	halted      bool
+}
+
+// Creates a server for the CPU. The CPU's memory is wrapped so watchpoints can
+// see accesses, and it shouldn't be run by anything else while served.
+func NewServer(c *cpu.CPU) *Server {
+	memory := &watcher{memory: c.Memory()}
+	c.SetMemory(memory)
+	return &Server{
+		cpu:         c,
+		memory:      memory,
+		breakpoints: map[uint16]BreakpointKind{},
+	}
+}
+
+// Accepts debuggers one after another until one kills the target or the
+// listener is closed.
+func (s *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		if err := s.ServeConn(conn); errors.Is(err, errKilled) {
+			return nil
+		}
+	}
+}
+
+// Talks to a single debugger until it detaches or the connection drops. The
+// connection is closed on return.
+func (s *Server) ServeConn(rw io.ReadWriteCloser) error {
+	c := &conn{
+		server: s,
+		rw:     rw,
+		events: make(chan event),
+	}
+	defer func() {
+		// Closing unblocks the reader, which then closes events.
+		rw.Close()
+		for range c.events {
+		}
+	}()
+
+	failed := make(chan error, 1)
+	go func() {
+		failed <- readEvents(rw, c.events, func() { c.interrupted.Store(true) })
+		close(c.events)
+	}()
+
+	for e := range c.events {
+		if err := c.handle(e); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+
+	if err := <-failed; !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// conn is the protocol state for one attached debugger.
+type conn struct {
+	server      *Server
+	rw          io.ReadWriter
+	events      chan event
+	interrupted atomic.Bool
+	noAck       bool
+	last        []byte
+	swBreak     bool
+	hwBreak     bool
+}
+
+// Acknowledges and answers one event. Returns io.EOF once the debugger has
+// detached.
+func (c *conn) handle(e event) error {
+	switch {
+	case e.nak:
+		if c.last != nil {
+			return c.write(c.last)
+		}
+		return nil
+	case e.bad:
+		if c.noAck {
+			return nil
+		}
+		_, err := c.rw.Write([]byte{NAK})
+		return err
+	}
+
+	if !c.noAck {
+		if _, err := c.rw.Write([]byte{ACK}); err != nil {
+			return err
+		}
+	}
+
+	packet := string(e.packet)
+	switch {
+	case packet == "k":
+		return errKilled
+	case packet == "D" || strings.HasPrefix(packet, "D;"):
+		if err := c.reply("OK"); err != nil {
+			return err
+		}
+		return io.EOF
+	case packet == "QStartNoAckMode":
+		if err := c.reply("OK"); err != nil {
+			return err
+		}
+		c.noAck = true
+		return nil
+	}
+
+	return c.reply(c.dispatch(packet))
+}
+
+func (c *conn) reply(data string) error {
+	c.last = encodePacket([]byte(data))
+	return c.write(c.last)
+}
+
+func (c *conn) write(packet []byte) error {
+	_, err := c.rw.Write(packet)
+	return err
+}
+
+// Works out the reply to a packet. An empty reply tells the debugger the
+// packet isn't supported.
+func (c *conn) dispatch(packet string) string {
+	s := c.server
+	if packet == "" {
+		return ""
+	}
+
+	switch packet[0] {
+	case '?':
+		if s.halted {
+			return "W00"
+		}
+		return stopSignal(SIGTRAP)
+	case 'g':
+		return s.readRegisters()
+	case 'G':
+		return s.writeRegisters(packet[1:])
+	case 'p':
+		return s.readRegister(packet[1:])
+	case 'P':
+		return s.writeRegister(packet[1:])
+	case 'm':
+		return s.readMemory(packet[1:])
+	case 'M':
+		return s.writeMemory(packet[1:], true)
+	case 'X':
+		return s.writeMemory(packet[1:], false)
+	case 'c', 's':
+		return c.resume(packet[0] == 's', packet[1:])
+	case 'Z':
+		return s.insertPoint(packet[1:])
+	case 'z':
+		return s.removePoint(packet[1:])
+	case 'H', 'T':
+		return "OK"
+	case 'q':
+		return c.query(packet[1:])
+	case 'v':
+		return c.verbose(packet[1:])
+	}
+	return ""
+}
+
+func (c *conn) query(query string) string {
+	switch {
+	case strings.HasPrefix(query, "Supported"):
+		features := strings.Split(strings.TrimPrefix(query, "Supported:"), ";")
+		for _, feature := range features {
+			c.swBreak = c.swBreak || feature == "swbreak+"
+			c.hwBreak = c.hwBreak || feature == "hwbreak+"
+		}
+		return fmt.Sprintf("PacketSize=%x;QStartNoAckMode+;swbreak+;hwbreak+;qXfer:features:read+;vContSupported+", PACKET_SIZE)
+	case query == "Attached":
+		return "1"
+	case query == "C":
+		return "QC1"
+	case query == "fThreadInfo":
+		return "m1"
+	case query == "sThreadInfo":
+		return "l"
+	case query == "Offsets":
+		return "Text=0;Data=0;Bss=0"
+	case query == "Symbol::":
+		return "OK"
+	case strings.HasPrefix(query, "Xfer:features:read:target.xml:"):
+		return readXfer(TARGET_XML, strings.TrimPrefix(query, "Xfer:features:read:target.xml:"))
+	}
+	return ""
+}
+
+// Handles the v packets, of which only vCont matters to a single threaded
+// target.
+func (c *conn) verbose(packet string) string {
+	switch {
+	case packet == "Cont?":
+		return "vCont;c;C;s;S"
+	case strings.HasPrefix(packet, "Cont;"):
+		// Only the first action matters, there's one thread and nothing else
+		// for the rest to apply to.
+		action := strings.Split(strings.TrimPrefix(packet, "Cont;"), ";")[0]
+		action, _, _ = strings.Cut(action, ":")
+		if action == "" {
+			return "E01"
+		}
+		switch action[0] {
+		case 'c', 'C':
+			return c.resume(false, "")
+		case 's', 'S':
+			return c.resume(true, "")
+		}
+		return "E01"
+	case packet == "MustReplyEmpty":
+		return ""
+	}
+	return ""
+}
+
+// Runs the CPU until something stops it, or for one instruction when
+// stepping, and returns the stop reply. An address continues from there
+// instead of the current PC.
+func (c *conn) resume(step bool, addr string) string {
+	s := c.server
+	if s.halted {
+		return "E01"
+	}
+	// A Ctrl-C sent while already stopped has nothing to interrupt.
+	c.interrupted.Store(false)
+	if addr != "" {
+		pc, err := strconv.ParseUint(addr, 16, 16)
+		if err != nil {
+			return "E01"
+		}
+		registers := s.cpu.Registers()
+		registers.PC = uint16(pc)
+		s.cpu.SetRegisters(registers)
+	}
+
+	reply, ok := s.run(step, &c.interrupted)
+	if !ok {
+		return stopSignal(SIGILL)
+	}
+	if reply == "" {
+		s.halted = true
+		return "W00"
+	}
+	if strings.HasPrefix(reply, "T05swbreak") && !c.swBreak || strings.HasPrefix(reply, "T05hwbreak") && !c.hwBreak {
+		return stopSignal(SIGTRAP)
+	}
+	return reply
+}
+
+// Runs until a breakpoint, watchpoint or interrupt and returns the stop reply,
+// or an empty reply when the program halted on BRK. Reports false if the CPU
+// hit an instruction it can't execute, leaving the PC pointing at it.
+func (s *Server) run(step bool, interrupted *atomic.Bool) (reply string, ok bool) {
+	pc := s.cpu.Registers().PC
+	defer func() {
+		s.memory.armed = false
+		s.memory.hit = nil
+		if r := recover(); r != nil {
+			registers := s.cpu.Registers()
+			registers.PC = pc
+			s.cpu.SetRegisters(registers)
+			reply, ok = "", false
+		}
+	}()
+
+	s.memory.armed = true
+	first := true
+	s.cpu.RunWithCallback(func(c *cpu.CPU) bool {
+		pc = c.Registers().PC
+		if hit := s.memory.hit; hit != nil {
+			reply = fmt.Sprintf("T%02x%s:%04x;", SIGTRAP, hit.kind, hit.addr)
+			return false
+		}
+		if first {
+			// Don't stop on the breakpoint we're already sitting on.
+			first = false
+			return true
+		}
+		if step {
+			reply = stopSignal(SIGTRAP)
+			return false
+		}
+		if kind, ok := s.breakpoints[pc]; ok {
+			reply = breakReply(kind)
+			return false
+		}
+		if interrupted.Swap(false) {
+			reply = stopSignal(SIGINT)
+			return false
+		}
+		return true
+	})
+	return reply, true
+}
+
+func stopSignal(signal int) string {
+	return fmt.Sprintf("S%02x", signal)
+}
+
+func breakReply(kind BreakpointKind) string {
+	if kind == HardwareBreakpoint {
+		return fmt.Sprintf("T%02xhwbreak:;", SIGTRAP)
+	}
+	return fmt.Sprintf("T%02xswbreak:;", SIGTRAP)
+}
+
+func (s *Server) readRegisters() string {
+	r := s.cpu.Registers()
+	return fmt.Sprintf("%02x%02x%02x%02x%02x%02x%02x", r.A, r.X, r.Y, r.Status, r.SP, uint8(r.PC), uint8(r.PC>>8))
+}
+
+func (s *Server) writeRegisters(data string) string {
+	values, err := decodeHex(data)
+	if err != nil || len(values) < REGISTER_COUNT+1 {
+		return "E01"
+	}
+	s.cpu.SetRegisters(cpu.Registers{
+		A:      values[0],
+		X:      values[1],
+		Y:      values[2],
+		Status: values[3],
+		SP:     values[4],
+		PC:     uint16(values[5]) | uint16(values[6])<<8,
+	})
+	return "OK"
+}
+
+func (s *Server) readRegister(data string) string {
+	n, err := strconv.ParseUint(data, 16, 8)
+	if err != nil || n >= REGISTER_COUNT {
+		return "E01"
+	}
+	all := s.readRegisters()
+	if n == REGISTER_PC {
+		return all[n*2:]
+	}
+	return all[n*2 : n*2+2]
+}
+
+func (s *Server) writeRegister(data string) string {
+	number, value, found := strings.Cut(data, "=")
+	n, err := strconv.ParseUint(number, 16, 8)
+	if !found || err != nil || n >= REGISTER_COUNT {
+		return "E01"
+	}
+	bytes, err := decodeHex(value)
+	if err != nil || len(bytes) == 0 {
+		return "E01"
+	}
+
+	r := s.cpu.Registers()
+	switch n {
+	case REGISTER_A:
+		r.A = bytes[0]
+	case REGISTER_X:
+		r.X = bytes[0]
+	case REGISTER_Y:
+		r.Y = bytes[0]
+	case REGISTER_STATUS:
+		r.Status = bytes[0]
+	case REGISTER_SP:
+		r.SP = bytes[0]
+	case REGISTER_PC:
+		if len(bytes) < 2 {
+			return "E01"
+		}
+		r.PC = uint16(bytes[0]) | uint16(bytes[1])<<8
+	}
+	s.cpu.SetRegisters(r)
+	return "OK"
+}
+
+func (s *Server) readMemory(data string) string {
+	addr, length, err := parseRange(data)
+	if err != nil || length > PACKET_SIZE/2 {
+		return "E01"
+	}
+
+	var reply strings.Builder
+	for i := 0; i < length && addr+i <= 0xFFFF; i++ {
+		fmt.Fprintf(&reply, "%02x", s.cpu.MemRead(uint16(addr+i)))
+	}
+	return reply.String()
+}
+
+// Writes memory from an M packet with hex data or an X packet with binary.
+func (s *Server) writeMemory(data string, hex bool) string {
+	header, payload, found := strings.Cut(data, ":")
+	addr, length, err := parseRange(header)
+	if !found || err != nil {
+		return "E01"
+	}
+
+	values := []byte(payload)
+	if hex {
+		values, err = decodeHex(payload)
+		if err != nil {
+			return "E01"
+		}
+	}
+	if len(values) != length || addr+length > 0x10000 {
+		return "E01"
+	}
+	for i, value := range values {
+		s.cpu.MemWrite(uint16(addr+i), value)
+	}
+	return "OK"
+}
+
+// Handles Z packets, type 0 and 1 are breakpoints and 2 to 4 are write, read
+// and access watchpoints.
+func (s *Server) insertPoint(data string) string {
+	kind, addr, length, err := parsePoint(data)
+	if err != nil {
+		return "E01"
+	}
+
+	switch kind {
+	case 0, 1:
+		s.breakpoints[uint16(addr)] = BreakpointKind(kind)
+	case 2, 3, 4:
+		s.memory.add(watchpoint{uint16(addr), uint16(addr + length - 1), WatchKind(kind - 2)})
+	default:
+		return ""
+	}
+	return "OK"
+}
+
+func (s *Server) removePoint(data string) string {
+	kind, addr, length, err := parsePoint(data)
+	if err != nil {
+		return "E01"
+	}
+
+	switch kind {
+	case 0, 1:
+		delete(s.breakpoints, uint16(addr))
+	case 2, 3, 4:
+		s.memory.remove(watchpoint{uint16(addr), uint16(addr + length - 1), WatchKind(kind - 2)})
+	default:
+		return ""
+	}
+	return "OK"
+}
+
+// Parses type,addr,kind from a Z or z packet. For watchpoints the kind is the
+// number of bytes watched.
+func parsePoint(data string) (kind int, addr int, length int, err error) {
+	fields := strings.Split(data, ",")
+	if len(fields) < 3 {
+		return 0, 0, 0, fmt.Errorf("malformed breakpoint %q", data)
+	}
+	kind, err = strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	addr, length, err = parseRange(fields[1] + "," + fields[2])
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if length == 0 {
+		length = 1
+	}
+	if addr+length > 0x10000 {
+		return 0, 0, 0, fmt.Errorf("range $%x,%x is outside the address space", addr, length)
+	}
+	return kind, addr, length, nil
+}
+
+// Parses addr,length in hex.
+func parseRange(data string) (int, int, error) {
+	a, l, found := strings.Cut(data, ",")
+	if !found {
+		return 0, 0, fmt.Errorf("malformed range %q", data)
+	}
+	addr, err := strconv.ParseUint(a, 16, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	length, err := strconv.ParseUint(l, 16, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(addr), int(length), nil
+}
+
+func decodeHex(data string) ([]byte, error) {
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("odd length hex %q", data)
+	}
+	values := make([]byte, len(data)/2)
+	for i := range values {
+		value, err := strconv.ParseUint(data[i*2:i*2+2], 16, 8)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = uint8(value)
+	}
+	return values, nil
+}
+
+// Answers a qXfer read of offset,length with the matching part of document.
+func readXfer(document string, data string) string {
+	offset, length, err := parseRange(data)
+	if err != nil {
+		return "E01"
+	}
+	if offset >= len(document) {
+		return "l"
+	}
+	end := offset + length
+	if end >= len(document) {
+		return "l" + document[offset:]
+	}
+	return "m" + document[offset:end]
+}
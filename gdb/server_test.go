@@ -0,0 +1,200 @@
+package gdb
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"switchtrue.com/hankee/cpu"
+)
+
+// client is a scripted debugger talking to a server over an in memory pipe.
+type client struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+	done   chan error
+	noAck  bool
+}
+
+func newClient(t *testing.T, program []uint8) (*client, *cpu.CPU) {
+	c := cpu.NewCPU()
+	c.LoadAt(0x0600, program)
+	c.Reset()
+
+	server, conn := net.Pipe()
+	cl := &client{t: t, conn: conn, reader: bufio.NewReader(conn), done: make(chan error, 1)}
+	s := NewServer(c)
+	go func() { cl.done <- s.ServeConn(server) }()
+	t.Cleanup(func() { conn.Close() })
+	return cl, c
+}
+
+// Sends a packet and returns the reply, checking both sides acknowledge.
+func (cl *client) send(packet string) string {
+	cl.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err := cl.conn.Write(encodePacket([]byte(packet)))
+	require.NoError(cl.t, err)
+
+	ack, err := cl.reader.ReadByte()
+	require.NoError(cl.t, err)
+	require.Equal(cl.t, byte(ACK), ack, "ack for %q", packet)
+	return cl.receive()
+}
+
+func (cl *client) receive() string {
+	start, err := cl.reader.ReadByte()
+	require.NoError(cl.t, err)
+	require.Equal(cl.t, byte(PACKET_START), start)
+	data, ok, err := readPacket(cl.reader)
+	require.NoError(cl.t, err)
+	require.True(cl.t, ok, "checksum of reply %q", data)
+	if !cl.noAck {
+		_, err = cl.conn.Write([]byte{ACK})
+		require.NoError(cl.t, err)
+	}
+	return string(data)
+}
+
+// LDA #$05; STA $10; INX; JMP $0603
+var loop = []uint8{0xa9, 0x05, 0x85, 0x10, 0xe8, 0x4c, 0x04, 0x06}
+
+// Test that registers are read in A X Y P SP PC order and can be written
+func Test_Server_Registers(t *testing.T) {
+	cl, c := newClient(t, loop)
+	assert.Equal(t, "S05", cl.send("?"))
+	assert.Equal(t, "000000"+"00"+"fd"+"0006", cl.send("g"))
+
+	assert.Equal(t, "OK", cl.send("P0=42"))
+	assert.Equal(t, "OK", cl.send("P5=0306"))
+	assert.Equal(t, "42", cl.send("p0"))
+	assert.Equal(t, "0306", cl.send("p5"))
+	assert.Equal(t, uint8(0x42), c.Registers().A)
+	assert.Equal(t, uint16(0x0603), c.Registers().PC)
+
+	assert.Equal(t, "OK", cl.send("G0102038024ff80"))
+	assert.Equal(t, cpu.Registers{A: 1, X: 2, Y: 3, Status: 0x80, SP: 0x24, PC: 0x80ff}, c.Registers())
+}
+
+// Test reading and writing memory with hex and binary packets
+func Test_Server_Memory(t *testing.T) {
+	cl, c := newClient(t, loop)
+	assert.Equal(t, "a9058510", cl.send("m600,4"))
+	assert.Equal(t, "OK", cl.send("M10,2:beef"))
+	assert.Equal(t, uint8(0xbe), c.MemRead(0x10))
+	assert.Equal(t, uint8(0xef), c.MemRead(0x11))
+
+	// The escaped # is a single byte once decoded.
+	assert.Equal(t, "OK", cl.send("X20,2:#A"))
+	assert.Equal(t, "2341", cl.send("m20,2"))
+	assert.Equal(t, "E01", cl.send("mzz,2"))
+}
+
+// Test that stepping runs one instruction at a time
+func Test_Server_Step(t *testing.T) {
+	cl, c := newClient(t, loop)
+	assert.Equal(t, "S05", cl.send("s"))
+	assert.Equal(t, uint16(0x0602), c.Registers().PC)
+	assert.Equal(t, "S05", cl.send("vCont;s:1"))
+	assert.Equal(t, uint16(0x0604), c.Registers().PC)
+	assert.Equal(t, uint8(0x05), c.MemRead(0x10))
+}
+
+// Test that continue stops at software and hardware breakpoints
+func Test_Server_Breakpoints(t *testing.T) {
+	cl, c := newClient(t, loop)
+	cl.send("qSupported:multiprocess+;swbreak+;hwbreak+")
+
+	assert.Equal(t, "OK", cl.send("Z0,604,1"))
+	assert.Equal(t, "T05swbreak:;", cl.send("c"))
+	assert.Equal(t, uint16(0x0604), c.Registers().PC)
+
+	// Continuing from a breakpoint goes round the loop and hits it again.
+	assert.Equal(t, "T05swbreak:;", cl.send("c"))
+	assert.Equal(t, uint8(1), c.Registers().X)
+
+	assert.Equal(t, "OK", cl.send("z0,604,1"))
+	assert.Equal(t, "OK", cl.send("Z1,605,1"))
+	assert.Equal(t, "T05hwbreak:;", cl.send("c"))
+	assert.Equal(t, uint16(0x0605), c.Registers().PC)
+}
+
+// Test that watchpoints stop after the instruction that touched memory
+func Test_Server_Watchpoints(t *testing.T) {
+	cl, c := newClient(t, loop)
+	assert.Equal(t, "OK", cl.send("Z2,10,1"))
+	assert.Equal(t, "T05watch:0010;", cl.send("c"))
+	assert.Equal(t, uint16(0x0604), c.Registers().PC)
+
+	// The debugger reading memory doesn't trigger a read watchpoint.
+	assert.Equal(t, "OK", cl.send("Z3,10,1"))
+	assert.Equal(t, "05", cl.send("m10,1"))
+	assert.Equal(t, "OK", cl.send("z2,10,1"))
+	assert.Equal(t, "OK", cl.send("z3,10,1"))
+}
+
+// Test that an interrupt stops a program that would otherwise run forever
+func Test_Server_Interrupt(t *testing.T) {
+	cl, _ := newClient(t, loop)
+	_, err := cl.conn.Write(encodePacket([]byte("c")))
+	require.NoError(t, err)
+	ack, err := cl.reader.ReadByte()
+	require.NoError(t, err)
+	assert.Equal(t, byte(ACK), ack)
+
+	time.Sleep(10 * time.Millisecond)
+	_, err = cl.conn.Write([]byte{INTERRUPT})
+	require.NoError(t, err)
+	assert.Equal(t, "S02", cl.receive())
+}
+
+// Test that an interrupt sent while stopped doesn't stop the next continue
+func Test_Server_InterruptWhileStopped(t *testing.T) {
+	cl, c := newClient(t, loop)
+	assert.Equal(t, "OK", cl.send("Z0,604,1"))
+	_, err := cl.conn.Write([]byte{INTERRUPT})
+	require.NoError(t, err)
+	assert.Equal(t, "S05", cl.send("?"))
+	assert.Equal(t, "S05", cl.send("c"))
+	assert.Equal(t, uint16(0x0604), c.Registers().PC)
+}
+
+// Test that BRK is reported as the program exiting
+func Test_Server_Exit(t *testing.T) {
+	cl, _ := newClient(t, []uint8{0xe8, 0x00})
+	assert.Equal(t, "W00", cl.send("c"))
+	assert.Equal(t, "W00", cl.send("?"))
+	assert.Equal(t, "E01", cl.send("s"))
+}
+
+// Test the target description and no ack mode
+func Test_Server_Protocol(t *testing.T) {
+	cl, _ := newClient(t, loop)
+	reply := cl.send("qXfer:features:read:target.xml:0,20")
+	assert.Equal(t, "m"+TARGET_XML[:0x20], reply)
+	assert.Equal(t, "l", cl.send(fmt.Sprintf("qXfer:features:read:target.xml:%x,20", len(TARGET_XML))))
+	assert.Equal(t, "", cl.send("qUnknownThing"))
+
+	// A corrupt packet is refused.
+	_, err := cl.conn.Write([]byte("$g#00"))
+	require.NoError(t, err)
+	nak, err := cl.reader.ReadByte()
+	require.NoError(t, err)
+	assert.Equal(t, byte(NAK), nak)
+
+	// Without acks the reply comes straight back.
+	assert.Equal(t, "OK", cl.send("QStartNoAckMode"))
+	cl.noAck = true
+	_, err = cl.conn.Write(encodePacket([]byte("m600,1")))
+	require.NoError(t, err)
+	assert.Equal(t, "a9", cl.receive())
+
+	_, err = cl.conn.Write(encodePacket([]byte("D")))
+	require.NoError(t, err)
+	assert.Equal(t, "OK", cl.receive())
+	assert.NoError(t, <-cl.done)
+}
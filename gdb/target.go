@@ -0,0 +1,27 @@
+package gdb
+
+// Target description sent to debuggers that ask for it, so they know the
+// register names and sizes without a built in 6502 architecture. The status
+// fields follow the flags in the cpu package.
+const TARGET_XML = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <feature name="com.switchtrue.hankee.6502">
+    <flags id="status_flags" size="1">
+      <field name="C" start="0" end="0"/>
+      <field name="Z" start="1" end="1"/>
+      <field name="I" start="2" end="2"/>
+      <field name="D" start="3" end="3"/>
+      <field name="B" start="4" end="4"/>
+      <field name="V" start="6" end="6"/>
+      <field name="N" start="7" end="7"/>
+    </flags>
+    <reg name="a" bitsize="8" regnum="0" type="uint8"/>
+    <reg name="x" bitsize="8" regnum="1" type="uint8"/>
+    <reg name="y" bitsize="8" regnum="2" type="uint8"/>
+    <reg name="p" bitsize="8" regnum="3" type="status_flags"/>
+    <reg name="sp" bitsize="8" regnum="4" type="uint8"/>
+    <reg name="pc" bitsize="16" regnum="5" type="code_ptr"/>
+  </feature>
+</target>
+`
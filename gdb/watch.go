@@ -0,0 +1,96 @@
+package gdb
+
+import (
+	"fmt"
+
+	"switchtrue.com/hankee/cpu"
+)
+
+// WatchKind is the kind of access a watchpoint triggers on.
+type WatchKind int
+
+const (
+	WatchWrite WatchKind = iota
+	WatchRead
+	WatchAccess
+)
+
+// Returns the name GDB uses for the watchpoint in stop replies.
+func (k WatchKind) String() string {
+	switch k {
+	case WatchWrite:
+		return "watch"
+	case WatchRead:
+		return "rwatch"
+	case WatchAccess:
+		return "awatch"
+	default:
+		return fmt.Sprintf("WatchKind(%d)", int(k))
+	}
+}
+
+type watchpoint struct {
+	start uint16
+	end   uint16
+	kind  WatchKind
+}
+
+type watchHit struct {
+	addr uint16
+	kind WatchKind
+}
+
+// watcher sits between the CPU and its memory and notices accesses to watched
+// addresses. It is only armed while the target runs so the debugger's own
+// memory reads don't trigger anything.
+type watcher struct {
+	memory      cpu.Memory
+	watchpoints []watchpoint
+	armed       bool
+	hit         *watchHit
+}
+
+func (w *watcher) Read(addr uint16) uint8 {
+	if w.armed {
+		w.check(addr, WatchRead)
+	}
+	return w.memory.Read(addr)
+}
+
+func (w *watcher) Write(addr uint16, data uint8) {
+	if w.armed {
+		w.check(addr, WatchWrite)
+	}
+	w.memory.Write(addr, data)
+}
+
+// Records the first access to hit a watchpoint. The CPU finishes the
+// instruction and the server stops before the next one, like GDB expects.
+func (w *watcher) check(addr uint16, access WatchKind) {
+	if w.hit != nil {
+		return
+	}
+	for _, wp := range w.watchpoints {
+		if addr < wp.start || addr > wp.end {
+			continue
+		}
+		if wp.kind == access || wp.kind == WatchAccess {
+			w.hit = &watchHit{addr: addr, kind: wp.kind}
+			return
+		}
+	}
+}
+
+func (w *watcher) add(wp watchpoint) {
+	w.watchpoints = append(w.watchpoints, wp)
+}
+
+func (w *watcher) remove(wp watchpoint) bool {
+	for i, existing := range w.watchpoints {
+		if existing == wp {
+			w.watchpoints = append(w.watchpoints[:i], w.watchpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
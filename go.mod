@@ -2,10 +2,16 @@ module switchtrue.com/hankee
 
 go 1.23.0
 
-require github.com/stretchr/testify v1.9.0
+require (
+	github.com/google/pprof v0.0.0-20250403155104-27863c87afa6
+	github.com/stretchr/testify v1.9.0
+	github.com/yuin/gopher-lua v1.1.1
+	golang.org/x/term v0.30.0
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/sys v0.31.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -1,9 +1,81 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Exit codes returned by every subcommand, so scripts and CI can tell a
+// program that ran to completion from one that failed or ran out of time.
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+	exitLimit   = 3
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"run", "run a ROM or raw binary until it halts", runCommand},
+		{"debug", "step through a program interactively", debugCommand},
+		{"gdb", "serve a program to GDB over the remote serial protocol", gdbCommand},
+		{"dap", "debug a program from an editor over the Debug Adapter Protocol", dapCommand},
+		{"serve", "control the emulator over a local HTTP JSON API", serveCommand},
+		{"netplay", "play a ROM with someone else over the network", netplayCommand},
+		{"disasm", "disassemble a ROM or raw binary", disasmCommand},
+		{"trace", "run a program printing a nestest style trace", traceCommand},
+		{"profile", "profile a program for go tool pprof", profileCommand},
+		{"nsf", "render a track from an NSF or NSFe music file to WAV", nsfCommand},
+		{"palette", "write a palette to a .pal file", paletteCommand},
+		{"info", "show details about a ROM", infoCommand},
+		{"test", "run a test ROM and report the result", testCommand},
+		{"snake", "play the tutorial's snake game in the terminal", snakeCommand},
+	}
+}
 
 func main() {
-	cpu := NewCPU()
-	cpu.loadAndRun([]uint8{1})
-	fmt.Println("Hello, World!")
+	os.Exit(dispatch(os.Args[1:]))
+}
+
+func dispatch(args []string) int {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return exitUsage
+	}
+
+	name := args[0]
+	if name == "help" || name == "-h" || name == "--help" {
+		usage(os.Stdout)
+		return exitOK
+	}
+
+	for _, c := range commands {
+		if c.name == name {
+			return c.run(args[1:])
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "hankee: unknown command %q\n\n", name)
+	usage(os.Stderr)
+	return exitUsage
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: hankee <command> [options] <file>")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'hankee <command> -h' for the options of a command.")
 }
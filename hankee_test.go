@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"switchtrue.com/hankee/cartridge"
+	"switchtrue.com/hankee/cpu"
+	"switchtrue.com/hankee/joypad"
+)
+
+// Test that addresses can be given in any of the accepted notations
+func Test_ParseAddress(t *testing.T) {
+	for _, s := range []string{"$C000", "0xC000", "49152"} {
+		addr, err := parseAddress(s)
+		assert.NoError(t, err)
+		assert.Equal(t, uint16(0xC000), addr)
+	}
+
+	_, err := parseAddress("$10000")
+	assert.Error(t, err)
+}
+
+// Test that a raw binary is loaded at the requested address and starts there
+func Test_OpenRaw_LoadAndEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "program.bin")
+	os.WriteFile(path, []uint8{0xa9, 0x01, 0xa9, 0x02, 0x00}, 0o644)
+	o := loadOptions{load: address{0x0600, true}, entry: address{0x0602, true}, variant: "2a03"}
+	s, err := o.open(path)
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(0xa9), s.cpu.MemRead(0x0600))
+	assert.Equal(t, uint16(0x0602), s.cpu.Registers().PC)
+}
+
+// Test that execution stops once the instruction limit is reached
+func Test_Execute_InstructionLimit(t *testing.T) {
+	c := cpu.NewCPU()
+	// JMP $8000
+	c.Load([]uint8{0x4c, 0x00, 0x80})
+	c.Reset()
+	limits := limitOptions{maxInstructions: 10}
+	reason, err := limits.execute(c, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, stopLimit, reason)
+}
+
+// Test that unknown opcodes are reported as errors rather than panics
+func Test_Execute_UnknownOpcode(t *testing.T) {
+	c := cpu.NewCPU()
+	c.Load([]uint8{0x02})
+	c.Reset()
+	var limits limitOptions
+	_, err := limits.execute(c, nil)
+	assert.ErrorContains(t, err, "at $8000")
+}
+
+// Test that the debugger stops at a breakpoint when continuing
+func Test_Debugger_Breakpoint(t *testing.T) {
+	o := loadOptions{load: address{0x8000, true}}
+	// LDA 5, TAX, INX, BRK
+	s := o.openRaw([]uint8{0xa9, 0x05, 0xaa, 0xe8, 0x00})
+	var out bytes.Buffer
+	d := newDebugger(s, limitOptions{}, &out)
+	d.repl(strings.NewReader("break $8003\ncontinue\nquit\n"))
+	assert.Contains(t, out.String(), "breakpoint at $8003")
+	assert.Equal(t, uint8(0x05), s.cpu.Registers().X)
+}
+
+// Test finding a counter with a RAM search and freezing it
+func Test_Debugger_RAMSearch(t *testing.T) {
+	o := loadOptions{load: address{0x8000, true}}
+	// LDA #3, STA $10, loop: DEC $10, JMP loop
+	s := o.openRaw([]uint8{0xa9, 0x03, 0x85, 0x10, 0xc6, 0x10, 0x4c, 0x04, 0x80})
+	var out bytes.Buffer
+	d := newDebugger(s, limitOptions{}, &out)
+	d.repl(strings.NewReader(strings.Join([]string{
+		"break $8004", "continue", "search new", "search eq 3",
+		"break $8006", "continue", "search by -1",
+		"freeze $10 9", "continue", "quit",
+	}, "\n")))
+
+	assert.Contains(t, out.String(), "candidates: 2048")
+	assert.Contains(t, out.String(), "candidates: 1\n$0010 ram  2 (was 2)")
+	assert.Equal(t, uint8(9), s.cpu.MemRead(0x10))
+	assert.Equal(t, uint16(0x8004), s.cpu.Registers().PC)
+}
+
+// Test adding and switching off cheats in the debugger
+func Test_Debugger_Cheats(t *testing.T) {
+	o := loadOptions{load: address{0x8000, true}}
+	// LDA $9000, STA $10, BRK
+	s := o.openRaw([]uint8{0xad, 0x00, 0x90, 0x85, 0x10, 0x00})
+	var out bytes.Buffer
+	d := newDebugger(s, limitOptions{}, &out)
+	d.repl(strings.NewReader(strings.Join([]string{
+		"cheat add S9000:42 The answer", "cheat add 0020:07", "cheat off 1",
+		"cheat", "continue", "quit",
+	}, "\n")))
+
+	assert.Contains(t, out.String(), " 0 on  ZGAPAA       The answer\n 1 off 0020:07\n")
+	assert.Equal(t, uint8(0x42), s.cpu.MemRead(0x10))
+	assert.Equal(t, uint8(0), s.cpu.MemRead(0x20))
+	assert.Equal(t, uint8(0), d.cheats.Memory().Read(0x9000), "the program itself isn't changed")
+}
+
+// Test that running without a display still ends the machine's frames, so a
+// Zapper sees the beam pass over the picture
+func Test_Headless_Frames(t *testing.T) {
+	// LDA #1, STA $2001 for a grey backdrop, loop: JMP loop
+	program := []uint8{0xa9, 0x01, 0x8d, 0x01, 0x20, 0x4c, 0x05, 0x80}
+	raw := []uint8{'N', 'E', 'S', 0x1A, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	prg := make([]uint8, cartridge.PRG_ROM_PAGE_SIZE)
+	copy(prg, program)
+	prg[0x3FFD] = 0x80
+	raw = append(append(raw, prg...), make([]uint8, cartridge.CHR_ROM_PAGE_SIZE)...)
+	path := filepath.Join(t.TempDir(), "grey.nes")
+	require.NoError(t, os.WriteFile(path, raw, 0o644))
+	o := loadOptions{region: "ntsc", variant: "2a03", ports: [2]string{"joypad", "zapper"}, expansion: "none"}
+	s, err := o.open(path)
+	require.NoError(t, err)
+	zapper := s.machine.Joypads().Device(2).(*joypad.Zapper)
+	zapper.Aim(128, 120)
+
+	frames := 0
+	seen := map[bool]bool{}
+	extras := hooks{
+		frame:  []func() bool{func() bool { frames++; return true }},
+		before: []func() bool{func() bool { seen[zapper.Light()] = true; return true }},
+	}
+	limits := limitOptions{maxInstructions: 30000}
+	_, err = limits.execute(s.cpu, extras.headless(s))
+	require.NoError(t, err)
+	assert.Equal(t, 4, frames)
+	assert.Greater(t, s.machine.FrameEnd(), s.cpu.Cycles())
+	assert.True(t, seen[true] && seen[false], "the light comes and goes as the beam passes")
+}
+
+// Test that the API only listens on localhost
+func Test_CheckLocal(t *testing.T) {
+	assert.NoError(t, checkLocal("localhost:8502"))
+	assert.NoError(t, checkLocal("127.0.0.1:8502"))
+	assert.NoError(t, checkLocal("[::1]:8502"))
+	assert.NoError(t, checkLocal("unix:/tmp/hankee.sock"))
+	assert.Error(t, checkLocal("0.0.0.0:8502"))
+	assert.Error(t, checkLocal(":8502"))
+	assert.Error(t, checkLocal("192.168.1.10:8502"))
+}
+
+// Test that asking a command for help succeeds, while a mistake is a usage
+// error
+func Test_ParseFileArgs(t *testing.T) {
+	for _, test := range []struct {
+		args []string
+		path string
+		code int
+	}{
+		{[]string{"-h"}, "", exitOK},
+		{[]string{"game.nes", "--help"}, "", exitOK},
+		{[]string{}, "", exitUsage},
+		{[]string{"-bogus", "game.nes"}, "", exitUsage},
+		{[]string{"game.nes", "-v"}, "game.nes", exitOK},
+	} {
+		fs := newFlagSet("test", "[options] <file>")
+		fs.Bool("v", false, "")
+		fs.SetOutput(io.Discard)
+		path, code, ok := parseFileArgs(fs, test.args)
+		assert.Equal(t, test.path, path, "%v", test.args)
+		assert.Equal(t, test.code, code, "%v", test.args)
+		assert.Equal(t, test.path != "", ok, "%v", test.args)
+	}
+}
+
+// Test that commands with an optional file take flags on either side of it
+func Test_ParseOptionalFileArgs(t *testing.T) {
+	for _, test := range []struct {
+		args []string
+		path string
+		code int
+		ok   bool
+	}{
+		{[]string{"game.nes", "--paused"}, "game.nes", exitOK, true},
+		{[]string{"--paused"}, "", exitOK, true},
+		{[]string{}, "", exitOK, true},
+		{[]string{"one.nes", "--paused", "two.nes"}, "", exitUsage, false},
+		{[]string{"-h"}, "", exitOK, false},
+	} {
+		fs := newFlagSet("test", "[options] [file]")
+		paused := fs.Bool("paused", false, "")
+		fs.SetOutput(io.Discard)
+		path, code, ok := parseOptionalFileArgs(fs, test.args)
+		assert.Equal(t, test.path, path, "%v", test.args)
+		assert.Equal(t, test.code, code, "%v", test.args)
+		assert.Equal(t, test.ok, ok, "%v", test.args)
+		if ok {
+			assert.Equal(t, len(test.args) > 0, *paused, "%v", test.args)
+		}
+	}
+}
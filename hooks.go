@@ -0,0 +1,72 @@
+package main
+
+import (
+	"switchtrue.com/hankee/nes"
+	"switchtrue.com/hankee/video"
+)
+
+// hooks are extras run alongside a program by whichever runner is showing it:
+// frame hooks at the start of every frame, before hooks ahead of every
+// instruction and overlays drawn over the picture. Frame and before hooks stop
+// the program by returning false.
+type hooks struct {
+	frame   []func() bool
+	before  []func() bool
+	overlay []func(f *video.Frame) *video.Frame
+}
+
+func (h *hooks) startFrame() bool {
+	for _, hook := range h.frame {
+		if !hook() {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *hooks) beforeInstruction() bool {
+	for _, hook := range h.before {
+		if !hook() {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the frame with every overlay drawn over it.
+func (h *hooks) draw(f *video.Frame) *video.Frame {
+	for _, overlay := range h.overlay {
+		f = overlay(f)
+	}
+	return f
+}
+
+// Returns a run callback for running without a display, where frames are as
+// long as the timing says. A machine's frames are ended as they go by, as they
+// would be with a display, so its beam, picture and APU keep up. Returns nil
+// if there's nothing to run.
+func (h *hooks) headless(s *session) func() bool {
+	c := s.cpu
+	if s.machine == nil && len(h.frame) == 0 && len(h.before) == 0 {
+		return nil
+	}
+	// The first frame starts straight away.
+	clock := nes.NewFrameClock(s.timing(), c.Cycles())
+	end, next := clock.End, clock.Next
+	if s.machine != nil {
+		end, next = s.machine.FrameEnd, s.machine.EndFrame
+	}
+	started := false
+	return func() bool {
+		if !started || c.Cycles() >= end() {
+			if started {
+				next()
+			}
+			started = true
+			if !h.startFrame() {
+				return false
+			}
+		}
+		return h.beforeInstruction()
+	}
+}
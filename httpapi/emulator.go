@@ -0,0 +1,271 @@
+// Package httpapi serves a JSON API for driving the emulator from other
+// processes, such as test harnesses, while it runs.
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"switchtrue.com/hankee/capture"
+	"switchtrue.com/hankee/nes"
+	"switchtrue.com/hankee/video"
+)
+
+// The loop runs at the machine's frame rate, this often when there's no
+// machine.
+const IDLE_PERIOD = time.Second / 60
+
+var (
+	ErrNoROM     = errors.New("no ROM loaded")
+	ErrHalted    = errors.New("the program has halted, reset to start again")
+	ErrCapturing = errors.New("already capturing, stop the capture first")
+	ErrNoCapture = errors.New("not capturing")
+)
+
+// Emulator runs a machine on its own goroutine and lets other goroutines
+// inspect and control it. Every access to the machine goes through Do, which
+// holds a lock the loop also takes for each frame.
+type Emulator struct {
+	mu      sync.Mutex
+	machine *nes.Machine
+	paused  bool
+	halted  bool
+	err     error
+	frames  uint64
+	palette *video.Palette
+	capture *capture.Capture
+	// The first thing to go wrong while capturing, reported when it stops.
+	captureErr error
+}
+
+// Creates an emulator for the machine, which can be nil until a ROM is loaded
+// with SetMachine.
+func NewEmulator(machine *nes.Machine) *Emulator {
+	return &Emulator{machine: machine, palette: &video.DefaultPalette}
+}
+
+// Runs frames at the machine region's frame rate until the context is
+// cancelled, unless the emulator is paused or the program has halted.
+func (e *Emulator) Run(ctx context.Context) {
+	period := IDLE_PERIOD
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.mu.Lock()
+			if e.machine != nil && !e.paused && !e.halted {
+				e.stepFrame()
+			}
+			// A newly loaded ROM can be from another region.
+			next := e.framePeriod()
+			e.mu.Unlock()
+			if next != period {
+				period = next
+				ticker.Reset(period)
+			}
+		}
+	}
+}
+
+// Returns how long a frame lasts. Must be called with the lock held.
+func (e *Emulator) framePeriod() time.Duration {
+	if e.machine == nil {
+		return IDLE_PERIOD
+	}
+	return time.Duration(float64(time.Second) / e.machine.Timing().FrameRate())
+}
+
+// Calls f with the machine while nothing else can touch it. Returns ErrNoROM
+// if no machine has been loaded.
+func (e *Emulator) Do(f func(m *nes.Machine) error) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.machine == nil {
+		return ErrNoROM
+	}
+	return f(e.machine)
+}
+
+// Swaps in a new machine, usually with a different cartridge inserted.
+func (e *Emulator) SetMachine(machine *nes.Machine) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// A capture belongs to the old machine.
+	e.stopCapture()
+	e.machine = machine
+	if machine != nil {
+		machine.SetPalette(e.palette)
+	}
+	e.halted = false
+	e.err = nil
+	e.frames = 0
+}
+
+// Stops or restarts the loop. Stepping still works while paused.
+func (e *Emulator) SetPaused(paused bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.paused = paused
+}
+
+// Sets the colours pictures and captures are made with.
+func (e *Emulator) SetPalette(palette *video.Palette) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.palette = palette
+	if e.machine != nil {
+		e.machine.SetPalette(palette)
+	}
+}
+
+func (e *Emulator) Palette() *video.Palette {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.palette
+}
+
+// Resets the machine and clears any halt.
+func (e *Emulator) Reset() error {
+	return e.Do(func(m *nes.Machine) error {
+		m.Reset()
+		e.halted = false
+		e.err = nil
+		return nil
+	})
+}
+
+// Executes n instructions.
+func (e *Emulator) StepInstructions(n int) error {
+	return e.Do(func(m *nes.Machine) error {
+		for i := 0; i < n && !e.halted; i++ {
+			e.guard(func() bool { return m.Step() })
+		}
+		return e.haltError()
+	})
+}
+
+// Runs n frames.
+func (e *Emulator) StepFrames(n int) error {
+	return e.Do(func(m *nes.Machine) error {
+		for i := 0; i < n && !e.halted; i++ {
+			e.stepFrame()
+		}
+		return e.haltError()
+	})
+}
+
+// Starts capturing every frame the machine runs, whether from the loop or
+// stepping, until StopCapture. The emulator's palette is used unless the
+// options give one.
+func (e *Emulator) StartCapture(options capture.Options) error {
+	return e.Do(func(m *nes.Machine) error {
+		if e.capture != nil {
+			return ErrCapturing
+		}
+		if options.Palette == nil {
+			options.Palette = e.palette
+		}
+		c, err := capture.Start(m, options)
+		if err != nil {
+			return err
+		}
+		e.capture, e.captureErr = c, nil
+		return nil
+	})
+}
+
+// Finishes the capture, returning how many frames it got and anything that
+// went wrong along the way.
+func (e *Emulator) StopCapture() (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.capture == nil {
+		return 0, ErrNoCapture
+	}
+	frames := e.capture.Frames()
+	return frames, e.stopCapture()
+}
+
+// Must be called with the lock held.
+func (e *Emulator) stopCapture() error {
+	if e.capture == nil {
+		return nil
+	}
+	err := errors.Join(e.captureErr, e.capture.Stop())
+	e.capture, e.captureErr = nil, nil
+	return err
+}
+
+// Status is a summary of what the emulator is doing.
+type Status struct {
+	Loaded bool   `json:"loaded"`
+	Paused bool   `json:"paused"`
+	Halted bool   `json:"halted"`
+	Frames uint64 `json:"frames"`
+	Cycles uint64 `json:"cycles"`
+	Error  string `json:"error,omitempty"`
+	// Where frames are being captured to, if they are.
+	Capture string `json:"capture,omitempty"`
+}
+
+func (e *Emulator) Status() Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	status := Status{Loaded: e.machine != nil, Paused: e.paused, Halted: e.halted, Frames: e.frames}
+	if e.machine != nil {
+		status.Cycles = e.machine.CPU().Cycles()
+	}
+	if e.err != nil {
+		status.Error = e.err.Error()
+	}
+	if e.capture != nil {
+		status.Capture = e.capture.Path()
+	}
+	return status
+}
+
+func (e *Emulator) stepFrame() {
+	if !e.guard(e.machine.StepFrame) {
+		return
+	}
+	e.frames++
+	// Frames that fail to capture are skipped, the error comes back from
+	// StopCapture.
+	if e.capture != nil && e.captureErr == nil {
+		e.captureErr = e.capture.Frame()
+	}
+}
+
+// Runs step, marking the emulator halted if the program stops or the CPU
+// panics on something it can't execute. Must be called with the lock held.
+func (e *Emulator) guard(step func() bool) (running bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			// The CPU has already moved past the opcode it choked on.
+			pc := e.machine.CPU().Registers().PC - 1
+			e.halted = true
+			e.err = fmt.Errorf("%v at $%04X", strings.TrimSpace(fmt.Sprint(r)), pc)
+			running = false
+		}
+	}()
+	running = step()
+	e.halted = !running
+	return running
+}
+
+func (e *Emulator) haltError() error {
+	if e.err != nil {
+		return e.err
+	}
+	if e.halted {
+		return ErrHalted
+	}
+	return nil
+}
@@ -0,0 +1,591 @@
+package httpapi
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"switchtrue.com/hankee/capture"
+	"switchtrue.com/hankee/cartridge"
+	"switchtrue.com/hankee/joypad"
+	"switchtrue.com/hankee/nes"
+	"switchtrue.com/hankee/ntsc"
+	"switchtrue.com/hankee/palette"
+	"switchtrue.com/hankee/video"
+)
+
+const (
+	// Largest request body accepted, enough for any NROM image or save state.
+	MAX_BODY = 4 << 20
+	// The most a single step request runs, about ten seconds of play, since
+	// nothing else can touch the machine until it's done.
+	MAX_STEP_FRAMES       = 600
+	MAX_STEP_INSTRUCTIONS = 1_000_000
+)
+
+// Options limit what the API can do to the machine it runs on.
+type Options struct {
+	// Directory captures are written under, given as paths relative to it.
+	// Captures are refused when it's empty.
+	CaptureDir string
+	// Skips checking requests are addressed to localhost, for Unix sockets,
+	// which browsers can't reach.
+	AnyHost bool
+}
+
+// handler serves the API for an emulator.
+type handler struct {
+	emulator *Emulator
+	options  Options
+	mux      *http.ServeMux
+}
+
+// Returns a handler serving the API:
+//
+//	GET  /status                      what the emulator is doing
+//	POST /rom[?path=file]             load a ROM from the body or a local file
+//	POST /reset                       reset the machine
+//	POST /pause, /resume              stop and start the frame loop
+//	POST /step?instructions=N         run up to MAX_STEP_INSTRUCTIONS instructions
+//	POST /step?frames=N               run up to MAX_STEP_FRAMES frames
+//	GET  /registers                   CPU registers
+//	GET  /memory?addr=A&len=N         read N bytes from the CPU address space
+//	PUT  /memory?addr=A               write {"data": "hex"} to the address space
+//	GET  /controllers/{port}          buttons held on pad 1 to 4
+//	PUT  /controllers/{port}          set {"buttons": ["A", "Start"]}
+//	GET  /frame.png[?palette=P]       the current picture in a built in palette
+//	GET  /frame.png?filter=ntsc       through the NTSC filter, both taking hue,
+//	                                  saturation and sharpness from -1 to 1
+//	GET  /state                       a save state
+//	PUT  /state                       load a save state from the body
+//	POST /capture/start?path=F        capture frames to a GIF or Y4M file in
+//	                                  the capture directory
+//	POST /capture/stop                finish the capture
+func NewHandler(e *Emulator, options Options) http.Handler {
+	h := &handler{emulator: e, options: options, mux: http.NewServeMux()}
+	routes := []struct {
+		pattern string
+		handle  func(w http.ResponseWriter, r *http.Request) error
+	}{
+		{"GET /status", h.status},
+		{"POST /rom", h.loadROM},
+		{"POST /reset", h.reset},
+		{"POST /pause", h.pause},
+		{"POST /resume", h.resume},
+		{"POST /step", h.step},
+		{"GET /registers", h.registers},
+		{"GET /memory", h.readMemory},
+		{"PUT /memory", h.writeMemory},
+		{"GET /controllers/{port}", h.controller},
+		{"PUT /controllers/{port}", h.setController},
+		{"GET /frame.png", h.frame},
+		{"GET /state", h.saveState},
+		{"PUT /state", h.loadState},
+		{"POST /capture/start", h.startCapture},
+		{"POST /capture/stop", h.stopCapture},
+	}
+	for _, route := range routes {
+		handle := route.handle
+		h.mux.HandleFunc(route.pattern, func(w http.ResponseWriter, r *http.Request) {
+			if err := handle(w, r); err != nil {
+				writeError(w, err)
+			}
+		})
+	}
+	return h
+}
+
+// Mutating requests must have one of these content types. Browsers won't send
+// either to another site without asking first with a CORS preflight, which
+// the API never agrees to.
+var BODY_TYPES = []string{"application/json", "application/octet-stream"}
+
+// Refuses requests a web page could have made. The API has no authentication
+// and can read files, and although it only listens on localhost, any page
+// open in a browser can send requests there, or reach it through DNS
+// rebinding under a name of its own.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	switch {
+	case !h.options.AnyHost && !IsLocalHost(host):
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": fmt.Sprintf("%s is not localhost", r.Host)})
+		return
+	case r.Header.Get("Origin") != "":
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "requests from web pages aren't allowed"})
+		return
+	case r.Method != http.MethodGet && r.Method != http.MethodHead:
+		contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if !slices.Contains(BODY_TYPES, contentType) {
+			writeJSON(w, http.StatusUnsupportedMediaType, map[string]string{"error": "expected a Content-Type of " + strings.Join(BODY_TYPES, " or ")})
+			return
+		}
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+// Returns whether a host name is this machine, localhost or a loopback
+// address.
+func IsLocalHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// requestError is a problem with the request rather than the emulator.
+type requestError struct {
+	err error
+}
+
+func (e requestError) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(format string, args ...any) error {
+	return requestError{fmt.Errorf(format, args...)}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var requestErr requestError
+	switch {
+	case errors.As(err, &requestErr):
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrNoROM), errors.Is(err, ErrHalted), errors.Is(err, ErrCapturing), errors.Is(err, ErrNoCapture):
+		status = http.StatusConflict
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(body)
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	data, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, MAX_BODY))
+	if err != nil {
+		return nil, badRequest("reading body: %v", err)
+	}
+	return data, nil
+}
+
+func readJSON(r *http.Request, v any) error {
+	data, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return badRequest("invalid JSON: %v", err)
+	}
+	return nil
+}
+
+func (h *handler) status(w http.ResponseWriter, r *http.Request) error {
+	return writeJSON(w, http.StatusOK, h.emulator.Status())
+}
+
+func (h *handler) loadROM(w http.ResponseWriter, r *http.Request) error {
+	var raw []byte
+	var err error
+	if path := r.URL.Query().Get("path"); path != "" {
+		raw, err = os.ReadFile(path)
+		if err != nil {
+			return badRequest("%v", err)
+		}
+	} else if raw, err = readBody(r); err != nil {
+		return err
+	}
+
+	cart, err := cartridge.Load(raw)
+	if err != nil {
+		return badRequest("%v", err)
+	}
+	machine := nes.New()
+	machine.SetRegion(nes.RegionFor(cart))
+	machine.InsertCartridge(cart)
+	h.emulator.SetMachine(machine)
+	return h.status(w, r)
+}
+
+func (h *handler) reset(w http.ResponseWriter, r *http.Request) error {
+	if err := h.emulator.Reset(); err != nil {
+		return err
+	}
+	return h.status(w, r)
+}
+
+func (h *handler) pause(w http.ResponseWriter, r *http.Request) error {
+	h.emulator.SetPaused(true)
+	return h.status(w, r)
+}
+
+func (h *handler) resume(w http.ResponseWriter, r *http.Request) error {
+	h.emulator.SetPaused(false)
+	return h.status(w, r)
+}
+
+func (h *handler) step(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	var err error
+	switch {
+	case query.Has("instructions"):
+		var n int
+		if n, err = count(query.Get("instructions"), MAX_STEP_INSTRUCTIONS); err == nil {
+			err = h.emulator.StepInstructions(n)
+		}
+	case query.Has("frames"):
+		var n int
+		if n, err = count(query.Get("frames"), MAX_STEP_FRAMES); err == nil {
+			err = h.emulator.StepFrames(n)
+		}
+	default:
+		err = badRequest("expected instructions or frames")
+	}
+	if err != nil {
+		return err
+	}
+	return h.registers(w, r)
+}
+
+// Parses a count from 0 to most.
+func count(s string, most int) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, badRequest("invalid count %q", s)
+	}
+	if n > most {
+		return 0, badRequest("count %d is over the limit of %d", n, most)
+	}
+	return n, nil
+}
+
+type registers struct {
+	A      uint8  `json:"a"`
+	X      uint8  `json:"x"`
+	Y      uint8  `json:"y"`
+	Status uint8  `json:"status"`
+	SP     uint8  `json:"sp"`
+	PC     uint16 `json:"pc"`
+	Cycles uint64 `json:"cycles"`
+}
+
+func (h *handler) registers(w http.ResponseWriter, r *http.Request) error {
+	var body registers
+	err := h.emulator.Do(func(m *nes.Machine) error {
+		c := m.CPU()
+		regs := c.Registers()
+		body = registers{regs.A, regs.X, regs.Y, regs.Status, regs.SP, regs.PC, c.Cycles()}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, body)
+}
+
+type memory struct {
+	Address uint16 `json:"address"`
+	Data    string `json:"data"`
+}
+
+func (h *handler) readMemory(w http.ResponseWriter, r *http.Request) error {
+	addr, err := parseAddress(r.URL.Query().Get("addr"))
+	if err != nil {
+		return err
+	}
+	length := 1
+	if s := r.URL.Query().Get("len"); s != "" {
+		if length, err = count(s, 0x10000); err != nil {
+			return err
+		}
+	}
+	if int(addr)+length > 0x10000 {
+		return badRequest("range runs past $FFFF")
+	}
+
+	data := make([]byte, length)
+	err = h.emulator.Do(func(m *nes.Machine) error {
+		for i := range data {
+			data[i] = m.Bus().Read(addr + uint16(i))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, memory{Address: addr, Data: hex.EncodeToString(data)})
+}
+
+func (h *handler) writeMemory(w http.ResponseWriter, r *http.Request) error {
+	addr, err := parseAddress(r.URL.Query().Get("addr"))
+	if err != nil {
+		return err
+	}
+	var body memory
+	if err := readJSON(r, &body); err != nil {
+		return err
+	}
+	data, err := hex.DecodeString(body.Data)
+	if err != nil {
+		return badRequest("invalid hex data: %v", err)
+	}
+	if int(addr)+len(data) > 0x10000 {
+		return badRequest("range runs past $FFFF")
+	}
+
+	err = h.emulator.Do(func(m *nes.Machine) error {
+		for i, value := range data {
+			m.Bus().Write(addr+uint16(i), value)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, memory{Address: addr, Data: body.Data})
+}
+
+// Parses $C000, 0xC000 or decimal.
+func parseAddress(s string) (uint16, error) {
+	if s == "" {
+		return 0, badRequest("missing addr")
+	}
+	value, err := strconv.ParseUint(strings.Replace(s, "$", "0x", 1), 0, 16)
+	if err != nil {
+		return 0, badRequest("invalid address %q", s)
+	}
+	return uint16(value), nil
+}
+
+type controller struct {
+	Buttons []string `json:"buttons"`
+}
+
+func (h *handler) joypad(m *nes.Machine, r *http.Request) (*joypad.Joypad, error) {
+	n, err := strconv.Atoi(r.PathValue("port"))
+	pad := m.Joypads().Pad(n)
+	if err != nil || pad == nil {
+		return nil, badRequest("unknown controller %q, expected 1 to 4", r.PathValue("port"))
+	}
+	return pad, nil
+}
+
+func (h *handler) controller(w http.ResponseWriter, r *http.Request) error {
+	body := controller{Buttons: []string{}}
+	err := h.emulator.Do(func(m *nes.Machine) error {
+		pad, err := h.joypad(m, r)
+		if err != nil {
+			return err
+		}
+		for i, name := range joypad.BUTTON_NAMES {
+			if pad.Buttons()&(1<<i) != 0 {
+				body.Buttons = append(body.Buttons, name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, body)
+}
+
+func (h *handler) setController(w http.ResponseWriter, r *http.Request) error {
+	var body controller
+	if err := readJSON(r, &body); err != nil {
+		return err
+	}
+	var buttons joypad.Button
+	for _, name := range body.Buttons {
+		button, err := joypad.ParseButton(name)
+		if err != nil {
+			return badRequest("%v", err)
+		}
+		buttons |= button
+	}
+
+	err := h.emulator.Do(func(m *nes.Machine) error {
+		pad, err := h.joypad(m, r)
+		if err != nil {
+			return err
+		}
+		pad.SetButtons(buttons)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return h.controller(w, r)
+}
+
+func (h *handler) frame(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	var options ntsc.Options
+	for name, value := range map[string]*float64{"hue": &options.Hue, "saturation": &options.Saturation, "sharpness": &options.Sharpness} {
+		if !query.Has(name) {
+			continue
+		}
+		v, err := strconv.ParseFloat(query.Get(name), 64)
+		if err != nil || v < -1 || v > 1 {
+			return badRequest("%s must be from -1 to 1", name)
+		}
+		*value = v
+	}
+	var filter *ntsc.Filter
+	switch query.Get("filter") {
+	case "", "none":
+	case "ntsc":
+		filter = ntsc.New(options)
+	default:
+		return badRequest("unknown filter %q, expected none or ntsc", query.Get("filter"))
+	}
+	colours := h.emulator.Palette()
+	if query.Has("palette") {
+		var ok bool
+		if colours, ok = palette.Named(query.Get("palette"), options); !ok {
+			return badRequest("unknown palette %q, expected one of %s", query.Get("palette"), strings.Join(palette.NAMES, ", "))
+		}
+	}
+
+	var frame *video.Frame
+	err := h.emulator.Do(func(m *nes.Machine) error {
+		current := m.Frame()
+		frame = &video.Frame{Width: current.Width, Height: current.Height, Pix: append([]uint16(nil), current.Pix...)}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Encode outside the lock so the loop isn't held up.
+	img := frame.RGBA(colours)
+	if filter != nil {
+		img = filter.Apply(frame)
+	}
+	w.Header().Set("Content-Type", "image/png")
+	return png.Encode(w, img)
+}
+
+func (h *handler) saveState(w http.ResponseWriter, r *http.Request) error {
+	var state *nes.State
+	err := h.emulator.Do(func(m *nes.Machine) error {
+		state = m.SaveState()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err := state.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, err = w.Write(data)
+	return err
+}
+
+func (h *handler) loadState(w http.ResponseWriter, r *http.Request) error {
+	data, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	var state nes.State
+	if err := state.UnmarshalBinary(data); err != nil {
+		return badRequest("%v", err)
+	}
+	err = h.emulator.Do(func(m *nes.Machine) error {
+		if err := m.LoadState(&state); err != nil {
+			return badRequest("%v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return h.status(w, r)
+}
+
+// Starts a capture to a file in the capture directory. The sound goes
+// alongside as WAV unless audio is given, or is none.
+func (h *handler) startCapture(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	if query.Get("path") == "" {
+		return badRequest("expected a path to capture to")
+	}
+	path, err := h.capturePath(query.Get("path"), ".gif", ".y4m")
+	if err != nil {
+		return err
+	}
+	format, err := capture.FormatFor(path)
+	if err != nil {
+		return badRequest("%v", err)
+	}
+	if query.Has("format") {
+		named, err := capture.ParseFormat(query.Get("format"))
+		if err != nil {
+			return badRequest("%v", err)
+		}
+		if named != capture.Auto && named != format {
+			return badRequest("%s isn't a %v file", query.Get("path"), named)
+		}
+	}
+	audio := capture.AudioPath(path)
+	switch query.Get("audio") {
+	case "":
+	case "none":
+		audio = ""
+	default:
+		if audio, err = h.capturePath(query.Get("audio"), ".wav"); err != nil {
+			return err
+		}
+	}
+	if err := h.emulator.StartCapture(capture.Options{Path: path, Format: format, Audio: audio}); err != nil {
+		if errors.Is(err, ErrNoROM) || errors.Is(err, ErrCapturing) {
+			return err
+		}
+		return badRequest("%v", err)
+	}
+	return h.status(w, r)
+}
+
+// Returns where a capture file named in a request goes. Anyone who can make
+// requests picks the name, so it must stay inside the capture directory and
+// have one of the given extensions rather than being able to replace any file.
+func (h *handler) capturePath(name string, extensions ...string) (string, error) {
+	if h.options.CaptureDir == "" {
+		return "", badRequest("captures are off, the server needs a capture directory")
+	}
+	if !filepath.IsLocal(name) {
+		return "", badRequest("%s must be a relative path inside the capture directory", name)
+	}
+	if !slices.Contains(extensions, strings.ToLower(filepath.Ext(name))) {
+		return "", badRequest("%s must be a %s file", name, strings.Join(extensions, " or "))
+	}
+	return filepath.Join(h.options.CaptureDir, name), nil
+}
+
+func (h *handler) stopCapture(w http.ResponseWriter, r *http.Request) error {
+	frames, err := h.emulator.StopCapture()
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, map[string]int{"frames": frames})
+}
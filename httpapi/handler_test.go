@@ -0,0 +1,352 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"switchtrue.com/hankee/cartridge"
+	"switchtrue.com/hankee/video"
+)
+
+// Builds an NROM-128 image with the program at $8000.
+func buildROM(program []uint8) []uint8 {
+	raw := []uint8{'N', 'E', 'S', 0x1A, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	prg := make([]uint8, cartridge.PRG_ROM_PAGE_SIZE)
+	copy(prg, program)
+	prg[0x3FFC] = 0x00
+	prg[0x3FFD] = 0x80
+	raw = append(raw, prg...)
+	return append(raw, make([]uint8, cartridge.CHR_ROM_PAGE_SIZE)...)
+}
+
+// INC $10; LDA $4016; STA $11; JMP $8000
+var counter = []uint8{0xE6, 0x10, 0xAD, 0x16, 0x40, 0x85, 0x11, 0x4C, 0x00, 0x80}
+
+// Starts a server with the emulator loop running, as it would be for real.
+func newServer(t *testing.T) *httptest.Server {
+	return serveWith(t, Options{})
+}
+
+func serveWith(t *testing.T, options Options) *httptest.Server {
+	e := NewEmulator(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	go e.Run(ctx)
+	server := httptest.NewServer(NewHandler(e, options))
+	t.Cleanup(func() {
+		server.Close()
+		cancel()
+	})
+	return server
+}
+
+// Makes a request, with a JSON body unless it's a GET.
+func call(t *testing.T, server *httptest.Server, method string, path string, body io.Reader) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, server.URL+path, body)
+	require.NoError(t, err)
+	if method != http.MethodGet {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return send(t, req)
+}
+
+// Makes a request with a binary body, such as a ROM or a save state.
+func upload(t *testing.T, server *httptest.Server, method string, path string, data []byte) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, server.URL+path, bytes.NewReader(data))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	return send(t, req)
+}
+
+func send(t *testing.T, req *http.Request) (*http.Response, []byte) {
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, data
+}
+
+func callJSON(t *testing.T, server *httptest.Server, method string, path string, body string) map[string]any {
+	resp, data := call(t, server, method, path, strings.NewReader(body))
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(data))
+	var result map[string]any
+	require.NoError(t, json.Unmarshal(data, &result))
+	return result
+}
+
+// Loads the counter program paused, so nothing runs until the test steps.
+func loadCounter(t *testing.T, server *httptest.Server) {
+	callJSON(t, server, http.MethodPost, "/pause", "")
+	resp, data := upload(t, server, http.MethodPost, "/rom", buildROM(counter))
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(data))
+}
+
+// Test that requests needing a machine fail until a ROM is loaded
+func Test_Handler_NoROM(t *testing.T) {
+	server := newServer(t)
+	assert.Equal(t, false, callJSON(t, server, http.MethodGet, "/status", "")["loaded"])
+
+	resp, data := call(t, server, http.MethodGet, "/registers", nil)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Contains(t, string(data), ErrNoROM.Error())
+
+	resp, _ = call(t, server, http.MethodPost, "/rom", strings.NewReader("not a rom"))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+// Test stepping instructions and frames while paused
+func Test_Handler_Step(t *testing.T) {
+	server := newServer(t)
+	loadCounter(t, server)
+
+	registers := callJSON(t, server, http.MethodPost, "/step?instructions=1", "")
+	assert.Equal(t, float64(0x8002), registers["pc"])
+	assert.Equal(t, float64(12), registers["cycles"])
+
+	callJSON(t, server, http.MethodPost, "/step?frames=2", "")
+	status := callJSON(t, server, http.MethodGet, "/status", "")
+	assert.Equal(t, float64(2), status["frames"])
+	assert.Equal(t, true, status["paused"])
+
+	resp, _ := call(t, server, http.MethodPost, "/step", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Steps too long to hold everything else up for are refused.
+	resp, _ = call(t, server, http.MethodPost, "/step?frames=1000000000", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = call(t, server, http.MethodPost, fmt.Sprintf("/step?instructions=%d", MAX_STEP_INSTRUCTIONS+1), nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, float64(2), callJSON(t, server, http.MethodGet, "/status", "")["frames"])
+}
+
+// Test reading and writing memory ranges
+func Test_Handler_Memory(t *testing.T) {
+	server := newServer(t)
+	loadCounter(t, server)
+
+	written := callJSON(t, server, http.MethodPut, "/memory?addr=$0300", `{"data": "0a0b0c"}`)
+	assert.Equal(t, float64(0x300), written["address"])
+	assert.Equal(t, "0b0c", callJSON(t, server, http.MethodGet, "/memory?addr=0x301&len=2", "")["data"])
+	assert.Equal(t, "e610", callJSON(t, server, http.MethodGet, "/memory?addr=32768&len=2", "")["data"])
+
+	resp, _ := call(t, server, http.MethodGet, "/memory?addr=$FFFF&len=2", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+// Test that controller state is visible to the program
+func Test_Handler_Controllers(t *testing.T) {
+	server := newServer(t)
+	loadCounter(t, server)
+
+	pad := callJSON(t, server, http.MethodPut, "/controllers/1", `{"buttons": ["a", "Start"]}`)
+	assert.Equal(t, []any{"A", "Start"}, pad["buttons"])
+
+	// Strobe the controller so the next read returns A.
+	callJSON(t, server, http.MethodPut, "/memory?addr=$4016", `{"data": "01"}`)
+	callJSON(t, server, http.MethodPut, "/memory?addr=$4016", `{"data": "00"}`)
+	callJSON(t, server, http.MethodPost, "/step?instructions=3", "")
+	assert.Equal(t, "01", callJSON(t, server, http.MethodGet, "/memory?addr=$11", "")["data"])
+
+	resp, _ := call(t, server, http.MethodPut, "/controllers/1", strings.NewReader(`{"buttons": ["Turbo"]}`))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = call(t, server, http.MethodGet, "/controllers/5", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+// Test grabbing the frame as a PNG
+func Test_Handler_Frame(t *testing.T) {
+	server := newServer(t)
+	loadCounter(t, server)
+
+	resp, data := call(t, server, http.MethodGet, "/frame.png", nil)
+	assert.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+	img, err := png.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, 256, img.Bounds().Dx())
+	assert.Equal(t, 240, img.Bounds().Dy())
+
+	// The backdrop is $0F, nearly black in the 2C02 palette and black on RGB PPUs.
+	assert.Equal(t, color.RGBAModel.Convert(video.DefaultPalette.Color(0x0F)), color.RGBAModel.Convert(img.At(0, 0)))
+	_, data = call(t, server, http.MethodGet, "/frame.png?palette=2c03", nil)
+	img, err = png.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, color.RGBA{0, 0, 0, 0xFF}, color.RGBAModel.Convert(img.At(0, 0)))
+	resp, _ = call(t, server, http.MethodGet, "/frame.png?palette=sepia", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	_, data = call(t, server, http.MethodGet, "/frame.png?filter=ntsc&saturation=-0.5", nil)
+	img, err = png.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, 602, img.Bounds().Dx())
+	resp, _ = call(t, server, http.MethodGet, "/frame.png?filter=ntsc&hue=2", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+// Test that a save state brings memory and registers back
+func Test_Handler_State(t *testing.T) {
+	server := newServer(t)
+	loadCounter(t, server)
+	callJSON(t, server, http.MethodPost, "/step?frames=1", "")
+
+	_, state := call(t, server, http.MethodGet, "/state", nil)
+	before := callJSON(t, server, http.MethodGet, "/memory?addr=$10", "")["data"]
+	pc := callJSON(t, server, http.MethodGet, "/registers", "")["pc"]
+
+	callJSON(t, server, http.MethodPost, "/step?frames=1", "")
+	assert.NotEqual(t, before, callJSON(t, server, http.MethodGet, "/memory?addr=$10", "")["data"])
+
+	resp, data := upload(t, server, http.MethodPut, "/state", state)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(data))
+	assert.Equal(t, before, callJSON(t, server, http.MethodGet, "/memory?addr=$10", "")["data"])
+	assert.Equal(t, pc, callJSON(t, server, http.MethodGet, "/registers", "")["pc"])
+
+	resp, _ = upload(t, server, http.MethodPut, "/state", []byte("garbage"))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+// Test that the loop runs frames on its own until paused
+func Test_Handler_Running(t *testing.T) {
+	server := newServer(t)
+	loadCounter(t, server)
+	callJSON(t, server, http.MethodPost, "/resume", "")
+
+	require.Eventually(t, func() bool {
+		return callJSON(t, server, http.MethodGet, "/status", "")["frames"].(float64) >= 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Hammer the machine while the loop is running, the race detector
+	// catches anything that isn't locked.
+	for i := 0; i < 20; i++ {
+		callJSON(t, server, http.MethodGet, "/memory?addr=$10", "")
+		callJSON(t, server, http.MethodGet, "/registers", "")
+	}
+	callJSON(t, server, http.MethodPost, "/pause", "")
+	frames := callJSON(t, server, http.MethodGet, "/status", "")["frames"]
+	assert.Equal(t, frames, callJSON(t, server, http.MethodGet, "/status", "")["frames"])
+}
+
+// Test that a program halting on BRK is reported
+func Test_Handler_Halted(t *testing.T) {
+	server := newServer(t)
+	callJSON(t, server, http.MethodPost, "/pause", "")
+	resp, _ := upload(t, server, http.MethodPost, "/rom", buildROM([]uint8{0xE8, 0x00}))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, data := call(t, server, http.MethodPost, "/step?instructions=5", nil)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, string(data))
+	assert.Equal(t, true, callJSON(t, server, http.MethodGet, "/status", "")["halted"])
+}
+
+// Test capturing stepped frames to a GIF with the sound alongside
+func Test_Handler_Capture(t *testing.T) {
+	dir := t.TempDir()
+	server := serveWith(t, Options{CaptureDir: dir})
+	loadCounter(t, server)
+	path := filepath.Join(dir, "bug.gif")
+
+	status := callJSON(t, server, http.MethodPost, "/capture/start?path=bug.gif", "")
+	assert.Equal(t, path, status["capture"])
+	resp, _ := call(t, server, http.MethodPost, "/capture/start?path=bug.gif", nil)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	callJSON(t, server, http.MethodPost, "/step?frames=6", "")
+	stopped := callJSON(t, server, http.MethodPost, "/capture/stop", "")
+	assert.Equal(t, float64(6), stopped["frames"])
+	resp, _ = call(t, server, http.MethodPost, "/capture/stop", nil)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	decoded, err := gif.DecodeAll(f)
+	require.NoError(t, err)
+	assert.Len(t, decoded.Image, 3)
+	assert.FileExists(t, filepath.Join(dir, "bug.wav"))
+
+	resp, _ = call(t, server, http.MethodPost, "/capture/start?path=bug.mp4", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+// Test that captures can only write files of their own kinds inside the
+// capture directory
+func Test_Handler_CapturePaths(t *testing.T) {
+	dir := t.TempDir()
+	server := serveWith(t, Options{CaptureDir: filepath.Join(dir, "captures")})
+	loadCounter(t, server)
+	for _, query := range []string{
+		"path=../bug.gif",
+		"path=" + url.QueryEscape(filepath.Join(dir, "bug.gif")),
+		"path=bug.txt&format=gif",
+		"path=bug.gif&format=y4m",
+		"path=bug.gif&audio=../bug.wav",
+		"path=bug.gif&audio=bug.txt",
+		"path=-",
+	} {
+		resp, data := call(t, server, http.MethodPost, "/capture/start?"+query, nil)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "%s: %s", query, data)
+	}
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// Without a directory there are no captures at all.
+	server = newServer(t)
+	loadCounter(t, server)
+	resp, _ := call(t, server, http.MethodPost, "/capture/start?path=bug.gif", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+// Test that requests a web page could have made are turned away
+func Test_Handler_WebPages(t *testing.T) {
+	server := newServer(t)
+	loadCounter(t, server)
+	request := func(method string, header map[string]string, host string) int {
+		req, err := http.NewRequest(method, server.URL+"/pause", nil)
+		require.NoError(t, err)
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+		if host != "" {
+			req.Host = host
+		}
+		resp, _ := send(t, req)
+		return resp.StatusCode
+	}
+	jsonBody := map[string]string{"Content-Type": "application/json"}
+
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, jsonBody, ""))
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, jsonBody, "localhost:8502"))
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, jsonBody, "[::1]:8502"))
+	// DNS rebinding leaves the attacker's name in Host.
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPost, jsonBody, "evil.example:8502"))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodGet, nil, "evil.example"))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPost, map[string]string{"Content-Type": "application/json", "Origin": "https://evil.example"}, ""))
+	// Forms and plain text are what pages can send without a preflight.
+	assert.Equal(t, http.StatusUnsupportedMediaType, request(http.MethodPost, nil, ""))
+	assert.Equal(t, http.StatusUnsupportedMediaType, request(http.MethodPost, map[string]string{"Content-Type": "text/plain"}, ""))
+	assert.Equal(t, http.StatusUnsupportedMediaType, request(http.MethodPost, map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, ""))
+
+	// Unix sockets can skip the host check.
+	server = serveWith(t, Options{AnyHost: true})
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/status", nil)
+	require.NoError(t, err)
+	req.Host = "hankee.sock"
+	resp, _ := send(t, req)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
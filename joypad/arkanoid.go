@@ -0,0 +1,110 @@
+package joypad
+
+const (
+	// The range of the Vaus' potentiometer, from the knob turned fully left
+	// to fully right.
+	ARKANOID_MIN = 0x62
+	ARKANOID_MAX = 0xF2
+	// Bits the NES Vaus drives on reads of its port.
+	ARKANOID_FIRE = 0x08
+	ARKANOID_DATA = 0x10
+)
+
+// Arkanoid is the Vaus controller that came with Arkanoid, a knob and a fire
+// button. Strobing it latches the knob's position, which reads then shift out
+// a bit at a time, most significant first and inverted. The NES version plugs
+// into a controller port, usually port 2, with the fire button on D3 and the
+// knob on D4.
+type Arkanoid struct {
+	position uint8
+	fire     bool
+	strobe   bool
+	latched  uint8
+	index    uint8
+}
+
+// Creates a Vaus with its knob in the middle.
+func NewArkanoid() *Arkanoid {
+	return &Arkanoid{position: (ARKANOID_MIN + ARKANOID_MAX) / 2}
+}
+
+// Sets the knob's position, kept between ARKANOID_MIN and ARKANOID_MAX.
+func (a *Arkanoid) SetPosition(position uint8) {
+	a.position = min(max(position, ARKANOID_MIN), ARKANOID_MAX)
+}
+
+func (a *Arkanoid) Position() uint8 {
+	return a.position
+}
+
+// Turns the knob to a fraction of the way from fully left at 0 to fully
+// right at 1.
+func (a *Arkanoid) Turn(fraction float64) {
+	fraction = min(max(fraction, 0), 1)
+	a.position = ARKANOID_MIN + uint8(fraction*(ARKANOID_MAX-ARKANOID_MIN)+0.5)
+}
+
+func (a *Arkanoid) SetFire(pressed bool) {
+	a.fire = pressed
+}
+
+func (a *Arkanoid) Fire() bool {
+	return a.fire
+}
+
+func (a *Arkanoid) Write(data uint8) {
+	a.strobe = data&1 == 1
+	if a.strobe {
+		a.latched = a.position
+		a.index = 0
+	}
+}
+
+// Returns the next bit of the knob's position, 1 for a 0 bit. Once all 8 are
+// out it keeps returning 0.
+func (a *Arkanoid) shift() uint8 {
+	if a.strobe {
+		a.latched = a.position
+		a.index = 0
+	}
+	if a.index > 7 {
+		return 0
+	}
+	bit := ^a.latched >> (7 - a.index) & 1
+	if !a.strobe {
+		a.index++
+	}
+	return bit
+}
+
+func (a *Arkanoid) Read() uint8 {
+	var bits uint8
+	if a.fire {
+		bits |= ARKANOID_FIRE
+	}
+	if a.shift() != 0 {
+		bits |= ARKANOID_DATA
+	}
+	return bits
+}
+
+// FamicomArkanoid is the Famicom's Vaus, which plugs into the expansion port.
+// The fire button is read on D1 of $4016 and the knob on D1 of $4017.
+type FamicomArkanoid struct {
+	*Arkanoid
+}
+
+// Creates a Famicom Vaus with its knob in the middle.
+func NewFamicomArkanoid() *FamicomArkanoid {
+	return &FamicomArkanoid{NewArkanoid()}
+}
+
+func (a *FamicomArkanoid) Read(addr uint16) uint8 {
+	if addr == 0x4016 {
+		if a.fire {
+			return 0x02
+		}
+		return 0
+	}
+	return a.shift() << 1
+}
@@ -0,0 +1,84 @@
+package joypad
+
+// Bits of the Four Score's signature, shifted out after both pads, that tell
+// games it's there. Port 1 sends 0x10 and port 2 0x20, least significant bit
+// first like the buttons.
+var FOUR_SCORE_SIGNATURES = [2]uint8{0x10, 0x20}
+
+// FourScore is one side of the NES Four Score, which plugs into both
+// controller ports. After a strobe each port shifts out 24 bits: the buttons of
+// its first pad, then its second pad, then the signature. Port 1 has pads 1
+// and 3 and port 2 has pads 2 and 4.
+type FourScore struct {
+	first     *Joypad
+	second    *Joypad
+	signature uint8
+	strobe    bool
+	index     uint8
+}
+
+// Creates the side of a Four Score plugged into port 1 or 2.
+func NewFourScore(port int, first *Joypad, second *Joypad) *FourScore {
+	return &FourScore{first: first, second: second, signature: FOUR_SCORE_SIGNATURES[port-1]}
+}
+
+func (f *FourScore) Write(data uint8) {
+	f.strobe = data&1 == 1
+	if f.strobe {
+		f.index = 0
+	}
+}
+
+func (f *FourScore) Read() uint8 {
+	var response uint8
+	switch {
+	case f.index < 8:
+		response = uint8(f.first.Buttons()>>f.index) & 1
+	case f.index < 16:
+		response = uint8(f.second.Buttons()>>(f.index-8)) & 1
+	case f.index < 24:
+		response = f.signature >> (f.index - 16) & 1
+	default:
+		// Like a standard controller it keeps returning 1 once it's done.
+		return 1
+	}
+	if !f.strobe {
+		f.index++
+	}
+	return response
+}
+
+// Returns the shift register's state, Buttons is unused.
+func (f *FourScore) State() State {
+	return State{Strobe: f.strobe, Index: f.index}
+}
+
+func (f *FourScore) SetState(state State) {
+	f.strobe = state.Strobe
+	f.index = state.Index
+}
+
+// FamicomAdapter is a 4 player adapter for the Famicom's expansion port, such
+// as Hori's in its Famicom mode. The console's own pads stay on D0 and the
+// adapter's two pads are read on D1, pad 3 from $4016 and pad 4 from $4017.
+type FamicomAdapter struct {
+	three *Joypad
+	four  *Joypad
+}
+
+// Creates an adapter with pads 3 and 4 plugged into it.
+func NewFamicomAdapter(three *Joypad, four *Joypad) *FamicomAdapter {
+	return &FamicomAdapter{three: three, four: four}
+}
+
+func (a *FamicomAdapter) Write(data uint8) {
+	a.three.Write(data)
+	a.four.Write(data)
+}
+
+func (a *FamicomAdapter) Read(addr uint16) uint8 {
+	if addr == 0x4016 {
+		return a.three.Read() << 1
+	}
+	return a.four.Read() << 1
+}
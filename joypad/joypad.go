@@ -0,0 +1,238 @@
+package joypad
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Button is one of the eight buttons on a standard controller. The values match
+// the order the controller shifts them out, A first.
+type Button uint8
+
+const (
+	ButtonA      Button = 1 << 0
+	ButtonB      Button = 1 << 1
+	ButtonSelect Button = 1 << 2
+	ButtonStart  Button = 1 << 3
+	ButtonUp     Button = 1 << 4
+	ButtonDown   Button = 1 << 5
+	ButtonLeft   Button = 1 << 6
+	ButtonRight  Button = 1 << 7
+)
+
+// Names of the buttons in the order they are shifted out.
+var BUTTON_NAMES = []string{"A", "B", "Select", "Start", "Up", "Down", "Left", "Right"}
+
+func (b Button) String() string {
+	var names []string
+	for i, name := range BUTTON_NAMES {
+		if b&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, "+")
+}
+
+// Parses a button name, ignoring case.
+func ParseButton(name string) (Button, error) {
+	for i, n := range BUTTON_NAMES {
+		if strings.EqualFold(n, name) {
+			return 1 << i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown button %q", name)
+}
+
+// Joypad is a standard NES controller. Writing 1 then 0 to $4016 latches the
+// button state and each read then shifts out one button, A, B, Select, Start,
+// Up, Down, Left, Right. While the strobe is held high every read returns A.
+type Joypad struct {
+	strobe  bool
+	index   uint8
+	buttons Button
+}
+
+func New() *Joypad {
+	return &Joypad{}
+}
+
+// Presses or releases a button.
+func (j *Joypad) SetButton(button Button, pressed bool) {
+	if pressed {
+		j.buttons |= button
+		return
+	}
+	j.buttons &^= button
+}
+
+// Replaces the state of every button at once.
+func (j *Joypad) SetButtons(buttons Button) {
+	j.buttons = buttons
+}
+
+func (j *Joypad) Buttons() Button {
+	return j.buttons
+}
+
+// State is everything needed to save and restore a controller, including
+// where it is part way through shifting out the buttons.
+type State struct {
+	Strobe  bool
+	Index   uint8
+	Buttons Button
+}
+
+func (j *Joypad) State() State {
+	return State{Strobe: j.strobe, Index: j.index, Buttons: j.buttons}
+}
+
+func (j *Joypad) SetState(state State) {
+	j.strobe = state.Strobe
+	j.index = state.Index
+	j.buttons = state.Buttons
+}
+
+func (j *Joypad) Write(data uint8) {
+	j.strobe = data&1 == 1
+	if j.strobe {
+		j.index = 0
+	}
+}
+
+func (j *Joypad) Read() uint8 {
+	// After all eight buttons have been read an official controller keeps
+	// returning 1.
+	if j.index > 7 {
+		return 1
+	}
+
+	response := uint8(j.buttons>>j.index) & 1
+	if !j.strobe {
+		j.index++
+	}
+	return response
+}
+
+// Device is something plugged into a controller port. Every write to $4016
+// reaches every device, with the strobe in bit 0, and a read of the port
+// returns the bits the device drives on the data lines, D0 for a controller
+// and D3-D4 for a Zapper.
+type Device interface {
+	Write(data uint8)
+	Read() uint8
+}
+
+// Expansion is something plugged into the Famicom's expansion port, which
+// sees every write to $4016 and drives D1-D4 of reads from both $4016 and
+// $4017 alongside whatever is in the controller ports.
+type Expansion interface {
+	Write(data uint8)
+	Read(addr uint16) uint8
+}
+
+// Ports are the two controller ports at $4016 and $4017 and the Famicom's
+// expansion port. A write to $4016 strobes everything, reads return the serial
+// bit of the matching port. Writes to $4017 belong to the APU frame counter so
+// they are ignored here. Each port has a standard controller until something
+// else is plugged in. Three and Four are the pads that only take part through
+// a Four Score or a Famicom 4 player adapter.
+type Ports struct {
+	One       *Joypad
+	Two       *Joypad
+	Three     *Joypad
+	Four      *Joypad
+	devices   [2]Device
+	fourScore [2]*FourScore
+	expansion Expansion
+}
+
+func NewPorts() *Ports {
+	p := &Ports{One: New(), Two: New(), Three: New(), Four: New()}
+	p.devices = [2]Device{p.One, p.Two}
+	p.fourScore = [2]*FourScore{NewFourScore(1, p.One, p.Three), NewFourScore(2, p.Two, p.Four)}
+	return p
+}
+
+// Returns pad 1 to 4, or nil for any other number.
+func (p *Ports) Pad(n int) *Joypad {
+	if n < 1 || n > 4 {
+		return nil
+	}
+	return [4]*Joypad{p.One, p.Two, p.Three, p.Four}[n-1]
+}
+
+// Plugs a device into port 1 or 2, or the port's controller back in if
+// device is nil.
+func (p *Ports) Plug(port int, device Device) {
+	if device == nil {
+		device = [2]*Joypad{p.One, p.Two}[port-1]
+	}
+	p.devices[port-1] = device
+}
+
+// Plugs a Four Score into both ports, with pads 1 and 3 on port 1 and pads 2
+// and 4 on port 2.
+func (p *Ports) PlugFourScore() {
+	p.devices = [2]Device{p.fourScore[0], p.fourScore[1]}
+}
+
+// Returns what's plugged into port 1 or 2.
+func (p *Ports) Device(port int) Device {
+	return p.devices[port-1]
+}
+
+// Plugs a device into the expansion port, or empties it if expansion is nil.
+func (p *Ports) PlugExpansion(expansion Expansion) {
+	p.expansion = expansion
+}
+
+// Returns what's plugged into the expansion port, nil if nothing is.
+func (p *Ports) Expansion() Expansion {
+	return p.expansion
+}
+
+// PortsState is the state of every pad and of the Four Score's shift
+// registers, which only use Strobe and Index.
+type PortsState struct {
+	Joypads   [4]State
+	FourScore [2]State
+}
+
+func (p *Ports) State() PortsState {
+	return PortsState{
+		Joypads:   [4]State{p.One.State(), p.Two.State(), p.Three.State(), p.Four.State()},
+		FourScore: [2]State{p.fourScore[0].State(), p.fourScore[1].State()},
+	}
+}
+
+func (p *Ports) SetState(state PortsState) {
+	for i, pad := range []*Joypad{p.One, p.Two, p.Three, p.Four} {
+		pad.SetState(state.Joypads[i])
+	}
+	for i, side := range p.fourScore {
+		side.SetState(state.FourScore[i])
+	}
+}
+
+func (p *Ports) Read(addr uint16) uint8 {
+	var data uint8
+	if addr == 0x4016 {
+		data = p.devices[0].Read()
+	} else {
+		data = p.devices[1].Read()
+	}
+	if p.expansion != nil {
+		data |= p.expansion.Read(addr)
+	}
+	return data
+}
+
+func (p *Ports) Write(addr uint16, data uint8) {
+	if addr == 0x4016 {
+		p.devices[0].Write(data)
+		p.devices[1].Write(data)
+		if p.expansion != nil {
+			p.expansion.Write(data)
+		}
+	}
+}
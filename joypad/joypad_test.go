@@ -0,0 +1,240 @@
+package joypad
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readAll(p *Ports, addr uint16) []uint8 {
+	bits := make([]uint8, 8)
+	for i := range bits {
+		bits[i] = p.Read(addr)
+	}
+	return bits
+}
+
+// Test that buttons are shifted out in order after a strobe
+func Test_Ports_ReadSequence(t *testing.T) {
+	p := NewPorts()
+	p.One.SetButton(ButtonA, true)
+	p.One.SetButton(ButtonStart, true)
+	p.One.SetButton(ButtonRight, true)
+	p.Write(0x4016, 1)
+	p.Write(0x4016, 0)
+	assert.Equal(t, []uint8{1, 0, 0, 1, 0, 0, 0, 1}, readAll(p, 0x4016))
+	assert.Equal(t, uint8(1), p.Read(0x4016))
+}
+
+// Test that reads keep returning A while the strobe is high
+func Test_Ports_StrobeHigh(t *testing.T) {
+	p := NewPorts()
+	p.One.SetButton(ButtonA, true)
+	p.Write(0x4016, 1)
+	assert.Equal(t, []uint8{1, 1, 1, 1, 1, 1, 1, 1}, readAll(p, 0x4016))
+}
+
+// Test that the second controller is read from $4017
+func Test_Ports_SecondController(t *testing.T) {
+	p := NewPorts()
+	p.Two.SetButton(ButtonB, true)
+	p.Write(0x4016, 1)
+	p.Write(0x4016, 0)
+	assert.Equal(t, []uint8{0, 1, 0, 0, 0, 0, 0, 0}, readAll(p, 0x4017))
+}
+
+// Test that a Four Score sends both of a port's pads and then its signature
+func Test_Ports_FourScore(t *testing.T) {
+	p := NewPorts()
+	p.PlugFourScore()
+	p.One.SetButton(ButtonA, true)
+	p.Three.SetButton(ButtonB, true)
+	p.Two.SetButton(ButtonStart, true)
+	p.Four.SetButton(ButtonRight, true)
+	p.Write(0x4016, 1)
+	p.Write(0x4016, 0)
+
+	one := append(append(readAll(p, 0x4016), readAll(p, 0x4016)...), readAll(p, 0x4016)...)
+	assert.Equal(t, []uint8{
+		1, 0, 0, 0, 0, 0, 0, 0,
+		0, 1, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 1, 0, 0, 0,
+	}, one)
+	two := append(append(readAll(p, 0x4017), readAll(p, 0x4017)...), readAll(p, 0x4017)...)
+	assert.Equal(t, []uint8{
+		0, 0, 0, 1, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 1,
+		0, 0, 0, 0, 0, 1, 0, 0,
+	}, two)
+	assert.Equal(t, uint8(1), p.Read(0x4016))
+
+	// Plugging the joypads back in drops the extra pads.
+	p.Plug(1, nil)
+	p.Write(0x4016, 1)
+	p.Write(0x4016, 0)
+	readAll(p, 0x4016)
+	assert.Equal(t, uint8(1), p.Read(0x4016))
+}
+
+// Test that the Famicom adapter reads pads 3 and 4 on D1 alongside pads 1 and 2
+func Test_Ports_FamicomAdapter(t *testing.T) {
+	p := NewPorts()
+	p.PlugExpansion(NewFamicomAdapter(p.Three, p.Four))
+	p.One.SetButton(ButtonA, true)
+	p.Three.SetButton(ButtonA, true)
+	p.Three.SetButton(ButtonB, true)
+	p.Four.SetButton(ButtonSelect, true)
+	p.Write(0x4016, 1)
+	p.Write(0x4016, 0)
+	assert.Equal(t, []uint8{3, 2, 0, 0, 0, 0, 0, 0}, readAll(p, 0x4016))
+	assert.Equal(t, []uint8{0, 0, 2, 0, 0, 0, 0, 0}, readAll(p, 0x4017))
+}
+
+// Test that the Vaus shifts out its knob's position inverted, most significant
+// bit first, with the fire button alongside
+func Test_Arkanoid_Serial(t *testing.T) {
+	p := NewPorts()
+	vaus := NewArkanoid()
+	p.Plug(2, vaus)
+	vaus.SetPosition(0xA5)
+	vaus.SetFire(true)
+	p.Write(0x4016, 1)
+	p.Write(0x4016, 0)
+	// Moving the knob after the strobe doesn't change what's shifted out.
+	vaus.SetPosition(ARKANOID_MAX)
+
+	var position uint8
+	for i := 0; i < 8; i++ {
+		data := p.Read(0x4017)
+		assert.Equal(t, uint8(ARKANOID_FIRE), data&ARKANOID_FIRE)
+		position = position<<1 | (^data>>4)&1
+	}
+	assert.Equal(t, uint8(0xA5), position)
+
+	vaus.Turn(0)
+	assert.Equal(t, uint8(ARKANOID_MIN), vaus.Position())
+	vaus.Turn(2)
+	assert.Equal(t, uint8(ARKANOID_MAX), vaus.Position())
+}
+
+// Test that the Famicom Vaus reads the fire button and knob on D1
+func Test_Arkanoid_Famicom(t *testing.T) {
+	p := NewPorts()
+	vaus := NewFamicomArkanoid()
+	p.PlugExpansion(vaus)
+	vaus.SetPosition(0x80)
+	p.Write(0x4016, 1)
+	p.Write(0x4016, 0)
+	assert.Equal(t, uint8(0), p.Read(0x4016)&0x02)
+	assert.Equal(t, []uint8{0, 2, 2, 2, 2, 2, 2, 2}, readAll(p, 0x4017))
+	vaus.SetFire(true)
+	assert.Equal(t, uint8(0x02), p.Read(0x4016)&0x02)
+}
+
+// Test scanning the keyboard's matrix the way Family BASIC does
+func Test_Keyboard_Matrix(t *testing.T) {
+	p := NewPorts()
+	keyboard := NewKeyboard(nil)
+	p.PlugExpansion(keyboard)
+	for _, name := range []string{"Return", "x", "space"} {
+		key, err := ParseKeyboardKey(name)
+		assert.NoError(t, err)
+		keyboard.SetKey(key, true)
+	}
+
+	var scan [KEYBOARD_ROWS][2]uint8
+	p.Write(0x4016, 0x05)
+	for row := range scan {
+		p.Write(0x4016, 0x04)
+		scan[row][0] = p.Read(0x4017) & KEYBOARD_KEYS
+		p.Write(0x4016, 0x06)
+		scan[row][1] = p.Read(0x4017) & KEYBOARD_KEYS
+	}
+	for row := range scan {
+		for column, bits := range scan[row] {
+			expected := uint8(KEYBOARD_KEYS)
+			switch {
+			case row == 0 && column == 0:
+				expected &^= 0x08 // Return
+			case row == 6 && column == 1:
+				expected &^= 0x10 // X
+			case row == 8 && column == 1:
+				expected &^= 0x08 // Space
+			}
+			assert.Equal(t, expected, bits, "row %d column %d", row, column)
+		}
+	}
+
+	// The matrix reads 0 while it's disabled.
+	p.Write(0x4016, 0x00)
+	assert.Equal(t, uint8(0), p.Read(0x4017)&KEYBOARD_KEYS)
+
+	_, err := ParseKeyboardKey("Meta")
+	assert.Error(t, err)
+}
+
+// clock is a CPU cycle counter for tests.
+type clock uint64
+
+func (c *clock) Cycles() uint64 {
+	return uint64(*c)
+}
+
+// Test that what's sent to the data recorder plays back from the tape
+func Test_DataRecorder_RoundTrip(t *testing.T) {
+	var cycles clock
+	const cpuClock = 1789773
+	recorder := NewDataRecorder(&cycles, cpuClock)
+	var tape seekBuffer
+	assert.NoError(t, recorder.Record(&tape))
+
+	// Send a millisecond high, a millisecond low, then high again.
+	levels := []bool{true, false, true}
+	for _, level := range levels {
+		recorder.Output(level)
+		cycles += cpuClock / 1000
+	}
+	assert.NoError(t, recorder.Close())
+
+	playback := NewDataRecorder(&cycles, cpuClock)
+	assert.NoError(t, playback.Load(bytes.NewReader(tape.data)))
+	// Playback starts the first time the tape is listened to.
+	start := cycles
+	assert.True(t, playback.Input())
+	for i, level := range levels {
+		// Check the middle of each millisecond.
+		cycles = start + clock(i*cpuClock/1000+cpuClock/2000)
+		assert.Equal(t, level, playback.Input(), "millisecond %d", i)
+	}
+	cycles += cpuClock
+	assert.False(t, playback.Input(), "past the end of the tape")
+}
+
+// seekBuffer is an in memory io.WriteSeeker.
+type seekBuffer struct {
+	data []byte
+	pos  int
+}
+
+func (b *seekBuffer) Write(p []byte) (int, error) {
+	if end := b.pos + len(p); end > len(b.data) {
+		b.data = append(b.data, make([]byte, end-len(b.data))...)
+	}
+	copy(b.data[b.pos:], p)
+	b.pos += len(p)
+	return len(p), nil
+}
+
+func (b *seekBuffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		b.pos = int(offset)
+	case io.SeekCurrent:
+		b.pos += int(offset)
+	case io.SeekEnd:
+		b.pos = len(b.data) + int(offset)
+	}
+	return int64(b.pos), nil
+}
@@ -0,0 +1,234 @@
+package joypad
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"switchtrue.com/hankee/wav"
+)
+
+const (
+	// Rows in the keyboard's matrix, each read as two columns of four keys.
+	KEYBOARD_ROWS = 9
+	// Bits of $4017 a column's keys are read on, clear while pressed.
+	KEYBOARD_KEYS = 0x1E
+	// Sample rate tapes are recorded at.
+	TAPE_SAMPLE_RATE = 44100
+	// Level of a high recorded bit, lows are the negative.
+	TAPE_LEVEL = 0x3000
+)
+
+// Names of the Family BASIC keyboard's keys by row, column and bit, from D1 up.
+var KEYBOARD_NAMES = [KEYBOARD_ROWS][2][4]string{
+	{{"]", "[", "Return", "F8"}, {"Stop", "Yen", "RShift", "Kana"}},
+	{{";", ":", "@", "F7"}, {"^", "-", "/", "_"}},
+	{{"K", "L", "O", "F6"}, {"0", "P", ",", "."}},
+	{{"J", "U", "I", "F5"}, {"8", "9", "N", "M"}},
+	{{"H", "G", "Y", "F4"}, {"6", "7", "V", "B"}},
+	{{"D", "R", "T", "F3"}, {"4", "5", "C", "F"}},
+	{{"A", "S", "W", "F2"}, {"3", "E", "Z", "X"}},
+	{{"Ctrl", "Q", "Esc", "F1"}, {"2", "1", "Grph", "LShift"}},
+	{{"Left", "Right", "Up", "ClrHome"}, {"Ins", "Del", "Space", "Down"}},
+}
+
+// KeyboardKey is a key's place in the matrix, row*8 + column*4 + bit.
+type KeyboardKey uint8
+
+func (k KeyboardKey) String() string {
+	return KEYBOARD_NAMES[k/8][k/4%2][k%4]
+}
+
+// Parses a key name as in KEYBOARD_NAMES, ignoring case.
+func ParseKeyboardKey(name string) (KeyboardKey, error) {
+	for row := range KEYBOARD_NAMES {
+		for column := range KEYBOARD_NAMES[row] {
+			for bit, n := range KEYBOARD_NAMES[row][column] {
+				if strings.EqualFold(n, name) {
+					return KeyboardKey(row*8 + column*4 + bit), nil
+				}
+			}
+		}
+	}
+	return 0, fmt.Errorf("unknown keyboard key %q", name)
+}
+
+// Keyboard is the Family BASIC keyboard on the Famicom's expansion port.
+// Writes to $4016 pick what's read from $4017: bit 0 goes back to the first
+// row, bit 1 picks the column, moving on a row each time it goes from 1 to 0,
+// and bit 2 enables the matrix. The data recorder plugged into it plays back
+// on D1 of $4016 reads and records bit 2 of writes.
+type Keyboard struct {
+	pressed  [KEYBOARD_ROWS * 8]bool
+	recorder *DataRecorder
+	row      int
+	column   uint8
+	enabled  bool
+}
+
+// Creates a keyboard with a data recorder, which can be nil for none.
+func NewKeyboard(recorder *DataRecorder) *Keyboard {
+	return &Keyboard{recorder: recorder}
+}
+
+func (k *Keyboard) SetKey(key KeyboardKey, pressed bool) {
+	k.pressed[key] = pressed
+}
+
+func (k *Keyboard) Key(key KeyboardKey) bool {
+	return k.pressed[key]
+}
+
+// Releases every key.
+func (k *Keyboard) Release() {
+	k.pressed = [KEYBOARD_ROWS * 8]bool{}
+}
+
+func (k *Keyboard) Recorder() *DataRecorder {
+	return k.recorder
+}
+
+func (k *Keyboard) Write(data uint8) {
+	column := data >> 1 & 1
+	if k.column == 1 && column == 0 {
+		k.row++
+	}
+	k.column = column
+	if data&1 != 0 {
+		k.row = 0
+	}
+	k.enabled = data&4 != 0
+	if k.recorder != nil {
+		k.recorder.Output(data&4 != 0)
+	}
+}
+
+func (k *Keyboard) Read(addr uint16) uint8 {
+	if addr == 0x4016 {
+		if k.recorder != nil && k.recorder.Input() {
+			return 0x02
+		}
+		return 0
+	}
+	if !k.enabled {
+		return 0
+	}
+	bits := uint8(KEYBOARD_KEYS)
+	if k.row < KEYBOARD_ROWS {
+		for bit := 0; bit < 4; bit++ {
+			if k.pressed[k.row*8+int(k.column)*4+bit] {
+				bits &^= 2 << bit
+			}
+		}
+	}
+	return bits
+}
+
+// Clock counts CPU cycles, which the data recorder keeps time by.
+type Clock interface {
+	Cycles() uint64
+}
+
+// DataRecorder is the cassette deck Family BASIC saves programs to. A loaded
+// tape starts playing the first time the program listens to it, and
+// everything the program sends is recorded as a square wave.
+type DataRecorder struct {
+	clock  Clock
+	rate   float64
+	tape   []int16
+	played bool
+	start  uint64
+
+	out     *wav.Writer
+	level   bool
+	written uint64
+	began   uint64
+	buf     []int16
+}
+
+// Creates a data recorder timed by a CPU running at cpuClock Hz.
+func NewDataRecorder(clock Clock, cpuClock float64) *DataRecorder {
+	return &DataRecorder{clock: clock, rate: cpuClock}
+}
+
+// Loads a tape from a WAV file, to play from the start.
+func (d *DataRecorder) Load(r io.Reader) error {
+	samples, sampleRate, err := wav.Read(r)
+	if err != nil {
+		return err
+	}
+	// Resample as it's loaded so playback is a lookup.
+	d.tape = make([]int16, int(float64(len(samples))*TAPE_SAMPLE_RATE/float64(sampleRate)))
+	for i := range d.tape {
+		d.tape[i] = samples[i*sampleRate/TAPE_SAMPLE_RATE]
+	}
+	d.played = false
+	return nil
+}
+
+// Returns the tape's sample under the head right now, past the end is silence.
+func (d *DataRecorder) Input() bool {
+	if d.tape == nil {
+		return false
+	}
+	if !d.played {
+		d.played = true
+		d.start = d.clock.Cycles()
+	}
+	i := d.sample(d.start)
+	return i < uint64(len(d.tape)) && d.tape[i] > 0
+}
+
+// Returns how many samples of the tape have gone by since a cycle.
+func (d *DataRecorder) sample(since uint64) uint64 {
+	return uint64(float64(d.clock.Cycles()-since) * TAPE_SAMPLE_RATE / d.rate)
+}
+
+// Starts recording to a WAV file.
+func (d *DataRecorder) Record(w io.WriteSeeker) error {
+	out, err := wav.NewWriter(w, TAPE_SAMPLE_RATE, 1)
+	if err != nil {
+		return err
+	}
+	d.out = out
+	d.began = d.clock.Cycles()
+	d.written = 0
+	return nil
+}
+
+// Sets the level going to the tape, writing out the old level up to now.
+func (d *DataRecorder) Output(level bool) {
+	if d.out == nil {
+		return
+	}
+	d.flush()
+	d.level = level
+}
+
+// Writes the current level to the tape up to now.
+func (d *DataRecorder) flush() {
+	now := d.sample(d.began)
+	sample := int16(-TAPE_LEVEL)
+	if d.level {
+		sample = TAPE_LEVEL
+	}
+	d.buf = d.buf[:0]
+	for ; d.written < now; d.written++ {
+		d.buf = append(d.buf, sample)
+	}
+	if len(d.buf) > 0 {
+		d.out.Write(d.buf)
+	}
+}
+
+// Finishes the recording, if there is one. The writer underneath is left
+// open.
+func (d *DataRecorder) Close() error {
+	if d.out == nil {
+		return nil
+	}
+	d.flush()
+	err := d.out.Close()
+	d.out = nil
+	return err
+}
@@ -0,0 +1,68 @@
+package joypad
+
+// Zapper bits on the data lines.
+const (
+	// Clear while the photodiode sees light.
+	ZAPPER_NO_LIGHT = 0x08
+	ZAPPER_TRIGGER  = 0x10
+)
+
+// LightSensor says whether there's light at a point on the screen right now,
+// which depends on where the beam is as much as what's drawn there.
+type LightSensor interface {
+	Light(x int, y int) bool
+}
+
+// Zapper is the NES light gun, usually in port 2. Games flash targets white
+// for a frame and read $4017 as the beam draws them to see whether the gun is
+// pointing at one.
+type Zapper struct {
+	sensor  LightSensor
+	x       int
+	y       int
+	aimed   bool
+	trigger bool
+}
+
+// Creates a Zapper seeing light through the sensor, aimed off the screen.
+func NewZapper(sensor LightSensor) *Zapper {
+	return &Zapper{sensor: sensor}
+}
+
+// Points the gun at a pixel, or off the screen if x or y is negative.
+func (z *Zapper) Aim(x int, y int) {
+	z.x, z.y = x, y
+	z.aimed = x >= 0 && y >= 0
+}
+
+// Returns where the gun points and whether that's at the screen at all.
+func (z *Zapper) Position() (int, int, bool) {
+	return z.x, z.y, z.aimed
+}
+
+func (z *Zapper) SetTrigger(pulled bool) {
+	z.trigger = pulled
+}
+
+func (z *Zapper) Trigger() bool {
+	return z.trigger
+}
+
+// Returns whether the gun sees light right now.
+func (z *Zapper) Light() bool {
+	return z.aimed && z.sensor.Light(z.x, z.y)
+}
+
+// The Zapper isn't strobed.
+func (z *Zapper) Write(data uint8) {}
+
+func (z *Zapper) Read() uint8 {
+	var bits uint8
+	if !z.Light() {
+		bits |= ZAPPER_NO_LIGHT
+	}
+	if z.trigger {
+		bits |= ZAPPER_TRIGGER
+	}
+	return bits
+}
@@ -0,0 +1,60 @@
+package nes
+
+import "switchtrue.com/hankee/video"
+
+// The colour the machine draws the whole picture in, since there's no PPU to
+// draw anything else.
+const BACKDROP = 0x0F
+
+// ppuRegisters stands in for the PPU's registers at $2000-$3FFF, mirrored
+// every 8 bytes, until there's a PPU. Only PPUMASK at $2001 does anything, its
+// greyscale and emphasis bits changing the colour the backdrop is drawn in.
+type ppuRegisters struct {
+	machine *Machine
+}
+
+func (r *ppuRegisters) Read(addr uint16) uint8 {
+	return 0
+}
+
+func (r *ppuRegisters) Write(addr uint16, data uint8) {
+	if addr&0x7 == 1 {
+		r.machine.setMask(data)
+	}
+}
+
+// Returns the last value written to PPUMASK.
+func (m *Machine) Mask() uint8 {
+	return m.mask
+}
+
+// Changes PPUMASK, first drawing the lines the beam has already passed with
+// the old value so a change part way down the screen shows there.
+func (m *Machine) setMask(mask uint8) {
+	scanline, _ := m.Beam()
+	m.drawTo(scanline)
+	m.mask = mask
+}
+
+// Puts PPUMASK back as it was when a state was saved. The lines the beam has
+// passed keep what was drawn, the rest of the frame is drawn with the mask.
+func (m *Machine) restoreMask(mask uint8) {
+	scanline, _ := m.Beam()
+	m.mask = mask
+	m.drawn = min(scanline, m.frame.Height)
+}
+
+// Draws the backdrop down to the given line of the picture.
+func (m *Machine) drawTo(line int) {
+	mask := m.mask
+	if m.region != NTSC {
+		mask = video.SwapEmphasis(mask)
+	}
+	pixel := video.Pixel(BACKDROP, mask)
+	for ; m.drawn < min(line, m.frame.Height); m.drawn++ {
+		row := m.frame.Pix[m.drawn*m.frame.Width : (m.drawn+1)*m.frame.Width]
+		for i := range row {
+			row[i] = pixel
+		}
+	}
+}
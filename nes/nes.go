@@ -0,0 +1,175 @@
+package nes
+
+import (
+	"switchtrue.com/hankee/apu"
+	"switchtrue.com/hankee/bus"
+	"switchtrue.com/hankee/cartridge"
+	"switchtrue.com/hankee/cpu"
+	"switchtrue.com/hankee/joypad"
+	"switchtrue.com/hankee/video"
+)
+
+// Machine is a complete NES, the CPU wired up to the bus with whatever
+// cartridge and devices have been plugged in.
+type Machine struct {
+	cpu     *cpu.CPU
+	bus     *bus.Bus
+	region  Region
+	timing  Timing
+	apu     *apu.APU
+	joypads *joypad.Ports
+
+	// The PPU isn't emulated yet so the frame is only ever the backdrop
+	// colour, as PPUMASK's greyscale and emphasis bits change it, but
+	// frontends can already be built around it.
+	frame *video.Frame
+	clock FrameClock
+	mask  uint8
+	// Lines of the frame drawn so far, see drawTo.
+	drawn int
+	// The colours being shown, which light guns judge brightness by.
+	palette *video.Palette
+}
+
+func New() *Machine {
+	b := bus.New()
+	m := &Machine{
+		cpu:     cpu.New(b),
+		bus:     b,
+		timing:  NTSC.Timing(),
+		joypads: joypad.NewPorts(),
+		frame:   video.NewFrame(video.WIDTH, video.HEIGHT),
+		palette: &video.DefaultPalette,
+	}
+	m.frame.Fill(BACKDROP)
+	m.clock = NewFrameClock(m.timing, 0)
+	m.apu = apu.New(m.timing.APU(), m.cpu)
+	b.Attach(0x2000, 0x3FFF, &ppuRegisters{machine: m})
+	b.Attach(0x4000, 0x4015, m.apu)
+	b.Attach(0x4016, 0x4017, &ioPorts{joypads: m.joypads, apu: m.apu})
+	return m
+}
+
+// ioPorts are $4016 and $4017, where the controller ports share $4017 with
+// the APU frame counter, which takes the writes.
+type ioPorts struct {
+	joypads *joypad.Ports
+	apu     *apu.APU
+}
+
+func (p *ioPorts) Read(addr uint16) uint8 {
+	return p.joypads.Read(addr)
+}
+
+func (p *ioPorts) Write(addr uint16, data uint8) {
+	if addr == 0x4017 {
+		p.apu.Write(addr, data)
+		return
+	}
+	p.joypads.Write(addr, data)
+}
+
+func (m *Machine) CPU() *cpu.CPU {
+	return m.cpu
+}
+
+func (m *Machine) Bus() *bus.Bus {
+	return m.bus
+}
+
+func (m *Machine) Region() Region {
+	return m.region
+}
+
+// Switches the machine to a region's timing, starting a new frame.
+func (m *Machine) SetRegion(region Region) {
+	m.region = region
+	m.timing = region.Timing()
+	m.clock = NewFrameClock(m.timing, m.cpu.Cycles())
+	m.drawn = 0
+	m.apu.SetTiming(m.timing.APU())
+}
+
+func (m *Machine) Timing() Timing {
+	return m.timing
+}
+
+// Returns the CPU cycle the next frame starts on.
+func (m *Machine) FrameEnd() uint64 {
+	return m.clock.End()
+}
+
+// Moves on to the next frame. StepFrame does this itself, frontends that run
+// the CPU themselves call it once the CPU reaches FrameEnd.
+func (m *Machine) EndFrame() {
+	m.drawTo(m.frame.Height)
+	m.drawn = 0
+	m.clock.Next()
+	m.apu.Run()
+}
+
+func (m *Machine) APU() *apu.APU {
+	return m.apu
+}
+
+// Returns the controllers plugged into the two ports.
+func (m *Machine) Joypads() *joypad.Ports {
+	return m.joypads
+}
+
+// Returns the most recent picture.
+func (m *Machine) Frame() *video.Frame {
+	return m.frame
+}
+
+// Sets the colours the frame is shown in, for anything in the machine that
+// looks at the picture, like the Zapper.
+func (m *Machine) SetPalette(palette *video.Palette) {
+	m.palette = palette
+}
+
+func (m *Machine) Palette() *video.Palette {
+	return m.palette
+}
+
+// Plugs in a cartridge and resets the machine so it starts from the
+// cartridge's reset vector.
+func (m *Machine) InsertCartridge(cart *cartridge.Cartridge) {
+	m.bus.InsertCartridge(cart)
+	m.Reset()
+}
+
+// Attaches a device to the inclusive address range start-end.
+func (m *Machine) Attach(start uint16, end uint16, device bus.Device) {
+	m.bus.Attach(start, end, device)
+}
+
+func (m *Machine) Reset() {
+	m.cpu.Reset()
+	m.apu.Reset()
+	m.clock = NewFrameClock(m.timing, m.cpu.Cycles())
+	m.mask = 0
+	m.drawn = 0
+}
+
+// Executes a single instruction, returning false once the CPU hits BRK.
+func (m *Machine) Step() bool {
+	return m.cpu.Step()
+}
+
+// Runs until the CPU has used up a frame's worth of cycles for the region.
+// Returns false if the CPU hit BRK part way through.
+func (m *Machine) StepFrame() bool {
+	for end := m.clock.End(); m.cpu.Cycles() < end; {
+		if !m.cpu.Step() {
+			return false
+		}
+	}
+	m.EndFrame()
+	return true
+}
+
+// Runs until the CPU hits BRK.
+func (m *Machine) Run() {
+	m.cpu.Run()
+}
@@ -0,0 +1,288 @@
+package nes
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"switchtrue.com/hankee/cartridge"
+	"switchtrue.com/hankee/joypad"
+	"switchtrue.com/hankee/video"
+)
+
+// Builds an NROM-128 cartridge with the program at $8000 and the reset vector
+// pointing at it.
+func newCartridge(t *testing.T, program []uint8) *cartridge.Cartridge {
+	raw := []uint8{'N', 'E', 'S', 0x1A, 1, 1, 0b0000_0010, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	prg := make([]uint8, cartridge.PRG_ROM_PAGE_SIZE)
+	copy(prg, program)
+	prg[0x3FFC] = 0x00
+	prg[0x3FFD] = 0x80
+	raw = append(raw, prg...)
+	raw = append(raw, make([]uint8, cartridge.CHR_ROM_PAGE_SIZE)...)
+
+	cart, err := cartridge.Load(raw)
+	assert.NoError(t, err)
+	return cart
+}
+
+// INC $10; INC $6000; JMP $8000
+var counter = []uint8{0xE6, 0x10, 0xEE, 0x00, 0x60, 0x4C, 0x00, 0x80}
+
+// Test that a frame runs for a frame's worth of cycles
+func Test_Machine_StepFrame(t *testing.T) {
+	m := New()
+	m.InsertCartridge(newCartridge(t, counter))
+	assert.Equal(t, uint16(0x8000), m.CPU().Registers().PC)
+
+	assert.True(t, m.StepFrame())
+	// The reset takes 7 cycles and the last instruction can run over by up to
+	// 6 more.
+	assert.GreaterOrEqual(t, m.CPU().Cycles(), uint64(7+29781))
+	assert.Less(t, m.CPU().Cycles(), uint64(7+29781+7))
+
+	// PAL frames are longer, Dendy ones longer still.
+	m.SetRegion(PAL)
+	start := m.CPU().Cycles()
+	assert.True(t, m.StepFrame())
+	assert.GreaterOrEqual(t, m.CPU().Cycles()-start, uint64(33248))
+	assert.Less(t, m.CPU().Cycles()-start, uint64(33248+7))
+}
+
+// Test the frame lengths and rates of each region
+func Test_Region_Timing(t *testing.T) {
+	ntsc, pal, dendy := NTSC.Timing(), PAL.Timing(), Dendy.Timing()
+	assert.Equal(t, 262, ntsc.Scanlines())
+	assert.Equal(t, 312, pal.Scanlines())
+	assert.Equal(t, 312, dendy.Scanlines())
+
+	assert.Equal(t, 29780.5, ntsc.CyclesPerFrame())
+	assert.Equal(t, 33247.5, pal.CyclesPerFrame())
+	assert.Equal(t, 35464.0, dendy.CyclesPerFrame())
+	assert.InDelta(t, 60.0988, ntsc.FrameRate(), 0.0001)
+	assert.InDelta(t, 50.0070, pal.FrameRate(), 0.0001)
+	assert.InDelta(t, 1789773, ntsc.CPUClock(), 1)
+	assert.InDelta(t, 1662607, pal.CPUClock(), 1)
+	assert.InDelta(t, 1773447, dendy.CPUClock(), 1)
+	assert.Equal(t, uint16(4068), ntsc.NoisePeriods[15])
+	assert.Equal(t, uint16(3778), pal.NoisePeriods[15])
+
+	// The half cycles add up rather than being rounded away each frame.
+	clock := NewFrameClock(ntsc, 0)
+	assert.Equal(t, uint64(29781), clock.End())
+	clock.Next()
+	assert.Equal(t, uint64(59561), clock.End())
+}
+
+// Test that loading a state puts back the CPU, RAM, cartridge RAM and pads
+func Test_Machine_SaveState(t *testing.T) {
+	m := New()
+	m.InsertCartridge(newCartridge(t, counter))
+	m.StepFrame()
+	m.Joypads().One.SetButtons(joypad.ButtonStart)
+	state := m.SaveState()
+	ram := m.Bus().Read(0x10)
+	prgRAM := m.Bus().Read(0x6000)
+	cycles := m.CPU().Cycles()
+
+	m.StepFrame()
+	m.Joypads().One.SetButtons(0)
+	assert.NotEqual(t, ram, m.Bus().Read(0x10))
+
+	assert.NoError(t, m.LoadState(state))
+	assert.Equal(t, ram, m.Bus().Read(0x10))
+	assert.Equal(t, prgRAM, m.Bus().Read(0x6000))
+	assert.Equal(t, cycles, m.CPU().Cycles())
+	assert.Equal(t, joypad.ButtonStart, m.Joypads().One.Buttons())
+
+	// A restored machine runs on exactly as the original did.
+	m.StepFrame()
+	after := m.SaveState()
+	assert.NoError(t, m.LoadState(state))
+	m.StepFrame()
+	assert.Equal(t, after, m.SaveState())
+}
+
+// Test that states survive encoding and bad data is refused
+func Test_State_Binary(t *testing.T) {
+	m := New()
+	m.InsertCartridge(newCartridge(t, counter))
+	m.StepFrame()
+	state := m.SaveState()
+
+	data, err := state.MarshalBinary()
+	assert.NoError(t, err)
+	var decoded State
+	assert.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, state, &decoded)
+
+	assert.Error(t, decoded.UnmarshalBinary([]byte("nope")))
+	assert.Error(t, decoded.UnmarshalBinary(data[:len(data)-1]))
+	data[len(STATE_TAG)] = 99
+	assert.Error(t, decoded.UnmarshalBinary(data))
+
+	// Cartridge RAM has to match the inserted cartridge.
+	assert.Error(t, m.LoadState(&State{PRGRAM: []uint8{1}}))
+	assert.Error(t, New().LoadState(state))
+}
+
+// Test that a save state carries the APU, so the channel and frame interrupt
+// status read back from $4015 is the one saved rather than whatever the APU
+// was doing before the load
+func Test_Machine_StateAPU(t *testing.T) {
+	m := New()
+	cart := newCartridge(t, counter)
+	m.InsertCartridge(cart)
+	playTone(m)
+	m.StepFrame()
+	m.StepFrame()
+	state := m.SaveState()
+	data, err := state.MarshalBinary()
+	require.NoError(t, err)
+
+	// Silence the APU and acknowledge the interrupt, then go back.
+	assert.Equal(t, uint8(0x41), m.Bus().Read(0x4015))
+	m.Bus().Write(0x4015, 0)
+	m.StepFrame()
+	require.NoError(t, m.LoadState(state))
+	assert.Equal(t, uint8(0x41), m.Bus().Read(0x4015))
+
+	// A fresh machine loading the file gets the same.
+	var decoded State
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, state, &decoded)
+	other := New()
+	other.InsertCartridge(cart)
+	require.NoError(t, other.LoadState(&decoded))
+	assert.Equal(t, uint8(0x41), other.Bus().Read(0x4015))
+	assert.Equal(t, m.SaveState(), other.SaveState())
+	m.APU().Samples()
+	m.StepFrame()
+	other.StepFrame()
+	assert.Equal(t, m.APU().Samples(), other.APU().Samples())
+
+	assert.Error(t, decoded.UnmarshalBinary(append(data, 0)))
+}
+
+// Test that PPUMASK greyscale and emphasis change the backdrop from the line
+// the beam is on when they're written
+func Test_Machine_Mask(t *testing.T) {
+	m := New()
+	// LDA #$21; STA $2009 (a mirror of $2001); JMP $8005
+	m.InsertCartridge(newCartridge(t, []uint8{0xA9, 0x21, 0x8D, 0x09, 0x20, 0x4C, 0x05, 0x80}))
+	m.StepFrame()
+	assert.Equal(t, uint8(0x21), m.Mask())
+	for _, pixel := range m.Frame().Pix {
+		require.Equal(t, video.Pixel(0x0F, 0x21), pixel)
+	}
+
+	// Red emphasis from line 100 down, which is green on PAL.
+	m.SetRegion(PAL)
+	m.Bus().Write(0x2001, 0x00)
+	m.CPU().SetCycles(m.CPU().Cycles() + uint64((100*DOTS_PER_SCANLINE*5+15)/16))
+	m.Bus().Write(0x2001, 0x20)
+	m.EndFrame()
+	assert.Equal(t, uint16(0x0F), m.Frame().At(0, 99))
+	assert.Equal(t, uint16(0x8F), m.Frame().At(0, 100))
+	assert.Equal(t, uint16(0x8F), m.Frame().At(255, 239))
+
+	state := m.SaveState()
+	m.Reset()
+	assert.Zero(t, m.Mask())
+	require.NoError(t, m.LoadState(state))
+	assert.Equal(t, uint8(0x20), m.Mask())
+}
+
+// Test that the Zapper sees a white target only once the beam has drawn it
+func Test_Zapper(t *testing.T) {
+	m := New()
+	zapper := joypad.NewZapper(m)
+	m.Joypads().Plug(2, zapper)
+	for y := 100; y < 110; y++ {
+		for x := 120; x < 130; x++ {
+			m.Frame().Set(x, y, 0x30)
+		}
+	}
+	// Returns $4017 with the beam at the start of a scanline.
+	read := func(scanline int) uint8 {
+		m.CPU().SetCycles(uint64(scanline * DOTS_PER_SCANLINE * 4 / 12))
+		return m.Bus().Read(0x4017)
+	}
+
+	zapper.Aim(125, 105)
+	assert.Equal(t, uint8(joypad.ZAPPER_NO_LIGHT), read(90), "not drawn yet")
+	assert.Equal(t, uint8(0), read(106))
+	assert.Equal(t, uint8(joypad.ZAPPER_NO_LIGHT), read(140), "the photodiode has settled")
+
+	// Brightness is judged in the colours being shown.
+	dark := video.DefaultPalette
+	dark[0x30] = color.RGBA{0x20, 0x20, 0x20, 0xFF}
+	m.SetPalette(&dark)
+	assert.Equal(t, uint8(joypad.ZAPPER_NO_LIGHT), read(106))
+	m.SetPalette(&video.DefaultPalette)
+
+	zapper.Aim(20, 105)
+	zapper.SetTrigger(true)
+	assert.Equal(t, uint8(joypad.ZAPPER_NO_LIGHT|joypad.ZAPPER_TRIGGER), read(106))
+	zapper.Aim(-1, -1)
+	assert.False(t, zapper.Light())
+
+	m.Joypads().Plug(2, nil)
+	assert.Equal(t, joypad.Device(m.Joypads().Two), m.Joypads().Device(2))
+}
+
+// Starts a square wave on pulse 1 so the APU has something to put back.
+func playTone(m *Machine) {
+	m.Bus().Write(0x4015, 0x01)
+	m.Bus().Write(0x4000, 0xBF)
+	m.Bus().Write(0x4002, 0xFD)
+	m.Bus().Write(0x4003, 0x00)
+}
+
+// Test that loading a snapshot puts back the machine and its sound, dropping
+// the samples made since
+func Test_Machine_Snapshot(t *testing.T) {
+	m := New()
+	m.InsertCartridge(newCartridge(t, counter))
+	playTone(m)
+	m.StepFrame()
+	m.APU().Samples()
+	m.StepFrame()
+
+	var s Snapshot
+	m.SaveSnapshot(&s)
+	state := m.SaveState()
+	samples := len(m.APU().Samples())
+	assert.NotZero(t, samples)
+	assert.Equal(t, state, s.State())
+
+	m.StepFrame()
+	after := m.SaveState()
+	sound := m.APU().Samples()
+	assert.NotEqual(t, state, after)
+
+	// Taking the samples again drops them, so restore a second time to check
+	// they're cut back rather than kept.
+	m.LoadSnapshot(&s)
+	m.StepFrame()
+	m.LoadSnapshot(&s)
+	assert.Equal(t, state, m.SaveState())
+	assert.Empty(t, m.APU().Samples())
+
+	m.StepFrame()
+	assert.Equal(t, after, m.SaveState())
+	assert.Equal(t, sound, m.APU().Samples())
+}
+
+func Benchmark_Machine_Snapshot(b *testing.B) {
+	m := New()
+	m.InsertCartridge(newCartridge(&testing.T{}, counter))
+	m.StepFrame()
+	var s Snapshot
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m.SaveSnapshot(&s)
+		m.LoadSnapshot(&s)
+	}
+}
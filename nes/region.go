@@ -0,0 +1,221 @@
+package nes
+
+import (
+	"fmt"
+	"strings"
+
+	"switchtrue.com/hankee/apu"
+	"switchtrue.com/hankee/cartridge"
+)
+
+// Region is the TV system the console was built for.
+type Region int
+
+const (
+	NTSC Region = iota
+	PAL
+	// Dendy is the family of Famicom clones sold in Russia and elsewhere that
+	// run PAL video with NTSC-like CPU timing.
+	Dendy
+)
+
+func (r Region) String() string {
+	switch r {
+	case NTSC:
+		return "ntsc"
+	case PAL:
+		return "pal"
+	case Dendy:
+		return "dendy"
+	default:
+		return fmt.Sprintf("Region(%d)", int(r))
+	}
+}
+
+// Parses a region name as accepted on the command line.
+func ParseRegion(name string) (Region, error) {
+	switch strings.ToLower(name) {
+	case "ntsc":
+		return NTSC, nil
+	case "pal":
+		return PAL, nil
+	case "dendy":
+		return Dendy, nil
+	default:
+		return NTSC, fmt.Errorf("unknown region %q, expected ntsc, pal or dendy", name)
+	}
+}
+
+// Picks the region a cartridge was made for from its header. Multi-region
+// games run as NTSC.
+func RegionFor(cart *cartridge.Cartridge) Region {
+	switch cart.Header.Timing {
+	case cartridge.TimingPAL:
+		return PAL
+	case cartridge.TimingDendy:
+		return Dendy
+	default:
+		return NTSC
+	}
+}
+
+// Visible scanlines are the same in every region, the others vary.
+const VISIBLE_SCANLINES = 240
+
+// PPU dots per scanline.
+const DOTS_PER_SCANLINE = 341
+
+// Timing is how a region's console is clocked. The CPU and PPU both divide
+// down the master clock and the APU tables are in CPU cycles.
+type Timing struct {
+	// Master clock crystal frequency in Hz.
+	MasterClock int
+	CPUDivider  int
+	PPUDivider  int
+	// Idle lines after the picture before vblank starts, and the length of
+	// vblank, where games do their PPU updates. These only set the frame's
+	// length and the beam's position, since without a PPU nothing raises
+	// the vblank flag or NMI.
+	PostRenderScanlines int
+	VBlankScanlines     int
+	// NTSC PPUs skip a dot on odd frames when rendering, making frames half
+	// a dot shorter on average.
+	SkipsOddDot bool
+	// CPU cycles after the frame counter was reset at which it clocks the
+	// envelopes, sweeps and length counters. The 4 step sequence uses the
+	// first four and the 5 step sequence all five.
+	FrameCounterSteps [5]int
+	// Noise channel periods and DMC rates in CPU cycles, indexed by the value
+	// written to $400E and $4010.
+	NoisePeriods [16]uint16
+	DMCRates     [16]uint16
+}
+
+var ntscNoisePeriods = [16]uint16{4, 8, 16, 32, 64, 96, 128, 160, 202, 254, 380, 508, 762, 1016, 2034, 4068}
+var ntscDMCRates = [16]uint16{428, 380, 340, 320, 286, 254, 226, 214, 190, 160, 142, 128, 106, 84, 72, 54}
+
+var timings = map[Region]Timing{
+	// 2A03 and 2C02.
+	NTSC: {
+		MasterClock:         21_477_272,
+		CPUDivider:          12,
+		PPUDivider:          4,
+		PostRenderScanlines: 1,
+		VBlankScanlines:     20,
+		SkipsOddDot:         true,
+		FrameCounterSteps:   [5]int{7457, 14913, 22371, 29829, 37281},
+		NoisePeriods:        ntscNoisePeriods,
+		DMCRates:            ntscDMCRates,
+	},
+	// 2A07 and 2C07, with a slower CPU, a longer vblank and retuned APU
+	// tables so music plays in tune.
+	PAL: {
+		MasterClock:         26_601_712,
+		CPUDivider:          16,
+		PPUDivider:          5,
+		PostRenderScanlines: 1,
+		VBlankScanlines:     70,
+		FrameCounterSteps:   [5]int{8313, 16627, 24939, 33253, 41565},
+		NoisePeriods:        [16]uint16{4, 8, 14, 30, 60, 88, 118, 148, 188, 236, 354, 472, 708, 944, 1890, 3778},
+		DMCRates:            [16]uint16{398, 354, 316, 298, 276, 236, 210, 198, 176, 148, 132, 118, 98, 78, 66, 50},
+	},
+	// Dendy clones pair the PAL crystal with a faster CPU divider and NTSC's
+	// APU and vblank length, padding the frame out with post-render lines so
+	// NTSC games run at nearly their normal speed.
+	Dendy: {
+		MasterClock:         26_601_712,
+		CPUDivider:          15,
+		PPUDivider:          5,
+		PostRenderScanlines: 51,
+		VBlankScanlines:     20,
+		FrameCounterSteps:   [5]int{7457, 14913, 22371, 29829, 37281},
+		NoisePeriods:        ntscNoisePeriods,
+		DMCRates:            ntscDMCRates,
+	},
+}
+
+// Returns the region's timing, NTSC's for unknown regions.
+func (r Region) Timing() Timing {
+	if t, ok := timings[r]; ok {
+		return t
+	}
+	return timings[NTSC]
+}
+
+// Returns the parts of the timing the APU needs.
+func (t Timing) APU() apu.Timing {
+	return apu.Timing{
+		CPUClock:          t.CPUClock(),
+		FrameCounterSteps: t.FrameCounterSteps,
+		NoisePeriods:      t.NoisePeriods,
+		DMCRates:          t.DMCRates,
+	}
+}
+
+// Returns the scanlines in a frame, including the pre-render line.
+func (t Timing) Scanlines() int {
+	return VISIBLE_SCANLINES + t.PostRenderScanlines + t.VBlankScanlines + 1
+}
+
+// Returns the length of a frame in master clock cycles, averaged over an odd
+// and even frame.
+func (t Timing) FrameClocks() uint64 {
+	clocks := uint64(t.Scanlines() * DOTS_PER_SCANLINE * t.PPUDivider)
+	if t.SkipsOddDot {
+		clocks -= uint64(t.PPUDivider / 2)
+	}
+	return clocks
+}
+
+// Returns the CPU frequency in Hz.
+func (t Timing) CPUClock() float64 {
+	return float64(t.MasterClock) / float64(t.CPUDivider)
+}
+
+// Returns the average number of CPU cycles in a frame, 29780.5 on NTSC.
+func (t Timing) CyclesPerFrame() float64 {
+	return float64(t.FrameClocks()) / float64(t.CPUDivider)
+}
+
+// Returns frames per second, about 60.1 on NTSC and 50 on PAL and Dendy.
+func (t Timing) FrameRate() float64 {
+	return float64(t.MasterClock) / float64(t.FrameClocks())
+}
+
+// FrameClock says where frames end in CPU cycles. Frames aren't a whole number
+// of CPU cycles, so it counts master clock cycles to keep the fractions from
+// drifting.
+type FrameClock struct {
+	timing Timing
+	// Master clock cycle the current frame ends on.
+	end uint64
+}
+
+// Starts a frame clock with a frame beginning at the given CPU cycle.
+func NewFrameClock(timing Timing, cycles uint64) FrameClock {
+	return FrameClock{timing: timing, end: cycles*uint64(timing.CPUDivider) + timing.FrameClocks()}
+}
+
+// Returns the first CPU cycle of the next frame.
+func (f *FrameClock) End() uint64 {
+	divider := uint64(f.timing.CPUDivider)
+	return (f.end + divider - 1) / divider
+}
+
+// Returns where the PPU's beam is at a CPU cycle in the frame, the scanline
+// counting from the top of the picture and the dot along it. Frames start at
+// the top of the picture, with the vblank lines at the end.
+func (f *FrameClock) Beam(cycles uint64) (scanline int, dot int) {
+	start := f.end - f.timing.FrameClocks()
+	master := cycles * uint64(f.timing.CPUDivider)
+	if master < start {
+		return 0, 0
+	}
+	dots := int((master - start) / uint64(f.timing.PPUDivider))
+	return dots / DOTS_PER_SCANLINE, dots % DOTS_PER_SCANLINE
+}
+
+// Moves on to the next frame.
+func (f *FrameClock) Next() {
+	f.end += f.timing.FrameClocks()
+}
@@ -0,0 +1,68 @@
+package nes
+
+import (
+	"switchtrue.com/hankee/apu"
+	"switchtrue.com/hankee/bus"
+	"switchtrue.com/hankee/cpu"
+	"switchtrue.com/hankee/joypad"
+)
+
+// Snapshot is an in-memory copy of a running machine for going back a few
+// frames, as netplay does many times a second. Unlike State it can be taken
+// over and over without allocating, but it can't be saved to a file. There's no PPU state because there's no PPU yet,
+// and no mapper state beyond cartridge RAM because NROM has no registers.
+// Both belong here once they exist.
+type Snapshot struct {
+	registers cpu.Registers
+	cycles    uint64
+	clock     FrameClock
+	mask      uint8
+	ram       [bus.RAM_SIZE]uint8
+	prgRAM    []uint8
+	joypads   joypad.PortsState
+	apu       apu.State
+}
+
+// Copies the machine into a snapshot, reusing the snapshot's memory.
+func (m *Machine) SaveSnapshot(s *Snapshot) {
+	s.registers = m.cpu.Registers()
+	s.cycles = m.cpu.Cycles()
+	s.clock = m.clock
+	s.mask = m.mask
+	copy(s.ram[:], m.bus.RAM())
+	s.prgRAM = s.prgRAM[:0]
+	if cart := m.bus.Cartridge(); cart != nil {
+		s.prgRAM = append(s.prgRAM, cart.PRGRAM...)
+	}
+	s.joypads = m.joypads.State()
+	m.apu.Save(&s.apu)
+}
+
+// Puts the machine back to a snapshot taken from it with SaveSnapshot.
+// Sound made since the snapshot that hasn't been collected is dropped.
+func (m *Machine) LoadSnapshot(s *Snapshot) {
+	m.cpu.SetRegisters(s.registers)
+	m.cpu.SetCycles(s.cycles)
+	m.clock = s.clock
+	m.restoreMask(s.mask)
+	copy(m.bus.RAM(), s.ram[:])
+	if cart := m.bus.Cartridge(); cart != nil {
+		copy(cart.PRGRAM, s.prgRAM)
+	}
+	m.joypads.SetState(s.joypads)
+	m.apu.Restore(&s.apu)
+}
+
+// Returns the part of the snapshot a State holds, for hashing or saving.
+func (s *Snapshot) State() *State {
+	return &State{
+		Registers: s.registers,
+		Cycles:    s.cycles,
+		FrameEnd:  s.clock.end,
+		Mask:      s.mask,
+		RAM:       s.ram,
+		Joypads:   s.joypads,
+		PRGRAM:    append([]uint8(nil), s.prgRAM...),
+		APU:       s.apu,
+	}
+}
@@ -0,0 +1,157 @@
+package nes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"switchtrue.com/hankee/apu"
+	"switchtrue.com/hankee/bus"
+	"switchtrue.com/hankee/cpu"
+	"switchtrue.com/hankee/joypad"
+)
+
+// Save states start with this tag and a version byte, which goes up whenever
+// the layout changes so old states are refused rather than misread.
+var STATE_TAG = []byte("HNKS")
+
+const STATE_VERSION = 5
+
+// State is a snapshot of everything that changes while a machine runs. The
+// cartridge ROM isn't included, so a state can only be loaded into a machine
+// with the same cartridge inserted.
+type State struct {
+	Registers cpu.Registers
+	Cycles    uint64
+	// Master clock cycle the current frame ends on.
+	FrameEnd uint64
+	// The last value written to PPUMASK.
+	Mask    uint8
+	RAM     [bus.RAM_SIZE]uint8
+	Joypads joypad.PortsState
+	PRGRAM  []uint8
+	APU     apu.State
+}
+
+// Takes a snapshot of the machine.
+func (m *Machine) SaveState() *State {
+	state := &State{
+		Registers: m.cpu.Registers(),
+		Cycles:    m.cpu.Cycles(),
+		FrameEnd:  m.clock.end,
+		Mask:      m.mask,
+		Joypads:   m.joypads.State(),
+	}
+	copy(state.RAM[:], m.bus.RAM())
+	if cart := m.bus.Cartridge(); cart != nil {
+		state.PRGRAM = append([]uint8(nil), cart.PRGRAM...)
+	}
+	m.apu.Save(&state.APU)
+	return state
+}
+
+// Restores a snapshot taken with SaveState.
+func (m *Machine) LoadState(state *State) error {
+	cart := m.bus.Cartridge()
+	switch {
+	case cart == nil && len(state.PRGRAM) != 0:
+		return errors.New("state has cartridge RAM but there's no cartridge")
+	case cart != nil && len(state.PRGRAM) != len(cart.PRGRAM):
+		return fmt.Errorf("state has %d bytes of cartridge RAM, the cartridge has %d", len(state.PRGRAM), len(cart.PRGRAM))
+	}
+
+	m.cpu.SetRegisters(state.Registers)
+	m.cpu.SetCycles(state.Cycles)
+	m.clock.end = state.FrameEnd
+	m.restoreMask(state.Mask)
+	copy(m.bus.RAM(), state.RAM[:])
+	m.joypads.SetState(state.Joypads)
+	if cart != nil {
+		copy(cart.PRGRAM, state.PRGRAM)
+	}
+	m.apu.Restore(&state.APU)
+	return nil
+}
+
+// The fixed size part of a state, written with encoding/binary.
+type stateHeader struct {
+	Registers cpu.Registers
+	Cycles    uint64
+	FrameEnd  uint64
+	Mask      uint8
+	RAM       [bus.RAM_SIZE]uint8
+	Joypads   joypad.PortsState
+	PRGRAMLen uint32
+	APULen    uint32
+}
+
+// Encodes the state for saving to a file.
+func (state *State) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(STATE_TAG)
+	buf.WriteByte(STATE_VERSION)
+	apuState, err := state.APU.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	header := stateHeader{
+		Registers: state.Registers,
+		Cycles:    state.Cycles,
+		FrameEnd:  state.FrameEnd,
+		Mask:      state.Mask,
+		RAM:       state.RAM,
+		Joypads:   state.Joypads,
+		PRGRAMLen: uint32(len(state.PRGRAM)),
+		APULen:    uint32(len(apuState)),
+	}
+	if err := binary.Write(&buf, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+	buf.Write(state.PRGRAM)
+	buf.Write(apuState)
+	return buf.Bytes(), nil
+}
+
+// Decodes a state written by MarshalBinary.
+func (state *State) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, STATE_TAG) || len(data) <= len(STATE_TAG) {
+		return errors.New("not a save state")
+	}
+	if version := data[len(STATE_TAG)]; version != STATE_VERSION {
+		return fmt.Errorf("save state version %d is not supported", version)
+	}
+
+	r := bytes.NewReader(data[len(STATE_TAG)+1:])
+	var header stateHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return fmt.Errorf("save state is truncated: %w", err)
+	}
+	switch size := int(header.PRGRAMLen) + int(header.APULen); {
+	case size > r.Len():
+		return errors.New("save state is truncated")
+	case size < r.Len():
+		return errors.New("save state has trailing data")
+	}
+	prgRAM := make([]uint8, header.PRGRAMLen)
+	if _, err := io.ReadFull(r, prgRAM); err != nil {
+		return fmt.Errorf("save state is truncated: %w", err)
+	}
+	var apuState apu.State
+	if err := apuState.UnmarshalBinary(data[len(data)-r.Len():]); err != nil {
+		return fmt.Errorf("save state: %w", err)
+	}
+
+	*state = State{
+		Registers: header.Registers,
+		Cycles:    header.Cycles,
+		FrameEnd:  header.FrameEnd,
+		Mask:      header.Mask,
+		RAM:       header.RAM,
+		Joypads:   header.Joypads,
+		PRGRAM:    prgRAM,
+		APU:       apuState,
+	}
+	return nil
+}
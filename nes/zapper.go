@@ -0,0 +1,40 @@
+package nes
+
+const (
+	// Scanlines the Zapper's photodiode keeps reporting light for after the
+	// beam passes something bright.
+	ZAPPER_LIGHT_SCANLINES = 20
+	// Pixels either side of where the Zapper points that it sees.
+	ZAPPER_RADIUS = 2
+	// Average of red, green and blue that's bright enough to trigger it.
+	ZAPPER_BRIGHTNESS = 85
+)
+
+// Returns where the PPU's beam is, see FrameClock.Beam.
+func (m *Machine) Beam() (scanline int, dot int) {
+	return m.clock.Beam(m.cpu.Cycles())
+}
+
+// Returns whether a Zapper pointed at x, y would see light now, which it does
+// for a while after the beam draws something bright near there, in the colours
+// of the machine's palette. Targets are blocked on the PPU: until one draws
+// the frame as the beam moves there's only the backdrop, so games can't see
+// them.
+func (m *Machine) Light(x int, y int) bool {
+	if x < 0 || y < 0 || x >= m.frame.Width || y >= m.frame.Height {
+		return false
+	}
+	scanline, dot := m.Beam()
+	if scanline < y || (scanline == y && dot <= x) || scanline-y >= ZAPPER_LIGHT_SCANLINES {
+		return false
+	}
+	for py := max(y-ZAPPER_RADIUS, 0); py <= min(y+ZAPPER_RADIUS, m.frame.Height-1); py++ {
+		for px := max(x-ZAPPER_RADIUS, 0); px <= min(x+ZAPPER_RADIUS, m.frame.Width-1); px++ {
+			c := m.palette.Color(m.frame.At(px, py))
+			if (int(c.R)+int(c.G)+int(c.B))/3 >= ZAPPER_BRIGHTNESS {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,463 @@
+// Package netplay lets two players on different machines play together over
+// TCP. Each side runs its own copy of the game and they swap controller input
+// every frame. Rather than wait for the other side's input, a frame runs
+// straight away with it predicted to be whatever they held last, and when the
+// real input turns out different the machine goes back to the snapshot taken
+// before that frame and runs forward again, which is rollback netplay as in
+// GGPO. Input delay trades a few frames of lag for fewer rollbacks, and every
+// so often each side sends a hash of its state so a desync is caught rather
+// than carrying on with two different games.
+package netplay
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"switchtrue.com/hankee/joypad"
+	"switchtrue.com/hankee/nes"
+)
+
+const (
+	PROTOCOL_VERSION = 1
+	// Frames that can run on predicted input before waiting for the other
+	// side to catch up.
+	MAX_ROLLBACK = 8
+	// Frames between state hash checks, about a second.
+	HASH_INTERVAL = 60
+	// The most input delay either side can ask for.
+	MAX_DELAY = 30
+	// How long to wait to hear from the other side before giving up on them.
+	TIMEOUT = 10 * time.Second
+)
+
+// Every connection starts with this tag.
+var MAGIC = []byte("HNKN")
+
+// ErrDesync is returned once the two sides' states have drifted apart.
+var ErrDesync = errors.New("netplay: desync")
+
+var errStopped = errors.New("netplay: the other side stopped responding")
+
+// Message types after the handshake.
+const (
+	msgInput = iota + 1
+	msgHash
+)
+
+// Config is what both sides agree on when they connect.
+type Config struct {
+	// The pad the local player plays as, 1 or 2. The other side plays the
+	// other one.
+	Player int
+	// Frames between reading the local player's input and it taking effect,
+	// which must be the same on both sides.
+	Delay int
+	// Identifies the game, such as a checksum of the ROM. Both sides must be
+	// running the same one.
+	Game string
+	// How long to wait to hear from the other side, TIMEOUT when zero. This
+	// one doesn't have to match.
+	Timeout time.Duration
+}
+
+// Session is one side of a game in progress.
+type Session struct {
+	conn    net.Conn
+	w       *bufio.Writer
+	machine *nes.Machine
+	config  Config
+
+	frame int
+	// Input for the frames that could still be run again, and the remote
+	// input each frame was run with, predicted or not.
+	local  inputs
+	remote inputs
+	used   inputs
+	// Snapshots taken at the start of each of the last few frames.
+	snapshots [MAX_ROLLBACK + 2]nes.Snapshot
+
+	hashed       int
+	localHashes  map[int]uint64
+	remoteHashes map[int]uint64
+	rollbacks    int
+
+	incoming chan message
+	done     chan struct{}
+	closing  sync.Once
+	err      error
+}
+
+// inputs holds buttons by frame, forgetting those of frames that can't be
+// rolled back to any more.
+type inputs struct {
+	first   int
+	buttons []joypad.Button
+}
+
+// Returns the frame after the last one held.
+func (in *inputs) len() int {
+	return in.first + len(in.buttons)
+}
+
+func (in *inputs) at(frame int) joypad.Button {
+	return in.buttons[frame-in.first]
+}
+
+func (in *inputs) set(frame int, buttons joypad.Button) {
+	in.buttons[frame-in.first] = buttons
+}
+
+func (in *inputs) add(buttons joypad.Button) {
+	in.buttons = append(in.buttons, buttons)
+}
+
+// Drops the buttons of frames before the given one.
+func (in *inputs) forget(before int) {
+	if before <= in.first {
+		return
+	}
+	n := min(before-in.first, len(in.buttons))
+	in.buttons = append(in.buttons[:0], in.buttons[n:]...)
+	in.first += n
+}
+
+type message struct {
+	kind  uint8
+	frame int
+	input joypad.Button
+	hash  uint64
+	err   error
+}
+
+type hello struct {
+	Version uint8
+	Player  uint8
+	Delay   uint8
+	GameLen uint8
+}
+
+// Starts a session over a connection to the other side, once both have
+// checked they agree on the game and the settings.
+func Start(conn net.Conn, machine *nes.Machine, config Config) (*Session, error) {
+	switch {
+	case config.Player != 1 && config.Player != 2:
+		return nil, fmt.Errorf("netplay: player %d, expected 1 or 2", config.Player)
+	case config.Delay < 0 || config.Delay > MAX_DELAY:
+		return nil, fmt.Errorf("netplay: input delay of %d frames, expected 0 to %d", config.Delay, MAX_DELAY)
+	case len(config.Game) > 255:
+		return nil, errors.New("netplay: game name is too long")
+	}
+
+	if config.Timeout <= 0 {
+		config.Timeout = TIMEOUT
+	}
+	w := bufio.NewWriter(conn)
+	w.Write(MAGIC)
+	binary.Write(w, binary.BigEndian, hello{PROTOCOL_VERSION, uint8(config.Player), uint8(config.Delay), uint8(len(config.Game))})
+	w.WriteString(config.Game)
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	var peer hello
+	tag := make([]byte, len(MAGIC))
+	conn.SetReadDeadline(time.Now().Add(config.Timeout))
+	if _, err := io.ReadFull(r, tag); errors.Is(err, os.ErrDeadlineExceeded) {
+		return nil, errStopped
+	} else if err != nil || string(tag) != string(MAGIC) {
+		return nil, errors.New("netplay: the other side isn't playing netplay")
+	}
+	if err := binary.Read(r, binary.BigEndian, &peer); err != nil {
+		return nil, fmt.Errorf("netplay: reading handshake: %w", err)
+	}
+	game := make([]byte, peer.GameLen)
+	if _, err := io.ReadFull(r, game); err != nil {
+		return nil, fmt.Errorf("netplay: reading handshake: %w", err)
+	}
+	switch {
+	case peer.Version != PROTOCOL_VERSION:
+		return nil, fmt.Errorf("netplay: the other side speaks version %d, not %d", peer.Version, PROTOCOL_VERSION)
+	case string(game) != config.Game:
+		return nil, fmt.Errorf("netplay: the other side is playing %s, not %s", game, config.Game)
+	case int(peer.Player) == config.Player:
+		return nil, fmt.Errorf("netplay: both sides want to be player %d", config.Player)
+	case int(peer.Delay) != config.Delay:
+		return nil, fmt.Errorf("netplay: the other side has %d frames of input delay, not %d", peer.Delay, config.Delay)
+	}
+
+	s := &Session{
+		conn:         conn,
+		w:            w,
+		machine:      machine,
+		config:       config,
+		localHashes:  map[int]uint64{},
+		remoteHashes: map[int]uint64{},
+		incoming:     make(chan message, 4*MAX_ROLLBACK),
+		done:         make(chan struct{}),
+	}
+	// Nobody has pressed anything during the delay at the start.
+	s.local.buttons = make([]joypad.Button, config.Delay)
+	s.remote.buttons = make([]joypad.Button, config.Delay)
+	go s.read(r)
+	return s, nil
+}
+
+// Reads messages from the other side until the connection closes.
+func (s *Session) read(r *bufio.Reader) {
+	for {
+		var m message
+		var header struct {
+			Kind  uint8
+			Frame uint32
+		}
+		// The other side sends input every frame, so a long silence means
+		// they've gone.
+		s.conn.SetReadDeadline(time.Now().Add(s.config.Timeout))
+		m.err = binary.Read(r, binary.BigEndian, &header)
+		m.kind, m.frame = header.Kind, int(header.Frame)
+		if m.err == nil {
+			switch header.Kind {
+			case msgInput:
+				var input uint8
+				m.err = binary.Read(r, binary.BigEndian, &input)
+				m.input = joypad.Button(input)
+			case msgHash:
+				m.err = binary.Read(r, binary.BigEndian, &m.hash)
+			default:
+				m.err = fmt.Errorf("netplay: unknown message type %d", header.Kind)
+			}
+		}
+		if errors.Is(m.err, io.EOF) || errors.Is(m.err, io.ErrUnexpectedEOF) {
+			m.err = errors.New("netplay: the other side disconnected")
+		} else if errors.Is(m.err, os.ErrDeadlineExceeded) {
+			m.err = errStopped
+		}
+		select {
+		case s.incoming <- m:
+		case <-s.done:
+			return
+		}
+		if m.err != nil {
+			return
+		}
+	}
+}
+
+// Returns the frame about to run, counting from 0 when the session started.
+func (s *Session) FrameCount() int {
+	return s.frame
+}
+
+// Returns how many times the session has gone back and run frames again.
+func (s *Session) Rollbacks() int {
+	return s.rollbacks
+}
+
+// Runs a frame with the local player holding the given buttons. Errors stick,
+// once the session has failed every call returns the same error.
+func (s *Session) Frame(buttons joypad.Button) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.err = s.step(buttons)
+	return s.err
+}
+
+func (s *Session) step(buttons joypad.Button) error {
+	s.local.add(buttons)
+	if err := s.send(msgInput, s.local.len()-1, func() { s.w.WriteByte(uint8(buttons)) }); err != nil {
+		return err
+	}
+
+	// Take in whatever has arrived, then wait if the other side has fallen
+	// too far behind to keep predicting.
+	rollback := s.frame
+	for done := false; !done; {
+		select {
+		case m := <-s.incoming:
+			if err := s.receive(m, &rollback); err != nil {
+				return err
+			}
+		default:
+			done = true
+		}
+	}
+	for s.frame-s.remote.len() >= MAX_ROLLBACK {
+		if err := s.receive(<-s.incoming, &rollback); err != nil {
+			return err
+		}
+	}
+
+	if err := s.rollback(rollback); err != nil {
+		return err
+	}
+	if err := s.run(s.frame); err != nil {
+		return err
+	}
+	s.frame++
+	if err := s.checkHashes(); err != nil {
+		return err
+	}
+	s.forget()
+	return nil
+}
+
+// Handles a message from the other side, moving rollback back to the first
+// frame that ran with the wrong input.
+func (s *Session) receive(m message, rollback *int) error {
+	if m.err != nil {
+		return m.err
+	}
+	switch m.kind {
+	case msgInput:
+		if m.frame != s.remote.len() {
+			return fmt.Errorf("netplay: got input for frame %d, expected frame %d", m.frame, s.remote.len())
+		}
+		if m.frame < s.frame && s.used.at(m.frame) != m.input {
+			*rollback = min(*rollback, m.frame)
+		}
+		s.remote.add(m.input)
+	case msgHash:
+		s.remoteHashes[m.frame] = m.hash
+	}
+	return nil
+}
+
+// Goes back to the start of a frame and runs the frames since again, if it's
+// before the current frame.
+func (s *Session) rollback(from int) error {
+	if from >= s.frame {
+		return nil
+	}
+	s.rollbacks++
+	s.machine.LoadSnapshot(s.snapshot(from))
+	for frame := from; frame < s.frame; frame++ {
+		if err := s.run(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Runs a frame, taking a snapshot first so it can be run again.
+func (s *Session) run(frame int) error {
+	s.machine.SaveSnapshot(s.snapshot(frame))
+
+	// The other side holds what they held last until we hear otherwise.
+	var remote joypad.Button
+	if frame < s.remote.len() {
+		remote = s.remote.at(frame)
+	} else if s.remote.len() > 0 {
+		remote = s.remote.at(s.remote.len() - 1)
+	}
+	if frame < s.used.len() {
+		s.used.set(frame, remote)
+	} else {
+		s.used.add(remote)
+	}
+
+	pads := s.machine.Joypads()
+	pads.Pad(s.config.Player).SetButtons(s.local.at(frame))
+	pads.Pad(3 - s.config.Player).SetButtons(remote)
+	if !s.machine.StepFrame() {
+		return errors.New("netplay: the program halted")
+	}
+	return nil
+}
+
+func (s *Session) snapshot(frame int) *nes.Snapshot {
+	return &s.snapshots[frame%len(s.snapshots)]
+}
+
+// Drops input for frames that have run with the other side's real input,
+// which nothing rolls back to. The other side's last input is kept to predict
+// from.
+func (s *Session) forget() {
+	confirmed := min(s.frame, s.remote.len())
+	s.local.forget(confirmed)
+	s.used.forget(confirmed)
+	s.remote.forget(confirmed - 1)
+}
+
+// Sends hashes of the states at the start of frames both sides have the
+// input for, and compares them with the other side's.
+func (s *Session) checkHashes() error {
+	for frame := s.hashed + HASH_INTERVAL; frame < s.frame && frame <= s.remote.len(); frame += HASH_INTERVAL {
+		hash, err := Hash(s.snapshot(frame).State())
+		if err != nil {
+			return err
+		}
+		s.localHashes[frame] = hash
+		s.hashed = frame
+		if err := s.send(msgHash, frame, func() { binary.Write(s.w, binary.BigEndian, hash) }); err != nil {
+			return err
+		}
+	}
+	for frame, local := range s.localHashes {
+		remote, ok := s.remoteHashes[frame]
+		if !ok {
+			continue
+		}
+		if remote != local {
+			return fmt.Errorf("%w at frame %d", ErrDesync, frame)
+		}
+		delete(s.localHashes, frame)
+		delete(s.remoteHashes, frame)
+	}
+	return nil
+}
+
+func (s *Session) send(kind uint8, frame int, payload func()) error {
+	s.w.WriteByte(kind)
+	binary.Write(s.w, binary.BigEndian, uint32(frame))
+	payload()
+	return s.w.Flush()
+}
+
+// Waits for the other side's input for every frame run so far and runs again
+// any that were predicted wrong, so both sides end up in the same state.
+func (s *Session) Confirm() error {
+	if s.err != nil {
+		return s.err
+	}
+	rollback := s.frame
+	for s.remote.len() < s.frame {
+		if s.err = s.receive(<-s.incoming, &rollback); s.err != nil {
+			return s.err
+		}
+	}
+	if s.err = s.rollback(rollback); s.err == nil {
+		s.forget()
+	}
+	return s.err
+}
+
+// Hangs up on the other side. Closing more than once does nothing.
+func (s *Session) Close() error {
+	var err error
+	s.closing.Do(func() {
+		close(s.done)
+		err = s.conn.Close()
+	})
+	return err
+}
+
+// Returns a hash of everything in a state, to compare with the other side's.
+func Hash(state *nes.State) (uint64, error) {
+	data, err := state.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+	h := fnv.New64a()
+	h.Write(data)
+	return h.Sum64(), nil
+}
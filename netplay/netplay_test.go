@@ -0,0 +1,210 @@
+package netplay
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"switchtrue.com/hankee/cartridge"
+	"switchtrue.com/hankee/joypad"
+	"switchtrue.com/hankee/nes"
+)
+
+// Reads both controllers over and over, folding them into $10 and $11 as
+// s = 3s + buttons, so a wrong button anywhere in the past shows up.
+var program = []uint8{
+	0xA9, 0x01, 0x8D, 0x16, 0x40, // LDA #1; STA $4016
+	0xA9, 0x00, 0x8D, 0x16, 0x40, // LDA #0; STA $4016
+	0xA2, 0x08, // LDX #8
+	0xAD, 0x16, 0x40, 0x4A, 0x26, 0x00, // LDA $4016; LSR A; ROL $00
+	0xAD, 0x17, 0x40, 0x4A, 0x26, 0x01, // LDA $4017; LSR A; ROL $01
+	0xCA, 0xD0, 0xF1, // DEX; BNE
+	0xA5, 0x10, 0x0A, 0x18, 0x65, 0x10, 0x18, 0x65, 0x00, 0x85, 0x10, // $10 = 3*$10 + $00
+	0xA5, 0x11, 0x0A, 0x18, 0x65, 0x11, 0x18, 0x65, 0x01, 0x85, 0x11, // $11 = 3*$11 + $01
+	0x4C, 0x00, 0x80, // JMP $8000
+}
+
+func newMachine(t *testing.T) *nes.Machine {
+	raw := []uint8{'N', 'E', 'S', 0x1A, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	prg := make([]uint8, cartridge.PRG_ROM_PAGE_SIZE)
+	copy(prg, program)
+	prg[0x3FFC] = 0x00
+	prg[0x3FFD] = 0x80
+	raw = append(raw, prg...)
+	raw = append(raw, make([]uint8, cartridge.CHR_ROM_PAGE_SIZE)...)
+	cart, err := cartridge.Load(raw)
+	require.NoError(t, err)
+	m := nes.New()
+	m.InsertCartridge(cart)
+	return m
+}
+
+// Connects two sessions over loopback.
+func connect(t *testing.T, one Config, two Config) (*Session, *Session, *nes.Machine, *nes.Machine) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	m1, m2 := newMachine(t), newMachine(t)
+	var s2 *Session
+	var err2 error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			err2 = err
+			return
+		}
+		s2, err2 = Start(conn, m2, two)
+	}()
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	s1, err1 := Start(conn, m1, one)
+	<-done
+	require.NoError(t, err1)
+	require.NoError(t, err2)
+	t.Cleanup(func() {
+		s1.Close()
+		s2.Close()
+	})
+	return s1, s2, m1, m2
+}
+
+// Plays frames on both sides at once, the second side pausing between frames
+// so the first runs ahead on predicted input. before is called on the first
+// side ahead of each of its frames.
+func play(t *testing.T, s1 *Session, s2 *Session, frames int, before func(frame int)) (error, error) {
+	inputs := func(player int, frame int) joypad.Button {
+		// Hold each combination for a few frames, like a person would.
+		return joypad.Button((frame/4)*(37+player*16) + player)
+	}
+	var wg sync.WaitGroup
+	var err1, err2 error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < frames && err1 == nil; i++ {
+			if before != nil {
+				before(s1.FrameCount())
+			}
+			err1 = s1.Frame(inputs(1, s1.FrameCount()))
+		}
+		if err1 == nil {
+			err1 = s1.Confirm()
+		} else {
+			s1.Close()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < frames && err2 == nil; i++ {
+			time.Sleep(time.Millisecond)
+			err2 = s2.Frame(inputs(2, s2.FrameCount()))
+		}
+		if err2 == nil {
+			err2 = s2.Confirm()
+		} else {
+			s2.Close()
+		}
+	}()
+	wg.Wait()
+	return err1, err2
+}
+
+// Test that two sides on loopback end up in the same state, with the side
+// that ran ahead rolling back when its predictions were wrong
+func Test_Session_Loopback(t *testing.T) {
+	for _, delay := range []int{0, 2} {
+		s1, s2, m1, m2 := connect(t, Config{Player: 1, Delay: delay, Game: "test"}, Config{Player: 2, Delay: delay, Game: "test"})
+		err1, err2 := play(t, s1, s2, 3*HASH_INTERVAL, nil)
+		require.NoError(t, err1)
+		require.NoError(t, err2)
+
+		assert.Equal(t, 3*HASH_INTERVAL, s1.FrameCount())
+		assert.Greater(t, s1.Rollbacks(), 0, "delay %d", delay)
+		assert.Equal(t, m1.SaveState(), m2.SaveState(), "delay %d", delay)
+		assert.NotZero(t, m1.Bus().RAM()[0x10])
+		assert.NotZero(t, m1.Bus().RAM()[0x11])
+		// Only input that could still be rolled back is kept.
+		for _, in := range []inputs{s1.local, s1.remote, s1.used, s2.local, s2.remote, s2.used} {
+			assert.LessOrEqual(t, len(in.buttons), MAX_ROLLBACK+delay, "delay %d", delay)
+		}
+	}
+}
+
+// Test that a state changed on one side is caught by the hash check
+func Test_Session_Desync(t *testing.T) {
+	s1, s2, m1, _ := connect(t, Config{Player: 1, Delay: 1, Game: "test"}, Config{Player: 2, Delay: 1, Game: "test"})
+	err1, err2 := play(t, s1, s2, 3*HASH_INTERVAL, func(frame int) {
+		if frame == HASH_INTERVAL/2 {
+			// Nothing rolls back past this, both sides have all the input
+			// for the frames before it once Confirm returns.
+			assert.NoError(t, s1.Confirm())
+			m1.Bus().RAM()[0x40] = 1
+		}
+	})
+	// Whichever side sees the other's hash first stops, and the other then
+	// finds the connection gone.
+	assert.True(t, errors.Is(err1, ErrDesync) || errors.Is(err2, ErrDesync), "errors %v and %v", err1, err2)
+}
+
+// Test that the two sides must agree before playing
+func Test_Session_Handshake(t *testing.T) {
+	for _, test := range []struct {
+		two   Config
+		error string
+	}{
+		{Config{Player: 1, Game: "test"}, "both sides want to be player 1"},
+		{Config{Player: 2, Game: "other"}, "playing other, not test"},
+		{Config{Player: 2, Delay: 3, Game: "test"}, "3 frames of input delay, not 0"},
+	} {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		go func() {
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			if err == nil {
+				Start(conn, newMachine(t), test.two)
+				conn.Close()
+			}
+		}()
+		conn, err := listener.Accept()
+		require.NoError(t, err)
+		_, err = Start(conn, newMachine(t), Config{Player: 1, Game: "test"})
+		assert.ErrorContains(t, err, test.error)
+		conn.Close()
+		listener.Close()
+	}
+}
+
+// Test that a side that goes quiet is given up on, both during the handshake
+// and once playing
+func Test_Session_Timeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = Start(conn, newMachine(t), Config{Player: 1, Game: "test", Timeout: 50 * time.Millisecond})
+	assert.ErrorContains(t, err, "stopped responding")
+
+	s1, _, _, _ := connect(t, Config{Player: 1, Game: "test", Timeout: 50 * time.Millisecond}, Config{Player: 2, Game: "test"})
+	err = nil
+	for i := 0; i <= MAX_ROLLBACK && err == nil; i++ {
+		err = s1.Frame(0)
+	}
+	assert.ErrorContains(t, err, "stopped responding")
+	assert.ErrorContains(t, s1.Confirm(), "stopped responding")
+}
@@ -0,0 +1,282 @@
+// Package nsf loads NSF and NSFe music rips and plays them on an emulated
+// CPU and APU without the rest of the console.
+package nsf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	NSF_TAG  = []byte("NESM\x1A")
+	NSFE_TAG = []byte("NSFE")
+)
+
+const HEADER_SIZE = 0x80
+
+// PLAY rates in microseconds for files that don't give one.
+const (
+	DEFAULT_NTSC_SPEED = 16639
+	DEFAULT_PAL_SPEED  = 19997
+)
+
+// Expansion is the set of extra sound chips a tune uses, from the header
+// flags.
+type Expansion uint8
+
+const (
+	VRC6 Expansion = 1 << iota
+	VRC7
+	FDS
+	MMC5
+	Namco163
+	Sunsoft5B
+	VT02
+)
+
+var expansionNames = []string{"VRC6", "VRC7", "FDS", "MMC5", "Namco 163", "Sunsoft 5B", "VT02+"}
+
+func (e Expansion) String() string {
+	var names []string
+	for i, name := range expansionNames {
+		if e&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+// File is a parsed NSF or NSFe file.
+type File struct {
+	Songs int
+	// The song to play first, counting from 1.
+	StartSong   int
+	LoadAddress uint16
+	InitAddress uint16
+	PlayAddress uint16
+	Name        string
+	Artist      string
+	Copyright   string
+	// Microseconds between PLAY calls for each region. DendySpeed is 0 when
+	// the file doesn't say.
+	NTSCSpeed  uint16
+	PALSpeed   uint16
+	DendySpeed uint16
+	// Bank numbers for $8000-$FFFF in 4KB slots, used when Bankswitched.
+	Banks        [8]uint8
+	Bankswitched bool
+	// The tune only works on PAL, or works on both.
+	PAL        bool
+	DualRegion bool
+	Expansion  Expansion
+	Data       []uint8
+
+	// NSFe only. Names and lengths in milliseconds per track, with -1 for
+	// lengths that aren't given.
+	TrackNames []string
+	TrackTimes []int
+	TrackFades []int
+}
+
+// Reads and parses an NSF or NSFe file.
+func LoadFile(path string) (*File, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+// Parses an NSF or NSFe file, telling them apart by their tags.
+func Parse(raw []uint8) (*File, error) {
+	switch {
+	case bytes.HasPrefix(raw, NSF_TAG):
+		return parseNSF(raw)
+	case bytes.HasPrefix(raw, NSFE_TAG):
+		return parseNSFe(raw)
+	default:
+		return nil, errors.New("not an NSF or NSFe file")
+	}
+}
+
+func parseNSF(raw []uint8) (*File, error) {
+	if len(raw) < HEADER_SIZE {
+		return nil, fmt.Errorf("NSF header is truncated, %d bytes", len(raw))
+	}
+	f := &File{
+		Songs:       int(raw[0x06]),
+		StartSong:   int(raw[0x07]),
+		LoadAddress: binary.LittleEndian.Uint16(raw[0x08:]),
+		InitAddress: binary.LittleEndian.Uint16(raw[0x0A:]),
+		PlayAddress: binary.LittleEndian.Uint16(raw[0x0C:]),
+		Name:        cString(raw[0x0E:0x2E]),
+		Artist:      cString(raw[0x2E:0x4E]),
+		Copyright:   cString(raw[0x4E:0x6E]),
+		NTSCSpeed:   binary.LittleEndian.Uint16(raw[0x6E:]),
+		PALSpeed:    binary.LittleEndian.Uint16(raw[0x78:]),
+		PAL:         raw[0x7A]&0b01 != 0,
+		DualRegion:  raw[0x7A]&0b10 != 0,
+		Expansion:   Expansion(raw[0x7B]),
+	}
+	copy(f.Banks[:], raw[0x70:0x78])
+	f.Bankswitched = f.Banks != [8]uint8{}
+
+	data := raw[HEADER_SIZE:]
+	// NSF2 can give the data length, with metadata after it.
+	if length := int(raw[0x7D]) | int(raw[0x7E])<<8 | int(raw[0x7F])<<16; raw[0x05] >= 2 && length != 0 {
+		if length > len(data) {
+			return nil, fmt.Errorf("NSF data is truncated, expected %d bytes, got %d", length, len(data))
+		}
+		data = data[:length]
+	}
+	f.Data = append([]uint8(nil), data...)
+	return f, f.finish()
+}
+
+// NSFe files are a series of chunks, each a little endian length, a four
+// letter ID and the data. Chunks starting with a capital letter are needed to
+// play the file, the rest can be skipped.
+func parseNSFe(raw []uint8) (*File, error) {
+	f := &File{Songs: 1, StartSong: 1}
+	seen := map[string]bool{}
+	for offset := len(NSFE_TAG); ; {
+		if offset+8 > len(raw) {
+			return nil, errors.New("NSFe file ends without an NEND chunk")
+		}
+		length := int(binary.LittleEndian.Uint32(raw[offset:]))
+		id := string(raw[offset+4 : offset+8])
+		offset += 8
+		if length > len(raw)-offset {
+			return nil, fmt.Errorf("NSFe %s chunk is truncated", id)
+		}
+		chunk := raw[offset : offset+length]
+		offset += length
+		seen[id] = true
+
+		switch id {
+		case "INFO":
+			if len(chunk) < 8 {
+				return nil, errors.New("NSFe INFO chunk is too short")
+			}
+			f.LoadAddress = binary.LittleEndian.Uint16(chunk[0:])
+			f.InitAddress = binary.LittleEndian.Uint16(chunk[2:])
+			f.PlayAddress = binary.LittleEndian.Uint16(chunk[4:])
+			f.PAL = chunk[6]&0b01 != 0
+			f.DualRegion = chunk[6]&0b10 != 0
+			f.Expansion = Expansion(chunk[7])
+			if len(chunk) >= 9 {
+				f.Songs = int(chunk[8])
+			}
+			if len(chunk) >= 10 {
+				f.StartSong = int(chunk[9]) + 1
+			}
+		case "DATA":
+			f.Data = append([]uint8(nil), chunk...)
+		case "BANK":
+			copy(f.Banks[:], chunk)
+			f.Bankswitched = true
+		case "RATE":
+			speeds := []*uint16{&f.NTSCSpeed, &f.PALSpeed, &f.DendySpeed}
+			for i := 0; i < len(speeds) && 2*i+2 <= len(chunk); i++ {
+				*speeds[i] = binary.LittleEndian.Uint16(chunk[2*i:])
+			}
+		case "auth":
+			fields := cStrings(chunk)
+			for i, field := range []*string{&f.Name, &f.Artist, &f.Copyright} {
+				if i < len(fields) {
+					*field = fields[i]
+				}
+			}
+		case "tlbl":
+			f.TrackNames = cStrings(chunk)
+		case "time":
+			f.TrackTimes = int32s(chunk)
+		case "fade":
+			f.TrackFades = int32s(chunk)
+		case "NEND":
+			if !seen["INFO"] || !seen["DATA"] {
+				return nil, errors.New("NSFe file is missing its INFO or DATA chunk")
+			}
+			return f, f.finish()
+		default:
+			if id[0] >= 'A' && id[0] <= 'Z' {
+				return nil, fmt.Errorf("NSFe file needs the unsupported %s chunk", id)
+			}
+		}
+	}
+}
+
+// Fills in defaults and checks the file can be played.
+func (f *File) finish() error {
+	if f.NTSCSpeed == 0 {
+		f.NTSCSpeed = DEFAULT_NTSC_SPEED
+	}
+	if f.PALSpeed == 0 {
+		f.PALSpeed = DEFAULT_PAL_SPEED
+	}
+	if f.Songs == 0 {
+		return errors.New("NSF has no songs")
+	}
+	if f.StartSong < 1 || f.StartSong > f.Songs {
+		f.StartSong = 1
+	}
+	if !f.Bankswitched && f.LoadAddress < 0x8000 {
+		return fmt.Errorf("NSF loads at $%04X, only tunes loading at $8000 and above are supported", f.LoadAddress)
+	}
+	return nil
+}
+
+// Returns a track's name, or "" if the file doesn't name it.
+func (f *File) TrackName(track int) string {
+	if track >= 1 && track <= len(f.TrackNames) {
+		return f.TrackNames[track-1]
+	}
+	return ""
+}
+
+// Returns a track's length and fade out in milliseconds, or -1 when the file
+// doesn't say.
+func (f *File) TrackLength(track int) (length int, fade int) {
+	length, fade = -1, -1
+	if track >= 1 && track <= len(f.TrackTimes) {
+		length = f.TrackTimes[track-1]
+	}
+	if track >= 1 && track <= len(f.TrackFades) {
+		fade = f.TrackFades[track-1]
+	}
+	return length, fade
+}
+
+func cString(b []uint8) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func cStrings(b []uint8) []string {
+	strs := strings.Split(string(b), "\x00")
+	if len(strs) > 0 && strs[len(strs)-1] == "" {
+		strs = strs[:len(strs)-1]
+	}
+	return strs
+}
+
+func int32s(b []uint8) []int {
+	values := make([]int, len(b)/4)
+	for i := range values {
+		values[i] = int(int32(binary.LittleEndian.Uint32(b[4*i:])))
+	}
+	return values
+}
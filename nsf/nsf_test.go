@@ -0,0 +1,123 @@
+package nsf
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"switchtrue.com/hankee/nes"
+)
+
+// INIT at $8000: STA $00; LDA #$BF; STA $4000; LDA #253; STA $4002;
+// LDA #0; STA $4003; RTS
+var tune = []uint8{
+	0x85, 0x00, 0xA9, 0xBF, 0x8D, 0x00, 0x40, 0xA9, 0xFD, 0x8D, 0x02, 0x40, 0xA9, 0x00, 0x8D, 0x03,
+	0x40, 0x60,
+}
+
+func buildNSF(banks [8]uint8, data []uint8) []uint8 {
+	header := make([]uint8, HEADER_SIZE)
+	copy(header, NSF_TAG)
+	header[0x05] = 1
+	header[0x06] = 3
+	header[0x07] = 2
+	binary.LittleEndian.PutUint16(header[0x08:], 0x8000)
+	binary.LittleEndian.PutUint16(header[0x0A:], 0x8000)
+	binary.LittleEndian.PutUint16(header[0x0C:], 0x8020)
+	copy(header[0x0E:], "Test Tune")
+	copy(header[0x70:], banks[:])
+	header[0x7B] = uint8(VRC6 | FDS)
+	return append(header, data...)
+}
+
+// Returns two banks with INIT at $8000, PLAY at $8020 and $AA at $9000.
+func program() []uint8 {
+	data := make([]uint8, 0x2000)
+	copy(data, tune)
+	// PLAY: INC $01; LDA $9000; STA $02; RTS
+	copy(data[0x20:], []uint8{0xE6, 0x01, 0xAD, 0x00, 0x90, 0x85, 0x02, 0x60})
+	data[0x1000] = 0xAA
+	return data
+}
+
+// Test that INIT gets the song and PLAY runs at the header's rate
+func Test_Player(t *testing.T) {
+	f, err := Parse(buildNSF([8]uint8{}, program()))
+	require.NoError(t, err)
+	assert.Equal(t, "Test Tune", f.Name)
+	assert.Equal(t, 2, f.StartSong)
+	assert.Equal(t, uint16(DEFAULT_NTSC_SPEED), f.NTSCSpeed)
+	assert.Equal(t, "VRC6, FDS", f.Expansion.String())
+
+	p := NewPlayer(f, RegionFor(f))
+	require.NoError(t, p.Init(3))
+	assert.Equal(t, uint8(2), p.memory.ram[0x00], "songs count from 0 in A")
+
+	var samples []int16
+	for i := 0; i < 60; i++ {
+		s, err := p.Play()
+		require.NoError(t, err)
+		samples = append(samples, s...)
+	}
+	assert.Equal(t, uint8(60), p.memory.ram[0x01])
+	assert.Equal(t, uint8(0xAA), p.memory.ram[0x02])
+	// 60 calls at 16639us is just under a second.
+	assert.InDelta(t, 0.9983*44100, len(samples), 2)
+	assert.NotZero(t, samples[len(samples)/2], "the pulse channel is playing")
+
+	assert.Error(t, p.Init(4))
+}
+
+// Test that $5FF8-$5FFF switch 4KB banks starting from the header's banks
+func Test_Player_Bankswitching(t *testing.T) {
+	// Bank 1 at $9000 in the header, then INIT swaps in bank 0.
+	data := program()
+	copy(data, []uint8{0xA9, 0x00, 0x8D, 0xF9, 0x5F, 0x60})
+	f, err := Parse(buildNSF([8]uint8{0, 1}, data))
+	require.NoError(t, err)
+	require.True(t, f.Bankswitched)
+
+	p := NewPlayer(f, nes.NTSC)
+	assert.Equal(t, uint8(0xAA), p.memory.Read(0x9000), "before INIT the header's banks apply")
+	require.NoError(t, p.Init(1))
+	assert.Equal(t, uint8(0xA9), p.memory.Read(0x9000))
+}
+
+func chunk(id string, data []uint8) []uint8 {
+	out := binary.LittleEndian.AppendUint32(nil, uint32(len(data)))
+	return append(append(out, id...), data...)
+}
+
+// Test parsing the NSFe chunks
+func Test_Parse_NSFe(t *testing.T) {
+	info := []uint8{0x00, 0x80, 0x00, 0x80, 0x20, 0x80, 0x01, 0x00, 2, 1}
+	times := binary.LittleEndian.AppendUint32(nil, 90000)
+	times = binary.LittleEndian.AppendUint32(times, 0xFFFFFFFF)
+	raw := append([]uint8(nil), NSFE_TAG...)
+	raw = append(raw, chunk("INFO", info)...)
+	raw = append(raw, chunk("DATA", program())...)
+	raw = append(raw, chunk("auth", []uint8("Game\x00Composer\x00(c) 1990\x00Ripper\x00"))...)
+	raw = append(raw, chunk("tlbl", []uint8("Title\x00Ending\x00"))...)
+	raw = append(raw, chunk("time", times)...)
+	raw = append(raw, chunk("xtra", []uint8{1, 2, 3})...)
+	f, err := Parse(append(raw, chunk("NEND", nil)...))
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, f.Songs)
+	assert.Equal(t, 2, f.StartSong)
+	assert.True(t, f.PAL)
+	assert.Equal(t, nes.PAL, RegionFor(f))
+	assert.Equal(t, "Composer", f.Artist)
+	assert.Equal(t, "Ending", f.TrackName(2))
+	length, fade := f.TrackLength(1)
+	assert.Equal(t, 90000, length)
+	assert.Equal(t, -1, fade)
+	length, _ = f.TrackLength(2)
+	assert.Equal(t, -1, length)
+
+	_, err = Parse(append(raw, chunk("VRC7", nil)...))
+	assert.ErrorContains(t, err, "VRC7")
+	_, err = Parse(raw)
+	assert.Error(t, err, "no NEND")
+}
@@ -0,0 +1,211 @@
+package nsf
+
+import (
+	"fmt"
+	"strings"
+
+	"switchtrue.com/hankee/apu"
+	"switchtrue.com/hankee/cpu"
+	"switchtrue.com/hankee/nes"
+)
+
+const BANK_SIZE = 4 * 1024
+
+// INIT and PLAY are called as subroutines returning here. Nothing is mapped at
+// this address, so the player can tell a routine has finished when the CPU
+// reaches it.
+const RETURN_ADDRESS uint16 = 0x4100
+
+// How long INIT or PLAY may take before the tune is assumed stuck. INIT can
+// take a while when tunes decompress their data first.
+const MAX_CALL_SECONDS = 2
+
+// memory is the NSF address space: work RAM, the APU, the bank registers at
+// $5FF8-$5FFF, 8KB of RAM at $6000 and the tune in 4KB banks from $8000.
+type memory struct {
+	ram   [0x800]uint8
+	wram  [0x2000]uint8
+	rom   []uint8
+	banks [8]int
+	apu   *apu.APU
+}
+
+func newMemory(f *File) *memory {
+	m := &memory{}
+	if f.Bankswitched {
+		// The data is padded so the load address falls at the same offset
+		// within its bank.
+		padding := int(f.LoadAddress & (BANK_SIZE - 1))
+		size := (padding + len(f.Data) + BANK_SIZE - 1) / BANK_SIZE * BANK_SIZE
+		m.rom = make([]uint8, size)
+		copy(m.rom[padding:], f.Data)
+	} else {
+		m.rom = make([]uint8, 0x8000)
+		copy(m.rom[f.LoadAddress-0x8000:], f.Data)
+	}
+	for i := range m.banks {
+		m.banks[i] = i
+	}
+	return m
+}
+
+func (m *memory) Read(addr uint16) uint8 {
+	switch {
+	case addr < 0x2000:
+		return m.ram[addr&0x7FF]
+	case addr == 0x4015:
+		return m.apu.Read(addr)
+	case addr >= 0x6000 && addr < 0x8000:
+		return m.wram[addr-0x6000]
+	case addr >= 0x8000:
+		offset := m.banks[(addr-0x8000)/BANK_SIZE]*BANK_SIZE + int(addr&(BANK_SIZE-1))
+		if offset < len(m.rom) {
+			return m.rom[offset]
+		}
+	}
+	return 0
+}
+
+func (m *memory) Write(addr uint16, data uint8) {
+	switch {
+	case addr < 0x2000:
+		m.ram[addr&0x7FF] = data
+	case addr >= 0x4000 && addr <= 0x4017 && addr != 0x4016:
+		m.apu.Write(addr, data)
+	case addr >= 0x5FF8 && addr <= 0x5FFF:
+		m.banks[addr-0x5FF8] = int(data)
+	case addr >= 0x6000 && addr < 0x8000:
+		m.wram[addr-0x6000] = data
+	}
+}
+
+// Player plays the songs in an NSF file.
+type Player struct {
+	file    *File
+	region  nes.Region
+	timing  nes.Timing
+	memory  *memory
+	cpu     *cpu.CPU
+	apu     *apu.APU
+	playing bool
+	// CPU cycles between PLAY calls, and when the next is due.
+	period   float64
+	nextPlay float64
+}
+
+// Creates a player for a file running at a region's speed.
+func NewPlayer(f *File, region nes.Region) *Player {
+	p := &Player{file: f, region: region, timing: region.Timing(), memory: newMemory(f)}
+	p.cpu = cpu.New(p.memory)
+	p.apu = apu.New(p.timing.APU(), p.cpu)
+	p.memory.apu = p.apu
+
+	speed := f.NTSCSpeed
+	switch {
+	case region == nes.PAL:
+		speed = f.PALSpeed
+	case region == nes.Dendy && f.DendySpeed != 0:
+		speed = f.DendySpeed
+	case region == nes.Dendy:
+		speed = f.PALSpeed
+	}
+	p.period = float64(speed) * p.timing.CPUClock() / 1e6
+	return p
+}
+
+// Picks the region a file was made for, NTSC unless it only works on PAL.
+func RegionFor(f *File) nes.Region {
+	if f.PAL && !f.DualRegion {
+		return nes.PAL
+	}
+	return nes.NTSC
+}
+
+func (p *Player) APU() *apu.APU {
+	return p.apu
+}
+
+// Starts a song, counting from 1, by resetting the RAM, APU and banks then
+// calling INIT with the song in A and the region in X.
+func (p *Player) Init(song int) error {
+	if song < 1 || song > p.file.Songs {
+		return fmt.Errorf("no song %d, the file has %d", song, p.file.Songs)
+	}
+	clear(p.memory.ram[:])
+	clear(p.memory.wram[:])
+	for addr := uint16(0x4000); addr <= 0x4013; addr++ {
+		p.memory.Write(addr, 0)
+	}
+	p.memory.Write(0x4015, 0x00)
+	p.memory.Write(0x4015, 0x0F)
+	p.memory.Write(0x4017, 0x40)
+	if p.file.Bankswitched {
+		for i, bank := range p.file.Banks {
+			p.memory.Write(0x5FF8+uint16(i), bank)
+		}
+	}
+
+	// Tunes only know NTSC and PAL, Dendy's APU is NTSC's.
+	region := uint8(0)
+	if p.region == nes.PAL {
+		region = 1
+	}
+	p.playing = false
+	if err := p.call(p.file.InitAddress, uint8(song-1), region, MAX_CALL_SECONDS*p.timing.CPUClock()); err != nil {
+		return fmt.Errorf("INIT: %w", err)
+	}
+	p.nextPlay = float64(p.cpu.Cycles())
+	p.playing = true
+	return nil
+}
+
+// Calls PLAY, then idles until the next call is due. Returns the samples
+// made, about a frame's worth. A PLAY that runs long delays the next one, as
+// it would on a console.
+func (p *Player) Play() ([]int16, error) {
+	if !p.playing {
+		return nil, fmt.Errorf("no song started")
+	}
+	if err := p.call(p.file.PlayAddress, 0, 0, MAX_CALL_SECONDS*p.timing.CPUClock()); err != nil {
+		return nil, fmt.Errorf("PLAY: %w", err)
+	}
+	p.nextPlay = max(p.nextPlay+p.period, float64(p.cpu.Cycles()))
+	p.cpu.SetCycles(uint64(p.nextPlay))
+	return p.apu.Samples(), nil
+}
+
+// Runs a routine as a subroutine until it returns, giving up after limit
+// cycles.
+func (p *Player) call(addr uint16, a uint8, x uint8, limit float64) (err error) {
+	r := p.cpu.Registers()
+	r.A, r.X, r.Y = a, x, 0
+	r.PC = addr
+	r.SP = 0xFD
+	p.cpu.SetRegisters(r)
+	// The return address is pushed less one, as JSR does.
+	ret := RETURN_ADDRESS - 1
+	p.memory.Write(0x01FF, uint8(ret>>8))
+	p.memory.Write(0x01FE, uint8(ret))
+
+	pc := addr
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v at $%04X", strings.TrimSpace(fmt.Sprint(r)), pc)
+		}
+	}()
+	end := p.cpu.Cycles() + uint64(limit)
+	returned := false
+	p.cpu.RunWithCallback(func(c *cpu.CPU) bool {
+		pc = c.Registers().PC
+		returned = pc == RETURN_ADDRESS
+		return !returned && c.Cycles() < end
+	})
+	switch {
+	case returned:
+		return nil
+	case p.cpu.Cycles() >= end:
+		return fmt.Errorf("didn't return within %d cycles", uint64(limit))
+	default:
+		return fmt.Errorf("hit BRK at $%04X", pc)
+	}
+}
@@ -0,0 +1,213 @@
+// Package ntsc turns frames into pictures the way a TV fed the NES's composite
+// output would show them. Each pixel's palette index and emphasis bits become
+// the square wave the PPU puts on the wire, which is then decoded back to RGB
+// with the luma and chroma bleeding into each other, so dithered patterns
+// blend and edges pick up colour fringes as games expected. It follows the
+// approach of Blargg's nes_ntsc and the NESdev wiki, in software.
+package ntsc
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"switchtrue.com/hankee/video"
+)
+
+const (
+	// Signal samples per pixel, the PPU outputs one level per master clock.
+	SAMPLES_PER_PIXEL = 8
+	// Phases in a cycle of the colour subcarrier, 12 half master clocks.
+	PHASES = 12
+	// Phases the subcarrier moves on by each scanline, 341 dots of 8 clocks.
+	SCANLINE_PHASE = 341 * SAMPLES_PER_PIXEL % PHASES
+	// Where the decoder's I axis sits against the signal, in phases, which
+	// lines the colours up with palettes measured from real consoles.
+	I_PHASE = 4.5
+)
+
+// Signal voltages relative to sync for the four luma levels, low then high
+// halves of the wave, and how much emphasis attenuates it.
+var (
+	levels      = [8]float64{0.350, 0.518, 0.962, 1.550, 1.094, 1.506, 1.962, 1.962}
+	black       = 0.518
+	white       = 1.962
+	attenuation = 0.746
+)
+
+// Returns how wide the filtered picture of a frame is, 602 pixels for the
+// NES's 256 as with nes_ntsc.
+func OutputWidth(width int) int {
+	return ((width-1)/3 + 1) * 7
+}
+
+// Options tune the picture. Each runs from -1 to 1 with 0 looking like a TV
+// with its controls in the middle.
+type Options struct {
+	// Rotates the colours, -1 and 1 are half a turn either way.
+	Hue float64
+	// -1 is black and white, 1 is twice as colourful.
+	Saturation float64
+	// -1 blurs the luma over more than a colour cycle, 1 keeps it crisp at
+	// the cost of more colour fringes bleeding into it.
+	Sharpness float64
+}
+
+// Filter converts frames, one after another so the subcarrier's phase moves
+// on each frame as it does on the real console.
+type Filter struct {
+	options Options
+	// Normalised signal level for every pixel value and phase.
+	signal [512][PHASES]float64
+	// The subcarrier for each phase, with the hue applied.
+	cos, sin [PHASES]float64
+	// Samples either side of a pixel's centre averaged for its luma.
+	lumaWidth int
+	gamma     [1024]uint8
+	phase     int
+}
+
+// Creates a filter with the given picture controls.
+func New(options Options) *Filter {
+	f := &Filter{options: options}
+	for pixel := range f.signal {
+		for phase := range f.signal[pixel] {
+			f.signal[pixel][phase] = (signal(uint16(pixel), phase) - black) / (white - black)
+		}
+	}
+	hue := options.Hue * math.Pi
+	for p := 0; p < PHASES; p++ {
+		angle := math.Pi*(float64(p)+I_PHASE)/6 + hue
+		f.cos[p] = math.Cos(angle)
+		f.sin[p] = math.Sin(angle)
+	}
+	// Half a cycle either side cancels the subcarrier out of the luma, less
+	// lets some through as fringes.
+	f.lumaWidth = int(math.Round(PHASES/2 - 2.5*clamp(options.Sharpness, -1, 1)))
+	for i := range f.gamma {
+		// The signal is gamma corrected for a 2.2 TV, shown here on a 2.2
+		// monitor with a touch of extra contrast like the wiki suggests.
+		f.gamma[i] = uint8(255.95 * math.Pow(float64(i)/float64(len(f.gamma)-1), 2.2/2.0))
+	}
+	return f
+}
+
+func (f *Filter) Options() Options {
+	return f.options
+}
+
+// Returns the level on the wire for a pixel at a subcarrier phase. Bits 0-3
+// of the pixel are the hue, 4-5 the luma and 6-8 the emphasis bits.
+func signal(pixel uint16, phase int) float64 {
+	colour := int(pixel & 0x0F)
+	level := int(pixel>>4) & 3
+	emphasis := pixel >> 6
+	if colour > 13 {
+		level = 1
+	}
+	low, high := levels[level], levels[4+level]
+	if colour == 0 {
+		low = high
+	}
+	if colour > 12 {
+		high = low
+	}
+	inPhase := func(colour int) bool {
+		return (colour+phase)%PHASES < 6
+	}
+	level0 := low
+	if inPhase(colour) {
+		level0 = high
+	}
+	if (emphasis&1 != 0 && inPhase(0)) || (emphasis&2 != 0 && inPhase(4)) || (emphasis&4 != 0 && inPhase(8)) {
+		level0 *= attenuation
+	}
+	return level0
+}
+
+func clamp(v float64, lo float64, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
+
+// Returns the frame as a TV would show it, OutputWidth pixels wide.
+func (f *Filter) Apply(frame *video.Frame) *image.RGBA {
+	width := OutputWidth(frame.Width)
+	img := image.NewRGBA(image.Rect(0, 0, width, frame.Height))
+	samples := frame.Width * SAMPLES_PER_PIXEL
+	line := make([]float64, samples)
+	saturation := clamp(f.options.Saturation+1, 0, 2)
+
+	for y := 0; y < frame.Height; y++ {
+		start := (f.phase + y*SCANLINE_PHASE) % PHASES
+		for x := 0; x < frame.Width; x++ {
+			pixel := frame.At(x, y) & 0x1FF
+			for i := 0; i < SAMPLES_PER_PIXEL; i++ {
+				line[x*SAMPLES_PER_PIXEL+i] = f.signal[pixel][(start+x*SAMPLES_PER_PIXEL+i)%PHASES]
+			}
+		}
+
+		for x := 0; x < width; x++ {
+			centre := (2*x + 1) * samples / (2 * width)
+			// Chroma is demodulated over a whole subcarrier cycle.
+			var luma, i, q float64
+			for p := max(centre-PHASES/2, 0); p < min(centre+PHASES/2, samples); p++ {
+				phase := (start + p) % PHASES
+				i += line[p] * f.cos[phase]
+				q += line[p] * f.sin[phase]
+			}
+			n := 0
+			for p := max(centre-f.lumaWidth, 0); p < min(centre+f.lumaWidth, samples); p++ {
+				luma += line[p]
+				n++
+			}
+			luma /= float64(n)
+			i *= saturation / PHASES
+			q *= saturation / PHASES
+
+			c := f.rgb(luma, i, q)
+			offset := img.PixOffset(x, y)
+			img.Pix[offset+0] = c.R
+			img.Pix[offset+1] = c.G
+			img.Pix[offset+2] = c.B
+			img.Pix[offset+3] = 0xFF
+		}
+	}
+
+	// The odd frame is a dot shorter when rendering, so the phase alternates.
+	f.phase = (f.phase + 4) % 8
+	return img
+}
+
+// Returns the colour each pixel value comes out as when the screen is filled
+// with it, a palette for showing frames without the filter that still looks
+// like the TV, emphasis included.
+func (f *Filter) Palette() *video.Palette {
+	var p video.Palette
+	saturation := clamp(f.options.Saturation+1, 0, 2)
+	for pixel := range p {
+		// A whole subcarrier cycle cancels the chroma out of the luma.
+		var luma, i, q float64
+		for phase := 0; phase < PHASES; phase++ {
+			level := f.signal[pixel][phase]
+			luma += level
+			i += level * f.cos[phase]
+			q += level * f.sin[phase]
+		}
+		p[pixel] = f.rgb(luma/PHASES, i*saturation/PHASES, q*saturation/PHASES)
+	}
+	return &p
+}
+
+// Converts YIQ to gamma corrected RGB.
+func (f *Filter) rgb(luma float64, i float64, q float64) color.RGBA {
+	return color.RGBA{
+		f.correct(luma + 0.946882*i + 0.623557*q),
+		f.correct(luma - 0.274788*i - 0.635691*q),
+		f.correct(luma - 1.108545*i + 1.709007*q),
+		0xFF,
+	}
+}
+
+func (f *Filter) correct(v float64) uint8 {
+	return f.gamma[int(clamp(v, 0, 1)*float64(len(f.gamma)-1))]
+}
@@ -0,0 +1,114 @@
+package ntsc
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"switchtrue.com/hankee/video"
+)
+
+func filled(index uint16) *video.Frame {
+	f := video.NewFrame(video.WIDTH, video.HEIGHT)
+	f.Fill(index)
+	return f
+}
+
+// Returns the colour in the middle of the picture.
+func middle(f *Filter, frame *video.Frame) color.RGBA {
+	img := f.Apply(frame)
+	return img.RGBAAt(img.Bounds().Dx()/2, img.Bounds().Dy()/2)
+}
+
+// Test that greys stay grey and colours come out roughly the right way round
+func Test_Filter_Colours(t *testing.T) {
+	f := New(Options{})
+	img := f.Apply(filled(0x0F))
+	assert.Equal(t, 602, img.Bounds().Dx())
+	assert.Equal(t, 240, img.Bounds().Dy())
+	assert.Equal(t, color.RGBA{0, 0, 0, 0xFF}, img.RGBAAt(300, 100))
+
+	grey := middle(f, filled(0x10))
+	assert.InDelta(t, grey.R, grey.G, 2)
+	assert.InDelta(t, grey.G, grey.B, 2)
+	assert.Greater(t, middle(f, filled(0x30)).R, grey.R)
+
+	red := middle(f, filled(0x16))
+	assert.Greater(t, red.R, red.G)
+	assert.Greater(t, red.R, red.B)
+	blue := middle(f, filled(0x12))
+	assert.Greater(t, blue.B, blue.R)
+	assert.Greater(t, blue.B, blue.G)
+
+	// Emphasising red dims green and blue.
+	emphasised := middle(f, filled(0x30|0x1<<6))
+	white := middle(f, filled(0x30))
+	assert.Less(t, emphasised.B, white.B)
+}
+
+// Test the picture controls
+func Test_Filter_Options(t *testing.T) {
+	grey := middle(New(Options{Saturation: -1}), filled(0x16))
+	assert.InDelta(t, grey.R, grey.B, 2)
+
+	// Half a turn of hue takes red towards cyan.
+	turned := middle(New(Options{Hue: 1}), filled(0x16))
+	assert.Greater(t, turned.B, turned.R)
+
+	// A checkerboard blends into one colour unless the luma is kept sharp.
+	checks := video.NewFrame(video.WIDTH, video.HEIGHT)
+	for i := range checks.Pix {
+		checks.Pix[i] = uint16(0x0F + (i%2)*0x21)
+	}
+	spread := func(options Options) int {
+		img := New(options).Apply(checks)
+		lo, hi := 255, 0
+		for x := 200; x < 400; x++ {
+			g := int(img.RGBAAt(x, 100).G)
+			lo, hi = min(lo, g), max(hi, g)
+		}
+		return hi - lo
+	}
+	assert.Greater(t, spread(Options{Sharpness: 1}), spread(Options{Sharpness: -1}))
+}
+
+// Test that one-pixel stripes blend into the colour between them
+func Test_Filter_Dither(t *testing.T) {
+	blend := func(a uint16, b uint16) color.RGBA {
+		stripes := video.NewFrame(video.WIDTH, video.HEIGHT)
+		for y := 0; y < stripes.Height; y++ {
+			for x := 0; x < stripes.Width; x++ {
+				stripes.Set(x, y, []uint16{a, b}[x%2])
+			}
+		}
+		// The artifacts swap round between frames, so average two of them.
+		f := New(Options{})
+		var sum [3]int
+		n := 0
+		for range 2 {
+			img := f.Apply(stripes)
+			for y := 100; y < 104; y++ {
+				for x := 100; x < 500; x++ {
+					c := img.RGBAAt(x, y)
+					sum[0], sum[1], sum[2] = sum[0]+int(c.R), sum[1]+int(c.G), sum[2]+int(c.B)
+					n++
+				}
+			}
+		}
+		return color.RGBA{uint8(sum[0] / n), uint8(sum[1] / n), uint8(sum[2] / n), 0xFF}
+	}
+	assertNear := func(expected color.RGBA, actual color.RGBA) {
+		t.Helper()
+		assert.InDelta(t, expected.R, actual.R, 4)
+		assert.InDelta(t, expected.G, actual.G, 4)
+		assert.InDelta(t, expected.B, actual.B, 4)
+	}
+
+	// Black and white make a mid grey.
+	assertNear(color.RGBA{119, 119, 119, 0xFF}, blend(0x0F, 0x30))
+	// Red and blue make a purple that is neither of them.
+	purple := blend(0x16, 0x12)
+	assertNear(color.RGBA{96, 61, 127, 0xFF}, purple)
+	assert.NotEqual(t, middle(New(Options{}), filled(0x16)), purple)
+	assert.NotEqual(t, middle(New(Options{}), filled(0x12)), purple)
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"switchtrue.com/hankee/capture"
+	"switchtrue.com/hankee/cartridge"
+	"switchtrue.com/hankee/cheat"
+	"switchtrue.com/hankee/cpu"
+	"switchtrue.com/hankee/joypad"
+	"switchtrue.com/hankee/nes"
+	"switchtrue.com/hankee/video"
+)
+
+// address is a flag holding a 16 bit address. It accepts $C000, 0xC000 or
+// plain decimal and remembers whether it was given at all.
+type address struct {
+	value uint16
+	set   bool
+}
+
+func (a *address) String() string {
+	return fmt.Sprintf("$%04X", a.value)
+}
+
+func (a *address) Set(s string) error {
+	value, err := parseAddress(s)
+	if err != nil {
+		return err
+	}
+	a.value = value
+	a.set = true
+	return nil
+}
+
+func parseAddress(s string) (uint16, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "$") {
+		s = "0x" + s[1:]
+	}
+	value, err := strconv.ParseUint(s, 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address %q", s)
+	}
+	return uint16(value), nil
+}
+
+func parseByte(s string) (uint8, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "$") {
+		s = "0x" + s[1:]
+	}
+	value, err := strconv.ParseUint(s, 0, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid byte %q", s)
+	}
+	return uint8(value), nil
+}
+
+// Parses a possibly negative number in decimal, or hex with $ or 0x.
+func parseValue(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	digits := strings.TrimPrefix(s, "-")
+	if strings.HasPrefix(digits, "$") {
+		digits = "0x" + digits[1:]
+	}
+	value, err := strconv.ParseInt(digits, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if negative {
+		value = -value
+	}
+	return value, nil
+}
+
+// loadOptions are the flags shared by every command that loads a program.
+type loadOptions struct {
+	raw       bool
+	load      address
+	entry     address
+	region    string
+	variant   string
+	ports     [2]string
+	expansion string
+}
+
+func (o *loadOptions) register(fs *flag.FlagSet) {
+	o.load = address{value: 0x8000}
+	fs.BoolVar(&o.raw, "raw", false, "treat the file as a raw binary even if it has an iNES header")
+	fs.Var(&o.load, "load", "address to load a raw binary at")
+	fs.Var(&o.entry, "entry", "address to start executing from (defaults to the reset vector)")
+	fs.StringVar(&o.region, "region", "auto", "console region: auto, ntsc, pal or dendy")
+	fs.StringVar(&o.variant, "cpu", "2a03", "CPU variant: 2a03 or 6502")
+	fs.StringVar(&o.ports[0], "port1", "joypad", "what's plugged into controller port 1: joypad, zapper, vaus or fourscore")
+	fs.StringVar(&o.ports[1], "port2", "joypad", "what's plugged into controller port 2: joypad, zapper, vaus or fourscore")
+	fs.StringVar(&o.expansion, "expansion", "none", "what's plugged into the Famicom expansion port: none, 4player, vaus or keyboard")
+}
+
+// session is a loaded program ready to run. ROMs run on a full machine while
+// raw binaries run on a CPU with a flat 64KB RAM, since they usually expect
+// RAM wherever they were assembled to.
+type session struct {
+	cpu       *cpu.CPU
+	machine   *nes.Machine
+	cartridge *cartridge.Cartridge
+	program   []uint8
+}
+
+func (o *loadOptions) open(path string) (*session, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	variant, err := parseVariant(o.variant)
+	if err != nil {
+		return nil, err
+	}
+
+	var s *session
+	if o.raw || !bytes.HasPrefix(raw, cartridge.NES_TAG) {
+		s = o.openRaw(raw)
+	} else {
+		s, err = o.openROM(raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.cpu.SetVariant(variant)
+	if o.entry.set {
+		registers := s.cpu.Registers()
+		registers.PC = o.entry.value
+		s.cpu.SetRegisters(registers)
+	}
+	return s, nil
+}
+
+// Returns the machine's timing. Raw binaries have no machine and count frames
+// like NTSC.
+func (s *session) timing() nes.Timing {
+	if s.machine != nil {
+		return s.machine.Timing()
+	}
+	return nes.NTSC.Timing()
+}
+
+func (o *loadOptions) openRaw(program []uint8) *session {
+	c := cpu.NewCPU()
+	c.LoadAt(o.load.value, program)
+	c.Reset()
+	return &session{cpu: c, program: program}
+}
+
+func (o *loadOptions) openROM(raw []uint8) (*session, error) {
+	cart, err := cartridge.Load(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	machine := nes.New()
+	region := nes.RegionFor(cart)
+	if o.region != "auto" {
+		region, err = nes.ParseRegion(o.region)
+		if err != nil {
+			return nil, err
+		}
+	}
+	machine.SetRegion(region)
+	machine.InsertCartridge(cart)
+	if err := o.plug(machine); err != nil {
+		return nil, err
+	}
+
+	return &session{cpu: machine.CPU(), machine: machine, cartridge: cart}, nil
+}
+
+// Plugs the devices named by --port1, --port2 and --expansion into the
+// machine. The Family BASIC keyboard comes with its data recorder. A Four
+// Score takes up both ports, so it can be named for either as long as the
+// other is left with its joypad or also says fourscore.
+func (o *loadOptions) plug(machine *nes.Machine) error {
+	ports := machine.Joypads()
+	var fourScore bool
+	for i, name := range o.ports {
+		switch strings.ToLower(name) {
+		case "", "joypad":
+			ports.Plug(i+1, nil)
+		case "zapper":
+			ports.Plug(i+1, joypad.NewZapper(machine))
+		case "vaus":
+			ports.Plug(i+1, joypad.NewArkanoid())
+		case "fourscore":
+			fourScore = true
+		default:
+			return fmt.Errorf("unknown device %q for port %d, expected joypad, zapper, vaus or fourscore", name, i+1)
+		}
+	}
+	if fourScore {
+		for i, name := range o.ports {
+			if name := strings.ToLower(name); name != "fourscore" && name != "joypad" && name != "" {
+				return fmt.Errorf("the Four Score takes both ports, port %d can't have a %s too", i+1, name)
+			}
+		}
+		ports.PlugFourScore()
+	}
+
+	switch strings.ToLower(o.expansion) {
+	case "", "none":
+		ports.PlugExpansion(nil)
+	case "4player":
+		ports.PlugExpansion(joypad.NewFamicomAdapter(ports.Three, ports.Four))
+	case "vaus":
+		ports.PlugExpansion(joypad.NewFamicomArkanoid())
+	case "keyboard":
+		recorder := joypad.NewDataRecorder(machine.CPU(), machine.Timing().CPUClock())
+		ports.PlugExpansion(joypad.NewKeyboard(recorder))
+	default:
+		return fmt.Errorf("unknown expansion device %q, expected none, 4player, vaus or keyboard", o.expansion)
+	}
+	return nil
+}
+
+func parseVariant(name string) (cpu.Variant, error) {
+	switch strings.ToLower(name) {
+	case "2a03":
+		return cpu.Ricoh2A03, nil
+	case "6502":
+		return cpu.MOS6502, nil
+	default:
+		return cpu.Ricoh2A03, fmt.Errorf("unknown CPU variant %q, expected 2a03 or 6502", name)
+	}
+}
+
+// cheatOptions are the --cheat flags, each a code or a cheat file, which can
+// be given more than once.
+type cheatOptions struct {
+	specs []string
+}
+
+func (o *cheatOptions) register(fs *flag.FlagSet) {
+	fs.Var(o, "cheat", "Game Genie or RAM write (AAAA:VV) code, or a cheat file; can be repeated")
+}
+
+func (o *cheatOptions) String() string {
+	return strings.Join(o.specs, ",")
+}
+
+func (o *cheatOptions) Set(s string) error {
+	o.specs = append(o.specs, s)
+	return nil
+}
+
+// Returns the codes given. Anything that exists as a file is loaded as a cheat
+// file, .cht files as FCEUX's.
+func (o *cheatOptions) codes() ([]cheat.Code, error) {
+	var codes []cheat.Code
+	for _, spec := range o.specs {
+		if _, err := os.Stat(spec); err == nil {
+			loaded, err := cheat.LoadFile(spec)
+			if err != nil {
+				return nil, err
+			}
+			codes = append(codes, loaded...)
+			continue
+		}
+		code, err := cheat.Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// Puts a cheat engine with the codes in front of the CPU's memory.
+func (o *cheatOptions) install(c *cpu.CPU) (*cheat.Engine, error) {
+	codes, err := o.codes()
+	if err != nil {
+		return nil, err
+	}
+	engine := cheat.New(c.Memory())
+	for _, code := range codes {
+		engine.Add(code)
+	}
+	c.SetMemory(engine)
+	return engine, nil
+}
+
+// captureOptions record a stretch of a run to a GIF or Y4M file with the
+// sound alongside.
+type captureOptions struct {
+	path   string
+	audio  string
+	start  uint64
+	frames uint64
+
+	capture *capture.Capture
+	count   uint64
+	err     error
+}
+
+func (o *captureOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.path, "capture", "", "GIF or Y4M file to capture the picture to, or - for Y4M on stdout")
+	fs.StringVar(&o.audio, "capture-audio", "", "WAV file for the sound while capturing, or none (default --capture with a .wav extension)")
+	fs.Uint64Var(&o.start, "capture-start", 0, "frame to start capturing on")
+	fs.Uint64Var(&o.frames, "capture-frames", 0, "frames to capture (0 for until the program stops)")
+}
+
+// Returns where the sound goes, if anywhere.
+func (o *captureOptions) audioPath() string {
+	switch {
+	case o.audio == "none":
+		return ""
+	case o.audio != "":
+		return o.audio
+	case o.path == "-":
+		return ""
+	default:
+		return capture.AudioPath(o.path)
+	}
+}
+
+func (o *captureOptions) validate() error {
+	if o.path == "" {
+		return nil
+	}
+	if o.path != "-" {
+		if _, err := capture.FormatFor(o.path); err != nil {
+			return err
+		}
+	}
+	if o.path == "-" && o.audio == "-" {
+		return errors.New("--capture and --capture-audio can't both be stdout")
+	}
+	return nil
+}
+
+// Returns a frame hook starting the capture at the start frame and stopping
+// it once it has enough frames. Frame hooks run as frames start, so each call
+// captures the frame that just finished.
+func (o *captureOptions) hook(m *nes.Machine, colours *video.Palette) func() bool {
+	return func() bool {
+		frame := o.count
+		o.count++
+		switch {
+		case o.capture == nil && frame == o.start:
+			o.capture, o.err = capture.Start(m, capture.Options{Path: o.path, Stdout: os.Stdout, Audio: o.audioPath(), Palette: colours})
+		case o.capture != nil:
+			o.err = o.capture.Frame()
+			if o.err == nil && o.frames > 0 && frame == o.start+o.frames {
+				o.err = o.stop()
+			}
+		}
+		return o.err == nil
+	}
+}
+
+// Finishes the capture if it's still going, returning any error from along
+// the way.
+func (o *captureOptions) stop() error {
+	if o.capture != nil {
+		err := o.capture.Stop()
+		o.capture = nil
+		if o.err == nil {
+			o.err = err
+		}
+	}
+	return o.err
+}
+
+// limitOptions bound how long a program is allowed to run. Zero means no limit.
+type limitOptions struct {
+	maxCycles       uint64
+	maxInstructions uint64
+}
+
+func (o *limitOptions) register(fs *flag.FlagSet) {
+	fs.Uint64Var(&o.maxCycles, "max-cycles", 0, "stop after this many CPU cycles (0 for no limit)")
+	fs.Uint64Var(&o.maxInstructions, "max-instructions", 0, "stop after this many instructions (0 for no limit)")
+}
+
+type stopReason int
+
+const (
+	stopHalted stopReason = iota
+	stopLimit
+	stopRequested
+)
+
+// Runs the CPU until it halts or a limit is hit. before is called ahead of
+// every instruction and can stop execution by returning false. Any panic in the
+// CPU, such as an unknown opcode, is returned as an error.
+func (o *limitOptions) execute(c *cpu.CPU, before func() bool) (reason stopReason, err error) {
+	var instructions uint64
+	pc := c.Registers().PC
+	defer func() {
+		if r := recover(); r != nil {
+			reason = stopHalted
+			err = fmt.Errorf("%v at $%04X", strings.TrimSpace(fmt.Sprint(r)), pc)
+		}
+	}()
+
+	reason = stopHalted
+	c.RunWithCallback(func(c *cpu.CPU) bool {
+		switch {
+		case o.maxInstructions != 0 && instructions >= o.maxInstructions:
+			reason = stopLimit
+			return false
+		case o.maxCycles != 0 && c.Cycles() >= o.maxCycles:
+			reason = stopLimit
+			return false
+		case before != nil && !before():
+			reason = stopRequested
+			return false
+		}
+		pc = c.Registers().PC
+		instructions++
+		return true
+	})
+	return reason, nil
+}
+
+// Executes one instruction, turning a panic into an error.
+func step(c *cpu.CPU) (running bool, err error) {
+	pc := c.Registers().PC
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v at $%04X", strings.TrimSpace(fmt.Sprint(r)), pc)
+		}
+	}()
+	return c.Step(), nil
+}
+
+// Parses the flags for a command that takes exactly one file argument. When
+// it fails, the exit code to stop with is returned as well.
+func parseFileArgs(fs *flag.FlagSet, args []string) (string, int, bool) {
+	files, code, ok := parseArgs(fs, args)
+	if !ok {
+		return "", code, false
+	}
+	if len(files) != 1 {
+		fmt.Fprintf(fs.Output(), "%s: expected exactly one file\n", fs.Name())
+		fs.Usage()
+		return "", exitUsage, false
+	}
+	return files[0], exitOK, true
+}
+
+// Parses the flags for a command whose file argument can be left out, as
+// parseFileArgs does, returning "" without one.
+func parseOptionalFileArgs(fs *flag.FlagSet, args []string) (string, int, bool) {
+	files, code, ok := parseArgs(fs, args)
+	if !ok {
+		return "", code, false
+	}
+	if len(files) > 1 {
+		fmt.Fprintf(fs.Output(), "%s: expected at most one file\n", fs.Name())
+		fs.Usage()
+		return "", exitUsage, false
+	}
+	if len(files) == 0 {
+		return "", exitOK, true
+	}
+	return files[0], exitOK, true
+}
+
+// Parses flags that can come before, between or after the arguments, and
+// returns the arguments.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, int, bool) {
+	if err := fs.Parse(args); err != nil {
+		return nil, parseExit(err), false
+	}
+	var rest []string
+	for fs.NArg() > 0 {
+		rest = append(rest, fs.Arg(0))
+		if err := fs.Parse(fs.Args()[1:]); err != nil {
+			return nil, parseExit(err), false
+		}
+	}
+	return rest, exitOK, true
+}
+
+// Returns the exit code for a flag parsing error. Asking for help with -h
+// isn't a mistake, so it succeeds.
+func parseExit(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	return exitUsage
+}
+
+func newFlagSet(name string, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: hankee %s %s\n\n", name, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func printRegisters(w io.Writer, c *cpu.CPU) {
+	r := c.Registers()
+	fmt.Fprintf(w, "PC:%04X A:%02X X:%02X Y:%02X P:%02X SP:%02X CYC:%d\n", r.PC, r.A, r.X, r.Y, r.Status, r.SP, c.Cycles())
+}
@@ -0,0 +1,117 @@
+// Package palette picks the colours frames are shown in, from the palettes
+// built in for the different PPUs, one decoded from the composite signal like
+// a TV would, or a .pal file.
+package palette
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"strings"
+
+	"switchtrue.com/hankee/ntsc"
+	"switchtrue.com/hankee/video"
+)
+
+const (
+	// Size of a .pal file with 64 RGB colours.
+	PAL_SIZE = 64 * 3
+	// Size of a .pal file with the 64 colours under each of the 8
+	// combinations of emphasis bits.
+	EMPHASIS_PAL_SIZE = 512 * 3
+)
+
+// The built in palettes.
+var NAMES = []string{"2c02", "composite", "2c03", "2c05"}
+
+// The 2C03 and 2C05 RGB PPUs' colours, 3 bits each of red, green and blue.
+var rgbPPU = [64]uint16{
+	0333, 0014, 0006, 0326, 0403, 0503, 0510, 0420, 0320, 0120, 0031, 0040, 0022, 0000, 0000, 0000,
+	0555, 0036, 0027, 0407, 0507, 0704, 0700, 0630, 0430, 0140, 0040, 0053, 0044, 0000, 0000, 0000,
+	0777, 0357, 0447, 0637, 0707, 0737, 0740, 0750, 0660, 0360, 0070, 0276, 0077, 0000, 0000, 0000,
+	0777, 0567, 0657, 0757, 0747, 0755, 0764, 0772, 0773, 0572, 0473, 0276, 0467, 0000, 0000, 0000,
+}
+
+// Returns a built in palette by name:
+//
+//	2c02       the NTSC PPU's colours, as measured for the tutorial
+//	composite  decoded from the 2C02's composite signal with the NTSC filter's
+//	           hue and saturation controls, so it can be tuned
+//	2c03       the RGB PPU used in arcade and PlayChoice-10 machines
+//	2c05       the Vs. System RGB PPU, which has the 2C03's colours
+func Named(name string, options ntsc.Options) (*video.Palette, bool) {
+	switch strings.ToLower(name) {
+	case "2c02":
+		p := video.DefaultPalette
+		return &p, true
+	case "composite":
+		return ntsc.New(options).Palette(), true
+	case "2c03", "2c05":
+		var colours [64]color.RGBA
+		for i, rgb := range rgbPPU {
+			level := func(shift int) uint8 {
+				return uint8((rgb >> shift & 7) * 255 / 7)
+			}
+			colours[i] = color.RGBA{level(6), level(3), level(0), 0xFF}
+		}
+		return video.ExpandRGB(colours), true
+	default:
+		return nil, false
+	}
+}
+
+// Returns the palette with a name, see Named, or else reads it from a file.
+func Load(name string, options ntsc.Options) (*video.Palette, error) {
+	if p, ok := Named(name, options); ok {
+		return p, nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) && !strings.ContainsAny(name, `./\`) {
+			return nil, fmt.Errorf("unknown palette %q, expected one of %s or a .pal file", name, strings.Join(NAMES, ", "))
+		}
+		return nil, err
+	}
+	defer f.Close()
+	p, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return p, nil
+}
+
+// Reads a .pal file of RGB triples, either 64 colours with the emphasised ones
+// worked out from them or all 512.
+func Read(r io.Reader) (*video.Palette, error) {
+	data, err := io.ReadAll(io.LimitReader(r, EMPHASIS_PAL_SIZE+1))
+	if err != nil {
+		return nil, err
+	}
+	switch len(data) {
+	case PAL_SIZE:
+		var colours [64]color.RGBA
+		for i := range colours {
+			colours[i] = color.RGBA{data[i*3], data[i*3+1], data[i*3+2], 0xFF}
+		}
+		return video.Expand(colours), nil
+	case EMPHASIS_PAL_SIZE:
+		var p video.Palette
+		for i := range p {
+			p[i] = color.RGBA{data[i*3], data[i*3+1], data[i*3+2], 0xFF}
+		}
+		return &p, nil
+	default:
+		return nil, fmt.Errorf("palettes are %d or %d bytes, not %d", PAL_SIZE, EMPHASIS_PAL_SIZE, len(data))
+	}
+}
+
+// Writes all 512 colours as a .pal file.
+func Write(w io.Writer, p *video.Palette) error {
+	data := make([]uint8, 0, EMPHASIS_PAL_SIZE)
+	for _, c := range p {
+		data = append(data, c.R, c.G, c.B)
+	}
+	_, err := w.Write(data)
+	return err
+}
@@ -0,0 +1,75 @@
+package palette
+
+import (
+	"bytes"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"switchtrue.com/hankee/ntsc"
+	"switchtrue.com/hankee/video"
+)
+
+// Test the built in palettes and how they treat emphasis
+func Test_Named(t *testing.T) {
+	for _, name := range NAMES {
+		p, ok := Named(name, ntsc.Options{})
+		require.True(t, ok, name)
+		assert.Equal(t, color.RGBA{0, 0, 0, 0xFF}, p.Color(0x0D), name)
+	}
+	_, ok := Named("2c04", ntsc.Options{})
+	assert.False(t, ok)
+
+	// The 2C02 dims what isn't emphasised, the 2C03 turns emphasised
+	// channels fully on.
+	p, _ := Named("2c02", ntsc.Options{})
+	white, red := p.Color(0x30), p.Color(video.Pixel(0x30, 0x20))
+	assert.Equal(t, white.R, red.R)
+	assert.Less(t, red.G, white.G)
+	assert.Less(t, p.Color(0x130).R, white.R, "blue emphasis dims red")
+
+	rgb, _ := Named("2c03", ntsc.Options{})
+	assert.Equal(t, color.RGBA{0x6D, 0x6D, 0x6D, 0xFF}, rgb.Color(0x00))
+	assert.Equal(t, color.RGBA{0xFF, 0x00, 0x00, 0xFF}, rgb.Color(video.Pixel(0x0F, 0x20)))
+
+	// Turning the composite palette's saturation down leaves greys.
+	grey, _ := Named("composite", ntsc.Options{Saturation: -1})
+	c := grey.Color(0x16)
+	assert.Equal(t, c.R, c.G)
+	assert.Equal(t, c.G, c.B)
+}
+
+// Test greyscale and emphasis in PPUMASK
+func Test_Pixel(t *testing.T) {
+	assert.Equal(t, uint16(0x16), video.Pixel(0x16, 0))
+	assert.Equal(t, uint16(0x10), video.Pixel(0x16, video.MASK_GREYSCALE))
+	assert.Equal(t, uint16(0x1C0|0x21), video.Pixel(0x21, 0xE0))
+	assert.Equal(t, uint8(0x41), video.SwapEmphasis(0x21))
+}
+
+// Test reading and writing .pal files of both sizes
+func Test_ReadWrite(t *testing.T) {
+	small := make([]uint8, PAL_SIZE)
+	small[0x30*3], small[0x30*3+1], small[0x30*3+2] = 200, 200, 200
+	p, err := Read(bytes.NewReader(small))
+	require.NoError(t, err)
+	assert.Equal(t, color.RGBA{200, 200, 200, 0xFF}, p.Color(0x30))
+	assert.Equal(t, color.RGBA{200, 163, 163, 0xFF}, p.Color(0x30|1<<6))
+
+	var out bytes.Buffer
+	require.NoError(t, Write(&out, p))
+	assert.Equal(t, EMPHASIS_PAL_SIZE, out.Len())
+	path := filepath.Join(t.TempDir(), "mine.pal")
+	require.NoError(t, os.WriteFile(path, out.Bytes(), 0o644))
+	loaded, err := Load(path, ntsc.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, p, loaded)
+
+	_, err = Read(bytes.NewReader(make([]uint8, 100)))
+	assert.Error(t, err)
+	_, err = Load("sepia", ntsc.Options{})
+	assert.ErrorContains(t, err, "unknown palette")
+}
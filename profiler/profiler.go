@@ -0,0 +1,242 @@
+// Package profiler finds where a program spends its time, attributing CPU
+// cycles to the instruction that used them and to the chain of subroutines it
+// was called through. Profiles are written in pprof format so they can be
+// explored with go tool pprof, including as flame graphs.
+package profiler
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"switchtrue.com/hankee/callstack"
+	"switchtrue.com/hankee/cpu"
+	"switchtrue.com/hankee/dbginfo"
+)
+
+// Every sample holds both values, cycles is the one shown by default.
+const (
+	SAMPLE_INSTRUCTIONS = 0
+	SAMPLE_CYCLES       = 1
+)
+
+// A stack of addresses, innermost first, packed into a string so it can key a
+// map.
+type stackKey string
+
+// site is an address and the routine it was running in, which becomes a pprof
+// location.
+type site struct {
+	addr    uint16
+	routine uint16
+}
+
+type counts [2]int64
+
+// Profiler records where cycles go. It has to see every instruction before it
+// executes, so Before is called from the CPU's run callback.
+type Profiler struct {
+	symbols *dbginfo.Info
+	calls   callstack.Tracker
+	samples map[stackKey]*counts
+	entry   uint16
+	started bool
+
+	// The instruction whose cycles are still to be counted.
+	pending    stackKey
+	lastCycles uint64
+	start      time.Time
+}
+
+// Creates a profiler. symbols names routines and adds source lines, it can be
+// nil in which case routines are named after their address.
+func New(symbols *dbginfo.Info) *Profiler {
+	return &Profiler{symbols: symbols, samples: map[stackKey]*counts{}}
+}
+
+// Records the instruction the CPU is about to execute, charging the cycles
+// used since the last call to the instruction before it.
+func (p *Profiler) Before(c *cpu.CPU) {
+	p.flush(c)
+	if !p.started {
+		p.started = true
+		p.entry = c.Registers().PC
+		p.start = time.Now()
+	}
+	p.pending = p.stack(c.Registers().PC)
+	p.lastCycles = c.Cycles()
+	// The call stack is updated afterwards so a JSR is charged to its caller.
+	p.calls.Before(c)
+}
+
+// Charges the cycles used by the last instruction. Call once the CPU has
+// stopped, before writing the profile.
+func (p *Profiler) Stop(c *cpu.CPU) {
+	p.flush(c)
+	p.pending = ""
+}
+
+func (p *Profiler) flush(c *cpu.CPU) {
+	if p.pending == "" {
+		return
+	}
+	sample, ok := p.samples[p.pending]
+	if !ok {
+		sample = &counts{}
+		p.samples[p.pending] = sample
+	}
+	sample[SAMPLE_INSTRUCTIONS]++
+	sample[SAMPLE_CYCLES] += int64(c.Cycles() - p.lastCycles)
+}
+
+// Builds the key for the stack at pc: pc followed by each JSR still waiting
+// to return, innermost first, each paired with the routine it is in.
+func (p *Profiler) stack(pc uint16) stackKey {
+	frames := p.calls.Frames()
+	var b strings.Builder
+	routine := p.entry
+	if len(frames) > 0 {
+		routine = frames[len(frames)-1].Target
+	}
+	writeSite(&b, site{pc, routine})
+	for i := len(frames) - 1; i >= 0; i-- {
+		routine = p.entry
+		if i > 0 {
+			routine = frames[i-1].Target
+		}
+		writeSite(&b, site{frames[i].Caller, routine})
+	}
+	return stackKey(b.String())
+}
+
+func writeSite(b *strings.Builder, s site) {
+	b.WriteByte(byte(s.addr >> 8))
+	b.WriteByte(byte(s.addr))
+	b.WriteByte(byte(s.routine >> 8))
+	b.WriteByte(byte(s.routine))
+}
+
+func (k stackKey) sites() []site {
+	sites := make([]site, len(k)/4)
+	for i := range sites {
+		s := k[i*4:]
+		sites[i] = site{uint16(s[0])<<8 | uint16(s[1]), uint16(s[2])<<8 | uint16(s[3])}
+	}
+	return sites
+}
+
+// Returns the total cycles recorded.
+func (p *Profiler) Cycles() int64 {
+	var total int64
+	for _, sample := range p.samples {
+		total += sample[SAMPLE_CYCLES]
+	}
+	return total
+}
+
+// Builds a pprof profile of everything recorded so far. name is shown as the
+// binary the addresses belong to.
+func (p *Profiler) Profile(name string) *profile.Profile {
+	prof := &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "instructions", Unit: "count"},
+			{Type: "cycles", Unit: "count"},
+		},
+		DefaultSampleType: "cycles",
+		PeriodType:        &profile.ValueType{Type: "cycles", Unit: "count"},
+		Period:            1,
+		TimeNanos:         p.start.UnixNano(),
+		Mapping: []*profile.Mapping{{
+			ID:             1,
+			Start:          0,
+			Limit:          0x10000,
+			File:           name,
+			HasFunctions:   true,
+			HasFilenames:   p.symbols != nil,
+			HasLineNumbers: p.symbols != nil,
+		}},
+	}
+
+	functions := map[uint16]*profile.Function{}
+	function := func(routine uint16) *profile.Function {
+		if f, ok := functions[routine]; ok {
+			return f
+		}
+		f := &profile.Function{ID: uint64(len(prof.Function) + 1), Name: p.routineName(routine)}
+		if line, ok := p.line(routine); ok {
+			f.Filename = line.File
+			f.StartLine = int64(line.Line)
+		}
+		functions[routine] = f
+		prof.Function = append(prof.Function, f)
+		return f
+	}
+
+	locations := map[site]*profile.Location{}
+	location := func(s site) *profile.Location {
+		if l, ok := locations[s]; ok {
+			return l
+		}
+		l := &profile.Location{
+			ID:      uint64(len(prof.Location) + 1),
+			Mapping: prof.Mapping[0],
+			Address: uint64(s.addr),
+		}
+		line := profile.Line{Function: function(s.routine)}
+		if source, ok := p.line(s.addr); ok {
+			line.Line = int64(source.Line)
+			// Routines without a line at their entry point pick up the file
+			// from any line inside them.
+			if line.Function.Filename == "" {
+				line.Function.Filename = source.File
+			}
+		}
+		l.Line = []profile.Line{line}
+		locations[s] = l
+		prof.Location = append(prof.Location, l)
+		return l
+	}
+
+	// Sorted so the same run always writes the same file.
+	for _, key := range slices.Sorted(maps.Keys(p.samples)) {
+		sample := p.samples[key]
+		var stack []*profile.Location
+		for _, s := range key.sites() {
+			stack = append(stack, location(s))
+		}
+		prof.Sample = append(prof.Sample, &profile.Sample{
+			Location: stack,
+			Value:    []int64{sample[SAMPLE_INSTRUCTIONS], sample[SAMPLE_CYCLES]},
+		})
+	}
+	return prof
+}
+
+// Writes the profile gzipped, as go tool pprof expects.
+func (p *Profiler) Write(w io.Writer, name string) error {
+	return p.Profile(name).Write(w)
+}
+
+// Names a routine after its label, or its address if there's no label there.
+func (p *Profiler) routineName(addr uint16) string {
+	if p.symbols != nil {
+		if name, ok := p.symbols.SymbolAt(addr); ok {
+			return name
+		}
+		if name, offset, ok := p.symbols.SymbolFor(addr); ok {
+			return fmt.Sprintf("%s+%d", name, offset)
+		}
+	}
+	return fmt.Sprintf("$%04X", addr)
+}
+
+func (p *Profiler) line(addr uint16) (dbginfo.Line, bool) {
+	if p.symbols == nil {
+		return dbginfo.Line{}, false
+	}
+	return p.symbols.LineFor(addr)
+}
@@ -0,0 +1,110 @@
+package profiler
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"switchtrue.com/hankee/cpu"
+	"switchtrue.com/hankee/dbginfo"
+)
+
+// main:  JSR sub; JSR sub; BRK
+// sub:   LDX #$03
+// loop:  DEX; BNE loop; JSR inner; RTS
+// inner: RTS
+var program = []uint8{
+	0x20, 0x07, 0x06, 0x20, 0x07, 0x06, 0x00,
+	0xa2, 0x03, 0xca, 0xd0, 0xfd, 0x20, 0x10, 0x06, 0x60,
+	0x60,
+}
+
+const programDebugInfo = `file	id=0,name="hot.s",size=100,mtime=0x5E1B6BFB,mod=0
+seg	id=0,name="CODE",start=0x000600,size=0x0011,addrsize=absolute,type=rw
+span	id=0,seg=0,start=9,size=1
+line	id=0,file=0,line=12,span=0
+sym	id=0,name="main",addrsize=absolute,scope=0,def=0,val=0x600,seg=0,type=lab
+sym	id=1,name="sub",addrsize=absolute,scope=0,def=0,val=0x607,seg=0,type=lab
+sym	id=2,name="loop",addrsize=absolute,scope=0,def=0,val=0x609,seg=0,type=lab
+sym	id=3,name="inner",addrsize=absolute,scope=0,def=0,val=0x610,seg=0,type=lab
+`
+
+func record(t *testing.T, symbols *dbginfo.Info) (*Profiler, uint64) {
+	c := cpu.NewCPU()
+	c.LoadAt(0x0600, program)
+	c.Reset()
+	start := c.Cycles()
+	p := New(symbols)
+	c.RunWithCallback(func(c *cpu.CPU) bool {
+		p.Before(c)
+		return true
+	})
+	p.Stop(c)
+	return p, c.Cycles() - start
+}
+
+// Returns the function names of a sample's stack, innermost first.
+func names(sample *profile.Sample) string {
+	var names []string
+	for _, location := range sample.Location {
+		names = append(names, location.Line[0].Function.Name)
+	}
+	return strings.Join(names, " < ")
+}
+
+// Test that every cycle is charged to a stack rebuilt from JSR and RTS
+func Test_Profiler_Stacks(t *testing.T) {
+	info, err := dbginfo.Parse(strings.NewReader(programDebugInfo))
+	require.NoError(t, err)
+	p, cycles := record(t, info)
+	assert.Equal(t, int64(cycles), p.Cycles())
+
+	var buf bytes.Buffer
+	require.NoError(t, p.Write(&buf, "hot.bin"))
+	prof, err := profile.Parse(&buf)
+	require.NoError(t, err)
+	require.NoError(t, prof.CheckValid())
+	assert.Equal(t, "cycles", prof.DefaultSampleType)
+
+	byStack := map[string][]int64{}
+	for _, sample := range prof.Sample {
+		stack := names(sample)
+		if byStack[stack] == nil {
+			byStack[stack] = make([]int64, 2)
+		}
+		byStack[stack][0] += sample.Value[0]
+		byStack[stack][1] += sample.Value[1]
+	}
+	// Two calls to sub, each running LDX, 3 DEX, 3 BNE, JSR and RTS.
+	assert.Equal(t, int64(2*9), byStack["sub < main"][SAMPLE_INSTRUCTIONS])
+	assert.Equal(t, int64(2), byStack["inner < sub < main"][SAMPLE_INSTRUCTIONS])
+	assert.Equal(t, int64(2*6), byStack["inner < sub < main"][SAMPLE_CYCLES])
+	// The JSRs from main are charged to main, as is the BRK it stops on.
+	assert.Equal(t, int64(3), byStack["main"][SAMPLE_INSTRUCTIONS])
+	assert.Equal(t, int64(2*6), byStack["main"][SAMPLE_CYCLES])
+
+	// Source lines come from the debug info.
+	for _, location := range prof.Location {
+		if location.Address == 0x0609 {
+			assert.Equal(t, int64(12), location.Line[0].Line)
+			assert.Equal(t, "hot.s", location.Line[0].Function.Filename)
+		}
+	}
+}
+
+// Test that routines are named by address without symbols
+func Test_Profiler_NoSymbols(t *testing.T) {
+	p, _ := record(t, nil)
+	prof := p.Profile("hot.bin")
+	require.NoError(t, prof.CheckValid())
+
+	var stacks []string
+	for _, sample := range prof.Sample {
+		stacks = append(stacks, names(sample))
+	}
+	assert.Contains(t, stacks, "$0610 < $0607 < $0600")
+	assert.Equal(t, prof.String(), p.Profile("hot.bin").String(), "output is stable")
+}
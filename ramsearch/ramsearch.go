@@ -0,0 +1,267 @@
+// Package ramsearch finds where a game keeps things like lives or health by
+// repeatedly snapshotting RAM and narrowing down the addresses whose values
+// behave the way the player's changes suggest, then holds found addresses at
+// a value.
+package ramsearch
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"switchtrue.com/hankee/bus"
+	"switchtrue.com/hankee/cpu"
+)
+
+// Region is a block of memory to search. Data aliases the live memory so the
+// search always sees current values.
+type Region struct {
+	Name string
+	// CPU address of the first byte.
+	Base uint16
+	Data []uint8
+}
+
+// Returns the regions worth searching on a machine, the 2KB work RAM and the
+// cartridge RAM if there is a cartridge.
+func Regions(b *bus.Bus) []Region {
+	regions := []Region{{Name: "ram", Base: 0x0000, Data: b.RAM()}}
+	if cart := b.Cartridge(); cart != nil && len(cart.PRGRAM) > 0 {
+		regions = append(regions, Region{Name: "cart", Base: 0x6000, Data: cart.PRGRAM})
+	}
+	return regions
+}
+
+// Operator compares a value to the previous snapshot or a given value.
+type Operator int
+
+const (
+	Equal Operator = iota
+	NotEqual
+	Greater
+	Less
+	GreaterOrEqual
+	LessOrEqual
+)
+
+var operatorNames = []string{"eq", "ne", "gt", "lt", "ge", "le"}
+
+func (op Operator) String() string {
+	if int(op) < len(operatorNames) {
+		return operatorNames[op]
+	}
+	return fmt.Sprintf("Operator(%d)", int(op))
+}
+
+// Parses an operator name, eq, ne, gt, lt, ge or le.
+func ParseOperator(name string) (Operator, error) {
+	for i, n := range operatorNames {
+		if strings.EqualFold(n, name) {
+			return Operator(i), nil
+		}
+	}
+	return Equal, fmt.Errorf("unknown comparison %q, expected one of %s", name, strings.Join(operatorNames, ", "))
+}
+
+func (op Operator) compare(a int64, b int64) bool {
+	switch op {
+	case Equal:
+		return a == b
+	case NotEqual:
+		return a != b
+	case Greater:
+		return a > b
+	case Less:
+		return a < b
+	case GreaterOrEqual:
+		return a >= b
+	case LessOrEqual:
+		return a <= b
+	default:
+		return false
+	}
+}
+
+// Options say how the bytes at each address are read as a value.
+type Options struct {
+	// 1 for bytes or 2 for little endian 16 bit words.
+	Size int
+	// Values are two's complement rather than unsigned.
+	Signed bool
+}
+
+// Result is an address still in the running.
+type Result struct {
+	Address  uint16
+	Region   string
+	Value    int64
+	Previous int64
+}
+
+type candidate struct {
+	region int
+	offset int
+}
+
+// Search is a RAM search in progress.
+type Search struct {
+	options    Options
+	regions    []Region
+	previous   [][]uint8
+	candidates []candidate
+}
+
+// Starts a search with every address in the regions as a candidate and a
+// snapshot of their values to compare against.
+func New(options Options, regions ...Region) (*Search, error) {
+	if options.Size != 1 && options.Size != 2 {
+		return nil, fmt.Errorf("values must be 1 or 2 bytes, not %d", options.Size)
+	}
+	s := &Search{options: options, regions: regions}
+	s.Reset()
+	return s, nil
+}
+
+func (s *Search) Options() Options {
+	return s.options
+}
+
+// Makes every address a candidate again and takes a new snapshot.
+func (s *Search) Reset() {
+	s.candidates = s.candidates[:0]
+	for i, region := range s.regions {
+		for offset := 0; offset+s.options.Size <= len(region.Data); offset++ {
+			s.candidates = append(s.candidates, candidate{i, offset})
+		}
+	}
+	s.snapshot()
+}
+
+func (s *Search) snapshot() {
+	s.previous = make([][]uint8, len(s.regions))
+	for i, region := range s.regions {
+		s.previous[i] = append([]uint8(nil), region.Data...)
+	}
+}
+
+// Keeps the candidates whose value compares with the snapshot, for searches
+// like "went down" when the exact value isn't known.
+func (s *Search) FilterPrevious(op Operator) int {
+	return s.filter(func(value int64, previous int64) bool {
+		return op.compare(value, previous)
+	})
+}
+
+// Keeps the candidates whose value compares with the given value.
+func (s *Search) FilterValue(op Operator, value int64) int {
+	return s.filter(func(current int64, previous int64) bool {
+		return op.compare(current, value)
+	})
+}
+
+// Keeps the candidates that changed by exactly n since the snapshot, wrapping
+// around like the game's own arithmetic would, so losing a life is -1.
+func (s *Search) FilterChangedBy(n int64) int {
+	mask := int64(1)<<(8*s.options.Size) - 1
+	return s.filter(func(value int64, previous int64) bool {
+		return (value-previous)&mask == n&mask
+	})
+}
+
+// Applies a filter, then takes a new snapshot so the next filter compares
+// against now. Returns how many candidates are left.
+func (s *Search) filter(keep func(value int64, previous int64) bool) int {
+	kept := s.candidates[:0]
+	for _, c := range s.candidates {
+		if keep(s.value(s.regions[c.region].Data, c.offset), s.value(s.previous[c.region], c.offset)) {
+			kept = append(kept, c)
+		}
+	}
+	s.candidates = kept
+	s.snapshot()
+	return len(s.candidates)
+}
+
+// Reads the value at offset as the options say.
+func (s *Search) value(data []uint8, offset int) int64 {
+	value := int64(data[offset])
+	if s.options.Size == 2 {
+		value |= int64(data[offset+1]) << 8
+	}
+	if s.options.Signed {
+		sign := int64(1) << (8*s.options.Size - 1)
+		value = (value ^ sign) - sign
+	}
+	return value
+}
+
+// Returns how many candidates are left.
+func (s *Search) Count() int {
+	return len(s.candidates)
+}
+
+// Returns up to limit candidates in address order, or all of them if limit
+// is 0.
+func (s *Search) Results(limit int) []Result {
+	n := len(s.candidates)
+	if limit > 0 {
+		n = min(n, limit)
+	}
+	results := make([]Result, n)
+	for i, c := range s.candidates[:n] {
+		region := s.regions[c.region]
+		results[i] = Result{
+			Address:  region.Base + uint16(c.offset),
+			Region:   region.Name,
+			Value:    s.value(region.Data, c.offset),
+			Previous: s.value(s.previous[c.region], c.offset),
+		}
+	}
+	return results
+}
+
+// Freezer holds bytes at fixed values by writing them back over and over,
+// the way cheat devices do.
+type Freezer struct {
+	values map[uint16]uint8
+}
+
+func NewFreezer() *Freezer {
+	return &Freezer{values: map[uint16]uint8{}}
+}
+
+// Holds the value at addr, in size bytes little endian.
+func (f *Freezer) Freeze(addr uint16, value int64, size int) {
+	for i := 0; i < size; i++ {
+		f.values[addr+uint16(i)] = uint8(value >> (8 * i))
+	}
+}
+
+// Lets go of the byte at addr.
+func (f *Freezer) Unfreeze(addr uint16) {
+	delete(f.values, addr)
+}
+
+// Returns the frozen addresses in order.
+func (f *Freezer) Addresses() []uint16 {
+	addrs := make([]uint16, 0, len(f.values))
+	for addr := range f.values {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+// Returns the value a byte is held at.
+func (f *Freezer) Value(addr uint16) (uint8, bool) {
+	value, ok := f.values[addr]
+	return value, ok
+}
+
+// Writes every frozen value. Runners call this often, once a frame or before
+// every instruction, so the game never sees anything else for long.
+func (f *Freezer) Apply(memory cpu.Memory) {
+	for addr, value := range f.values {
+		memory.Write(addr, value)
+	}
+}
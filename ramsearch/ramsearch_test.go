@@ -0,0 +1,92 @@
+package ramsearch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"switchtrue.com/hankee/bus"
+	"switchtrue.com/hankee/cartridge"
+)
+
+func addresses(results []Result) []uint16 {
+	var addrs []uint16
+	for _, r := range results {
+		addrs = append(addrs, r.Address)
+	}
+	return addrs
+}
+
+// Test narrowing down an 8 bit lives counter
+func Test_Search_Bytes(t *testing.T) {
+	b := bus.New()
+	b.InsertCartridge(&cartridge.Cartridge{PRGRAM: make([]uint8, cartridge.PRG_RAM_SIZE)})
+	regions := Regions(b)
+	require.Len(t, regions, 2)
+	ram, cart := b.RAM(), b.Cartridge().PRGRAM
+
+	ram[0x30], ram[0x31], cart[0x10] = 3, 3, 3
+	s, err := New(Options{Size: 1}, regions...)
+	require.NoError(t, err)
+	assert.Equal(t, bus.RAM_SIZE+cartridge.PRG_RAM_SIZE, s.Count())
+
+	assert.Equal(t, 3, s.FilterValue(Equal, 3))
+	// Lose a life, the decoy at $31 goes up instead.
+	ram[0x30], ram[0x31], cart[0x10] = 2, 4, 2
+	assert.Equal(t, 2, s.FilterChangedBy(-1))
+	assert.Equal(t, []uint16{0x0030, 0x6010}, addresses(s.Results(0)))
+
+	// Nothing happened, then lose another one everywhere but the cartridge.
+	assert.Equal(t, 2, s.FilterPrevious(Equal))
+	ram[0x30] = 1
+	assert.Equal(t, 1, s.FilterPrevious(Less))
+	assert.Equal(t, []Result{{Address: 0x0030, Region: "ram", Value: 1, Previous: 1}}, s.Results(10))
+
+	s.Reset()
+	assert.Equal(t, bus.RAM_SIZE+cartridge.PRG_RAM_SIZE, s.Count())
+}
+
+// Test signed and 16 bit little endian values
+func Test_Search_Words(t *testing.T) {
+	data := make([]uint8, 16)
+	data[4], data[5] = 0x10, 0x27 // 10000
+	data[8], data[9] = 0xFE, 0xFF // -2
+	s, err := New(Options{Size: 2, Signed: true}, Region{Name: "test", Base: 0x100, Data: data})
+	require.NoError(t, err)
+	assert.Equal(t, 15, s.Count())
+
+	// The word straddling $107 and $108 is $FE00.
+	assert.Equal(t, 2, s.FilterValue(Less, 0))
+	assert.Equal(t, []Result{
+		{Address: 0x107, Region: "test", Value: -512, Previous: -512},
+		{Address: 0x108, Region: "test", Value: -2, Previous: -2},
+	}, s.Results(0))
+
+	s, _ = New(Options{Size: 2}, Region{Name: "test", Base: 0x100, Data: data})
+	assert.Equal(t, 3, s.FilterValue(Greater, 9999))
+	data[4], data[5] = 0x00, 0x28 // 10240, a carry into the high byte
+	assert.Equal(t, 1, s.FilterChangedBy(240))
+	assert.Equal(t, int64(10240), s.Results(0)[0].Value)
+
+	_, err = New(Options{Size: 4})
+	assert.Error(t, err)
+}
+
+// Test that frozen values are written back
+func Test_Freezer(t *testing.T) {
+	b := bus.New()
+	f := NewFreezer()
+	f.Freeze(0x0040, 0x1234, 2)
+	f.Freeze(0x0010, 9, 1)
+	assert.Equal(t, []uint16{0x0010, 0x0040, 0x0041}, f.Addresses())
+
+	b.Write(0x0040, 0)
+	f.Apply(b)
+	assert.Equal(t, uint8(0x34), b.Read(0x0040))
+	assert.Equal(t, uint8(0x12), b.Read(0x0041))
+	assert.Equal(t, uint8(9), b.Read(0x0010))
+
+	f.Unfreeze(0x0010)
+	_, ok := f.Value(0x0010)
+	assert.False(t, ok)
+}
@@ -0,0 +1,81 @@
+package script
+
+import "switchtrue.com/hankee/video"
+
+const (
+	GLYPH_WIDTH  = 3
+	GLYPH_HEIGHT = 5
+)
+
+// A 3x5 font covering ASCII space to underscore, lower case is drawn as upper
+// case. Each glyph is five rows top to bottom, one octal digit per row with
+// the left pixel in the high bit.
+var font = [...]string{
+	"00000", "22202", "55000", "57575", "36736", "51245", "25253", "22000", // space to '
+	"12221", "42224", "05250", "02720", "00024", "00700", "00002", "11244", // ( to /
+	"75557", "26227", "71747", "71317", "55711", "74717", "74757", "71111", // 0 to 7
+	"75757", "75717", "02020", "02024", "12421", "07070", "42124", "71202", // 8 to ?
+	"75647", "25755", "65656", "34443", "65556", "74647", "74644", "34553", // @ to G
+	"55755", "72227", "11152", "55655", "44447", "57755", "65555", "25552", // H to O
+	"65644", "25553", "65655", "34216", "72222", "55557", "55552", "55775", // P to W
+	"55255", "55222", "71247", "64446", "44211", "32223", "25000", "00007", // X to _
+}
+
+// Draws text with its top left corner at x, y. Characters without a glyph
+// are drawn as a question mark and anything off the frame is clipped.
+func drawText(f *video.Frame, x int, y int, text string, colour uint16) {
+	left := x
+	for _, r := range text {
+		if r == '\n' {
+			x = left
+			y += GLYPH_HEIGHT + 1
+			continue
+		}
+		if r >= 'a' && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		if r < ' ' || r > '_' {
+			r = '?'
+		}
+		glyph := font[r-' ']
+		for row := 0; row < GLYPH_HEIGHT; row++ {
+			bits := glyph[row] - '0'
+			for column := 0; column < GLYPH_WIDTH; column++ {
+				if bits&(4>>column) != 0 {
+					setPixel(f, x+column, y+row, colour)
+				}
+			}
+		}
+		x += GLYPH_WIDTH + 1
+	}
+}
+
+// Draws the outline of a rectangle.
+func drawRect(f *video.Frame, x int, y int, width int, height int, colour uint16) {
+	if width <= 0 || height <= 0 {
+		return
+	}
+	for i := 0; i < width; i++ {
+		setPixel(f, x+i, y, colour)
+		setPixel(f, x+i, y+height-1, colour)
+	}
+	for i := 0; i < height; i++ {
+		setPixel(f, x, y+i, colour)
+		setPixel(f, x+width-1, y+i, colour)
+	}
+}
+
+// Fills a rectangle.
+func fillRect(f *video.Frame, x int, y int, width int, height int, colour uint16) {
+	for row := 0; row < height; row++ {
+		for column := 0; column < width; column++ {
+			setPixel(f, x+column, y+row, colour)
+		}
+	}
+}
+
+func setPixel(f *video.Frame, x int, y int, colour uint16) {
+	if x >= 0 && y >= 0 && x < f.Width && y < f.Height {
+		f.Set(x, y, colour)
+	}
+}
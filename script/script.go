@@ -0,0 +1,431 @@
+// Package script runs Lua scripts alongside a program, calling back into them
+// on frames, instructions and memory accesses so they can watch, poke and draw
+// over the game without recompiling anything.
+//
+// Scripts get an emu table:
+//
+//	emu.onFrame(fn)                 fn() at the start of every frame
+//	emu.onExec(addr, fn)            fn(addr) before the instruction at addr
+//	emu.onRead(start, end, fn)      fn(addr, value) after reads in the range
+//	emu.onWrite(start, end, fn)     fn(addr, value) before writes in the range
+//	emu.memRead(addr)               read a byte without triggering hooks
+//	emu.memWrite(addr, value)       write a byte without triggering hooks
+//	emu.registers()                 {a, x, y, p, sp, pc, cycles}
+//	emu.setRegisters(t)             set any of a, x, y, p, sp and pc
+//	emu.input(pad)                  {A = true, ...} for pad 1 to 4
+//	emu.setInput(pad, t)            hold the buttons set to true in t
+//	emu.zapper(port)                {x, y, trigger, light} for a Zapper
+//	emu.setZapper(port, t)          aim with x and y, negative is off screen,
+//	                                and pull the trigger if trigger is true
+//	emu.drawText(x, y, text[, c])   draw text in palette colour c
+//	emu.drawBox(x, y, w, h, c[, f]) draw a box outlined in c, filled with f
+//	emu.frameCount()                frames since the script was loaded
+//	emu.stop()                      stop the emulator
+package script
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+	"switchtrue.com/hankee/cpu"
+	"switchtrue.com/hankee/joypad"
+	"switchtrue.com/hankee/video"
+)
+
+// Palette index text is drawn in when the script doesn't pick one.
+const DEFAULT_TEXT_COLOUR = 0x30
+
+// ErrStopped is returned by Err once the script has called emu.stop.
+var ErrStopped = errors.New("stopped by script")
+
+// Target is what a script can see and change. Joypads is nil for raw programs
+// that have no controllers.
+type Target struct {
+	CPU     *cpu.CPU
+	Joypads *joypad.Ports
+}
+
+type rangeHook struct {
+	start uint16
+	end   uint16
+	fn    *lua.LFunction
+}
+
+// Script is a loaded Lua script and the hooks it has registered.
+type Script struct {
+	state   *lua.LState
+	target  Target
+	memory  cpu.Memory
+	frames  uint64
+	err     error
+	running bool
+
+	frameHooks []*lua.LFunction
+	execHooks  map[uint16][]*lua.LFunction
+	readHooks  []rangeHook
+	writeHooks []rangeHook
+	drawing    []func(f *video.Frame)
+}
+
+// Loads the script from a file and runs its top level, which usually
+// registers hooks.
+func Load(path string, target Target) (*Script, error) {
+	s := newScript(target)
+	if err := s.state.DoFile(path); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Loads a script from source, name is used in error messages.
+func LoadString(name string, source string, target Target) (*Script, error) {
+	s := newScript(target)
+	fn, err := s.state.Load(strings.NewReader(source), name)
+	if err == nil {
+		s.state.Push(fn)
+		err = s.state.PCall(0, lua.MultRet, nil)
+	}
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func newScript(target Target) *Script {
+	s := &Script{
+		state:     lua.NewState(lua.Options{SkipOpenLibs: true}),
+		target:    target,
+		memory:    target.CPU.Memory(),
+		execHooks: map[uint16][]*lua.LFunction{},
+	}
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		s.state.Push(s.state.NewFunction(lib.open))
+		s.state.Push(lua.LString(lib.name))
+		s.state.Call(1, 0)
+	}
+	s.state.SetGlobal("emu", s.state.SetFuncs(s.state.NewTable(), s.api()))
+
+	// Sit between the CPU and its memory so accesses can be hooked.
+	target.CPU.SetMemory(&hookedMemory{memory: s.memory, script: s})
+	return s
+}
+
+// Takes the hooks back out of the CPU and shuts down the interpreter. Closing
+// more than once does nothing.
+func (s *Script) Close() {
+	if s.state.IsClosed() {
+		return
+	}
+	s.target.CPU.SetMemory(s.memory)
+	s.state.Close()
+}
+
+// Returns the error that stopped the script, if any. A script calling
+// emu.stop gives ErrStopped.
+func (s *Script) Err() error {
+	return s.err
+}
+
+// Runs the frame hooks. Frontends call this at the start of each frame, after
+// reading their own input, so input set by the script wins. Returns false once
+// the script has stopped.
+func (s *Script) Frame() bool {
+	s.drawing = s.drawing[:0]
+	s.frames++
+	for _, fn := range s.frameHooks {
+		s.call(fn)
+	}
+	return s.err == nil
+}
+
+// Runs the hooks for the instruction about to execute, for use from the CPU's
+// run callback. Returns false once the script has stopped.
+func (s *Script) Before(c *cpu.CPU) bool {
+	if hooks, ok := s.execHooks[c.Registers().PC]; ok {
+		for _, fn := range hooks {
+			s.call(fn, lua.LNumber(c.Registers().PC))
+		}
+	}
+	return s.err == nil
+}
+
+// Returns a copy of the frame with anything the script has drawn this frame
+// on top. The frame itself is left alone.
+func (s *Script) Overlay(f *video.Frame) *video.Frame {
+	if len(s.drawing) == 0 {
+		return f
+	}
+	out := &video.Frame{Width: f.Width, Height: f.Height, Pix: append([]uint16(nil), f.Pix...)}
+	for _, draw := range s.drawing {
+		draw(out)
+	}
+	return out
+}
+
+// Calls a Lua function, recording the first error. Hooks don't fire while
+// another hook is running, so a hook can't trigger itself.
+func (s *Script) call(fn *lua.LFunction, args ...lua.LValue) {
+	if s.err != nil || s.running {
+		return
+	}
+	s.running = true
+	defer func() { s.running = false }()
+	if err := s.state.CallByParam(lua.P{Fn: fn, NRet: 0, Protect: true}, args...); err != nil {
+		s.err = err
+	}
+}
+
+// hookedMemory calls the script's read and write hooks as the CPU accesses
+// memory.
+type hookedMemory struct {
+	memory cpu.Memory
+	script *Script
+}
+
+func (m *hookedMemory) Read(addr uint16) uint8 {
+	value := m.memory.Read(addr)
+	m.script.fire(m.script.readHooks, addr, value)
+	return value
+}
+
+func (m *hookedMemory) Write(addr uint16, data uint8) {
+	m.script.fire(m.script.writeHooks, addr, data)
+	m.memory.Write(addr, data)
+}
+
+func (s *Script) fire(hooks []rangeHook, addr uint16, value uint8) {
+	for _, hook := range hooks {
+		if addr >= hook.start && addr <= hook.end {
+			s.call(hook.fn, lua.LNumber(addr), lua.LNumber(value))
+		}
+	}
+}
+
+func (s *Script) api() map[string]lua.LGFunction {
+	return map[string]lua.LGFunction{
+		"onFrame": func(L *lua.LState) int {
+			s.frameHooks = append(s.frameHooks, L.CheckFunction(1))
+			return 0
+		},
+		"onExec": func(L *lua.LState) int {
+			addr := checkAddress(L, 1)
+			s.execHooks[addr] = append(s.execHooks[addr], L.CheckFunction(2))
+			return 0
+		},
+		"onRead": func(L *lua.LState) int {
+			s.readHooks = append(s.readHooks, checkRangeHook(L))
+			return 0
+		},
+		"onWrite": func(L *lua.LState) int {
+			s.writeHooks = append(s.writeHooks, checkRangeHook(L))
+			return 0
+		},
+		"memRead": func(L *lua.LState) int {
+			L.Push(lua.LNumber(s.memory.Read(checkAddress(L, 1))))
+			return 1
+		},
+		"memWrite": func(L *lua.LState) int {
+			s.memory.Write(checkAddress(L, 1), checkByte(L, 2))
+			return 0
+		},
+		"registers":    s.registers,
+		"setRegisters": s.setRegisters,
+		"input":        s.input,
+		"setInput":     s.setInput,
+		"zapper":       s.zapper,
+		"setZapper":    s.setZapper,
+		"drawText":     s.drawText,
+		"drawBox":      s.drawBox,
+		"frameCount": func(L *lua.LState) int {
+			L.Push(lua.LNumber(s.frames))
+			return 1
+		},
+		"stop": func(L *lua.LState) int {
+			if s.err == nil {
+				s.err = ErrStopped
+			}
+			return 0
+		},
+	}
+}
+
+func (s *Script) registers(L *lua.LState) int {
+	r := s.target.CPU.Registers()
+	t := L.NewTable()
+	t.RawSetString("a", lua.LNumber(r.A))
+	t.RawSetString("x", lua.LNumber(r.X))
+	t.RawSetString("y", lua.LNumber(r.Y))
+	t.RawSetString("p", lua.LNumber(r.Status))
+	t.RawSetString("sp", lua.LNumber(r.SP))
+	t.RawSetString("pc", lua.LNumber(r.PC))
+	t.RawSetString("cycles", lua.LNumber(s.target.CPU.Cycles()))
+	L.Push(t)
+	return 1
+}
+
+func (s *Script) setRegisters(L *lua.LState) int {
+	t := L.CheckTable(1)
+	r := s.target.CPU.Registers()
+	for _, field := range []struct {
+		name  string
+		value *uint8
+	}{
+		{"a", &r.A}, {"x", &r.X}, {"y", &r.Y}, {"p", &r.Status}, {"sp", &r.SP},
+	} {
+		if v, ok := t.RawGetString(field.name).(lua.LNumber); ok {
+			*field.value = uint8(v)
+		}
+	}
+	if v, ok := t.RawGetString("pc").(lua.LNumber); ok {
+		r.PC = uint16(v)
+	}
+	s.target.CPU.SetRegisters(r)
+	return 0
+}
+
+func (s *Script) joypad(L *lua.LState) *joypad.Joypad {
+	if s.target.Joypads == nil {
+		L.RaiseError("there are no controllers")
+	}
+	pad := s.target.Joypads.Pad(L.CheckInt(1))
+	if pad == nil {
+		L.ArgError(1, "expected pad 1 to 4")
+	}
+	return pad
+}
+
+func (s *Script) input(L *lua.LState) int {
+	buttons := s.joypad(L).Buttons()
+	t := L.NewTable()
+	for i, name := range joypad.BUTTON_NAMES {
+		t.RawSetString(name, lua.LBool(buttons&(1<<i) != 0))
+	}
+	L.Push(t)
+	return 1
+}
+
+func (s *Script) setInput(L *lua.LState) int {
+	pad := s.joypad(L)
+	var buttons joypad.Button
+	var err error
+	L.CheckTable(2).ForEach(func(key lua.LValue, value lua.LValue) {
+		button, parseErr := joypad.ParseButton(key.String())
+		if parseErr != nil {
+			err = parseErr
+		} else if lua.LVAsBool(value) {
+			buttons |= button
+		}
+	})
+	if err != nil {
+		L.ArgError(2, err.Error())
+	}
+	pad.SetButtons(buttons)
+	return 0
+}
+
+func (s *Script) zapperAt(L *lua.LState) *joypad.Zapper {
+	if s.target.Joypads == nil {
+		L.RaiseError("there are no controller ports")
+	}
+	port := L.CheckInt(1)
+	if port != 1 && port != 2 {
+		L.ArgError(1, "expected port 1 or 2")
+	}
+	zapper, ok := s.target.Joypads.Device(port).(*joypad.Zapper)
+	if !ok {
+		L.ArgError(1, fmt.Sprintf("there's no Zapper in port %d", port))
+	}
+	return zapper
+}
+
+func (s *Script) zapper(L *lua.LState) int {
+	zapper := s.zapperAt(L)
+	x, y, aimed := zapper.Position()
+	if !aimed {
+		x, y = -1, -1
+	}
+	t := L.NewTable()
+	t.RawSetString("x", lua.LNumber(x))
+	t.RawSetString("y", lua.LNumber(y))
+	t.RawSetString("trigger", lua.LBool(zapper.Trigger()))
+	t.RawSetString("light", lua.LBool(zapper.Light()))
+	L.Push(t)
+	return 1
+}
+
+func (s *Script) setZapper(L *lua.LState) int {
+	zapper := s.zapperAt(L)
+	t := L.CheckTable(2)
+	x, y, _ := zapper.Position()
+	if v, ok := t.RawGetString("x").(lua.LNumber); ok {
+		x = int(v)
+	}
+	if v, ok := t.RawGetString("y").(lua.LNumber); ok {
+		y = int(v)
+	}
+	zapper.Aim(x, y)
+	if v := t.RawGetString("trigger"); v != lua.LNil {
+		zapper.SetTrigger(lua.LVAsBool(v))
+	}
+	return 0
+}
+
+func (s *Script) drawText(L *lua.LState) int {
+	x, y := L.CheckInt(1), L.CheckInt(2)
+	text := L.CheckString(3)
+	colour := uint16(L.OptInt(4, DEFAULT_TEXT_COLOUR))
+	s.drawing = append(s.drawing, func(f *video.Frame) {
+		drawText(f, x, y, text, colour)
+	})
+	return 0
+}
+
+func (s *Script) drawBox(L *lua.LState) int {
+	x, y := L.CheckInt(1), L.CheckInt(2)
+	width, height := L.CheckInt(3), L.CheckInt(4)
+	outline := uint16(L.CheckInt(5))
+	fill, filled := L.Get(6).(lua.LNumber)
+	s.drawing = append(s.drawing, func(f *video.Frame) {
+		if filled {
+			fillRect(f, x+1, y+1, width-2, height-2, uint16(fill))
+		}
+		drawRect(f, x, y, width, height, outline)
+	})
+	return 0
+}
+
+func checkAddress(L *lua.LState, n int) uint16 {
+	value := L.CheckInt(n)
+	if value < 0 || value > 0xFFFF {
+		L.ArgError(n, fmt.Sprintf("address %d is out of range", value))
+	}
+	return uint16(value)
+}
+
+func checkByte(L *lua.LState, n int) uint8 {
+	value := L.CheckInt(n)
+	if value < -0x80 || value > 0xFF {
+		L.ArgError(n, fmt.Sprintf("value %d doesn't fit in a byte", value))
+	}
+	return uint8(value)
+}
+
+func checkRangeHook(L *lua.LState) rangeHook {
+	hook := rangeHook{start: checkAddress(L, 1), end: checkAddress(L, 2), fn: L.CheckFunction(3)}
+	if hook.end < hook.start {
+		L.ArgError(2, "range ends before it starts")
+	}
+	return hook
+}
@@ -0,0 +1,155 @@
+package script
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	lua "github.com/yuin/gopher-lua"
+	"switchtrue.com/hankee/cpu"
+	"switchtrue.com/hankee/joypad"
+	"switchtrue.com/hankee/video"
+)
+
+// LDA #$05; STA $10; LDA $10; BRK
+var program = []uint8{0xa9, 0x05, 0x85, 0x10, 0xa5, 0x10, 0x00}
+
+func newTarget() Target {
+	c := cpu.NewCPU()
+	c.LoadAt(0x0600, program)
+	c.Reset()
+	return Target{CPU: c, Joypads: joypad.NewPorts()}
+}
+
+func load(t *testing.T, target Target, source string) *Script {
+	s, err := LoadString("test.lua", source, target)
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func global(s *Script, name string) lua.LValue {
+	return s.state.GetGlobal(name)
+}
+
+// Test that instruction and memory hooks fire as the program runs
+func Test_Script_Hooks(t *testing.T) {
+	target := newTarget()
+	s := load(t, target, `
+		execs, writes, reads = 0, {}, {}
+		emu.onExec(0x0602, function(addr) execs = execs + 1; execAt = addr end)
+		emu.onWrite(0x10, 0x1F, function(addr, value) writes[#writes + 1] = value end)
+		emu.onRead(0x10, 0x10, function(addr, value)
+			reads[#reads + 1] = addr
+			-- Reads from scripts don't trigger hooks.
+			emu.memRead(0x10)
+		end)
+	`)
+	target.CPU.RunWithCallback(func(c *cpu.CPU) bool { return s.Before(c) })
+	require.NoError(t, s.Err())
+
+	assert.Equal(t, lua.LNumber(1), global(s, "execs"))
+	assert.Equal(t, lua.LNumber(0x0602), global(s, "execAt"))
+	writes := global(s, "writes").(*lua.LTable)
+	assert.Equal(t, 1, writes.Len())
+	assert.Equal(t, lua.LNumber(5), writes.RawGetInt(1))
+	assert.Equal(t, 1, global(s, "reads").(*lua.LTable).Len())
+
+	// Closing takes the hooks out of the CPU.
+	s.Close()
+	assert.IsType(t, &cpu.RAM{}, target.CPU.Memory())
+}
+
+// Test reading and changing registers, memory and input from a script
+func Test_Script_API(t *testing.T) {
+	target := newTarget()
+	target.Joypads.One.SetButtons(joypad.ButtonA)
+	s := load(t, target, `
+		emu.onFrame(function()
+			local r = emu.registers()
+			pc, held = r.pc, emu.input(1).A
+			emu.setRegisters{a = 0x42, pc = 0x0604}
+			emu.memWrite(0x20, emu.memRead(0x0600))
+			emu.setInput(2, {start = true, Right = true, A = false})
+			frames = emu.frameCount()
+		end)
+	`)
+	require.True(t, s.Frame())
+
+	assert.Equal(t, lua.LNumber(0x0600), global(s, "pc"))
+	assert.Equal(t, lua.LTrue, global(s, "held"))
+	assert.Equal(t, lua.LNumber(1), global(s, "frames"))
+	assert.Equal(t, uint8(0x42), target.CPU.Registers().A)
+	assert.Equal(t, uint16(0x0604), target.CPU.Registers().PC)
+	assert.Equal(t, uint8(0xa9), target.CPU.MemRead(0x20))
+	assert.Equal(t, joypad.ButtonStart|joypad.ButtonRight, target.Joypads.Two.Buttons())
+}
+
+// lightAt is a light sensor that only sees light at one point.
+type lightAt struct{ x, y int }
+
+func (l lightAt) Light(x int, y int) bool {
+	return x == l.x && y == l.y
+}
+
+// Test aiming and firing a Zapper from a script
+func Test_Script_Zapper(t *testing.T) {
+	target := newTarget()
+	target.Joypads.Plug(2, joypad.NewZapper(lightAt{10, 20}))
+	s := load(t, target, `
+		emu.setZapper(2, {x = 10, y = 20, trigger = true})
+		zapper = emu.zapper(2)
+		emu.setZapper(2, {x = -1})
+		off = emu.zapper(2)
+	`)
+	zapper := global(s, "zapper").(*lua.LTable)
+	assert.Equal(t, lua.LNumber(10), zapper.RawGetString("x"))
+	assert.Equal(t, lua.LNumber(20), zapper.RawGetString("y"))
+	assert.Equal(t, lua.LTrue, zapper.RawGetString("trigger"))
+	assert.Equal(t, lua.LTrue, zapper.RawGetString("light"))
+	off := global(s, "off").(*lua.LTable)
+	assert.Equal(t, lua.LNumber(-1), off.RawGetString("x"))
+	assert.Equal(t, lua.LFalse, off.RawGetString("light"))
+	assert.Equal(t, uint8(joypad.ZAPPER_NO_LIGHT|joypad.ZAPPER_TRIGGER), target.Joypads.Read(0x4017))
+
+	_, err := LoadString("test.lua", "emu.zapper(1)", target)
+	assert.ErrorContains(t, err, "no Zapper in port 1")
+}
+
+// Test drawing text and boxes over a frame
+func Test_Script_Drawing(t *testing.T) {
+	s := load(t, newTarget(), `
+		emu.onFrame(function()
+			emu.drawBox(0, 0, 5, 4, 0x16, 0x2A)
+			emu.drawText(10, 10, "a")
+		end)
+	`)
+	frame := video.NewFrame(video.WIDTH, video.HEIGHT)
+	assert.Same(t, frame, s.Overlay(frame))
+
+	s.Frame()
+	out := s.Overlay(frame)
+	assert.Equal(t, uint16(0x16), out.At(0, 0))
+	assert.Equal(t, uint16(0x16), out.At(4, 3))
+	assert.Equal(t, uint16(0x2A), out.At(1, 1))
+	// The top row of an A is just the middle pixel.
+	assert.Equal(t, uint16(0), out.At(10, 10))
+	assert.Equal(t, uint16(DEFAULT_TEXT_COLOUR), out.At(11, 10))
+	assert.Equal(t, uint16(0), frame.At(0, 0), "the original frame is untouched")
+}
+
+// Test that errors and emu.stop stop the script
+func Test_Script_Errors(t *testing.T) {
+	_, err := LoadString("bad.lua", "emu.onFrame(", newTarget())
+	assert.Error(t, err)
+
+	s := load(t, newTarget(), `emu.onFrame(function() emu.memRead(0x10000) end)`)
+	assert.False(t, s.Frame())
+	assert.ErrorContains(t, s.Err(), "out of range")
+
+	target := newTarget()
+	s = load(t, target, `emu.onExec(0x0602, emu.stop)`)
+	target.CPU.RunWithCallback(func(c *cpu.CPU) bool { return s.Before(c) })
+	assert.ErrorIs(t, s.Err(), ErrStopped)
+	assert.Equal(t, uint16(0x0602), target.CPU.Registers().PC)
+}
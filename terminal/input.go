@@ -0,0 +1,176 @@
+package terminal
+
+// Key is a key read from the terminal. Printable keys are their rune, keys
+// that arrive as escape sequences use the constants below.
+type Key rune
+
+const (
+	KeyUp Key = 0x110000 + iota
+	KeyDown
+	KeyRight
+	KeyLeft
+	KeyEscape
+
+	KeyEnter     Key = '\r'
+	KeyTab       Key = '\t'
+	KeyBackspace Key = 0x7F
+	KeyCtrlC     Key = 0x03
+)
+
+// Mouse is a mouse event, reported once the screen has turned on mouse
+// reporting.
+type Mouse struct {
+	// Character cell the pointer is over, from 0.
+	Column int
+	Row    int
+	// 0 for left, 1 for middle, 2 for right and 3 for a move with nothing
+	// held.
+	Button int
+	// Whether the button is down after this event. Moving the pointer sends
+	// an event too, with the button held if it is.
+	Pressed bool
+}
+
+// Splits raw terminal input into keys. Arrow keys arrive as ESC [ A-D, or
+// ESC O A-D in application cursor mode. An ESC that doesn't start a known
+// sequence is reported as KeyEscape.
+func ParseKeys(input []byte) []Key {
+	keys, _ := ParseInput(input)
+	return keys
+}
+
+// Splits raw terminal input into keys and mouse events, which arrive in SGR
+// form as ESC [ < button ; column ; row, then M for a press or move and m for
+// a release.
+func ParseInput(input []byte) ([]Key, []Mouse) {
+	keys, mice, _ := parseInput(input, true)
+	return keys, mice
+}
+
+// Decoder splits a stream of terminal input into keys and mouse events. An
+// escape sequence cut off at the end of one read is held back until the rest
+// arrives, or until Flush decides it was the Escape key after all.
+type Decoder struct {
+	pending []byte
+}
+
+// Returns the keys and mouse events completed by input.
+func (d *Decoder) Decode(input []byte) ([]Key, []Mouse) {
+	d.pending = append(d.pending, input...)
+	keys, mice, n := parseInput(d.pending, false)
+	d.pending = append(d.pending[:0], d.pending[n:]...)
+	return keys, mice
+}
+
+// Returns whether the start of an escape sequence is being held back.
+func (d *Decoder) Pending() bool {
+	return len(d.pending) > 0
+}
+
+// Gives up waiting for the rest of a held back escape sequence and returns
+// it as keys, so a lone ESC is KeyEscape.
+func (d *Decoder) Flush() []Key {
+	keys, _, _ := parseInput(d.pending, true)
+	d.pending = d.pending[:0]
+	return keys
+}
+
+// Parses input, returning how much of it was used. Unless final is set, an
+// escape sequence that runs off the end is left unused for the next read.
+func parseInput(input []byte, final bool) ([]Key, []Mouse, int) {
+	var keys []Key
+	var mice []Mouse
+	for i := 0; i < len(input); i++ {
+		b := input[i]
+		if b == 0x1b && !final && partialEscape(input[i+1:]) {
+			return keys, mice, i
+		}
+		if b == 0x1b && i+2 < len(input) && input[i+1] == '[' && input[i+2] == '<' {
+			if event, n, ok := parseMouse(input[i+3:]); ok {
+				mice = append(mice, event)
+				i += 2 + n
+				continue
+			}
+		}
+		if b == 0x1b && i+2 < len(input) && (input[i+1] == '[' || input[i+1] == 'O') {
+			if key, ok := arrowKey(input[i+2]); ok {
+				keys = append(keys, key)
+				i += 2
+				continue
+			}
+		}
+
+		switch b {
+		case 0x1b:
+			keys = append(keys, KeyEscape)
+		case '\n':
+			keys = append(keys, KeyEnter)
+		case 0x08:
+			keys = append(keys, KeyBackspace)
+		default:
+			keys = append(keys, Key(b))
+		}
+	}
+	return keys, mice, len(input)
+}
+
+// Returns whether what follows an ESC could be the start of a sequence that
+// hasn't finished arriving.
+func partialEscape(rest []byte) bool {
+	switch {
+	case len(rest) == 0:
+		return true
+	case rest[0] == 'O':
+		return len(rest) == 1
+	case rest[0] != '[':
+		return false
+	case len(rest) == 1:
+		return true
+	case rest[1] != '<':
+		return false
+	}
+	for _, b := range rest[2:] {
+		if (b < '0' || b > '9') && b != ';' {
+			return false
+		}
+	}
+	return true
+}
+
+// Parses the rest of an SGR mouse report, returning how many bytes it took.
+func parseMouse(input []byte) (Mouse, int, bool) {
+	var fields [3]int
+	field := 0
+	for i, b := range input {
+		switch {
+		case b >= '0' && b <= '9':
+			fields[field] = fields[field]*10 + int(b-'0')
+		case b == ';' && field < 2:
+			field++
+		case (b == 'M' || b == 'm') && field == 2:
+			code := fields[0]
+			// Wheel events are 64 and up, the low bits of a move with
+			// nothing held are 3.
+			pressed := b == 'M' && code&3 != 3 && code < 64
+			return Mouse{Column: fields[1] - 1, Row: fields[2] - 1, Button: code & 3, Pressed: pressed}, i + 1, true
+		default:
+			return Mouse{}, 0, false
+		}
+	}
+	return Mouse{}, 0, false
+}
+
+func arrowKey(b byte) (Key, bool) {
+	switch b {
+	case 'A':
+		return KeyUp, true
+	case 'B':
+		return KeyDown, true
+	case 'C':
+		return KeyRight, true
+	case 'D':
+		return KeyLeft, true
+	default:
+		return 0, false
+	}
+}
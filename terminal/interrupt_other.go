@@ -0,0 +1,11 @@
+//go:build !unix
+
+package terminal
+
+import "os"
+
+// Returns in as it is, reads can't be interrupted here so the reader is left
+// running after Close.
+func interruptible(in *os.File) (*os.File, func(), error) {
+	return in, nil, nil
+}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package terminal
+
+import (
+	"os"
+	"syscall"
+)
+
+// Returns a copy of in whose reads interrupt can cut short. Terminals are
+// opened blocking, which Go can't interrupt, so the copy is switched to
+// non-blocking to go through the poller, and back again once interrupted.
+func interruptible(in *os.File) (*os.File, func(), error) {
+	fd, err := syscall.Dup(int(in.Fd()))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, nil, err
+	}
+	reader := os.NewFile(uintptr(fd), in.Name())
+	interrupt := func() {
+		reader.Close()
+		// The copy shares the flag with in, which has to be left blocking.
+		syscall.SetNonblock(int(in.Fd()), false)
+	}
+	return reader, interrupt, nil
+}
@@ -0,0 +1,63 @@
+package terminal
+
+import "switchtrue.com/hankee/joypad"
+
+// Keymap maps terminal keys to controller buttons.
+type Keymap map[Key]joypad.Button
+
+var DefaultKeymap = Keymap{
+	KeyUp:        joypad.ButtonUp,
+	KeyDown:      joypad.ButtonDown,
+	KeyLeft:      joypad.ButtonLeft,
+	KeyRight:     joypad.ButtonRight,
+	'w':          joypad.ButtonUp,
+	's':          joypad.ButtonDown,
+	'a':          joypad.ButtonLeft,
+	'd':          joypad.ButtonRight,
+	'x':          joypad.ButtonA,
+	'z':          joypad.ButtonB,
+	KeyEnter:     joypad.ButtonStart,
+	KeyTab:       joypad.ButtonSelect,
+	KeyBackspace: joypad.ButtonSelect,
+}
+
+// Terminals only report key presses, never releases, so a button is held for
+// this many frames after its key was last seen. Holding a key down relies on
+// the terminal's key repeat to keep the button pressed.
+const HOLD_FRAMES = 8
+
+// Buttons turns a stream of key presses into controller state.
+type Buttons struct {
+	Keymap Keymap
+	held   [8]int
+}
+
+func NewButtons(keymap Keymap) *Buttons {
+	return &Buttons{Keymap: keymap}
+}
+
+// Records a key press, ignoring keys that aren't mapped.
+func (b *Buttons) Press(key Key) {
+	button, ok := b.Keymap[key]
+	if !ok {
+		return
+	}
+	for i := range b.held {
+		if button&(1<<i) != 0 {
+			b.held[i] = HOLD_FRAMES
+		}
+	}
+}
+
+// Returns the buttons currently held and counts down to their release. Call
+// once per frame.
+func (b *Buttons) Frame() joypad.Button {
+	var buttons joypad.Button
+	for i := range b.held {
+		if b.held[i] > 0 {
+			buttons |= 1 << i
+			b.held[i]--
+		}
+	}
+	return buttons
+}
@@ -0,0 +1,87 @@
+package terminal
+
+import "switchtrue.com/hankee/joypad"
+
+// Typing turns a stream of key presses into the Family BASIC keyboard's keys,
+// holding each for HOLD_FRAMES like Buttons. Letters and digits type
+// themselves, capitals and shifted punctuation hold a shift key too.
+type Typing struct {
+	held map[joypad.KeyboardKey]int
+}
+
+func NewTyping() *Typing {
+	return &Typing{held: map[joypad.KeyboardKey]int{}}
+}
+
+// Terminal keys with no key of their own on the keyboard, and the keys they
+// type.
+var typingKeys = map[Key][]string{
+	KeyEnter:     {"Return"},
+	KeyBackspace: {"Del"},
+	KeyTab:       {"Stop"},
+	KeyUp:        {"Up"},
+	KeyDown:      {"Down"},
+	KeyLeft:      {"Left"},
+	KeyRight:     {"Right"},
+	' ':          {"Space"},
+	'!':          {"LShift", "1"},
+	'"':          {"LShift", "2"},
+	'#':          {"LShift", "3"},
+	'$':          {"LShift", "4"},
+	'%':          {"LShift", "5"},
+	'&':          {"LShift", "6"},
+	'\'':         {"LShift", "7"},
+	'(':          {"LShift", "8"},
+	')':          {"LShift", "9"},
+	'=':          {"LShift", "-"},
+	'+':          {"LShift", ";"},
+	'*':          {"LShift", ":"},
+	'<':          {"LShift", ","},
+	'>':          {"LShift", "."},
+	'?':          {"LShift", "/"},
+}
+
+// Returns the keyboard keys a terminal key types, if any.
+func KeyboardKeys(key Key) []joypad.KeyboardKey {
+	names, ok := typingKeys[key]
+	if !ok {
+		if key < ' ' || key > '~' {
+			return nil
+		}
+		name := string(rune(key))
+		names = []string{name}
+		if key >= 'A' && key <= 'Z' {
+			names = []string{"LShift", name}
+		}
+	}
+	var keys []joypad.KeyboardKey
+	for _, name := range names {
+		k, err := joypad.ParseKeyboardKey(name)
+		if err != nil {
+			return nil
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Records a key press, ignoring keys the keyboard doesn't have.
+func (t *Typing) Press(key Key) {
+	for _, k := range KeyboardKeys(key) {
+		t.held[k] = HOLD_FRAMES
+	}
+}
+
+// Sets the keyboard's keys to those currently held and counts down to their
+// release. Call once per frame.
+func (t *Typing) Frame(keyboard *joypad.Keyboard) {
+	keyboard.Release()
+	for k, frames := range t.held {
+		keyboard.SetKey(k, true)
+		if frames <= 1 {
+			delete(t.held, k)
+		} else {
+			t.held[k] = frames - 1
+		}
+	}
+}
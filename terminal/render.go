@@ -0,0 +1,126 @@
+package terminal
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+
+	"switchtrue.com/hankee/video"
+)
+
+const (
+	// The upper half block is drawn in the foreground colour over the
+	// background colour, so each character cell shows two pixels stacked.
+	UPPER_HALF_BLOCK = "▀"
+
+	cursorHome  = "\x1b[H"
+	clearScreen = "\x1b[2J"
+	hideCursor  = "\x1b[?25l"
+	showCursor  = "\x1b[?25h"
+	resetColour = "\x1b[0m"
+	// Report every mouse press, release and move, in SGR form.
+	mouseOn  = "\x1b[?1003h\x1b[?1006h"
+	mouseOff = "\x1b[?1003l\x1b[?1006l"
+)
+
+// Renderer draws frames to a terminal with half block characters and 24 bit
+// ANSI colour.
+type Renderer struct {
+	Palette *video.Palette
+	// Turns frames into RGB in place of the palette, such as the NTSC filter.
+	// The picture keeps the frame's shape however wide the filter makes it.
+	Filter func(f *video.Frame) *image.RGBA
+	// Size of the area to draw in, in character cells. Frames are scaled with
+	// nearest neighbour sampling to the largest size that fits while keeping
+	// square pixels.
+	Columns int
+	Rows    int
+
+	buf bytes.Buffer
+	// The size of the last frame drawn and the cells it took up.
+	width       int
+	height      int
+	lastColumns int
+	lastRows    int
+}
+
+// Returns how many columns and rows a frame will take up.
+func (r *Renderer) Size(f *video.Frame) (int, int) {
+	columns := r.Columns
+	if columns <= 0 {
+		columns = f.Width
+	}
+	if r.Rows > 0 && columns*f.Height > r.Rows*2*f.Width {
+		columns = r.Rows * 2 * f.Width / f.Height
+	}
+	columns = max(columns, 1)
+	rows := max((columns*f.Height/f.Width+1)/2, 1)
+	return columns, rows
+}
+
+// Draws a frame from the top left of the terminal.
+func (r *Renderer) Render(w io.Writer, f *video.Frame) error {
+	palette := r.Palette
+	if palette == nil {
+		palette = &video.DefaultPalette
+	}
+
+	// Returns the colour at column, y.
+	colour := func(column int, y int, columns int) color.RGBA {
+		return palette.Color(f.At(column*f.Width/columns, y))
+	}
+	if r.Filter != nil {
+		img := r.Filter(f)
+		colour = func(column int, y int, columns int) color.RGBA {
+			return img.RGBAAt(column*img.Bounds().Dx()/columns, y)
+		}
+	}
+
+	columns, rows := r.Size(f)
+	r.width, r.height, r.lastColumns, r.lastRows = f.Width, f.Height, columns, rows
+	r.buf.Reset()
+	r.buf.WriteString(cursorHome)
+
+	var fg, bg color.RGBA
+	first := true
+	for row := 0; row < rows; row++ {
+		topY := (row * 2) * f.Height / (rows * 2)
+		bottomY := (row*2 + 1) * f.Height / (rows * 2)
+		for column := 0; column < columns; column++ {
+			top := colour(column, topY, columns)
+			bottom := colour(column, bottomY, columns)
+
+			// Only send colour changes, runs of the same colour are common and
+			// this keeps the output small enough for slow SSH links.
+			if first || top != fg {
+				fmt.Fprintf(&r.buf, "\x1b[38;2;%d;%d;%dm", top.R, top.G, top.B)
+				fg = top
+			}
+			if first || bottom != bg {
+				fmt.Fprintf(&r.buf, "\x1b[48;2;%d;%d;%dm", bottom.R, bottom.G, bottom.B)
+				bg = bottom
+			}
+			first = false
+			r.buf.WriteString(UPPER_HALF_BLOCK)
+		}
+		r.buf.WriteString(resetColour)
+		first = true
+		if row < rows-1 {
+			r.buf.WriteString("\r\n")
+		}
+	}
+
+	_, err := w.Write(r.buf.Bytes())
+	return err
+}
+
+// Returns the pixel of the last frame drawn in a character cell, taking the
+// middle of the cell's two pixels. Returns false outside the picture.
+func (r *Renderer) Pixel(column int, row int) (int, int, bool) {
+	if column < 0 || row < 0 || column >= r.lastColumns || row >= r.lastRows {
+		return 0, 0, false
+	}
+	return column * r.width / r.lastColumns, (row*2 + 1) * r.height / (r.lastRows * 2), true
+}
@@ -0,0 +1,255 @@
+package terminal
+
+import (
+	"errors"
+	"image"
+	"os"
+	"time"
+
+	"golang.org/x/term"
+	"switchtrue.com/hankee/video"
+)
+
+// Console is what the terminal frontend drives, usually an NES but anything
+// that produces frames and wants keyboard input will do.
+type Console interface {
+	// Receives a key read from the terminal. Keys arrive before the frame
+	// they should affect is stepped.
+	Key(key Key)
+	// Advances emulation by one frame, returning false to stop.
+	StepFrame() bool
+	// Returns the picture to show.
+	Frame() *video.Frame
+}
+
+// MouseConsole is a Console that wants the mouse as well, such as one with a
+// Zapper plugged in.
+type MouseConsole interface {
+	Console
+	// Receives a mouse event with the pixel it points at, or -1, -1 when the
+	// pointer is off the picture.
+	Mouse(x int, y int, event Mouse)
+}
+
+// How long an ESC can go without the rest of an escape sequence before it is
+// taken as the Escape key. Slow terminals and connections split arrow keys and
+// mouse reports across reads.
+const ESCAPE_TIMEOUT = 50 * time.Millisecond
+
+// ErrInterrupted is returned by Run when the user quits with Ctrl-C or Escape.
+var ErrInterrupted = errors.New("interrupted")
+
+type Options struct {
+	// Frames per second to throttle to, 60 when zero.
+	FPS     float64
+	Palette *video.Palette
+	// Turns frames into RGB in place of the palette, see Renderer.
+	Filter func(f *video.Frame) *image.RGBA
+	// Columns to draw in, 0 to fit the terminal.
+	Columns int
+	// Report mouse events, see Screen.Mouse.
+	Mouse bool
+	In    *os.File
+	Out   *os.File
+}
+
+// Screen is a terminal set up for drawing frames and reading keys. Hosts that
+// drive the CPU themselves use it directly, everything else can use Run.
+type Screen struct {
+	in       *os.File
+	out      *os.File
+	renderer *Renderer
+	input    chan []byte
+	decoder  Decoder
+	// When input last arrived, to time out a held back ESC.
+	lastInput time.Time
+	mice      []Mouse
+	mouse     bool
+	ticker    *time.Ticker
+	restore   func()
+	// Closed to stop the reader, which closes done once it has.
+	stop chan struct{}
+	done chan struct{}
+	// Interrupts a read in progress, nil when the reader can't be stopped.
+	interrupt func()
+}
+
+// Prepares the terminal for drawing. Stdin is put into raw mode so key presses
+// arrive straight away, Close puts it back.
+func Open(opts Options) (*Screen, error) {
+	s := &Screen{
+		in:       opts.In,
+		out:      opts.Out,
+		renderer: &Renderer{Palette: opts.Palette, Filter: opts.Filter, Columns: opts.Columns},
+		input:    make(chan []byte, 16),
+		mouse:    opts.Mouse,
+		restore:  func() {},
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if s.in == nil {
+		s.in = os.Stdin
+	}
+	if s.out == nil {
+		s.out = os.Stdout
+	}
+	fps := opts.FPS
+	if fps <= 0 {
+		fps = 60
+	}
+
+	if fd := int(s.in.Fd()); term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return nil, err
+		}
+		s.restore = func() { term.Restore(fd, state) }
+	}
+
+	if columns, rows, err := term.GetSize(int(s.out.Fd())); err == nil {
+		if s.renderer.Columns == 0 {
+			s.renderer.Columns = columns
+		}
+		s.renderer.Rows = rows
+	}
+
+	reader, interrupt, err := interruptible(s.in)
+	if err != nil {
+		s.restore()
+		return nil, err
+	}
+	s.interrupt = interrupt
+
+	s.out.WriteString(hideCursor + clearScreen)
+	if s.mouse {
+		s.out.WriteString(mouseOn)
+	}
+	s.ticker = time.NewTicker(time.Duration(float64(time.Second) / fps))
+	go s.readInput(reader)
+	return s, nil
+}
+
+// Returns the keys pressed since the last call, without blocking. Ctrl-C and
+// Escape are turned into ErrInterrupted, though an ESC is only taken as Escape
+// once ESCAPE_TIMEOUT passes without the rest of a sequence.
+func (s *Screen) Keys() ([]Key, error) {
+	var keys []Key
+	for {
+		var batch []Key
+		select {
+		case data := <-s.input:
+			s.lastInput = time.Now()
+			var mice []Mouse
+			batch, mice = s.decoder.Decode(data)
+			s.mice = append(s.mice, mice...)
+		default:
+			if !s.decoder.Pending() || time.Since(s.lastInput) < ESCAPE_TIMEOUT {
+				return keys, nil
+			}
+			batch = s.decoder.Flush()
+		}
+		for _, key := range batch {
+			if key == KeyCtrlC || key == KeyEscape {
+				return keys, ErrInterrupted
+			}
+			keys = append(keys, key)
+		}
+	}
+}
+
+// Returns the mouse events that arrived with the keys from the last call to
+// Keys.
+func (s *Screen) Mouse() []Mouse {
+	mice := s.mice
+	s.mice = nil
+	return mice
+}
+
+// Returns the pixel of the last frame drawn in a character cell, see
+// Renderer.Pixel.
+func (s *Screen) Pixel(column int, row int) (int, int, bool) {
+	return s.renderer.Pixel(column, row)
+}
+
+func (s *Screen) Draw(frame *video.Frame) error {
+	return s.renderer.Render(s.out, frame)
+}
+
+// Blocks until it's time for the next frame.
+func (s *Screen) Wait() {
+	<-s.ticker.C
+}
+
+// Restores the terminal to how it was before Open, stopping the reader first
+// so it doesn't take the next key meant for whatever runs after.
+func (s *Screen) Close() {
+	close(s.stop)
+	if s.interrupt != nil {
+		s.interrupt()
+		<-s.done
+	}
+	s.ticker.Stop()
+	if s.mouse {
+		s.out.WriteString(mouseOff)
+	}
+	s.out.WriteString(resetColour + showCursor + "\r\n")
+	s.restore()
+}
+
+// Runs the console in the terminal until it stops or the user quits.
+func Run(console Console, opts Options) error {
+	mouseConsole, wantsMouse := console.(MouseConsole)
+	opts.Mouse = opts.Mouse || wantsMouse
+	screen, err := Open(opts)
+	if err != nil {
+		return err
+	}
+	defer screen.Close()
+
+	for {
+		keys, err := screen.Keys()
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			console.Key(key)
+		}
+		for _, event := range screen.Mouse() {
+			if wantsMouse {
+				x, y, ok := screen.Pixel(event.Column, event.Row)
+				if !ok {
+					x, y = -1, -1
+				}
+				mouseConsole.Mouse(x, y, event)
+			}
+		}
+
+		running := console.StepFrame()
+		if err := screen.Draw(console.Frame()); err != nil {
+			return err
+		}
+		if !running {
+			return nil
+		}
+		screen.Wait()
+	}
+}
+
+// Passes what is read from the terminal to Keys until Close stops it.
+func (s *Screen) readInput(in *os.File) {
+	defer close(s.done)
+	for {
+		buf := make([]byte, 64)
+		n, err := in.Read(buf)
+		if n > 0 {
+			select {
+			case s.input <- buf[:n]:
+			case <-s.stop:
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package terminal
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"switchtrue.com/hankee/joypad"
+	"switchtrue.com/hankee/video"
+)
+
+// Test that arrow key escape sequences and plain keys are split apart
+func Test_ParseKeys(t *testing.T) {
+	keys := ParseKeys([]byte("w\x1b[A\x1bOD\r\x1b"))
+	assert.Equal(t, []Key{'w', KeyUp, KeyLeft, KeyEnter, KeyEscape}, keys)
+}
+
+// Test that SGR mouse reports are picked out from between keys and mapped
+// back to the pixels drawn in the cell
+func Test_ParseInput_Mouse(t *testing.T) {
+	keys, mice := ParseInput([]byte("x\x1b[<0;11;3M\x1b[<0;11;3m\x1b[<35;1;1Mz"))
+	assert.Equal(t, []Key{'x', 'z'}, keys)
+	assert.Equal(t, []Mouse{
+		{Column: 10, Row: 2, Button: 0, Pressed: true},
+		{Column: 10, Row: 2, Button: 0, Pressed: false},
+		{Column: 0, Row: 0, Button: 3, Pressed: false},
+	}, mice)
+
+	r := &Renderer{Columns: 128}
+	assert.NoError(t, r.Render(&bytes.Buffer{}, video.NewFrame(video.WIDTH, video.HEIGHT)))
+	x, y, ok := r.Pixel(10, 2)
+	assert.True(t, ok)
+	assert.Equal(t, 20, x)
+	assert.Equal(t, 10, y)
+	_, _, ok = r.Pixel(128, 0)
+	assert.False(t, ok)
+}
+
+// Test that escape sequences split across reads are held back until the rest
+// arrives, and a lone ESC only becomes Escape once flushed
+func Test_Decoder_Split(t *testing.T) {
+	var d Decoder
+	keys, _ := d.Decode([]byte("w\x1b"))
+	assert.Equal(t, []Key{'w'}, keys)
+	keys, _ = d.Decode([]byte("["))
+	assert.Empty(t, keys)
+	keys, _ = d.Decode([]byte("Ax\x1b[<0;1"))
+	assert.Equal(t, []Key{KeyUp, 'x'}, keys)
+	keys, mice := d.Decode([]byte("1;3M"))
+	assert.Empty(t, keys)
+	assert.Equal(t, []Mouse{{Column: 10, Row: 2, Button: 0, Pressed: true}}, mice)
+	assert.False(t, d.Pending())
+
+	keys, _ = d.Decode([]byte("\x1b"))
+	assert.Empty(t, keys)
+	assert.True(t, d.Pending())
+	assert.Equal(t, []Key{KeyEscape}, d.Flush())
+	assert.False(t, d.Pending())
+}
+
+// Test that the screen waits out a split arrow key rather than quitting, quits
+// on a lone ESC, and leaves input alone after Close
+func Test_Screen_Keys(t *testing.T) {
+	in, keyboard, err := os.Pipe()
+	require.NoError(t, err)
+	defer in.Close()
+	defer keyboard.Close()
+	out, err := os.Create(t.TempDir() + "/out")
+	require.NoError(t, err)
+	defer out.Close()
+
+	screen, err := Open(Options{In: in, Out: out})
+	require.NoError(t, err)
+	keyboard.WriteString("\x1b[")
+	time.Sleep(ESCAPE_TIMEOUT / 5)
+	keys, err := screen.Keys()
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+	keyboard.WriteString("A")
+	assert.Eventually(t, func() bool {
+		more, err := screen.Keys()
+		require.NoError(t, err)
+		keys = append(keys, more...)
+		return len(keys) > 0
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []Key{KeyUp}, keys)
+
+	keyboard.WriteString("\x1b")
+	assert.Eventually(t, func() bool {
+		_, err := screen.Keys()
+		return err == ErrInterrupted
+	}, time.Second, time.Millisecond)
+
+	screen.Close()
+	keyboard.WriteString("q")
+	buf := make([]byte, 1)
+	_, err = in.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "q", string(buf))
+}
+
+// Test that two rows of pixels are drawn as one row of half blocks, with the
+// top pixel as the foreground and the bottom pixel as the background
+func Test_Renderer_HalfBlocks(t *testing.T) {
+	frame := video.NewFrame(2, 2)
+	frame.Set(0, 0, 0x30)
+	frame.Set(1, 0, 0x30)
+	frame.Set(0, 1, 0x0D)
+	frame.Set(1, 1, 0x0D)
+
+	var out bytes.Buffer
+	r := &Renderer{}
+	assert.NoError(t, r.Render(&out, frame))
+
+	expected := cursorHome +
+		"\x1b[38;2;255;255;255m\x1b[48;2;0;0;0m" + UPPER_HALF_BLOCK + UPPER_HALF_BLOCK +
+		resetColour
+	assert.Equal(t, expected, out.String())
+}
+
+// Test that a filter's wider picture is squeezed back into the frame's shape
+func Test_Renderer_Filter(t *testing.T) {
+	frame := video.NewFrame(2, 2)
+	var out bytes.Buffer
+	r := &Renderer{Filter: func(f *video.Frame) *image.RGBA {
+		img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+		img.SetRGBA(2, 0, color.RGBA{1, 2, 3, 0xFF})
+		return img
+	}}
+	assert.NoError(t, r.Render(&out, frame))
+
+	expected := cursorHome +
+		"\x1b[38;2;0;0;0m\x1b[48;2;0;0;0m" + UPPER_HALF_BLOCK +
+		"\x1b[38;2;1;2;3m" + UPPER_HALF_BLOCK +
+		resetColour
+	assert.Equal(t, expected, out.String())
+}
+
+// Test that frames are scaled down to fit the available rows
+func Test_Renderer_FitRows(t *testing.T) {
+	frame := video.NewFrame(video.WIDTH, video.HEIGHT)
+	r := &Renderer{Columns: 200, Rows: 60}
+	columns, rows := r.Size(frame)
+	assert.Equal(t, 128, columns)
+	assert.Equal(t, 60, rows)
+
+	var out bytes.Buffer
+	assert.NoError(t, r.Render(&out, frame))
+	assert.Equal(t, rows, strings.Count(out.String(), "\r\n")+1)
+}
+
+// Test that a key press holds its button for a few frames then releases it
+func Test_Buttons_Hold(t *testing.T) {
+	b := NewButtons(DefaultKeymap)
+	b.Press('x')
+	for i := 0; i < HOLD_FRAMES; i++ {
+		assert.Equal(t, joypad.ButtonA, b.Frame())
+	}
+	assert.Equal(t, joypad.Button(0), b.Frame())
+}
+
+// Test that typed characters hold the Family BASIC keyboard's keys, with
+// shift for capitals and shifted punctuation
+func Test_Typing(t *testing.T) {
+	key := func(name string) joypad.KeyboardKey {
+		k, err := joypad.ParseKeyboardKey(name)
+		assert.NoError(t, err)
+		return k
+	}
+	assert.Equal(t, []joypad.KeyboardKey{key("A")}, KeyboardKeys('a'))
+	assert.Equal(t, []joypad.KeyboardKey{key("LShift"), key("A")}, KeyboardKeys('A'))
+	assert.Equal(t, []joypad.KeyboardKey{key("LShift"), key("2")}, KeyboardKeys('"'))
+	assert.Equal(t, []joypad.KeyboardKey{key("Return")}, KeyboardKeys(KeyEnter))
+	assert.Empty(t, KeyboardKeys('~'))
+
+	typing := NewTyping()
+	keyboard := joypad.NewKeyboard(nil)
+	typing.Press('Q')
+	for i := 0; i < HOLD_FRAMES; i++ {
+		typing.Frame(keyboard)
+		assert.True(t, keyboard.Key(key("Q")))
+		assert.True(t, keyboard.Key(key("LShift")))
+	}
+	typing.Frame(keyboard)
+	assert.False(t, keyboard.Key(key("Q")))
+}
@@ -0,0 +1,160 @@
+package video
+
+import (
+	"image"
+	"image/color"
+)
+
+const (
+	WIDTH  = 256
+	HEIGHT = 240
+)
+
+// Frame is a picture as the PPU produces it, one palette index per pixel
+// rather than an RGB colour. The low 6 bits select the colour and bits 6-8
+// hold the PPUMASK colour emphasis bits, so filters further down the line can
+// work from the same signal the TV would have seen.
+type Frame struct {
+	Width  int
+	Height int
+	Pix    []uint16
+}
+
+func NewFrame(width int, height int) *Frame {
+	return &Frame{
+		Width:  width,
+		Height: height,
+		Pix:    make([]uint16, width*height),
+	}
+}
+
+func (f *Frame) At(x int, y int) uint16 {
+	return f.Pix[y*f.Width+x]
+}
+
+func (f *Frame) Set(x int, y int, index uint16) {
+	f.Pix[y*f.Width+x] = index
+}
+
+// Sets every pixel to the same palette index.
+func (f *Frame) Fill(index uint16) {
+	for i := range f.Pix {
+		f.Pix[i] = index
+	}
+}
+
+// Converts the frame to RGB using the given palette.
+func (f *Frame) RGBA(palette *Palette) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, f.Width, f.Height))
+	for i, index := range f.Pix {
+		c := palette.Color(index)
+		img.Pix[i*4+0] = c.R
+		img.Pix[i*4+1] = c.G
+		img.Pix[i*4+2] = c.B
+		img.Pix[i*4+3] = 0xFF
+	}
+	return img
+}
+
+// PPUMASK bits that change the colours the PPU outputs.
+const (
+	// Keeps only the luma bits of each colour, giving the greys in column 0.
+	MASK_GREYSCALE = 0x01
+	// Emphasise red, green and blue on NTSC PPUs, green and red swapped on PAL.
+	MASK_EMPHASIS = 0xE0
+)
+
+// Returns the pixel value the PPU outputs for a colour from palette RAM with
+// PPUMASK set to mask, the colour in bits 0-5 and the emphasis bits in 6-8.
+func Pixel(colour uint8, mask uint8) uint16 {
+	if mask&MASK_GREYSCALE != 0 {
+		colour &= 0x30
+	}
+	return uint16(colour&0x3F) | uint16(mask&MASK_EMPHASIS)<<1
+}
+
+// Swaps the red and green emphasis bits of a PPUMASK value, which PAL and
+// Dendy PPUs have the other way round to NTSC ones.
+func SwapEmphasis(mask uint8) uint8 {
+	return mask&^0x60 | (mask&0x20)<<1 | (mask&0x40)>>1
+}
+
+// Palette maps the 512 pixel values the PPU can output, each of the 64
+// colours under every combination of emphasis bits, to RGB.
+type Palette [512]color.RGBA
+
+// Returns the RGB colour for a pixel value.
+func (p *Palette) Color(index uint16) color.RGBA {
+	return p[index&0x1FF]
+}
+
+// Returns the 64 colours without any emphasis.
+func (p *Palette) Base() [64]color.RGBA {
+	return [64]color.RGBA(p[:64])
+}
+
+// How much the 2C02 dims the colours that aren't emphasised.
+const EMPHASIS_ATTENUATION = 0.816
+
+// Makes a palette from 64 colours, working out the emphasised ones the way a
+// 2C02 does by dimming the channels that aren't emphasised. Palettes measured
+// under each emphasis are better, this is for when there's only 64 colours.
+func Expand(colours [64]color.RGBA) *Palette {
+	var p Palette
+	for emphasis := 0; emphasis < 8; emphasis++ {
+		for i, c := range colours {
+			scale := func(v uint8, bit int) uint8 {
+				// Emphasising all three dims everything.
+				if emphasis == 0 || (emphasis&bit != 0 && emphasis != 7) {
+					return v
+				}
+				return uint8(float64(v)*EMPHASIS_ATTENUATION + 0.5)
+			}
+			p[emphasis<<6|i] = color.RGBA{scale(c.R, 1), scale(c.G, 2), scale(c.B, 4), 0xFF}
+		}
+	}
+	return &p
+}
+
+// Makes a palette for an RGB PPU like the 2C03 or 2C05 from its 64 colours.
+// These drive each emphasised channel fully on rather than dimming the rest.
+func ExpandRGB(colours [64]color.RGBA) *Palette {
+	var p Palette
+	for emphasis := 0; emphasis < 8; emphasis++ {
+		for i, c := range colours {
+			if emphasis&1 != 0 {
+				c.R = 0xFF
+			}
+			if emphasis&2 != 0 {
+				c.G = 0xFF
+			}
+			if emphasis&4 != 0 {
+				c.B = 0xFF
+			}
+			p[emphasis<<6|i] = c
+		}
+	}
+	return &p
+}
+
+// The 2C02 palette used by the tutorial this emulator follows.
+var DefaultPalette = *Expand(defaultColours)
+
+var defaultColours = [64]color.RGBA{
+	{0x80, 0x80, 0x80, 0xFF}, {0x00, 0x3D, 0xA6, 0xFF}, {0x00, 0x12, 0xB0, 0xFF}, {0x44, 0x00, 0x96, 0xFF},
+	{0xA1, 0x00, 0x5E, 0xFF}, {0xC7, 0x00, 0x28, 0xFF}, {0xBA, 0x06, 0x00, 0xFF}, {0x8C, 0x17, 0x00, 0xFF},
+	{0x5C, 0x2F, 0x00, 0xFF}, {0x10, 0x45, 0x00, 0xFF}, {0x05, 0x4A, 0x00, 0xFF}, {0x00, 0x47, 0x2E, 0xFF},
+	{0x00, 0x41, 0x66, 0xFF}, {0x00, 0x00, 0x00, 0xFF}, {0x05, 0x05, 0x05, 0xFF}, {0x05, 0x05, 0x05, 0xFF},
+	{0xC7, 0xC7, 0xC7, 0xFF}, {0x00, 0x77, 0xFF, 0xFF}, {0x21, 0x55, 0xFF, 0xFF}, {0x82, 0x37, 0xFA, 0xFF},
+	{0xEB, 0x2F, 0xB5, 0xFF}, {0xFF, 0x29, 0x50, 0xFF}, {0xFF, 0x22, 0x00, 0xFF}, {0xD6, 0x32, 0x00, 0xFF},
+	{0xC4, 0x62, 0x00, 0xFF}, {0x35, 0x80, 0x00, 0xFF}, {0x05, 0x8F, 0x00, 0xFF}, {0x00, 0x8A, 0x55, 0xFF},
+	{0x00, 0x99, 0xCC, 0xFF}, {0x21, 0x21, 0x21, 0xFF}, {0x09, 0x09, 0x09, 0xFF}, {0x09, 0x09, 0x09, 0xFF},
+	{0xFF, 0xFF, 0xFF, 0xFF}, {0x0F, 0xD7, 0xFF, 0xFF}, {0x69, 0xA2, 0xFF, 0xFF}, {0xD4, 0x80, 0xFF, 0xFF},
+	{0xFF, 0x45, 0xF3, 0xFF}, {0xFF, 0x61, 0x8B, 0xFF}, {0xFF, 0x88, 0x33, 0xFF}, {0xFF, 0x9C, 0x12, 0xFF},
+	{0xFA, 0xBC, 0x20, 0xFF}, {0x9F, 0xE3, 0x0E, 0xFF}, {0x2B, 0xF0, 0x35, 0xFF}, {0x0C, 0xF0, 0xA4, 0xFF},
+	{0x05, 0xFB, 0xFF, 0xFF}, {0x5E, 0x5E, 0x5E, 0xFF}, {0x0D, 0x0D, 0x0D, 0xFF}, {0x0D, 0x0D, 0x0D, 0xFF},
+	{0xFF, 0xFF, 0xFF, 0xFF}, {0xA6, 0xFC, 0xFF, 0xFF}, {0xB3, 0xEC, 0xFF, 0xFF}, {0xDA, 0xAB, 0xEB, 0xFF},
+	{0xFF, 0xA8, 0xF9, 0xFF}, {0xFF, 0xAB, 0xB3, 0xFF}, {0xFF, 0xD2, 0xB0, 0xFF}, {0xFF, 0xEF, 0xA6, 0xFF},
+	{0xFF, 0xF7, 0x9C, 0xFF}, {0xD7, 0xE8, 0x95, 0xFF}, {0xA6, 0xED, 0xAF, 0xFF}, {0xA2, 0xF2, 0xDA, 0xFF},
+	{0x99, 0xFF, 0xFC, 0xFF}, {0xDD, 0xDD, 0xDD, 0xFF}, {0x11, 0x11, 0x11, 0xFF}, {0x11, 0x11, 0x11, 0xFF},
+}
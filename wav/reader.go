@@ -0,0 +1,89 @@
+package wav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Reads a PCM WAV file of 8 or 16 bit samples, mixing the channels down to
+// one. Returns the samples and the sample rate.
+func Read(r io.Reader) ([]int16, int, error) {
+	var riff struct {
+		RIFF [4]byte
+		Size uint32
+		WAVE [4]byte
+	}
+	if err := binary.Read(r, binary.LittleEndian, &riff); err != nil || string(riff.RIFF[:]) != "RIFF" || string(riff.WAVE[:]) != "WAVE" {
+		return nil, 0, errors.New("wav: not a WAV file")
+	}
+
+	var format struct {
+		Format        uint16
+		Channels      uint16
+		SampleRate    uint32
+		ByteRate      uint32
+		BlockAlign    uint16
+		BitsPerSample uint16
+	}
+	haveFormat := false
+	for {
+		var chunk struct {
+			ID   [4]byte
+			Size uint32
+		}
+		if err := binary.Read(r, binary.LittleEndian, &chunk); err != nil {
+			return nil, 0, errors.New("wav: no data chunk")
+		}
+		data, err := io.ReadAll(io.LimitReader(r, int64(chunk.Size)))
+		if err != nil {
+			return nil, 0, err
+		}
+		// Files cut short still play up to where they stop.
+		if len(data) < int(chunk.Size) && string(chunk.ID[:]) != "data" {
+			return nil, 0, fmt.Errorf("wav: truncated %q chunk", chunk.ID)
+		}
+		// Chunks are padded to an even length.
+		if chunk.Size%2 == 1 {
+			io.ReadFull(r, make([]byte, 1))
+		}
+
+		switch string(chunk.ID[:]) {
+		case "fmt ":
+			if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &format); err != nil {
+				return nil, 0, errors.New("wav: truncated format")
+			}
+			if format.Format != 1 || format.Channels == 0 || (format.BitsPerSample != 8 && format.BitsPerSample != 16) {
+				return nil, 0, fmt.Errorf("wav: only 8 and 16 bit PCM is supported, not format %d with %d bits", format.Format, format.BitsPerSample)
+			}
+			haveFormat = true
+		case "data":
+			if !haveFormat {
+				return nil, 0, errors.New("wav: data before the format")
+			}
+			return mix(data, int(format.Channels), int(format.BitsPerSample)), int(format.SampleRate), nil
+		}
+	}
+}
+
+// Decodes interleaved samples and averages the channels.
+func mix(data []byte, channels int, bits int) []int16 {
+	size := bits / 8
+	samples := make([]int16, len(data)/(size*channels))
+	for i := range samples {
+		var sum int
+		for c := 0; c < channels; c++ {
+			offset := (i*channels + c) * size
+			if bits == 8 {
+				// 8 bit samples are unsigned.
+				sum += (int(data[offset]) - 128) << 8
+			} else {
+				sum += int(int16(binary.LittleEndian.Uint16(data[offset:])))
+			}
+		}
+		samples[i] = int16(sum / channels)
+	}
+	return samples
+}
@@ -0,0 +1,91 @@
+// Package wav writes 16 bit PCM WAV files and reads them back.
+package wav
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const HEADER_SIZE = 44
+
+// Writer streams samples into a WAV file. The header's sizes aren't known
+// until the end, so Close seeks back and fills them in.
+type Writer struct {
+	w          io.WriteSeeker
+	sampleRate int
+	channels   int
+	bytes      uint32
+	err        error
+}
+
+// Starts a WAV file of 16 bit samples, interleaved if there's more than one
+// channel.
+func NewWriter(w io.WriteSeeker, sampleRate int, channels int) (*Writer, error) {
+	ww := &Writer{w: w, sampleRate: sampleRate, channels: channels}
+	if err := ww.writeHeader(); err != nil {
+		return nil, err
+	}
+	return ww, nil
+}
+
+func (w *Writer) writeHeader() error {
+	blockAlign := 2 * w.channels
+	header := struct {
+		RIFF          [4]byte
+		ChunkSize     uint32
+		WAVE          [4]byte
+		Fmt           [4]byte
+		FmtSize       uint32
+		Format        uint16
+		Channels      uint16
+		SampleRate    uint32
+		ByteRate      uint32
+		BlockAlign    uint16
+		BitsPerSample uint16
+		Data          [4]byte
+		DataSize      uint32
+	}{
+		RIFF:          [4]byte{'R', 'I', 'F', 'F'},
+		ChunkSize:     HEADER_SIZE - 8 + w.bytes,
+		WAVE:          [4]byte{'W', 'A', 'V', 'E'},
+		Fmt:           [4]byte{'f', 'm', 't', ' '},
+		FmtSize:       16,
+		Format:        1,
+		Channels:      uint16(w.channels),
+		SampleRate:    uint32(w.sampleRate),
+		ByteRate:      uint32(w.sampleRate * blockAlign),
+		BlockAlign:    uint16(blockAlign),
+		BitsPerSample: 16,
+		Data:          [4]byte{'d', 'a', 't', 'a'},
+		DataSize:      w.bytes,
+	}
+	return binary.Write(w.w, binary.LittleEndian, &header)
+}
+
+// Writes samples, interleaved by channel.
+func (w *Writer) Write(samples []int16) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.err = binary.Write(w.w, binary.LittleEndian, samples); w.err == nil {
+		w.bytes += uint32(2 * len(samples))
+	}
+	return w.err
+}
+
+// Fills in the header. It doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if _, err := w.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	_, err := w.w.Seek(0, io.SeekEnd)
+	w.err = errors.New("wav: writer is closed")
+	return err
+}